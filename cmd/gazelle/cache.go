@@ -0,0 +1,636 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// genCacheVersion should be incremented whenever the format of the cache
+// file changes or whenever rule generation changes in a way that would make
+// existing cache entries invalid.
+const genCacheVersion = 1
+
+// genCache is a persistent cache of rules generated in each directory. It is
+// enabled with the -cache flag.
+//
+// Each entry is keyed by a hash of everything that could affect rule
+// generation in a directory: the names and contents of files in the
+// directory, the content of the build file, the effective configuration
+// (which includes directives from build files in parent directories), and
+// keys of subdirectories that were also updated. When the key for a directory
+// matches the key stored in the cache, rules are restored from the cache
+// instead of being generated. Dependencies are still resolved for all rules,
+// since resolution depends on rules in other directories.
+type genCache struct {
+	path string
+
+	// rootKey is a hash of information that applies to all directories: the
+	// cache version, the languages in use, and the Gazelle executable.
+	rootKey []byte
+
+	// old contains entries loaded from the cache file. It is not modified
+	// after the cache is loaded.
+	old map[string]*genCacheEntry
+
+	// hasher computes hashes of configurations. It may only be used by the
+	// goroutine that calls walk.Walk.
+	hasher configHasher
+
+	mu  sync.Mutex
+	new map[string]*genCacheEntry
+}
+
+// genCacheFile is the content of the cache file.
+type genCacheFile struct {
+	Version int
+	Entries map[string]*genCacheEntry
+}
+
+// genCacheEntry stores rules generated in a directory.
+type genCacheEntry struct {
+	// Key is the hash of inputs for the directory.
+	Key []byte
+
+	// Stamps records information about each regular file in the directory,
+	// so files that haven't changed don't need to be hashed again.
+	Stamps map[string]genCacheStamp
+
+	// Visit is false if no build file was present and no rules were generated.
+	// The remaining fields are only set if Visit is true.
+	Visit bool
+
+	// File is the content of the build file after generated rules were merged,
+	// before dependencies were resolved.
+	File []byte
+
+	// Results contains rules generated by each language.
+	Results []genCacheResult
+
+	// MappedKinds is a list of kind mappings applied to generated rules.
+	MappedKinds []config.MappedKind
+}
+
+// genCacheResult stores the result of GenerateRules for one language.
+type genCacheResult struct {
+	Lang string
+
+	// Gen and Empty are generated rules in build file syntax.
+	Gen, Empty []byte
+
+	// Imports contains the imports for each rule in Gen, as encoded by the
+	// language. An entry may be nil if the language returned nil.
+	Imports [][]byte
+}
+
+type genCacheStamp struct {
+	Size, ModTime int64
+	Hash          []byte
+}
+
+// langResult pairs a language with the result of calling its GenerateRules
+// method.
+type langResult struct {
+	lang language.Language
+	res  language.GenerateResult
+}
+
+// loadGenCache reads a cache file. If the file doesn't exist or can't be read,
+// an empty cache is returned, and the error is returned for logging.
+func loadGenCache(path string, langs []language.Language) (*genCache, error) {
+	gc := &genCache{
+		path:    path,
+		rootKey: genCacheRootKey(langs),
+		old:     make(map[string]*genCacheEntry),
+		new:     make(map[string]*genCacheEntry),
+		hasher:  configHasher{memo: make(map[configHasherKey]configHasherEntry)},
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return gc, nil
+	} else if err != nil {
+		return gc, err
+	}
+	var cf genCacheFile
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cf); err != nil {
+		return gc, fmt.Errorf("%s: could not read cache, ignoring: %v", path, err)
+	}
+	if cf.Version == genCacheVersion && cf.Entries != nil {
+		gc.old = cf.Entries
+	}
+	return gc, nil
+}
+
+// save writes the cache file. Entries that were not replaced during this run
+// are preserved. The file is written atomically.
+func (gc *genCache) save() error {
+	entries := make(map[string]*genCacheEntry, len(gc.old)+len(gc.new))
+	for rel, e := range gc.old {
+		entries[rel] = e
+	}
+	for rel, e := range gc.new {
+		entries[rel] = e
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(genCacheFile{Version: genCacheVersion, Entries: entries}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(gc.path), 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(gc.path), filepath.Base(gc.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), gc.path)
+}
+
+// genCacheRootKey returns a hash of information that affects rule generation
+// in all directories.
+func genCacheRootKey(langs []language.Language) []byte {
+	h := sha256.New()
+	writeInt(h, genCacheVersion)
+	for _, l := range langs {
+		writeString(h, l.Name())
+	}
+	// Rule generation may change when Gazelle is rebuilt, so include the size
+	// and modification time of the executable.
+	if exe, err := os.Executable(); err == nil {
+		if fi, err := os.Stat(exe); err == nil {
+			writeString(h, exe)
+			writeInt(h, fi.Size())
+			writeInt(h, fi.ModTime().UnixNano())
+		}
+	}
+	return h.Sum(nil)
+}
+
+// cacheable returns whether results for a directory configured with c may
+// be cached. All languages must implement language.GenerateCacher.
+func (gc *genCache) cacheable(c *config.Config) bool {
+	for _, l := range filterLanguages(c, languages) {
+		if _, ok := l.(language.GenerateCacher); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// dirKey computes a key for a directory from its configuration hash and
+// inputs. childKeys must contain keys for subdirectories that were updated
+// in the same run, in order. stamps are returned, to be stored with the
+// entry for the directory. nil is returned if the directory can't be cached.
+//
+// dirKey may be called concurrently.
+func (gc *genCache) dirKey(rel string, configHash []byte, dir string, f *rule.File, subdirs, regularFiles, genFiles []string, childKeys [][]byte) (key []byte, stamps map[string]genCacheStamp) {
+	if configHash == nil {
+		return nil, nil
+	}
+	h := sha256.New()
+	h.Write(gc.rootKey)
+	h.Write(configHash)
+	writeString(h, rel)
+	if f == nil {
+		writeInt(h, 0)
+	} else {
+		writeInt(h, 1)
+		writeString(h, filepath.Base(f.Path))
+		writeBytes(h, f.Content)
+	}
+	writeStrings(h, subdirs)
+	writeStrings(h, genFiles)
+
+	var oldStamps map[string]genCacheStamp
+	if old := gc.old[rel]; old != nil {
+		oldStamps = old.Stamps
+	}
+	stamps = make(map[string]genCacheStamp)
+	writeInt(h, int64(len(regularFiles)))
+	for _, name := range regularFiles {
+		stamp, err := fileStamp(filepath.Join(dir, name), oldStamps[name])
+		if err != nil {
+			return nil, nil
+		}
+		stamps[name] = stamp
+		writeString(h, name)
+		h.Write(stamp.Hash)
+	}
+
+	writeInt(h, int64(len(childKeys)))
+	for _, k := range childKeys {
+		if k == nil {
+			// If a subdirectory can't be cached, its results may change without
+			// its key changing, so this directory can't be cached either.
+			return nil, nil
+		}
+		h.Write(k)
+	}
+	return h.Sum(nil), stamps
+}
+
+// fileStamp returns a stamp for the file at path. If the size and
+// modification time match old, the hash from old is reused. Otherwise,
+// the file is read and hashed.
+func fileStamp(path string, old genCacheStamp) (genCacheStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return genCacheStamp{}, err
+	}
+	stamp := genCacheStamp{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+	if old.Hash != nil && old.Size == stamp.Size && old.ModTime == stamp.ModTime {
+		stamp.Hash = old.Hash
+		return stamp, nil
+	}
+	if fi.IsDir() {
+		stamp.Hash = make([]byte, sha256.Size)
+		return stamp, nil
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		return genCacheStamp{}, err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return genCacheStamp{}, err
+	}
+	stamp.Hash = h.Sum(nil)
+	return stamp, nil
+}
+
+// restore reconstructs the visitRecord for a directory from the cache entry
+// with the given key. false is returned if there is no matching entry or
+// the entry can't be decoded. When true is returned, the visitRecord may be
+// nil if no build file should be written.
+//
+// restore may be called concurrently for different directories.
+func (gc *genCache) restore(key []byte, c *config.Config, dir, rel string, f *rule.File, subdirs, regularFiles, genFiles []string, kinds map[string]rule.KindInfo, mrslv *metaResolver) (*visitRecord, bool) {
+	e := gc.old[rel]
+	if key == nil || e == nil || !bytes.Equal(key, e.Key) {
+		return nil, false
+	}
+
+	// Decode all results before notifying any languages.
+	langs := filterLanguages(c, languages)
+	if len(e.Results) != len(langs) {
+		return nil, false
+	}
+	results := make([]langResult, len(langs))
+	for i, l := range langs {
+		cr := e.Results[i]
+		if cr.Lang != l.Name() {
+			return nil, false
+		}
+		gen, err := parseCachedRules(cr.Gen)
+		if err != nil || len(gen) != len(cr.Imports) {
+			return nil, false
+		}
+		empty, err := parseCachedRules(cr.Empty)
+		if err != nil {
+			return nil, false
+		}
+		imports := make([]interface{}, len(cr.Imports))
+		for j, data := range cr.Imports {
+			if data == nil {
+				continue
+			}
+			if imports[j], err = l.(language.GenerateCacher).DecodeImports(data); err != nil {
+				return nil, false
+			}
+		}
+		results[i] = langResult{lang: l, res: language.GenerateResult{Gen: gen, Empty: empty, Imports: imports}}
+	}
+	var mergedFile *rule.File
+	if e.Visit {
+		path := filepath.Join(dir, c.DefaultBuildFileName())
+		if f != nil {
+			path = f.Path
+		}
+		var err error
+		mergedFile, err = rule.LoadData(path, rel, e.File)
+		if err != nil {
+			return nil, false
+		}
+		if f != nil {
+			mergedFile.Content = f.Content
+		} else {
+			mergedFile.Content = nil
+		}
+	}
+
+	// Let languages update their internal state as if GenerateRules were called.
+	var empty, gen []*rule.Rule
+	var imports []interface{}
+	for _, lr := range results {
+		lr.lang.(language.GenerateCacher).RestoreGenerateResult(language.GenerateArgs{
+			Config:       c,
+			Dir:          dir,
+			Rel:          rel,
+			File:         f,
+			Subdirs:      subdirs,
+			RegularFiles: walk.FilterLangFiles(c, lr.lang.Name(), rel, regularFiles),
+			GenFiles:     genFiles,
+			OtherEmpty:   empty,
+			OtherGen:     gen,
+		}, lr.res)
+		empty = append(empty, lr.res.Empty...)
+		gen = append(gen, lr.res.Gen...)
+		imports = append(imports, lr.res.Imports...)
+	}
+	gc.keep(rel, e)
+	if !e.Visit {
+		return nil, true
+	}
+
+	mappedKindInfo := make(map[string]rule.KindInfo)
+	for _, mk := range e.MappedKinds {
+		mappedKindInfo[mk.KindName] = kinds[mk.FromKind]
+		mrslv.MappedKind(rel, mk)
+	}
+	return &visitRecord{
+		pkgRel:         rel,
+		c:              c,
+		rules:          gen,
+		imports:        imports,
+		empty:          empty,
+		file:           mergedFile,
+		mappedKinds:    e.MappedKinds,
+		mappedKindInfo: mappedKindInfo,
+	}, true
+}
+
+// store records rules generated for a directory. It must be called after
+// generated rules are merged, before dependencies are resolved.
+//
+// store may be called concurrently for different directories.
+func (gc *genCache) store(key []byte, stamps map[string]genCacheStamp, rel string, v *visitRecord, results []langResult) {
+	if key == nil {
+		return
+	}
+	e := &genCacheEntry{Key: key, Stamps: stamps}
+	for _, lr := range results {
+		cr := genCacheResult{
+			Lang:    lr.lang.Name(),
+			Gen:     formatCachedRules(lr.res.Gen),
+			Empty:   formatCachedRules(lr.res.Empty),
+			Imports: make([][]byte, len(lr.res.Imports)),
+		}
+		for i, imp := range lr.res.Imports {
+			if imp == nil {
+				continue
+			}
+			data, err := lr.lang.(language.GenerateCacher).EncodeImports(imp)
+			if err != nil {
+				return
+			}
+			cr.Imports[i] = data
+		}
+		e.Results = append(e.Results, cr)
+	}
+	if v != nil {
+		e.Visit = true
+		e.File = v.file.Format()
+		e.MappedKinds = v.mappedKinds
+	}
+	gc.keep(rel, e)
+}
+
+func (gc *genCache) keep(rel string, e *genCacheEntry) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.new[rel] = e
+}
+
+// formatCachedRules formats rules in build file syntax without modifying
+// them. Rules may already belong to a build file.
+func formatCachedRules(rules []*rule.Rule) []byte {
+	var buf bytes.Buffer
+	for _, r := range rules {
+		fmt.Fprintf(&buf, "%s(\n", r.Kind())
+		for _, arg := range r.Args() {
+			fmt.Fprintf(&buf, "    %s,\n", bzl.FormatString(arg))
+		}
+		for _, key := range r.AttrKeys() {
+			fmt.Fprintf(&buf, "    %s = %s,\n", key, bzl.FormatString(r.Attr(key)))
+		}
+		buf.WriteString(")\n")
+	}
+	return buf.Bytes()
+}
+
+func parseCachedRules(data []byte) ([]*rule.Rule, error) {
+	f, err := rule.LoadData("", "", data)
+	if err != nil {
+		return nil, err
+	}
+	return f.Rules, nil
+}
+
+// configHasher computes hashes of configurations by reflecting over their
+// contents. Values reachable through pointers and maps are hashed once,
+// since configurations in different directories share most of their values.
+type configHasher struct {
+	memo map[configHasherKey]configHasherEntry
+}
+
+type configHasherKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+// configHasherEntry is a memoized hash. ref holds the hashed pointer or map
+// so it can't be collected and its address reused by a different value.
+type configHasherEntry struct {
+	ref reflect.Value
+	sum []byte
+}
+
+// hash returns a hash of the effective configuration c. Fields that don't
+// affect rule generation, like the working directory, diagnostics, options
+// for the update command itself, and the resolve.Explainer, are ignored.
+func (ch *configHasher) hash(c *config.Config) []byte {
+	cc := *c
+	cc.WorkDir = ""
+	cc.Diagnostics = nil
+	cc.Strict = false
+	cc.Exts = nil
+	h := sha256.New()
+	ch.hashValue(h, reflect.ValueOf(cc))
+
+	// Exts is copied for each directory, so it's hashed here instead of
+	// through hashRef, which would memoize a map that's about to be dropped.
+	exts := make(map[string]interface{}, len(c.Exts))
+	for k, v := range c.Exts {
+		if _, ok := v.(*resolve.Explainer); ok || k == updateName {
+			continue
+		}
+		exts[k] = v
+	}
+	ch.hashMap(h, reflect.ValueOf(exts))
+	return h.Sum(nil)
+}
+
+var ruleType = reflect.TypeOf((*rule.Rule)(nil))
+
+func (ch *configHasher) hashValue(h hash.Hash, v reflect.Value) {
+	writeInt(h, int64(v.Kind()))
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			writeInt(h, 1)
+		} else {
+			writeInt(h, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeInt(h, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeInt(h, int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		writeInt(h, int64(math.Float64bits(v.Float())))
+	case reflect.String:
+		writeString(h, v.String())
+	case reflect.Array, reflect.Slice:
+		writeInt(h, int64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			ch.hashValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		writeString(h, v.Type().String())
+		for i := 0; i < v.NumField(); i++ {
+			ch.hashValue(h, v.Field(i))
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			writeInt(h, 0)
+			return
+		}
+		writeString(h, v.Elem().Type().String())
+		ch.hashValue(h, v.Elem())
+	case reflect.Ptr, reflect.Map:
+		if v.IsNil() {
+			writeInt(h, 0)
+			return
+		}
+		h.Write(ch.hashRef(v))
+	default:
+		// Functions, channels, and unsafe pointers can't be compared
+		// meaningfully. Only record whether they are set.
+		if v.IsNil() {
+			writeInt(h, 0)
+		} else {
+			writeInt(h, 1)
+		}
+	}
+}
+
+// hashRef returns a hash of the value referenced by a non-nil pointer or map,
+// memoizing the result.
+func (ch *configHasher) hashRef(v reflect.Value) []byte {
+	key := configHasherKey{t: v.Type(), p: v.Pointer()}
+	if e, ok := ch.memo[key]; ok {
+		return e.sum
+	}
+	// Break cycles. A value that refers to itself hashes its own reference
+	// as zero.
+	ch.memo[key] = configHasherEntry{ref: v, sum: make([]byte, sha256.Size)}
+
+	h := sha256.New()
+	switch {
+	case v.Type() == ruleType && v.CanInterface():
+		// Rules contain syntax trees with positions and other details that
+		// don't matter. Hash the formatted rule instead.
+		h.Write(formatCachedRules([]*rule.Rule{v.Interface().(*rule.Rule)}))
+	case v.Kind() == reflect.Ptr:
+		ch.hashValue(h, v.Elem())
+	default:
+		ch.hashMap(h, v)
+	}
+	sum := h.Sum(nil)
+	ch.memo[key] = configHasherEntry{ref: v, sum: sum}
+	return sum
+}
+
+// hashMap writes a hash of the entries in the map v, independent of
+// iteration order.
+func (ch *configHasher) hashMap(h hash.Hash, v reflect.Value) {
+	keys := v.MapKeys()
+	type pair struct{ k, v []byte }
+	pairs := make([]pair, len(keys))
+	for i, k := range keys {
+		kh, vh := sha256.New(), sha256.New()
+		ch.hashValue(kh, k)
+		ch.hashValue(vh, v.MapIndex(k))
+		pairs[i] = pair{kh.Sum(nil), vh.Sum(nil)}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].k, pairs[j].k) < 0 })
+	writeInt(h, int64(len(pairs)))
+	for _, p := range pairs {
+		h.Write(p.k)
+		h.Write(p.v)
+	}
+}
+
+func writeInt(w io.Writer, n int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(n))
+	w.Write(buf[:])
+}
+
+func writeBytes(w io.Writer, b []byte) {
+	writeInt(w, int64(len(b)))
+	w.Write(b)
+}
+
+func writeString(w io.Writer, s string) {
+	writeInt(w, int64(len(s)))
+	io.WriteString(w, s)
+}
+
+func writeStrings(w io.Writer, ss []string) {
+	writeInt(w, int64(len(ss)))
+	for _, s := range ss {
+		writeString(w, s)
+	}
+}
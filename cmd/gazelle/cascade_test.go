@@ -0,0 +1,112 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestCascade(t *testing.T) {
+	bBuild := `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "b",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/b",
+    visibility = ["//visibility:public"],
+    deps = ["//a:go_default_library"],
+)
+`
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `# gazelle:prefix example.com/repo
+# gazelle:go_naming_convention import
+`},
+		{Path: "a/BUILD.bazel", Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "a/a.go", Content: "package a"},
+		{Path: "b/BUILD.bazel", Content: bBuild},
+		{Path: "b/b.go", Content: `package b
+
+import _ "example.com/repo/a"
+`},
+	}
+
+	for _, tc := range []struct {
+		desc string
+		args []string
+		want string
+	}{
+		{
+			desc: "without_cascade",
+			args: []string{"fix", "a"},
+			want: bBuild,
+		}, {
+			desc: "with_cascade",
+			args: []string{"fix", "-cascade", "a"},
+			want: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "b",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/b",
+    visibility = ["//visibility:public"],
+    deps = ["//a"],
+)
+`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, cleanup := testtools.CreateFiles(t, files)
+			defer cleanup()
+			if err := runGazelle(dir, tc.args); err != nil {
+				t.Fatal(err)
+			}
+			testtools.CheckFiles(t, dir, []testtools.FileSpec{
+				{
+					Path: "a/BUILD.bazel",
+					Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+)
+`,
+				},
+				{Path: "b/BUILD.bazel", Content: tc.want},
+			})
+		})
+	}
+}
+
+func TestCascadeRequiresIndex(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+	if err := runGazelle(dir, []string{"-cascade", "-index=false", "-go_prefix=example.com/repo"}); err == nil {
+		t.Fatal("got success; want error")
+	}
+}
@@ -0,0 +1,217 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// changeSet describes the directories affected by a list of changed files,
+// passed with -changed_files.
+type changeSet struct {
+	// dirs is a list of absolute paths of directories that should be updated.
+	dirs []string
+
+	// trees is a list of absolute paths of directories in dirs whose
+	// subdirectories should also be updated. They're added to dirs by
+	// addTrees.
+	trees []string
+
+	// deletedTrees is a list of slash-separated paths, relative to the
+	// repository root, of files and directories that no longer exist. Rules
+	// in packages within them were removed.
+	deletedTrees []string
+
+	// deletedPkgs is a set of packages whose build files no longer exist.
+	// Rules in those packages that aren't generated again were removed.
+	deletedPkgs map[string]bool
+}
+
+// readChangedFiles reads a list of paths, one per line, from r. Blank lines
+// are skipped.
+func readChangedFiles(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if p := strings.TrimSpace(scanner.Text()); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// loadChangedFiles reads the list of changed files named by -changed_files.
+// "-" means stdin. A list in the repository is read from c.FileSystem(), like
+// other files in the repository; a list outside it is read from the
+// operating system's file system.
+func loadChangedFiles(c *config.Config, path string) ([]string, error) {
+	if path == "-" {
+		return readChangedFiles(os.Stdin)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.WorkDir, path)
+	}
+	fsys := vfs.OS
+	if isDescendingDir(path, c.RepoRoot) {
+		fsys = c.FileSystem()
+	}
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readChangedFiles(bytes.NewReader(data))
+}
+
+// newChangeSet maps a list of changed files to the directories whose build
+// files should be updated. paths may be absolute or relative to the
+// repository root, as printed by "git diff --name-only". Paths may name
+// files or directories that have been added, modified, or deleted.
+//
+// A source file's directory is updated. Since a directory without a build
+// file is part of its parent's package, parent directories are updated up
+// to the nearest one with a build file; this covers files in new
+// directories. When a build file changes, its directory and all
+// subdirectories are updated, since directives may have changed; see
+// addTrees. When a file is deleted, the nearest directory that still exists
+// is updated. Files are looked up in c.FileSystem(), so -source and -overlay
+// apply.
+func newChangeSet(c *config.Config, paths []string) (*changeSet, error) {
+	fsys := c.FileSystem()
+	cs := &changeSet{deletedPkgs: make(map[string]bool)}
+	seen := make(map[string]bool)
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			cs.dirs = append(cs.dirs, dir)
+		}
+	}
+	addPackage := func(dir string) {
+		for {
+			add(dir)
+			if dir == c.RepoRoot || hasBuildFile(c, dir) {
+				return
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+	addTree := func(dir string) {
+		add(dir)
+		cs.trees = append(cs.trees, dir)
+	}
+	rel := func(path string) string {
+		rel, _ := filepath.Rel(c.RepoRoot, path)
+		if rel == "." {
+			return ""
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	for _, p := range paths {
+		path := p
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.RepoRoot, filepath.FromSlash(path))
+		}
+		path = filepath.Clean(path)
+		if !isDescendingDir(path, c.RepoRoot) {
+			return nil, fmt.Errorf("%s: changed file is not in repo root %s", p, c.RepoRoot)
+		}
+
+		fi, err := fsys.Stat(path)
+		switch {
+		case err == nil && fi.IsDir():
+			addTree(path)
+			if path != c.RepoRoot {
+				addPackage(filepath.Dir(path))
+			}
+
+		case err == nil:
+			dir := filepath.Dir(path)
+			if c.IsValidBuildFileName(filepath.Base(path)) {
+				addTree(dir)
+			}
+			addPackage(dir)
+
+		case os.IsNotExist(err):
+			// Find the nearest directory that still exists.
+			deleted := path
+			for {
+				if _, err := fsys.Stat(filepath.Dir(deleted)); err == nil {
+					break
+				}
+				deleted = filepath.Dir(deleted)
+			}
+			cs.deletedTrees = append(cs.deletedTrees, rel(deleted))
+			dir := filepath.Dir(deleted)
+			if deleted == path && c.IsValidBuildFileName(filepath.Base(path)) {
+				// The build file was deleted, but its directory wasn't.
+				// Directives from the build file no longer apply below.
+				cs.deletedPkgs[rel(dir)] = true
+				addTree(dir)
+			}
+			addPackage(dir)
+
+		default:
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+// addTrees returns dirs with the subdirectories of cs.trees added. They're
+// listed by walk.Walk, so directories Walk would skip, like excluded
+// directories and those listed in .bazelignore or .gitignore, aren't added.
+// This must be called after c is fully configured.
+func (cs *changeSet) addTrees(c *config.Config, cexts []config.Configurer, dirs []string) []string {
+	if len(cs.trees) == 0 {
+		return dirs
+	}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		seen[dir] = true
+	}
+	// Walk configures c in the repository root, and problems in build files
+	// are reported again when they're visited later.
+	wc := c.Clone()
+	wc.Diagnostics = &config.Diagnostics{}
+	walk.Walk(wc, cexts, cs.trees, walk.UpdateSubdirsMode, func(dir, _ string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	})
+	return dirs
+}
+
+// hasBuildFile returns whether dir contains a build file.
+func hasBuildFile(c *config.Config, dir string) bool {
+	for _, name := range c.ValidBuildFileNames {
+		if fi, err := c.FileSystem().Stat(filepath.Join(dir, name)); err == nil && !fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
+)
+
+func TestNewChangeSet(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "BUILD.bazel"},
+		{Path: "a/BUILD.bazel"},
+		{Path: "a/a.go"},
+		{Path: "a/sub/BUILD.bazel"},
+		{Path: "b/BUILD.bazel"},
+		{Path: "new/dir/x.go"},
+		{Path: "gone/BUILD.bazel"},
+		{Path: "gone/sub/BUILD.bazel"},
+	})
+	defer cleanup()
+	if err := os.RemoveAll(filepath.Join(dir, "b/BUILD.bazel")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "gone/sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.New()
+	c.RepoRoot = dir
+	cs, err := newChangeSet(c, []string{
+		"a/a.go",
+		"new/dir/x.go",
+		"b/BUILD.bazel",
+		"gone/sub/x.go",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []string
+	for _, d := range cs.dirs {
+		rel, err := filepath.Rel(dir, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	sort.Strings(rels)
+	want := []string{".", "a", "b", "gone", "new", "new/dir"}
+	if !reflect.DeepEqual(rels, want) {
+		t.Errorf("got dirs %q; want %q", rels, want)
+	}
+	if want := []string{"b/BUILD.bazel", "gone/sub"}; !reflect.DeepEqual(cs.deletedTrees, want) {
+		t.Errorf("got deleted trees %q; want %q", cs.deletedTrees, want)
+	}
+	if want := map[string]bool{"b": true}; !reflect.DeepEqual(cs.deletedPkgs, want) {
+		t.Errorf("got deleted packages %v; want %v", cs.deletedPkgs, want)
+	}
+
+	if _, err := newChangeSet(c, []string{"../outside"}); err == nil {
+		t.Error("path outside repo root: got success; want error")
+	}
+}
+
+func TestNewChangeSetFS(t *testing.T) {
+	// Files are looked up in the configured file system, not on disk.
+	root := filepath.FromSlash("/repo")
+	if vol := filepath.VolumeName(os.TempDir()); vol != "" {
+		root = vol + root
+	}
+	m := vfs.NewMapFS(root)
+	for _, f := range []string{"BUILD.bazel", "a/BUILD.bazel", "a/x.go", "a/sub/BUILD.bazel"} {
+		if err := m.WriteFile(filepath.Join(root, filepath.FromSlash(f)), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := config.New()
+	c.RepoRoot = root
+	c.FS = m
+	cs, err := newChangeSet(c, []string{"a/BUILD.bazel", "b/y.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []string
+	for _, d := range cs.dirs {
+		rel, err := filepath.Rel(root, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	sort.Strings(rels)
+	if want := []string{".", "a"}; !reflect.DeepEqual(rels, want) {
+		t.Errorf("got dirs %q; want %q", rels, want)
+	}
+	if want := []string{filepath.Join(root, "a")}; !reflect.DeepEqual(cs.trees, want) {
+		t.Errorf("got trees %q; want %q", cs.trees, want)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(cs.deletedTrees, want) {
+		t.Errorf("got deleted trees %q; want %q", cs.deletedTrees, want)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	// a is not in the list of changed files, but its dependency was
+	// removed, so it's resolved again. Other stale build files outside the
+	// changed packages are not updated.
+	staleBuild := `go_library(
+    name = "c",
+    importpath = "example.com/repo/c",
+)
+`
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `# gazelle:prefix example.com/repo
+# gazelle:resolve go example.com/repo/b //lib
+`},
+		{Path: "a/BUILD.bazel", Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = ["//b"],
+)
+`},
+		{Path: "a/a.go", Content: `package a
+
+import _ "example.com/repo/b"
+`},
+		{Path: "c/BUILD.bazel", Content: staleBuild},
+		{Path: "c/c.go", Content: "package c"},
+
+		// b was moved to lib, which doesn't have a build file yet.
+		{Path: "lib/b.go", Content: "package b"},
+		{Path: "changes.txt", Content: "b/b.go\nb/BUILD.bazel\nlib/b.go\n"},
+	})
+	defer cleanup()
+	if err := runGazelle(dir, []string{"-changed_files=changes.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "lib/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "lib",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path: "a/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = ["//lib"],
+)
+`,
+		},
+		{Path: "c/BUILD.bazel", Content: staleBuild},
+	})
+}
+
+func TestChangedFilesExcluded(t *testing.T) {
+	// When a build file changes, subdirectories Walk would skip aren't
+	// updated.
+	staleBuild := `go_library(
+    name = "x",
+    importpath = "example.com/repo/x",
+)
+`
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: ".bazelignore", Content: "ignored\n"},
+		{Path: "BUILD.bazel", Content: `# gazelle:prefix example.com/repo
+# gazelle:exclude excluded
+`},
+		{Path: "excluded/BUILD.bazel", Content: staleBuild},
+		{Path: "excluded/x.go", Content: "package x"},
+		{Path: "ignored/BUILD.bazel", Content: staleBuild},
+		{Path: "ignored/x.go", Content: "package x"},
+		{Path: "sub/x.go", Content: "package x"},
+		{Path: "changes.txt", Content: "BUILD.bazel\n"},
+	})
+	defer cleanup()
+	if err := runGazelle(dir, []string{"-changed_files=changes.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "excluded/BUILD.bazel", Content: staleBuild},
+		{Path: "ignored/BUILD.bazel", Content: staleBuild},
+		{
+			Path: "sub/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "sub",
+    srcs = ["x.go"],
+    importpath = "example.com/repo/sub",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+	})
+
+	// Dependents of changed rules can't be found without the index.
+	if err := runGazelle(dir, []string{"-changed_files=changes.txt", "-index=false"}); err == nil || !strings.Contains(err.Error(), "-index=true") {
+		t.Errorf("-index=false: got error %v; want -changed_files rejected", err)
+	}
+}
@@ -0,0 +1,647 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// staleError is returned by runFixUpdate in check mode when any build file
+// is out of date. main exits with staleExitCode when it sees this error.
+var staleError = errors.New("encountered stale build files while running check")
+
+// staleExitCode is the exit code used in check mode when build files are out
+// of date. Other errors result in exit code 1.
+const staleExitCode = 3
+
+// checkReport lists the build files examined in check mode and the changes
+// Gazelle would make to them.
+type checkReport struct {
+	Files []checkFileReport `json:"files"`
+}
+
+// checkFileReport describes changes Gazelle would make to one build file.
+type checkFileReport struct {
+	// Path is the slash-separated path to the file, relative to the repository
+	// root.
+	Path string `json:"path"`
+
+	// Stale is true if Gazelle would change the file.
+	Stale bool `json:"stale"`
+
+	// New is true if the file does not exist yet.
+	New bool `json:"new,omitempty"`
+
+	// Rules lists rules that would be added, removed, or changed. A file
+	// may be stale even if no rules change, for example, if load statements
+	// or formatting would change.
+	Rules []checkRuleChange `json:"rules,omitempty"`
+
+	// Error is set if the file could not be checked.
+	Error string `json:"error,omitempty"`
+}
+
+// checkRuleChange describes a rule that would be added, removed, or changed.
+type checkRuleChange struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Change string `json:"change"`
+
+	// Line is the line where the rule starts in the existing file. It is 0
+	// for rules that would be added.
+	Line int `json:"line,omitempty"`
+
+	// Attrs lists attributes that would change. It is only set for changed
+	// rules.
+	Attrs []checkAttrChange `json:"attrs,omitempty"`
+}
+
+// checkAttrChange describes an attribute that would be added, removed,
+// or changed. Old and New are formatted expressions.
+type checkAttrChange struct {
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// checkFile is the emitFunc for check mode. It records changes Gazelle would
+// make to f in the report without writing anything.
+func checkFile(c *config.Config, f *rule.File) error {
+	uc := getUpdateConfig(c)
+	fr := compareFile(c, f)
+	uc.checkReport.Files = append(uc.checkReport.Files, fr)
+	return nil
+}
+
+func compareFile(c *config.Config, f *rule.File) checkFileReport {
+	rel, err := filepath.Rel(c.RepoRoot, f.Path)
+	if err != nil {
+		rel = f.Path
+	}
+	fr := checkFileReport{Path: filepath.ToSlash(rel)}
+
+	newContent := f.Format()
+	if bytes.Equal(newContent, f.Content) {
+		return fr
+	}
+	fr.Stale = true
+	if _, err := c.FileSystem().Stat(f.Path); os.IsNotExist(err) {
+		fr.New = true
+	}
+
+	oldFile, err := rule.LoadData(f.Path, f.Pkg, f.Content)
+	if err != nil {
+		fr.Error = err.Error()
+		return fr
+	}
+	newFile, err := rule.LoadData(f.Path, f.Pkg, newContent)
+	if err != nil {
+		fr.Error = err.Error()
+		return fr
+	}
+	fr.Rules = compareRules(oldFile, newFile)
+	return fr
+}
+
+// compareRules returns a list of rules that were added to, removed from, or
+// changed in newFile, compared with oldFile. Rules are matched by name.
+// Removed and changed rules are listed in the order they appear in
+// oldFile; added rules are listed afterward in the order they appear in
+// newFile.
+func compareRules(oldFile, newFile *rule.File) []checkRuleChange {
+	newRules := make(map[string]*rule.Rule)
+	for _, r := range newFile.Rules {
+		if r.Name() != "" {
+			newRules[r.Name()] = r
+		}
+	}
+	oldRules := make(map[string]bool)
+
+	var changes []checkRuleChange
+	for _, or := range oldFile.Rules {
+		name := or.Name()
+		if name == "" {
+			continue
+		}
+		oldRules[name] = true
+		line := ruleLine(oldFile, or)
+		nr, ok := newRules[name]
+		if !ok {
+			changes = append(changes, checkRuleChange{Kind: or.Kind(), Name: name, Change: changeRemoved, Line: line})
+			continue
+		}
+		attrs := compareAttrs(or, nr)
+		if or.Kind() != nr.Kind() || len(attrs) > 0 {
+			changes = append(changes, checkRuleChange{Kind: nr.Kind(), Name: name, Change: changeChanged, Line: line, Attrs: attrs})
+		}
+	}
+	for _, nr := range newFile.Rules {
+		if nr.Name() != "" && !oldRules[nr.Name()] {
+			changes = append(changes, checkRuleChange{Kind: nr.Kind(), Name: nr.Name(), Change: changeAdded})
+		}
+	}
+	return changes
+}
+
+func compareAttrs(or, nr *rule.Rule) []checkAttrChange {
+	keySet := make(map[string]bool)
+	for _, k := range or.AttrKeys() {
+		keySet[k] = true
+	}
+	for _, k := range nr.AttrKeys() {
+		keySet[k] = true
+	}
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var changes []checkAttrChange
+	for _, k := range keys {
+		var oldValue, newValue string
+		if e := or.Attr(k); e != nil {
+			oldValue = bzl.FormatString(e)
+		}
+		if e := nr.Attr(k); e != nil {
+			newValue = bzl.FormatString(e)
+		}
+		switch {
+		case oldValue == newValue:
+			continue
+		case oldValue == "":
+			changes = append(changes, checkAttrChange{Name: k, Change: changeAdded, New: newValue})
+		case newValue == "":
+			changes = append(changes, checkAttrChange{Name: k, Change: changeRemoved, Old: oldValue})
+		default:
+			changes = append(changes, checkAttrChange{Name: k, Change: changeChanged, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+// ruleLine returns the line number where r starts in f, or 0 if it can't
+// be determined. Rules are matched by kind and name.
+func ruleLine(f *rule.File, r *rule.Rule) int {
+	for _, stmt := range f.File.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		if x, ok := call.X.(*bzl.Ident); !ok || x.Name != r.Kind() {
+			continue
+		}
+		for _, arg := range call.List {
+			attr, ok := arg.(*bzl.AssignExpr)
+			if !ok {
+				continue
+			}
+			key, ok := attr.LHS.(*bzl.Ident)
+			if !ok || key.Name != "name" {
+				continue
+			}
+			if value, ok := attr.RHS.(*bzl.StringExpr); ok && value.Value == r.Name() {
+				start, _ := call.Span()
+				return start.Line
+			}
+		}
+	}
+	return 0
+}
+
+// isStale returns true if any file in the report would be changed.
+func (cr *checkReport) isStale() bool {
+	for _, f := range cr.Files {
+		if f.Stale {
+			return true
+		}
+	}
+	return false
+}
+
+// hasErrors returns true if any file in the report could not be checked.
+func (cr *checkReport) hasErrors() bool {
+	for _, f := range cr.Files {
+		if f.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCheckReport writes the report in the given format to path, or to
+// stdout if path is empty.
+func writeCheckReport(cr *checkReport, format, path string) error {
+	sort.Slice(cr.Files, func(i, j int) bool { return cr.Files[i].Path < cr.Files[j].Path })
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "text":
+		err = writeCheckReportText(&buf, cr)
+	case "json":
+		err = writeCheckReportJSON(&buf, cr)
+	case "sarif":
+		err = writeCheckReportSARIF(&buf, cr)
+	case "junit":
+		err = writeCheckReportJUnit(&buf, cr)
+	default:
+		err = fmt.Errorf("unknown report format: %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0666)
+}
+
+func writeCheckReportJSON(w io.Writer, cr *checkReport) error {
+	if cr.Files == nil {
+		cr.Files = []checkFileReport{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cr)
+}
+
+// writeCheckReportText writes a human-readable summary of stale files and
+// errors. Nothing is written for files that are up to date.
+func writeCheckReportText(w io.Writer, cr *checkReport) error {
+	for _, fr := range cr.Files {
+		if fr.Error != "" {
+			if _, err := fmt.Fprintf(w, "%s: %s\n", fr.Path, fr.Error); err != nil {
+				return err
+			}
+			continue
+		}
+		if !fr.Stale {
+			continue
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s\n", fr.summary())
+		for _, rc := range fr.Rules {
+			fmt.Fprintf(&sb, "  %s\n", rc.describe())
+			for _, ac := range rc.Attrs {
+				fmt.Fprintf(&sb, "    %s\n", ac.describe())
+			}
+		}
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describe returns a one-line summary of an attribute change.
+func (ac checkAttrChange) describe() string {
+	switch ac.Change {
+	case changeAdded:
+		return fmt.Sprintf("%s would be added: %s", ac.Name, ac.New)
+	case changeRemoved:
+		return fmt.Sprintf("%s would be removed: %s", ac.Name, ac.Old)
+	default:
+		return fmt.Sprintf("%s would change from %s to %s", ac.Name, ac.Old, ac.New)
+	}
+}
+
+// describe returns a one-line summary of a rule change.
+func (rc checkRuleChange) describe() string {
+	switch rc.Change {
+	case changeAdded:
+		return fmt.Sprintf("%s rule %q would be added", rc.Kind, rc.Name)
+	case changeRemoved:
+		return fmt.Sprintf("%s rule %q would be removed", rc.Kind, rc.Name)
+	default:
+		names := make([]string, len(rc.Attrs))
+		for i, a := range rc.Attrs {
+			names[i] = a.Name
+		}
+		if len(names) == 0 {
+			return fmt.Sprintf("%s rule %q would be changed", rc.Kind, rc.Name)
+		}
+		return fmt.Sprintf("%s rule %q would be changed (%s)", rc.Kind, rc.Name, strings.Join(names, ", "))
+	}
+}
+
+// describe returns a multi-line summary of changes to a file.
+func (fr checkFileReport) describe() string {
+	if fr.Error != "" {
+		return fr.Error
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n", fr.summary())
+	for _, rc := range fr.Rules {
+		fmt.Fprintf(&sb, "  %s\n", rc.describe())
+	}
+	return sb.String()
+}
+
+// summary returns a one-line summary of a stale file.
+func (fr checkFileReport) summary() string {
+	if fr.New {
+		return fmt.Sprintf("%s would be created", fr.Path)
+	}
+	return fmt.Sprintf("%s is out of date", fr.Path)
+}
+
+// SARIF 2.1.0 types. Only the subset of the format needed to report stale
+// build files is defined.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+const (
+	sarifStaleRuleID = "stale-build-file"
+	sarifErrorRuleID = "check-error"
+)
+
+func writeCheckReportSARIF(w io.Writer, cr *checkReport) error {
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "gazelle",
+				InformationURI: "https://github.com/bazelbuild/bazel-gazelle",
+				Rules: []sarifRule{
+					{ID: sarifStaleRuleID, ShortDescription: sarifMessage{Text: "Build file is out of date. Run gazelle to update it."}},
+					{ID: sarifErrorRuleID, ShortDescription: sarifMessage{Text: "Build file could not be checked."}},
+				},
+			}},
+			Results: []sarifResult{},
+		}},
+	}
+	run := &log.Runs[0]
+	location := func(path string, line int) []sarifLocation {
+		loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: path}}}
+		if line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: line}
+		}
+		return []sarifLocation{loc}
+	}
+	for _, fr := range cr.Files {
+		switch {
+		case fr.Error != "":
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    sarifErrorRuleID,
+				Level:     "error",
+				Message:   sarifMessage{Text: fr.Error},
+				Locations: location(fr.Path, 0),
+			})
+		case !fr.Stale:
+			continue
+		case len(fr.Rules) == 0:
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    sarifStaleRuleID,
+				Level:     "warning",
+				Message:   sarifMessage{Text: strings.TrimSpace(fr.describe())},
+				Locations: location(fr.Path, 1),
+			})
+		default:
+			for _, rc := range fr.Rules {
+				line := rc.Line
+				if line == 0 {
+					line = 1
+				}
+				run.Results = append(run.Results, sarifResult{
+					RuleID:    sarifStaleRuleID,
+					Level:     "warning",
+					Message:   sarifMessage{Text: rc.describe()},
+					Locations: location(fr.Path, line),
+				})
+			}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// JUnit XML types. Each build file is reported as a test case, which fails
+// if the file is stale.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeCheckReportJUnit(w io.Writer, cr *checkReport) error {
+	suite := junitTestSuite{Name: "gazelle", Tests: len(cr.Files)}
+	for _, fr := range cr.Files {
+		tc := junitTestCase{ClassName: "gazelle", Name: fr.Path}
+		switch {
+		case fr.Error != "":
+			suite.Errors++
+			tc.Error = &junitProblem{Message: "build file could not be checked", Text: fr.Error}
+		case fr.Stale:
+			suite.Failures++
+			tc.Failure = &junitProblem{Message: "build file is out of date", Text: fr.describe()}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// repoSourceAttrs are attributes of repository rules that determine what
+// is downloaded. update-repos -check only reports differences in these.
+var repoSourceAttrs = map[string]bool{
+	"commit":     true,
+	"importpath": true,
+	"remote":     true,
+	"replace":    true,
+	"sum":        true,
+	"tag":        true,
+	"vcs":        true,
+	"version":    true,
+}
+
+// compareRepos returns a report of differences between repository rules
+// in gen and existing rules in WORKSPACE and repository macros, grouped by
+// the file each rule is declared in. Rules in gen that don't exist yet are
+// reported as added to newFile. Existing go_repository rules that aren't in
+// gen are reported as removed.
+func compareRepos(c *config.Config, gen []*rule.Rule, newFile *rule.File) *checkReport {
+	uc := getUpdateReposConfig(c)
+	var fileOrder []*rule.File
+	fileReports := make(map[*rule.File]*checkFileReport)
+	report := func(f *rule.File, rc checkRuleChange) {
+		fr, ok := fileReports[f]
+		if !ok {
+			rel, err := filepath.Rel(c.RepoRoot, f.Path)
+			if err != nil {
+				rel = f.Path
+			}
+			fr = &checkFileReport{Path: filepath.ToSlash(rel)}
+			fileReports[f] = fr
+			fileOrder = append(fileOrder, f)
+		}
+		fr.Stale = true
+		fr.Rules = append(fr.Rules, rc)
+	}
+
+	genNames := make(map[string]bool)
+	for _, r := range gen {
+		genNames[r.Name()] = true
+		f := uc.repoFileMap[r.Name()]
+		old := findRuleByName(f, r.Name())
+		if old == nil {
+			report(newFile, checkRuleChange{Kind: r.Kind(), Name: r.Name(), Change: changeAdded})
+			continue
+		}
+		var attrs []checkAttrChange
+		for _, ac := range compareAttrs(old, r) {
+			if repoSourceAttrs[ac.Name] {
+				attrs = append(attrs, ac)
+			}
+		}
+		if old.Kind() != r.Kind() || len(attrs) > 0 {
+			report(f, checkRuleChange{Kind: r.Kind(), Name: r.Name(), Change: changeChanged, Line: ruleLine(f, old), Attrs: attrs})
+		}
+	}
+
+	names := make([]string, 0, len(uc.repoFileMap))
+	for name := range uc.repoFileMap {
+		if !genNames[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := uc.repoFileMap[name]
+		if old := findRuleByName(f, name); old != nil && old.Kind() == "go_repository" {
+			report(f, checkRuleChange{Kind: old.Kind(), Name: name, Change: changeRemoved, Line: ruleLine(f, old)})
+		}
+	}
+
+	cr := &checkReport{}
+	for _, f := range fileOrder {
+		cr.Files = append(cr.Files, *fileReports[f])
+	}
+	return cr
+}
+
+func findRuleByName(f *rule.File, name string) *rule.Rule {
+	if f == nil {
+		return nil
+	}
+	for _, r := range f.Rules {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,524 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestCheckJSON(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# gazelle:prefix example.com/hello
+
+go_library(
+    name = "hello",
+    srcs = [
+        "gone.go",
+        "hello.go",
+    ],
+    importpath = "example.com/hello",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "hello_test",
+    srcs = ["hello_test.go"],
+)
+`,
+		}, {
+			Path:    "hello.go",
+			Content: `package hello`,
+		}, {
+			Path:    "sub/sub.go",
+			Content: `package sub`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-mode=check", "-report=report.json"}); err != staleError {
+		t.Fatalf("got error %v; want %v", err, staleError)
+	}
+	testtools.CheckFiles(t, dir, files)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got checkReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := checkReport{Files: []checkFileReport{
+		{
+			Path:  "BUILD.bazel",
+			Stale: true,
+			Rules: []checkRuleChange{
+				{
+					Kind:   "go_library",
+					Name:   "hello",
+					Change: changeChanged,
+					Line:   6,
+					Attrs: []checkAttrChange{{
+						Name:   "srcs",
+						Change: changeChanged,
+						Old:    "[\n    \"gone.go\",\n    \"hello.go\",\n]",
+						New:    `["hello.go"]`,
+					}},
+				}, {
+					Kind:   "go_test",
+					Name:   "hello_test",
+					Change: changeRemoved,
+					Line:   16,
+				},
+			},
+		}, {
+			Path:  "sub/BUILD.bazel",
+			Stale: true,
+			New:   true,
+			Rules: []checkRuleChange{{
+				Kind:   "go_library",
+				Name:   "sub",
+				Change: changeAdded,
+			}},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestCheckUpToDate(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+# gazelle:prefix example.com/hello
+
+go_library(
+    name = "hello",
+    srcs = ["hello.go"],
+    importpath = "example.com/hello",
+    visibility = ["//visibility:public"],
+)
+`,
+		}, {
+			Path:    "hello.go",
+			Content: `package hello`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-mode=check", "-report=report.json"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got checkReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := checkReport{Files: []checkFileReport{{Path: "BUILD.bazel"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestCheckSARIF(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# gazelle:prefix example.com/hello
+
+go_library(
+    name = "hello",
+    srcs = [
+        "gone.go",
+        "hello.go",
+    ],
+    importpath = "example.com/hello",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "hello_test",
+    srcs = ["hello_test.go"],
+)
+`,
+		}, {
+			Path:    "hello.go",
+			Content: `package hello`,
+		}, {
+			Path:    "sub/sub.go",
+			Content: `package sub`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-mode=check", "-report_format=sarif", "-report=report.sarif"}); err != staleError {
+		t.Fatalf("got error %v; want %v", err, staleError)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.sarif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 {
+		t.Fatalf("got %d runs; want 1", len(log.Runs))
+	}
+	type result struct {
+		uri  string
+		line int
+		msg  string
+	}
+	var got []result
+	for _, r := range log.Runs[0].Results {
+		loc := r.Locations[0].PhysicalLocation
+		got = append(got, result{loc.ArtifactLocation.URI, loc.Region.StartLine, r.Message.Text})
+	}
+	want := []result{
+		{"BUILD.bazel", 6, `go_library rule "hello" would be changed (srcs)`},
+		{"BUILD.bazel", 16, `go_test rule "hello_test" would be removed`},
+		{"sub/BUILD.bazel", 1, `go_library rule "sub" would be added`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestCheckJUnit(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+# gazelle:prefix example.com/hello
+
+go_library(
+    name = "hello",
+    srcs = [
+        "gone.go",
+        "hello.go",
+    ],
+    importpath = "example.com/hello",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "hello_test",
+    srcs = ["hello_test.go"],
+)
+`,
+		}, {
+			Path:    "hello.go",
+			Content: `package hello`,
+		}, {
+			Path:    "sub/sub.go",
+			Content: `package sub`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-mode=check", "-report_format=junit", "-report=report.xml"}); err != staleError {
+		t.Fatalf("got error %v; want %v", err, staleError)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatal(err)
+	}
+	if len(suites.Suites) != 1 {
+		t.Fatalf("got %d test suites; want 1", len(suites.Suites))
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 2 || suite.Failures != 2 || suite.Errors != 0 {
+		t.Errorf("got tests=%d failures=%d errors=%d; want tests=2 failures=2 errors=0", suite.Tests, suite.Failures, suite.Errors)
+	}
+	for _, tc := range suite.Cases {
+		if tc.Failure == nil {
+			t.Errorf("%s: got no failure; want failure", tc.Name)
+		}
+	}
+}
+
+func TestCheckReportFlags(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	wantError := "-report set but -mode is fix, not check"
+	if err := runGazelle(dir, []string{"-report=report.json"}); err == nil || err.Error() != wantError {
+		t.Errorf("got %v; want %q", err, wantError)
+	}
+	wantError = `unrecognized report format: "xml"`
+	if err := runGazelle(dir, []string{"-mode=check", "-report_format=xml"}); err == nil || err.Error() != wantError {
+		t.Errorf("got %v; want %q", err, wantError)
+	}
+}
+
+func TestUpdateReposCheckText(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+			Content: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+# gazelle:repo bazel_gazelle
+
+go_repository(
+    name = "com_github_selvatico_go_mocket",
+    importpath = "github.com/selvatico/go-mocket",
+    replace = "github.com/Selvatico/go-mocket-fork",
+    version = "v1.0.6",
+)
+
+go_repository(
+    name = "com_example_extra",
+    importpath = "example.com/extra",
+    sum = "h1:extra",
+    version = "v1.0.0",
+)
+`,
+		}, {
+			Path: "go.mod",
+			Content: `
+module github.com/linzhp/go_examples/importcases
+
+go 1.13
+
+require (
+	github.com/Selvatico/go-mocket v1.0.7
+	github.com/selvatico/go-mocket v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/selvatico/go-mocket => github.com/Selvatico/go-mocket v1.0.7
+`,
+		}, {
+			Path: "go.sum",
+			Content: `
+github.com/Selvatico/go-mocket v1.0.7/go.mod h1:4gO2v+uQmsL+jzQgLANy3tyEFzaEzHlymVbZ3GP2Oes=
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"update-repos", "-from_file=go.mod", "-check", "-report=report.txt"}
+	if err := runGazelle(dir, args); err != staleError {
+		t.Fatalf("got error %v; want %v", err, staleError)
+	}
+	testtools.CheckFiles(t, dir, append(files, testtools.FileSpec{
+		Path: "report.txt",
+		Content: `WORKSPACE is out of date
+  go_repository rule "com_github_selvatico_go_mocket" would be changed (replace, sum, version)
+    replace would change from "github.com/Selvatico/go-mocket-fork" to "github.com/Selvatico/go-mocket"
+    sum would be added: "h1:sXuFMnMfVL9b/Os8rGXPgbOFbr4HJm8aHsulD/uMTUk="
+    version would change from "v1.0.6" to "v1.0.7"
+  go_repository rule "com_example_extra" would be removed
+`,
+	}))
+}
+
+func TestUpdateReposCheckJSON(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+			Content: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+# gazelle:repo bazel_gazelle
+
+go_repository(
+    name = "com_github_selvatico_go_mocket",
+    importpath = "github.com/selvatico/go-mocket",
+    replace = "github.com/Selvatico/go-mocket-fork",
+    version = "v1.0.6",
+)
+
+go_repository(
+    name = "com_example_extra",
+    importpath = "example.com/extra",
+    sum = "h1:extra",
+    version = "v1.0.0",
+)
+`,
+		}, {
+			Path: "go.mod",
+			Content: `
+module github.com/linzhp/go_examples/importcases
+
+go 1.13
+
+require (
+	github.com/Selvatico/go-mocket v1.0.7
+	github.com/selvatico/go-mocket v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/selvatico/go-mocket => github.com/Selvatico/go-mocket v1.0.7
+`,
+		}, {
+			Path: "go.sum",
+			Content: `
+github.com/Selvatico/go-mocket v1.0.7/go.mod h1:4gO2v+uQmsL+jzQgLANy3tyEFzaEzHlymVbZ3GP2Oes=
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"update-repos", "-from_file=go.mod", "-check", "-report_format=json", "-report=report.json"}
+	if err := runGazelle(dir, args); err != staleError {
+		t.Fatalf("got error %v; want %v", err, staleError)
+	}
+	testtools.CheckFiles(t, dir, files)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got checkReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := checkReport{Files: []checkFileReport{{
+		Path:  "WORKSPACE",
+		Stale: true,
+		Rules: []checkRuleChange{
+			{
+				Kind:   "go_repository",
+				Name:   "com_github_selvatico_go_mocket",
+				Change: changeChanged,
+				Line:   6,
+				Attrs: []checkAttrChange{
+					{Name: "replace", Change: changeChanged, Old: `"github.com/Selvatico/go-mocket-fork"`, New: `"github.com/Selvatico/go-mocket"`},
+					{Name: "sum", Change: changeAdded, New: `"h1:sXuFMnMfVL9b/Os8rGXPgbOFbr4HJm8aHsulD/uMTUk="`},
+					{Name: "version", Change: changeChanged, Old: `"v1.0.6"`, New: `"v1.0.7"`},
+				},
+			}, {
+				Kind:   "go_repository",
+				Name:   "com_example_extra",
+				Change: changeRemoved,
+				Line:   13,
+			},
+		},
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestUpdateReposCheckUpToDate(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "WORKSPACE",
+			Content: `
+load("@bazel_gazelle//:deps.bzl", "go_repository")
+
+# gazelle:repo bazel_gazelle
+
+go_repository(
+    name = "com_github_selvatico_go_mocket",
+    importpath = "github.com/selvatico/go-mocket",
+    replace = "github.com/Selvatico/go-mocket",
+    sum = "h1:sXuFMnMfVL9b/Os8rGXPgbOFbr4HJm8aHsulD/uMTUk=",
+    version = "v1.0.7",
+)
+`,
+		}, {
+			Path: "go.mod",
+			Content: `
+module github.com/linzhp/go_examples/importcases
+
+go 1.13
+
+require (
+	github.com/Selvatico/go-mocket v1.0.7
+	github.com/selvatico/go-mocket v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/selvatico/go-mocket => github.com/Selvatico/go-mocket v1.0.7
+`,
+		}, {
+			Path: "go.sum",
+			Content: `
+github.com/Selvatico/go-mocket v1.0.7/go.mod h1:4gO2v+uQmsL+jzQgLANy3tyEFzaEzHlymVbZ3GP2Oes=
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"update-repos", "-from_file=go.mod", "-check", "-report=report.txt"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, append(files, testtools.FileSpec{Path: "report.txt", Content: ""}))
+}
+
+func TestUpdateReposCheckFlagErrors(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"update-repos", "-check", "example.com/m"},
+			want: "the -check option can only be used with -from_file",
+		}, {
+			args: []string{"update-repos", "-check", "-from_file=go.mod", "-report_format=sarif"},
+			want: `unrecognized report format: "sarif"`,
+		}, {
+			args: []string{"update-repos", "-report=report.txt", "example.com/m"},
+			want: "-report may only be used with -check",
+		},
+	} {
+		if err := runGazelle(dir, tc.args); err == nil || err.Error() != tc.want {
+			t.Errorf("%v: got error %v; want %q", tc.args, err, tc.want)
+		}
+	}
+}
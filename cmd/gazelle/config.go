@@ -0,0 +1,472 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+type configCmdConfig struct {
+	dir    string
+	rel    string
+	format string
+}
+
+const configCmdName = "_config_cmd"
+
+func getConfigCmdConfig(c *config.Config) *configCmdConfig {
+	return c.Exts[configCmdName].(*configCmdConfig)
+}
+
+// configCmdConfigurer handles flags for the config command. It also
+// records the build file and the effective directive values in each
+// directory Walk visits, so the origin of each value can be reported.
+type configCmdConfigurer struct {
+	cexts  []config.Configurer
+	levels []configLevel
+}
+
+// configLevel is the configuration of one directory visited by Walk.
+type configLevel struct {
+	rel  string
+	file *rule.File
+
+	// values maps directive keys to their effective values in rel. Keys of
+	// directives whose Configurer doesn't implement config.DirectiveValuer
+	// are missing.
+	values map[string][]string
+}
+
+func (*configCmdConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
+	cc := &configCmdConfig{}
+	c.Exts[configCmdName] = cc
+	fs.StringVar(&cc.format, "format", "text", "format of the output: text or json")
+}
+
+func (*configCmdConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	cc := getConfigCmdConfig(c)
+	if cc.format != "text" && cc.format != "json" {
+		return fmt.Errorf("unrecognized format: %q", cc.format)
+	}
+	switch fs.NArg() {
+	case 0:
+		cc.dir = c.WorkDir
+	case 1:
+		cc.dir = fs.Arg(0)
+		if !filepath.IsAbs(cc.dir) {
+			cc.dir = filepath.Join(c.WorkDir, cc.dir)
+		}
+	default:
+		return errors.New("config: only one directory may be given")
+	}
+	dir, err := filepath.EvalSymlinks(cc.dir)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	if !isDescendingDir(dir, c.RepoRoot) {
+		return fmt.Errorf("config: dir %s is not in the repo root %s", cc.dir, c.RepoRoot)
+	}
+	cc.dir = dir
+	rel, _ := filepath.Rel(c.RepoRoot, dir)
+	cc.rel = filepath.ToSlash(rel)
+	if cc.rel == "." {
+		cc.rel = ""
+	}
+	return nil
+}
+
+func (*configCmdConfigurer) KnownDirectives() []string { return nil }
+
+func (ccr *configCmdConfigurer) Configure(c *config.Config, rel string, f *rule.File) {
+	ccr.levels = append(ccr.levels, configLevel{rel: rel, file: f, values: directiveValues(c, ccr.cexts)})
+}
+
+// directiveValues returns the effective values of directives in c, as
+// reported by Configurers in cexts that implement config.DirectiveValuer.
+// If more than one Configurer knows a directive, the first one is used.
+func directiveValues(c *config.Config, cexts []config.Configurer) map[string][]string {
+	values := make(map[string][]string)
+	for _, cext := range cexts {
+		dv, ok := cext.(config.DirectiveValuer)
+		if !ok {
+			continue
+		}
+		for _, key := range cext.KnownDirectives() {
+			if _, ok := values[key]; !ok {
+				values[key] = dv.DirectiveValues(c, key)
+			}
+		}
+	}
+	return values
+}
+
+// Sources of directive values.
+const (
+	sourceDefault   = "default"
+	sourceFlag      = "flag"
+	sourceDirective = "directive"
+)
+
+// directiveValue is a value of a directive in the effective configuration
+// of a directory. Source tells where the value came from: a default, a
+// command line flag, or a directive in a build file, in which case File and
+// Line are set.
+type directiveValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+}
+
+// effectiveDirective lists the values of one directive in a directory.
+// Values is empty if the directive is not set.
+type effectiveDirective struct {
+	Key    string           `json:"key"`
+	Values []directiveValue `json:"values"`
+}
+
+type configResult struct {
+	Dir        string               `json:"dir"`
+	Directives []effectiveDirective `json:"directives"`
+}
+
+func runConfig(wd string, args []string) error {
+	ccr := &configCmdConfigurer{}
+	cexts := make([]config.Configurer, 0, len(languages)+4)
+	cexts = append(cexts,
+		&config.CommonConfigurer{},
+		&walk.Configurer{},
+		&resolve.Configurer{})
+	for _, lang := range languages {
+		cexts = append(cexts, lang)
+	}
+	cexts = append(cexts, ccr)
+	ccr.cexts = cexts
+
+	c, err := newConfigCmdConfiguration(wd, args, cexts)
+	if err != nil {
+		return err
+	}
+	cc := getConfigCmdConfig(c)
+
+	// Values that aren't set by directives come from flags or defaults. Find
+	// both by configuring the repository root without a build file, once
+	// with the flags that were given and once without them.
+	flagValues := baseDirectiveValues(c.Clone(), cexts)
+	dc, err := newConfigCmdConfiguration(wd, []string{"-repo_root=" + c.RepoRoot, cc.dir}, cexts)
+	if err != nil {
+		return err
+	}
+	defaultValues := baseDirectiveValues(dc, cexts)
+
+	// Walk only visits the target directory and its parents in this mode,
+	// configuring each one from the root down.
+	visited := false
+	walk.Walk(c, cexts, []string{cc.dir}, walk.UpdateDirsMode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
+		visited = true
+	})
+	if !visited {
+		return fmt.Errorf("config: %s is excluded", cc.dir)
+	}
+
+	var levels []configLevel
+	for _, l := range ccr.levels {
+		if pathtools.HasPrefix(cc.rel, l.rel) {
+			levels = append(levels, l)
+		}
+	}
+	res := effectiveDirectives(c.RepoRoot, cc.rel, config.NewDirectiveRegistry(cexts), levels, flagValues, defaultValues)
+	if cc.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	return printConfigResult(os.Stdout, res)
+}
+
+// baseDirectiveValues configures the repository root in c as if it had no
+// build file and returns the effective directive values.
+func baseDirectiveValues(c *config.Config, cexts []config.Configurer) map[string][]string {
+	for _, cext := range cexts {
+		if _, ok := cext.(*configCmdConfigurer); !ok {
+			cext.Configure(c, "", nil)
+		}
+	}
+	return directiveValues(c, cexts)
+}
+
+// effectiveDirectives computes the directives in effect in the directory
+// rel. levels are the configurations of rel and its parent directories,
+// ordered from the root down. flagValues and defaultValues are the values
+// of directives set by command line flags and by default, before any build
+// file is read. Every directive in registry is listed.
+func effectiveDirectives(repoRoot, rel string, registry *config.DirectiveRegistry, levels []configLevel, flagValues, defaultValues map[string][]string) configResult {
+	res := configResult{Dir: rel}
+	if res.Dir == "" {
+		res.Dir = "."
+	}
+	var target configLevel
+	if len(levels) > 0 {
+		target = levels[len(levels)-1]
+	}
+
+	for _, info := range registry.Directives() {
+		// Find the directives in build files that set this directive.
+		type setter struct {
+			level int
+			d     rule.Directive
+			file  string
+			line  int
+		}
+		var setters []setter
+		for i, l := range levels {
+			if l.file == nil || info.Scope == config.ScopeLocal && l.rel != rel {
+				continue
+			}
+			path, err := filepath.Rel(repoRoot, l.file.Path)
+			if err != nil {
+				path = l.file.Path
+			}
+			for j, d := range l.file.Directives {
+				if d.Key == info.Key && registry.Check(d) == nil {
+					setters = append(setters, setter{level: i, d: d, file: filepath.ToSlash(path), line: l.file.DirectiveLine(j)})
+				}
+			}
+		}
+		fromDirective := func(s setter, value string) directiveValue {
+			return directiveValue{Value: value, Source: sourceDirective, File: s.file, Line: s.line}
+		}
+		base := func() string {
+			if !stringsEqual(flagValues[info.Key], defaultValues[info.Key]) {
+				return sourceFlag
+			}
+			return sourceDefault
+		}
+
+		values := []directiveValue{}
+		final, valued := target.values[info.Key]
+		switch {
+		case !valued:
+			// The Configurer can't report values, so list what build files set.
+			for _, s := range setters {
+				if info.Scope == config.ScopeAccumulated || s.level == setters[len(setters)-1].level {
+					values = append(values, fromDirective(s, s.d.Value))
+				}
+			}
+			if info.Scope != config.ScopeAccumulated && len(values) > 1 {
+				values = values[len(values)-1:]
+			}
+
+		case info.Scope == config.ScopeAccumulated:
+			// Each value comes from where it first appeared: flags or defaults,
+			// or the build file in the first directory where it was in effect.
+			origins := make(map[string][]directiveValue)
+			prev := flagValues[info.Key]
+			defaults := make(map[string]int)
+			for _, v := range defaultValues[info.Key] {
+				defaults[v]++
+			}
+			for _, v := range prev {
+				source := sourceFlag
+				if defaults[v] > 0 {
+					defaults[v]--
+					source = sourceDefault
+				}
+				origins[v] = append(origins[v], directiveValue{Value: v, Source: source})
+			}
+			for i, l := range levels {
+				var ds []setter
+				for _, s := range setters {
+					if s.level == i {
+						ds = append(ds, s)
+					}
+				}
+				for j, v := range newValues(prev, l.values[info.Key]) {
+					dv := directiveValue{Value: v, Source: sourceDefault}
+					if len(ds) > 0 {
+						dv = fromDirective(ds[minInt(j, len(ds)-1)], v)
+					}
+					origins[v] = append(origins[v], dv)
+				}
+				prev = l.values[info.Key]
+			}
+			for _, v := range final {
+				if len(origins[v]) > 0 {
+					values = append(values, origins[v][0])
+					origins[v] = origins[v][1:]
+				} else {
+					values = append(values, directiveValue{Value: v, Source: base()})
+				}
+			}
+
+		default:
+			// The nearest directive that sets the value wins.
+			for _, v := range final {
+				var dv directiveValue
+				switch {
+				case len(setters) > 0:
+					dv = fromDirective(setters[len(setters)-1], v)
+				case stringsEqual(final, flagValues[info.Key]):
+					dv = directiveValue{Value: v, Source: base()}
+				default:
+					// The value was inferred, for example, from a vendor
+					// directory or a go.mod file.
+					dv = directiveValue{Value: v, Source: sourceDefault}
+				}
+				values = append(values, dv)
+			}
+		}
+		res.Directives = append(res.Directives, effectiveDirective{Key: info.Key, Values: values})
+	}
+	return res
+}
+
+// newValues returns the values in cur that aren't in prev, counting
+// duplicates, in the order they appear in cur.
+func newValues(prev, cur []string) []string {
+	seen := make(map[string]int)
+	for _, v := range prev {
+		seen[v]++
+	}
+	var added []string
+	for _, v := range cur {
+		if seen[v] > 0 {
+			seen[v]--
+		} else {
+			added = append(added, v)
+		}
+	}
+	return added
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func printConfigResult(w io.Writer, res configResult) error {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	for _, d := range res.Directives {
+		if len(d.Values) == 0 {
+			fmt.Fprintf(tw, "%s\t(not set)\t\n", d.Key)
+			continue
+		}
+		for _, v := range d.Values {
+			value := v.Value
+			if value == "" {
+				value = `""`
+			}
+			origin := "(" + v.Source + ")"
+			if v.Source == sourceDirective {
+				origin = fmt.Sprintf("%s:%d", v.File, v.Line)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Key, value, origin)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// Rows for unset directives end with an empty cell so tabwriter aligns
+	// them with the other rows. Trim the padding that leaves behind.
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Effective directives in %s\n", res.Dir)
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line != "" {
+			sb.WriteString(strings.TrimRight(line, " \n"))
+			sb.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func newConfigCmdConfiguration(wd string, args []string, cexts []config.Configurer) (*config.Config, error) {
+	c := config.New()
+	c.WorkDir = wd
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	// Flag will call this on any parse error. Don't print usage unless
+	// -h or -help were passed explicitly.
+	fs.Usage = func() {}
+	for _, cext := range cexts {
+		cext.RegisterFlags(fs, "config", c)
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			configUsage(fs)
+			return nil, err
+		}
+		// flag already prints the error; don't print it again.
+		return nil, errors.New("Try -help for more information")
+	}
+	for _, cext := range cexts {
+		if err := cext.CheckFlags(fs, c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func configUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle config [flags...] [dir]
+
+The config command prints the directives in effect in a directory (the
+working directory if none is given). Directives are inherited from build
+files in parent directories, so the effective configuration may come from
+several files. For each directive known to Gazelle and its extensions, the
+effective value is printed along with the build file and line that set it,
+or "(flag)" or "(default)" for values set by command line flags or
+defaults.
+
+Most directives replace values set in parent directories; only the nearest
+one is shown. Directives like exclude, resolve, and map_kind add to values
+from parent directories, so every value is shown.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,188 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestConfigText(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:exclude vendor
+# gazelle:resolve go example.com/x //x
+# gazelle:ignore
+`,
+		}, {
+			Path: "a/BUILD.bazel",
+			Content: `# gazelle:go_naming_convention import
+
+# gazelle:exclude testdata
+`,
+		}, {
+			Path:    "a/b/BUILD.bazel",
+			Content: "# gazelle:prefix example.com/other\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	got, err := captureStdout(t, func() error {
+		return runGazelle(dir, []string{"config", "-build_tags=bar", "-exclude=x", "a/b"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Effective directives in a/b
+bazelignore                    true                                (default)
+build_file_name                BUILD.bazel,BUILD                   (default)
+build_tags                     bar                                 (flag)
+build_tags                     gc                                  (default)
+exclude                        x                                   (flag)
+exclude                        vendor                              BUILD.bazel:2
+exclude                        a/testdata                          a/BUILD.bazel:3
+exclude_lang                   (not set)
+follow                         (not set)
+gitignore                      false                               (default)
+go_generate_proto              true                                (default)
+go_grpc_compilers              @io_bazel_rules_go//proto:go_grpc   (default)
+go_naming_convention           import                              a/BUILD.bazel:1
+go_naming_convention_external  (not set)
+go_proto_compilers             @io_bazel_rules_go//proto:go_proto  (default)
+go_visibility                  (not set)
+ignore                         (not set)
+importmap_prefix               (not set)
+lang                           (not set)
+map_kind                       (not set)
+prefix                         example.com/other                   a/b/BUILD.bazel:1
+proto                          default                             (default)
+proto_group                    (not set)
+proto_import_prefix            (not set)
+proto_strip_import_prefix      (not set)
+resolve                        go example.com/x //x                BUILD.bazel:3
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConfigJSON(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:exclude vendor
+# gazelle:resolve go example.com/x //x
+# gazelle:ignore
+`,
+		}, {
+			Path: "a/BUILD.bazel",
+			Content: `# gazelle:go_naming_convention import
+
+# gazelle:exclude testdata
+`,
+		}, {
+			Path:    "a/b/BUILD.bazel",
+			Content: "# gazelle:prefix example.com/other\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	got, err := captureStdout(t, func() error {
+		return runGazelle(filepath.Join(dir, "a"), []string{"config", "-format=json", "-repo_root", dir})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res configResult
+	if err := json.Unmarshal([]byte(got), &res); err != nil {
+		t.Fatalf("%v; output:\n%s", err, got)
+	}
+	if res.Dir != "a" {
+		t.Errorf("got dir %q; want %q", res.Dir, "a")
+	}
+	values := make(map[string][]directiveValue)
+	for _, d := range res.Directives {
+		values[d.Key] = d.Values
+	}
+	if vs := values["prefix"]; len(vs) != 1 || vs[0] != (directiveValue{Value: "example.com/repo", Source: sourceDirective, File: "BUILD.bazel", Line: 1}) {
+		t.Errorf("prefix: got %v", vs)
+	}
+	if vs := values["ignore"]; len(vs) != 0 {
+		t.Errorf("ignore: got %v; want it not to be inherited", vs)
+	}
+	if vs := values["proto"]; len(vs) != 1 || vs[0] != (directiveValue{Value: "default", Source: sourceDefault}) {
+		t.Errorf("proto: got %v; want the default", vs)
+	}
+	if vs, ok := values["proto_group"]; !ok || len(vs) != 0 {
+		t.Errorf("proto_group: got %v, %v; want known but not set", vs, ok)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:exclude vendor
+# gazelle:resolve go example.com/x //x
+# gazelle:ignore
+`,
+		}, {
+			Path: "a/BUILD.bazel",
+			Content: `# gazelle:go_naming_convention import
+
+# gazelle:exclude testdata
+`,
+		}, {
+			Path:    "a/b/BUILD.bazel",
+			Content: "# gazelle:prefix example.com/other\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	for _, tc := range []struct {
+		args    []string
+		wantErr string
+	}{
+		{
+			args:    []string{"config", "a", "a/b"},
+			wantErr: "only one directory may be given",
+		}, {
+			args:    []string{"config", "-format=yaml"},
+			wantErr: `unrecognized format: "yaml"`,
+		}, {
+			args:    []string{"config", "missing"},
+			wantErr: "no such file or directory",
+		},
+	} {
+		if err := runGazelle(dir, tc.args); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%v: got error %v; want %q", tc.args, err, tc.wantErr)
+		}
+	}
+}
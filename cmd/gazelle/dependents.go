@@ -0,0 +1,160 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// updateDependents generates rules in directories that weren't going to be
+// updated but contain rules that depend on rules in directories that were,
+// when those rules were removed, renamed, or now provide different imports.
+// This lets dependencies of rules in those directories be resolved again, so
+// their labels don't go stale. Only build files whose contents change are
+// written.
+//
+// cs describes the files listed with -changed_files. Rules in packages that
+// were deleted are treated as removed. cs may be nil.
+//
+// updateDependents must be called after rules are generated for tasks and
+// before tasks are indexed. Directories that weren't updated are only visited
+// when the index is enabled.
+func updateDependents(c *config.Config, cs *changeSet, tasks []*dirTask, kinds map[string]rule.KindInfo, mrslv *metaResolver) {
+	// Record what each rule in updated directories imported before and after
+	// rules were generated, keyed by package and rule name.
+	oldRules := make(map[string]map[string]string)
+	newRules := make(map[string]map[string]string)
+	for _, t := range tasks {
+		<-t.done
+		if t.indexOnly {
+			continue
+		}
+		rules := make(map[string]string)
+		if t.visit != nil {
+			for _, r := range t.visit.file.Rules {
+				rules[r.Name()] = importKey(t.visit.c, mrslv, t.rel, r, t.visit.file)
+			}
+		}
+		newRules[t.rel] = rules
+		if t.file != nil && t.file.Content != nil {
+			if old, err := rule.LoadData(t.file.Path, t.file.Pkg, t.file.Content); err == nil {
+				rules := make(map[string]string)
+				for _, r := range old.Rules {
+					rules[r.Name()] = importKey(t.c, mrslv, t.rel, r, old)
+				}
+				oldRules[t.rel] = rules
+			}
+		}
+	}
+	changed := func(l label.Label) bool {
+		if l.Repo != "" && l.Repo != c.RepoName {
+			return false
+		}
+		newKey, inNew := newRules[l.Pkg][l.Name]
+		oldKey, inOld := oldRules[l.Pkg][l.Name]
+		if inNew {
+			return inOld && oldKey != newKey
+		}
+		if inOld {
+			return true
+		}
+		if cs == nil {
+			return false
+		}
+		if cs.deletedPkgs[l.Pkg] {
+			return true
+		}
+		for _, d := range cs.deletedTrees {
+			if pathtools.HasPrefix(l.Pkg, d) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, t := range tasks {
+		if !t.indexOnly || t.file == nil || isIgnored(t.file) || !dependsOn(t.file, changed) {
+			continue
+		}
+		t.visit, _ = generateDir(t.c, t.dir, t.rel, t.file, t.subdirs, t.regularFiles, t.genFiles, kinds, mrslv)
+		t.indexOnly = false
+	}
+}
+
+// importKey returns a string describing the imports r provides, which can
+// be compared to tell whether those imports changed.
+func importKey(c *config.Config, mrslv *metaResolver, rel string, r *rule.Rule, f *rule.File) string {
+	rslv := mrslv.Resolver(r, rel)
+	if rslv == nil {
+		return ""
+	}
+	var specs []string
+	for _, imp := range rslv.Imports(c, r, f) {
+		specs = append(specs, imp.Lang+":"+imp.Imp)
+	}
+	sort.Strings(specs)
+	return r.Kind() + " " + strings.Join(specs, " ")
+}
+
+// dependsOn returns whether any rule in f has an attribute with a label for
+// which match returns true.
+func dependsOn(f *rule.File, match func(label.Label) bool) bool {
+	for _, l := range ruleLabels(f) {
+		if match(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleLabels returns the labels in attributes of rules in f. Relative
+// labels, which refer to rules in the same package, are skipped.
+func ruleLabels(f *rule.File) []label.Label {
+	var labels []label.Label
+	for _, r := range f.Rules {
+		for _, key := range r.AttrKeys() {
+			values := r.AttrStrings(key)
+			if s := r.AttrString(key); s != "" {
+				values = append(values, s)
+			}
+			for _, v := range values {
+				l, err := label.Parse(v)
+				if err != nil || l.Relative {
+					continue
+				}
+				labels = append(labels, l)
+			}
+		}
+	}
+	return labels
+}
+
+// isIgnored returns whether f has a "# gazelle:ignore" directive. Walk
+// doesn't update directories with ignored build files.
+func isIgnored(f *rule.File) bool {
+	for _, d := range f.Directives {
+		if d.Key == "ignore" {
+			return true
+		}
+	}
+	return false
+}
@@ -49,7 +49,7 @@ func diffFile(c *config.Config, f *rule.File) error {
 		return nil
 	}
 
-	if _, err := os.Stat(f.Path); os.IsNotExist(err) {
+	if _, err := c.FileSystem().Stat(f.Path); os.IsNotExist(err) {
 		diff.FromFile = "/dev/null"
 	} else if err != nil {
 		return fmt.Errorf("error reading original file: %v", err)
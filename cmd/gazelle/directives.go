@@ -0,0 +1,213 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// directiveArgJSON and directiveJSON are the JSON forms of
+// config.DirectiveArg and config.DirectiveInfo printed by
+// "gazelle help directives -format=json".
+type directiveArgJSON struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Allowed  []string `json:"allowed,omitempty"`
+	Optional bool     `json:"optional,omitempty"`
+	List     bool     `json:"list,omitempty"`
+}
+
+type directiveJSON struct {
+	Key   string             `json:"key"`
+	Usage string             `json:"usage"`
+	Doc   string             `json:"doc"`
+	Scope string             `json:"scope"`
+	Args  []directiveArgJSON `json:"args"`
+}
+
+// helpDirectives prints reference documentation for the directives known
+// to Gazelle and its extensions, including language plugins.
+func helpDirectives(wd string, args []string) error {
+	stop, err := startLangPlugins(wd, args)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	// Flag will call this on any parse error. Don't print usage unless
+	// -h or -help were passed explicitly.
+	fs.Usage = func() {}
+	var format string
+	var langPlugins []string
+	fs.StringVar(&format, "format", "text", "format of the output: text or json")
+	fs.Var(&gzflag.MultiFlag{Values: &langPlugins}, langPluginFlag, "executable that implements a language extension over the plugin protocol (can specify multiple times)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			helpDirectivesUsage(fs)
+			return err
+		}
+		// flag already prints the error; don't print it again.
+		return errors.New("Try -help for more information")
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("help directives: unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cexts := []config.Configurer{
+		&config.CommonConfigurer{},
+		&walk.Configurer{},
+		&resolve.Configurer{},
+	}
+	for _, lang := range languages {
+		cexts = append(cexts, lang)
+	}
+	registry := config.NewDirectiveRegistry(cexts)
+
+	switch format {
+	case "text":
+		return printDirectives(os.Stdout, registry)
+	case "json":
+		var ds []directiveJSON
+		for _, info := range registry.Directives() {
+			d := directiveJSON{
+				Key:   info.Key,
+				Usage: info.Usage(),
+				Doc:   info.Doc,
+				Scope: info.Scope.String(),
+				Args:  []directiveArgJSON{},
+			}
+			for _, a := range info.Args {
+				d.Args = append(d.Args, directiveArgJSON{
+					Name:     a.Name,
+					Type:     a.Type.String(),
+					Allowed:  a.Allowed,
+					Optional: a.Optional,
+					List:     a.List,
+				})
+			}
+			ds = append(ds, d)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(ds)
+	default:
+		return fmt.Errorf("unrecognized format: %q", format)
+	}
+}
+
+func printDirectives(w io.Writer, registry *config.DirectiveRegistry) error {
+	var sb strings.Builder
+	for i, info := range registry.Directives() {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		fmt.Fprintf(&sb, "# %s\n", info.Usage())
+		if !registry.IsDescribed(info.Key) {
+			sb.WriteString("    No description available.\n")
+			continue
+		}
+		writeWrapped(&sb, info.Doc, "    ", 76)
+		writeWrapped(&sb, "Scope: "+scopeDoc(info.Scope), "    ", 76)
+		for _, a := range info.Args {
+			writeWrapped(&sb, fmt.Sprintf("%s: %s", a.Name, argDoc(a)), "    ", 76)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func scopeDoc(s config.DirectiveScope) string {
+	switch s {
+	case config.ScopeAccumulated:
+		return "this directory and subdirectories; adds to values from parent directories"
+	case config.ScopeLocal:
+		return "this directory only"
+	default:
+		return "this directory and subdirectories"
+	}
+}
+
+func argDoc(a config.DirectiveArg) string {
+	var doc string
+	switch a.Type {
+	case config.ArgEnum:
+		doc = "one of " + strings.Join(a.Allowed, ", ")
+	case config.ArgBool:
+		doc = "true or false"
+	default:
+		doc = a.Type.String()
+	}
+	if a.List {
+		doc = "comma-separated list, each " + doc
+	}
+	if a.Optional {
+		doc += " (optional)"
+	}
+	return doc
+}
+
+// writeWrapped writes text to sb, broken into lines at word boundaries so
+// that lines, including indent, are no longer than width when possible.
+func writeWrapped(sb *strings.Builder, text, indent string, width int) {
+	n := 0
+	for _, word := range strings.Fields(text) {
+		if n > 0 && n+1+len(word) > width {
+			sb.WriteByte('\n')
+			n = 0
+		}
+		if n == 0 {
+			sb.WriteString(indent)
+			n = len(indent)
+		} else {
+			sb.WriteByte(' ')
+			n++
+		}
+		sb.WriteString(word)
+		n += len(word)
+	}
+	if n > 0 {
+		sb.WriteByte('\n')
+	}
+}
+
+func helpDirectivesUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle help directives [flags...]
+
+Prints reference documentation for the directives known to Gazelle and its
+extensions. Directives are written as comments in build files, like
+"# gazelle:key value". For each directive, the arguments it accepts and the
+directories it applies to are listed.
+
+Gazelle checks directive values against this documentation. Directives
+with invalid values are reported and ignored.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
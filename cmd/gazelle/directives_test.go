@@ -0,0 +1,87 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHelpDirectivesText(t *testing.T) {
+	out, err := captureStdout(t, func() error {
+		return runGazelle(".", []string{"help", "directives"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`# gazelle:ignore
+    Prevents Gazelle from modifying the build file. Gazelle still reads
+    rules in the file and may modify build files in subdirectories.
+    Scope: this directory only
+`,
+		`# gazelle:resolve source-lang [import-lang] import-string label
+    Maps an import string to the label Gazelle should use in deps.
+    import-lang may be omitted if it's the same as source-lang.
+    Scope: this directory and subdirectories; adds to values from parent
+    directories
+    source-lang: string
+    import-lang: string (optional)
+    import-string: string
+    label: label
+`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain:\n%s\noutput:\n%s", want, out)
+		}
+	}
+}
+
+func TestHelpDirectivesJSON(t *testing.T) {
+	out, err := captureStdout(t, func() error {
+		return runGazelle(".", []string{"help", "directives", "-format=json"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ds []directiveJSON
+	if err := json.Unmarshal([]byte(out), &ds); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range ds {
+		if d.Key != "proto" {
+			continue
+		}
+		want := directiveJSON{
+			Key:   "proto",
+			Usage: "gazelle:proto mode",
+			Doc:   "Tells Gazelle how to generate rules for .proto files.",
+			Scope: "inherited",
+			Args: []directiveArgJSON{{
+				Name:    "mode",
+				Type:    "enum",
+				Allowed: []string{"default", "package", "legacy", "disable", "disable_global"},
+			}},
+		}
+		if !reflect.DeepEqual(d, want) {
+			t.Errorf("got %#v; want %#v", d, want)
+		}
+		return
+	}
+	t.Error("proto directive not found")
+}
@@ -0,0 +1,107 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// explainFile is the emitFunc for the explain command. Build files are
+// generated and resolved as usual, but nothing is written.
+func explainFile(c *config.Config, f *rule.File) error {
+	return nil
+}
+
+// parseExplainTargets parses the labels passed with -target. Labels must be
+// absolute.
+func parseExplainTargets(targets []string) ([]label.Label, error) {
+	labels := make([]label.Label, 0, len(targets))
+	for _, t := range targets {
+		l, err := label.Parse(t)
+		if err != nil {
+			return nil, fmt.Errorf("-target: %v", err)
+		}
+		if l.Relative {
+			return nil, fmt.Errorf("-target: label %q must be absolute", t)
+		}
+		labels = append(labels, l)
+	}
+	return labels, nil
+}
+
+// printExplanations writes a description of each explanation to w.
+func printExplanations(w io.Writer, xs []*resolve.Explanation) error {
+	var sb strings.Builder
+	for i, x := range xs {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s (%s) imports %q (%s)\n", x.From, x.Kind, x.Imp.Imp, x.Imp.Lang)
+		for _, s := range x.Steps {
+			fmt.Fprintf(&sb, "  %s: %s", s.Method, s.Note)
+			if len(s.Candidates) > 0 {
+				cs := make([]string, len(s.Candidates))
+				for i, c := range s.Candidates {
+					cs[i] = c.String()
+				}
+				fmt.Fprintf(&sb, " (candidates: %s)", strings.Join(cs, ", "))
+			}
+			sb.WriteString("\n")
+		}
+		switch {
+		case x.Err != nil:
+			fmt.Fprintf(&sb, "  => error: %v\n", x.Err)
+		case x.Skipped:
+			fmt.Fprintf(&sb, "  => no dependency: %s\n", x.Reason)
+		case x.Method == "":
+			sb.WriteString("  => not resolved\n")
+		default:
+			fmt.Fprintf(&sb, "  => %s by %s: %s\n", x.Result, x.Method, x.Reason)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func explainUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle explain [flags...] [package-dirs...]
+
+The explain command generates and resolves build files like update, but
+instead of writing them, it prints how dependencies were resolved. For each
+import, it lists the resolution methods that were tried, the candidate rules
+each method found, and the rule that was chosen and why.
+
+Explanations are printed for imports in the rules named with -target and for
+import strings named with -import. Both flags may be given multiple times.
+If neither is given, all imports are explained.
+
+Resolution decisions are recorded by the Go and proto extensions. Imports
+resolved by other extensions may not be explained.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,225 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+// captureStdout calls f and returns what it wrote to os.Stdout.
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		done <- data
+	}()
+	err = f()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-done), err
+}
+
+func TestExplainTarget(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:go_naming_convention import
+# gazelle:resolve go example.com/over //override:lib
+`,
+		}, {
+			Path: "a/a.go",
+			Content: `package a
+
+import (
+	"fmt"
+
+	_ "example.com/over"
+	_ "example.com/repo/b"
+	_ "golang.org/x/ext"
+)
+
+var _ = fmt.Println
+`,
+		}, {
+			Path:    "b/b.go",
+			Content: "package b\n",
+		}, {
+			Path: "p/p.proto",
+			Content: `syntax = "proto3";
+
+package p;
+
+import "google/protobuf/any.proto";
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	got, err := captureStdout(t, func() error {
+		return runGazelle(dir, []string{"explain", "-external=vendored", "-target=//a"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `//a (go_library) imports "example.com/over" (go)
+  override: chose //override:lib
+  => //override:lib by override: matched a # gazelle:resolve directive
+
+//a (go_library) imports "example.com/repo/b" (go)
+  override: no # gazelle:resolve directive matched
+  index: chose //b (candidates: //b)
+  => //b by index: only rule in the index that provides this import
+
+//a (go_library) imports "fmt" (go)
+  std: skipped
+  => no dependency: package is in the standard library
+
+//a (go_library) imports "golang.org/x/ext" (go)
+  override: no # gazelle:resolve directive matched
+  index: no indexed rule provides this import
+  vendored: chose //vendor/golang.org/x/ext
+  => //vendor/golang.org/x/ext by vendored: external dependencies are vendored (-external=vendored)
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Nothing should be written.
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "BUILD.bazel", Content: files[1].Content},
+		{Path: "a/BUILD.bazel", NotExist: true},
+		{Path: "b/BUILD.bazel", NotExist: true},
+	})
+}
+
+func TestExplainTargetWorkspaceName(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE", Content: `workspace(name = "com_example_repo")`},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+		{Path: "a/a.go", Content: "package a\n\nimport _ \"example.com/repo/b\"\n"},
+		{Path: "b/b.go", Content: "package b\n"},
+	})
+	defer cleanup()
+
+	// Targets without a repository name refer to the main repository, even
+	// when it's named.
+	for _, target := range []string{"//a", "@com_example_repo//a"} {
+		got, err := captureStdout(t, func() error {
+			return runGazelle(dir, []string{"explain", "-target=" + target})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, `imports "example.com/repo/b" (go)`) {
+			t.Errorf("%s: got:\n%s\nwant explanation for example.com/repo/b", target, got)
+		}
+	}
+}
+
+func TestExplainImport(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:go_naming_convention import
+# gazelle:resolve go example.com/over //override:lib
+`,
+		}, {
+			Path: "a/a.go",
+			Content: `package a
+
+import (
+	"fmt"
+
+	_ "example.com/over"
+	_ "example.com/repo/b"
+	_ "golang.org/x/ext"
+)
+
+var _ = fmt.Println
+`,
+		}, {
+			Path:    "b/b.go",
+			Content: "package b\n",
+		}, {
+			Path: "p/p.proto",
+			Content: `syntax = "proto3";
+
+package p;
+
+import "google/protobuf/any.proto";
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	got, err := captureStdout(t, func() error {
+		return runGazelle(dir, []string{"explain", "-import=google/protobuf/any.proto"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `//p:p_go_proto (go_proto_library) imports "google/protobuf/any.proto" (proto)
+  well_known: skipped
+  => no dependency: well known types are provided by rules_go
+
+//p:p_proto (proto_library) imports "google/protobuf/any.proto" (proto)
+  override: no # gazelle:resolve directive matched
+  known_imports: chose @com_google_protobuf//:any_proto
+  => @com_google_protobuf//:any_proto by known_imports: listed in Gazelle's table of well known proto imports
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExplainFlags(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	for _, tc := range []struct {
+		args    []string
+		wantErr string
+	}{
+		{
+			args:    []string{"explain", "-target=:a"},
+			wantErr: `-target: label ":a" must be absolute`,
+		}, {
+			args:    []string{"explain", "-mode=diff"},
+			wantErr: "-mode may not be set with explain",
+		},
+	} {
+		if err := runGazelle(dir, tc.args); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%v: got error %v; want %q", tc.args, err, tc.wantErr)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -25,6 +26,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
@@ -32,9 +34,11 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 	"github.com/bazelbuild/bazel-gazelle/walk"
 )
 
@@ -49,6 +53,31 @@ type updateConfig struct {
 	walkMode       walk.Mode
 	patchPath      string
 	patchBuffer    bytes.Buffer
+	jobs           int
+	cachePath      string
+	reportFormat   string
+	reportPath     string
+	checkReport    *checkReport
+	explainer      *resolve.Explainer
+	queries        []query
+	queryFormat    string
+
+	// migrations lists the migrations selected with -fix. When it's nil,
+	// Language.Fix is called instead.
+	migrations      []langMigration
+	migrationReport *migrationReport
+
+	// txn holds build files staged by fixFile. They're written together
+	// after all files have been emitted.
+	txn *fileTxn
+
+	// changes describes the files listed with -changed_files. When it's set,
+	// dirs lists the directories affected by those files.
+	changes *changeSet
+
+	// cascade is set by -cascade. Rules outside dirs that depend on rules
+	// that changed are resolved again.
+	cascade bool
 }
 
 type emitFunc func(c *config.Config, f *rule.File) error
@@ -57,6 +86,7 @@ var modeFromName = map[string]emitFunc{
 	"print": printFile,
 	"fix":   fixFile,
 	"diff":  diffFile,
+	"check": checkFile,
 }
 
 const updateName = "_update"
@@ -70,6 +100,17 @@ type updateConfigurer struct {
 	recursive      bool
 	knownImports   []string
 	repoConfigPath string
+	explain        bool
+	targets        []string
+	imports        []string
+	query          bool
+	fixes          string
+	changedFiles   string
+
+	// langPlugins is only registered so -lang_plugin is accepted and
+	// documented. Plugins are started by startLangPlugins before flags
+	// are parsed.
+	langPlugins []string
 }
 
 func (ucr *updateConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
@@ -77,12 +118,35 @@ func (ucr *updateConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *conf
 	c.Exts[updateName] = uc
 
 	c.ShouldFix = cmd == "fix"
+	ucr.explain = cmd == "explain"
+	ucr.query = cmd == "query"
 
-	fs.StringVar(&ucr.mode, "mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
+	fs.StringVar(&ucr.mode, "mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff\n\tcheck: reports stale BUILD files without changing them")
 	fs.BoolVar(&ucr.recursive, "r", true, "when true, gazelle will update subdirectories recursively")
 	fs.StringVar(&uc.patchPath, "patch", "", "when set with -mode=diff, gazelle will write to a file instead of stdout")
 	fs.Var(&gzflag.MultiFlag{Values: &ucr.knownImports}, "known_import", "import path for which external resolution is skipped (can specify multiple times)")
 	fs.StringVar(&ucr.repoConfigPath, "repo_config", "", "file where Gazelle should load repository configuration. Defaults to WORKSPACE.")
+	fs.IntVar(&uc.jobs, "jobs", 1, "maximum number of directories to generate rules for and resolve concurrently; all languages must be safe for concurrent use if greater than 1")
+	fs.StringVar(&uc.reportFormat, "report_format", "json", "when set with -mode=check, format of the report: json, sarif, or junit")
+	fs.StringVar(&uc.reportPath, "report", "", "when set with -mode=check, gazelle will write the report to a file instead of stdout")
+	if ucr.explain {
+		fs.Var(&gzflag.MultiFlag{Values: &ucr.targets}, "target", "label of a rule whose dependencies should be explained (can specify multiple times)")
+		fs.Var(&gzflag.MultiFlag{Values: &ucr.imports}, "import", "import string whose resolution should be explained (can specify multiple times)")
+	}
+	if ucr.query {
+		fs.StringVar(&uc.queryFormat, "format", "text", "format of query results: text or json")
+	}
+	if cmd == "fix" {
+		// update doesn't apply fixes that delete or rename rules, so
+		// selecting them would do nothing.
+		fs.StringVar(&ucr.fixes, "fix", "", "comma-separated list of migrations to apply instead of all fixes. Run 'gazelle help fixes' for a list.")
+	}
+	if cmd == "fix" || cmd == "update" {
+		fs.BoolVar(&uc.cascade, "cascade", false, "when true, build files outside the given directories are also updated if they depend on rules that were removed, renamed, or changed imports")
+		fs.StringVar(&ucr.changedFiles, "changed_files", "", "file listing changed files, one per line, or - to read from stdin. Only packages affected by those files are updated. Paths are relative to the repository root.")
+	}
+	fs.Var(&gzflag.MultiFlag{Values: &ucr.langPlugins}, langPluginFlag, "executable that implements a language extension over the plugin protocol (can specify multiple times)")
+	fs.StringVar(&uc.cachePath, "cache", "", "file where Gazelle should cache generated rules. Directories whose inputs have not changed since the last run are not regenerated.")
 }
 
 func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
@@ -93,15 +157,101 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 	if !ok {
 		return fmt.Errorf("unrecognized emit mode: %q", ucr.mode)
 	}
+	if ucr.mode == "fix" {
+		uc.txn = newFileTxn()
+	}
+	if ucr.explain {
+		if ucr.mode != "fix" {
+			return fmt.Errorf("-mode may not be set with explain")
+		}
+		targets, err := parseExplainTargets(ucr.targets)
+		if err != nil {
+			return err
+		}
+		uc.emit = explainFile
+		uc.explainer = resolve.NewExplainer(targets, ucr.imports)
+		resolve.SetExplainer(c, uc.explainer)
+	}
 	if uc.patchPath != "" && ucr.mode != "diff" {
 		return fmt.Errorf("-patch set but -mode is %s, not diff", ucr.mode)
 	}
+	if ucr.mode == "check" {
+		switch uc.reportFormat {
+		case "json", "sarif", "junit":
+		default:
+			return fmt.Errorf("unrecognized report format: %q", uc.reportFormat)
+		}
+		uc.checkReport = &checkReport{}
+	} else if uc.reportPath != "" {
+		return fmt.Errorf("-report set but -mode is %s, not check", ucr.mode)
+	}
+	if uc.reportPath != "" && !filepath.IsAbs(uc.reportPath) {
+		uc.reportPath = filepath.Join(c.WorkDir, uc.reportPath)
+	}
 	if uc.patchPath != "" && !filepath.IsAbs(uc.patchPath) {
 		uc.patchPath = filepath.Join(c.WorkDir, uc.patchPath)
 	}
+	if uc.jobs < 1 {
+		return fmt.Errorf("-jobs must be at least 1, got %d", uc.jobs)
+	}
+	// Files read from another source can't be written back. Neither those
+	// nor overlaid files have modification times the cache could trust.
+	if source := fs.Lookup("source"); source != nil && source.Value.String() != "" && ucr.mode == "fix" {
+		return fmt.Errorf("-source may only be used with -mode=print, diff, or check")
+	}
+	if c.FileSystem() != vfs.OS && uc.cachePath != "" {
+		return fmt.Errorf("-cache may not be used with -source or -overlay")
+	}
+	if uc.cachePath != "" && !filepath.IsAbs(uc.cachePath) {
+		uc.cachePath = filepath.Join(c.WorkDir, uc.cachePath)
+	}
+	if ucr.fixes != "" {
+		ms, err := selectMigrations(ucr.fixes, languages)
+		if err != nil {
+			return err
+		}
+		uc.migrations = ms
+		uc.migrationReport = &migrationReport{}
+	}
 
 	dirs := fs.Args()
-	if len(dirs) == 0 {
+	if ucr.query {
+		if ucr.mode != "fix" {
+			return fmt.Errorf("-mode may not be set with query")
+		}
+		if uc.queryFormat != "text" && uc.queryFormat != "json" {
+			return fmt.Errorf("unrecognized query format: %q", uc.queryFormat)
+		}
+		if !c.IndexLibraries {
+			return fmt.Errorf("query requires -index=true")
+		}
+		var err error
+		if uc.queries, err = parseQueries(c, dirs); err != nil {
+			return err
+		}
+		dirs = []string{c.RepoRoot}
+	}
+	if uc.cascade && !c.IndexLibraries {
+		return fmt.Errorf("-cascade requires -index=true")
+	}
+	if ucr.changedFiles != "" {
+		if len(dirs) > 0 {
+			return fmt.Errorf("-changed_files may not be used with directory arguments")
+		}
+		if !c.IndexLibraries {
+			// Dependents of changed rules can't be found without the index.
+			return fmt.Errorf("-changed_files requires -index=true")
+		}
+		paths, err := loadChangedFiles(c, ucr.changedFiles)
+		if err != nil {
+			return fmt.Errorf("-changed_files: %v", err)
+		}
+		if uc.changes, err = newChangeSet(c, paths); err != nil {
+			return err
+		}
+		dirs = uc.changes.dirs
+		ucr.recursive = false
+	} else if len(dirs) == 0 {
 		dirs = []string{"."}
 	}
 	uc.dirs = make([]string, len(dirs))
@@ -110,7 +260,7 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 		if !filepath.IsAbs(dir) {
 			dir = filepath.Join(c.WorkDir, dir)
 		}
-		dir, err := filepath.EvalSymlinks(dir)
+		dir, err := c.FileSystem().EvalSymlinks(dir)
 		if err != nil {
 			return fmt.Errorf("%s: failed to resolve symlinks: %v", arg, err)
 		}
@@ -135,17 +285,28 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 	// dependency resolution for Go.
 	// TODO(jayconrod): Go-specific code should be moved to language/go.
 	if ucr.repoConfigPath == "" {
-		ucr.repoConfigPath = wspace.FindWORKSPACEFile(c.RepoRoot)
+		ucr.repoConfigPath = wspace.FindWORKSPACEFileFS(c.FileSystem(), c.RepoRoot)
 	}
-	repoConfigFile, err := rule.LoadWorkspaceFile(ucr.repoConfigPath, "")
+	repoConfigFile, err := loadWorkspaceFile(c, ucr.repoConfigPath)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	} else if err == nil {
-		c.Repos, _, err = repo.ListRepositories(repoConfigFile)
+		c.Repos, _, err = repo.ListRepositoriesFS(c.FileSystem(), repoConfigFile)
 		if err != nil {
 			return err
 		}
 	}
+	moduleFile, err := loadModuleFile(c)
+	if err != nil {
+		return err
+	}
+	if moduleFile != nil {
+		moduleRepos, err := repo.ListModuleRepositories(c.FileSystem(), moduleFile)
+		if err != nil {
+			return err
+		}
+		c.Repos = repo.MergeModuleRepositories(c.Repos, moduleRepos)
+	}
 	for _, imp := range ucr.knownImports {
 		uc.repos = append(uc.repos, repo.Repo{
 			Name:     label.ImportPathToBazelRepoName(imp),
@@ -163,19 +324,24 @@ func (ucr *updateConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) erro
 
 	// If the repo configuration file is not WORKSPACE, also load WORKSPACE
 	// and any declared macro files so we can apply fixes.
-	workspacePath := wspace.FindWORKSPACEFile(c.RepoRoot)
+	workspacePath := wspace.FindWORKSPACEFileFS(c.FileSystem(), c.RepoRoot)
 	var workspace *rule.File
 	if ucr.repoConfigPath == workspacePath {
 		workspace = repoConfigFile
 	} else {
-		workspace, err = rule.LoadWorkspaceFile(workspacePath, "")
+		workspace, err = loadWorkspaceFile(c, workspacePath)
 		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	}
 	if workspace != nil {
 		c.RepoName = findWorkspaceName(workspace)
-		_, repoFileMap, err := repo.ListRepositories(workspace)
+	}
+	if c.RepoName == "" && moduleFile != nil {
+		c.RepoName = moduleFile.RepoName
+	}
+	if workspace != nil {
+		_, repoFileMap, err := repo.ListRepositoriesFS(c.FileSystem(), workspace)
 		if err != nil {
 			return err
 		}
@@ -264,124 +430,87 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err == nil {
+			err = c.CheckStrict()
+		}
+	}()
 
 	if err := fixRepoFiles(c, loads); err != nil {
 		return err
 	}
 
-	// Visit all directories in the repository.
-	var visits []visitRecord
+	// Languages that implement lifecycle hooks are called with ctx between
+	// phases. ctx is cancelled on SIGINT or SIGTERM, or when we return.
+	ctx, cancel := hookContext(c)
+	defer cancel()
 	uc := getUpdateConfig(c)
-	walk.Walk(c, cexts, uc.dirs, uc.walkMode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
-		// If this file is ignored or if Gazelle was not asked to update this
-		// directory, just index the build file and move on.
-		if !update {
-			if c.IndexLibraries && f != nil {
-				for _, r := range f.Rules {
-					ruleIndex.AddRule(c, r, f)
-				}
-			}
-			return
-		}
-
-		// Fix any problems in the file.
-		if f != nil {
-			for _, l := range filterLanguages(c, languages) {
-				l.Fix(c, f)
-			}
-		}
-
-		// Generate rules.
-		var empty, gen []*rule.Rule
-		var imports []interface{}
-		for _, l := range filterLanguages(c, languages) {
-			res := l.GenerateRules(language.GenerateArgs{
-				Config:       c,
-				Dir:          dir,
-				Rel:          rel,
-				File:         f,
-				Subdirs:      subdirs,
-				RegularFiles: regularFiles,
-				GenFiles:     genFiles,
-				OtherEmpty:   empty,
-				OtherGen:     gen})
-			if len(res.Gen) != len(res.Imports) {
-				log.Panicf("%s: language %s generated %d rules but returned %d imports", rel, l.Name(), len(res.Gen), len(res.Imports))
-			}
-			empty = append(empty, res.Empty...)
-			gen = append(gen, res.Gen...)
-			imports = append(imports, res.Imports...)
-		}
-		if f == nil && len(gen) == 0 {
-			return
-		}
+	if uc.changes != nil {
+		uc.dirs = uc.changes.addTrees(c, cexts, uc.dirs)
+	}
+	if err := beforeWalk(ctx, language.BeforeWalkArgs{Config: c, Cmd: cmd.String(), Dirs: uc.dirs}); err != nil {
+		return err
+	}
 
-		// Apply and record relevant kind mappings.
-		var (
-			mappedKinds    []config.MappedKind
-			mappedKindInfo = make(map[string]rule.KindInfo)
-		)
-		for _, r := range gen {
-			if repl, ok := c.KindMap[r.Kind()]; ok {
-				mappedKindInfo[repl.KindName] = kinds[r.Kind()]
-				mappedKinds = append(mappedKinds, repl)
-				mrslv.MappedKind(rel, repl)
-				r.SetKind(repl.KindName)
-			}
+	// Visit all directories in the repository. Rules are generated in the
+	// background for up to uc.jobs directories at a time. Rules are indexed
+	// afterward in the order directories were visited, so the output doesn't
+	// depend on scheduling.
+	// The cache is not used with -fix, since cached directories would not be
+	// fixed.
+	var gc *genCache
+	if uc.cachePath != "" && uc.migrations == nil {
+		var err error
+		if gc, err = loadGenCache(uc.cachePath, languages); err != nil {
+			log.Print(err)
 		}
+	}
+	tasks := generateTasks(c, cexts, uc.dirs, uc.walkMode, gc, kinds, mrslv)
 
-		// Insert or merge rules into the build file.
-		if f == nil {
-			f = rule.EmptyFile(filepath.Join(dir, c.DefaultBuildFileName()), rel)
-			for _, r := range gen {
-				r.Insert(f)
-			}
-		} else {
-			merger.MergeFile(f, empty, gen, merger.PreResolve,
-				unionKindInfoMaps(kinds, mappedKindInfo))
-		}
-		visits = append(visits, visitRecord{
-			pkgRel:         rel,
-			c:              c,
-			rules:          gen,
-			imports:        imports,
-			empty:          empty,
-			file:           f,
-			mappedKinds:    mappedKinds,
-			mappedKindInfo: mappedKindInfo,
-		})
+	// With -cascade or -changed_files, rules that depend on rules that were
+	// removed, renamed, or changed are resolved again, even if nothing
+	// changed in their directories.
+	if uc.cascade || uc.changes != nil {
+		updateDependents(c, uc.changes, tasks, kinds, mrslv)
+	}
 
-		// Add library rules to the dependency resolution table.
-		if c.IndexLibraries {
-			for _, r := range f.Rules {
-				ruleIndex.AddRule(c, r, f)
-			}
-		}
-	})
+	// Add rules to the dependency resolution table, in the order directories
+	// were visited.
+	visits := indexTasks(c, ruleIndex, tasks)
 
 	// Finish building the index for dependency resolution.
 	ruleIndex.Finish()
 
-	// Resolve dependencies.
+	files := make([]*rule.File, 0, len(visits))
+	for _, v := range visits {
+		files = append(files, v.file)
+	}
+	if err := afterGenerate(ctx, language.AfterGenerateArgs{Config: c, Cmd: cmd.String(), Files: files, Index: ruleIndex}); err != nil {
+		return err
+	}
+
+	if uc.queries != nil {
+		return runQueries(c, ruleIndex, files, uc.queries, uc.queryFormat)
+	}
+
+	// Resolve dependencies. Each build file is resolved independently, so
+	// this is done concurrently.
 	rc, cleanupRc := repo.NewRemoteCache(uc.repos)
 	defer func() {
 		if cerr := cleanupRc(); err == nil && cerr != nil {
 			err = cerr
 		}
 	}()
-	for _, v := range visits {
-		for i, r := range v.rules {
-			from := label.New(c.RepoName, v.pkgRel, r.Name())
-			if rslv := mrslv.Resolver(r, v.pkgRel); rslv != nil {
-				rslv.Resolve(v.c, ruleIndex, rc, r, v.imports[i], from)
-			}
-		}
-		merger.MergeFile(v.file, v.empty, v.rules, merger.PostResolve,
-			unionKindInfoMaps(kinds, v.mappedKindInfo))
+	resolveVisits(c, ruleIndex, rc, mrslv, kinds, visits, uc.jobs)
+	if err := afterResolve(ctx, language.AfterResolveArgs{Config: c, Cmd: cmd.String(), Files: files}); err != nil {
+		return err
 	}
 
-	// Emit merged files.
+	// Emit merged files. With -mode=fix, files are only staged here. They're
+	// written together below if nothing went wrong, so a failure doesn't leave
+	// some build files updated and others stale.
 	var exit error
+	emitFailed := false
 	for _, v := range visits {
 		merger.FixLoads(v.file, applyKindMappings(v.mappedKinds, loads))
 		if err := uc.emit(v.c, v.file); err != nil {
@@ -389,18 +518,317 @@ func runFixUpdate(wd string, cmd command, args []string) (err error) {
 				exit = err
 			} else {
 				log.Print(err)
+				emitFailed = true
 			}
 		}
 	}
+	if uc.txn != nil {
+		if emitFailed {
+			return errors.New("errors occurred while emitting build files; no files were written")
+		}
+		if err := uc.txn.commitWithBackup(c.RepoRoot); err != nil {
+			return err
+		}
+	}
+	if uc.migrationReport != nil {
+		if err := uc.migrationReport.print(os.Stderr, uc.migrations); err != nil {
+			return err
+		}
+	}
 	if uc.patchPath != "" {
 		if err := ioutil.WriteFile(uc.patchPath, uc.patchBuffer.Bytes(), 0666); err != nil {
 			return err
 		}
 	}
+	if gc != nil {
+		if err := gc.save(); err != nil {
+			log.Printf("error writing cache: %v", err)
+		}
+	}
+	if uc.explainer != nil {
+		xs := uc.explainer.Explanations()
+		if len(xs) == 0 {
+			log.Print("no matching imports were resolved")
+		}
+		if err := printExplanations(os.Stdout, xs); err != nil {
+			return err
+		}
+	}
+	if uc.checkReport != nil {
+		if err := writeCheckReport(uc.checkReport, uc.reportFormat, uc.reportPath); err != nil {
+			return err
+		}
+		if uc.checkReport.hasErrors() {
+			return fmt.Errorf("encountered errors while running check")
+		}
+		if uc.checkReport.isStale() {
+			return staleError
+		}
+	}
 
 	return exit
 }
 
+// dirTask records a directory visited by walk.Walk in generateTasks.
+type dirTask struct {
+	dir  string
+	rel  string
+	c    *config.Config
+	file *rule.File
+
+	// subdirs, regularFiles, and genFiles are the files Walk found in dir.
+	subdirs, regularFiles, genFiles []string
+
+	// indexOnly is true if the directory should not be updated. Rules in
+	// the existing build file may still be indexed.
+	indexOnly bool
+
+	// visit holds rules generated for the directory. It is nil if the directory
+	// is indexOnly or if no build file should be written.
+	visit *visitRecord
+
+	// key identifies the inputs for the directory in genCache. It is nil if
+	// the cache is disabled or the directory can't be cached.
+	key []byte
+
+	// done is closed when visit is ready.
+	done chan struct{}
+}
+
+// generateTasks visits dirs with walk.Walk in the given mode and generates
+// rules in directories that should be updated. Rules are generated in the
+// background for up to uc.jobs directories at a time. Tasks are returned in
+// the order directories were visited; callers must wait for each task's
+// done channel before reading its results.
+func generateTasks(c *config.Config, cexts []config.Configurer, dirs []string, mode walk.Mode, gc *genCache, kinds map[string]rule.KindInfo, mrslv *metaResolver) []*dirTask {
+	uc := getUpdateConfig(c)
+	var tasks []*dirTask
+	sem := make(chan struct{}, uc.jobs)
+	walk.Walk(c, cexts, dirs, mode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
+		t := &dirTask{
+			dir:          dir,
+			rel:          rel,
+			c:            c,
+			file:         f,
+			subdirs:      subdirs,
+			regularFiles: regularFiles,
+			genFiles:     genFiles,
+			done:         make(chan struct{}),
+		}
+
+		// Directories are visited in post-order, so tasks for subdirectories
+		// are at the end of the list. Languages may depend on results from
+		// subdirectories, so we wait for those before generating rules here.
+		var deps []*dirTask
+		for i := len(tasks) - 1; i >= 0 && pathtools.HasPrefix(tasks[i].rel, rel); i-- {
+			deps = append(deps, tasks[i])
+		}
+		tasks = append(tasks, t)
+
+		// If this file is ignored or if Gazelle was not asked to update this
+		// directory, just index the build file later and move on.
+		if !update {
+			t.indexOnly = true
+			close(t.done)
+			return
+		}
+
+		var configHash []byte
+		if gc != nil && gc.cacheable(c) {
+			configHash = gc.hasher.hash(c)
+		}
+		gen := func() {
+			defer close(t.done)
+			if gc == nil {
+				t.visit, _ = generateDir(c, dir, rel, f, subdirs, regularFiles, genFiles, kinds, mrslv)
+				return
+			}
+			var childKeys [][]byte
+			for _, d := range deps {
+				if !d.indexOnly {
+					childKeys = append(childKeys, d.key)
+				}
+			}
+			key, stamps := gc.dirKey(rel, configHash, dir, f, subdirs, regularFiles, genFiles, childKeys)
+			t.key = key
+			if v, ok := gc.restore(key, c, dir, rel, f, subdirs, regularFiles, genFiles, kinds, mrslv); ok {
+				t.visit = v
+				return
+			}
+			var results []langResult
+			t.visit, results = generateDir(c, dir, rel, f, subdirs, regularFiles, genFiles, kinds, mrslv)
+			gc.store(key, stamps, rel, t.visit, results)
+		}
+		if uc.jobs == 1 {
+			gen()
+			return
+		}
+		go func() {
+			for _, d := range deps {
+				<-d.done
+			}
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			gen()
+		}()
+	})
+	return tasks
+}
+
+// indexTasks waits for each task to finish, then adds rules from its build
+// file to ix, in order. Visit records for directories that were updated are
+// returned.
+func indexTasks(c *config.Config, ix *resolve.RuleIndex, tasks []*dirTask) []visitRecord {
+	var visits []visitRecord
+	for _, t := range tasks {
+		<-t.done
+		if t.indexOnly {
+			if c.IndexLibraries && t.file != nil {
+				for _, r := range t.file.Rules {
+					ix.AddRule(t.c, r, t.file)
+				}
+			}
+			continue
+		}
+		if t.visit == nil {
+			continue
+		}
+		visits = append(visits, *t.visit)
+		if c.IndexLibraries {
+			for _, r := range t.visit.file.Rules {
+				ix.AddRule(t.visit.c, r, t.visit.file)
+			}
+		}
+	}
+	return visits
+}
+
+// resolveVisits resolves dependencies of generated rules and merges them
+// into build files. Each build file is resolved independently, so this is
+// done concurrently.
+func resolveVisits(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, mrslv *metaResolver, kinds map[string]rule.KindInfo, visits []visitRecord, jobs int) {
+	runJobs(len(visits), jobs, func(i int) {
+		v := &visits[i]
+		for i, r := range v.rules {
+			from := label.New(c.RepoName, v.pkgRel, r.Name())
+			if rslv := mrslv.Resolver(r, v.pkgRel); rslv != nil {
+				rslv.Resolve(v.c, ix, rc, r, v.imports[i], from)
+			}
+		}
+		merger.MergeFile(v.file, v.empty, v.rules, merger.PostResolve,
+			unionKindInfoMaps(kinds, v.mappedKindInfo))
+	})
+}
+
+// generateDir fixes the build file in a directory, generates rules with each
+// language, and merges them into the build file. A nil visitRecord is
+// returned if there is no build file and nothing was generated. The results
+// from each language are also returned.
+//
+// generateDir may be called concurrently for different directories.
+func generateDir(c *config.Config, dir, rel string, f *rule.File, subdirs, regularFiles, genFiles []string, kinds map[string]rule.KindInfo, mrslv *metaResolver) (*visitRecord, []langResult) {
+	// Fix any problems in the file. If migrations were selected with -fix,
+	// only those are applied.
+	if f != nil {
+		if uc := getUpdateConfig(c); uc.migrations != nil {
+			applyMigrations(c, f, uc.migrations, uc.migrationReport)
+		} else {
+			for _, l := range filterLanguages(c, languages) {
+				l.Fix(c, f)
+			}
+		}
+	}
+
+	// Generate rules.
+	var empty, gen []*rule.Rule
+	var imports []interface{}
+	var results []langResult
+	for _, l := range filterLanguages(c, languages) {
+		res := l.GenerateRules(language.GenerateArgs{
+			Config:       c,
+			Dir:          dir,
+			Rel:          rel,
+			File:         f,
+			Subdirs:      subdirs,
+			RegularFiles: walk.FilterLangFiles(c, l.Name(), rel, regularFiles),
+			GenFiles:     genFiles,
+			OtherEmpty:   empty,
+			OtherGen:     gen})
+		if len(res.Gen) != len(res.Imports) {
+			log.Panicf("%s: language %s generated %d rules but returned %d imports", rel, l.Name(), len(res.Gen), len(res.Imports))
+		}
+		results = append(results, langResult{lang: l, res: res})
+		empty = append(empty, res.Empty...)
+		gen = append(gen, res.Gen...)
+		imports = append(imports, res.Imports...)
+	}
+	if f == nil && len(gen) == 0 {
+		return nil, results
+	}
+
+	// Apply and record relevant kind mappings.
+	var (
+		mappedKinds    []config.MappedKind
+		mappedKindInfo = make(map[string]rule.KindInfo)
+	)
+	for _, r := range gen {
+		if repl, ok := c.KindMap[r.Kind()]; ok {
+			mappedKindInfo[repl.KindName] = kinds[r.Kind()]
+			mappedKinds = append(mappedKinds, repl)
+			mrslv.MappedKind(rel, repl)
+			r.SetKind(repl.KindName)
+		}
+	}
+
+	// Insert or merge rules into the build file.
+	if f == nil {
+		f = rule.EmptyFile(filepath.Join(dir, c.DefaultBuildFileName()), rel)
+		for _, r := range gen {
+			r.Insert(f)
+		}
+	} else {
+		merger.MergeFileWithDiagnostics(f, empty, gen, merger.PreResolve,
+			unionKindInfoMaps(kinds, mappedKindInfo), c.Diagnostics)
+	}
+	return &visitRecord{
+		pkgRel:         rel,
+		c:              c,
+		rules:          gen,
+		imports:        imports,
+		empty:          empty,
+		file:           f,
+		mappedKinds:    mappedKinds,
+		mappedKindInfo: mappedKindInfo,
+	}, results
+}
+
+// runJobs calls f for each integer in [0, n), using up to jobs goroutines.
+// runJobs returns after all calls have returned.
+func runJobs(n, jobs int, f func(i int)) {
+	if jobs <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	ch := make(chan int)
+	for j := 0; j < jobs && j < n; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+}
+
 func newFixUpdateConfiguration(wd string, cmd command, args []string, cexts []config.Configurer) (*config.Config, error) {
 	c := config.New()
 	c.WorkDir = wd
@@ -416,7 +844,17 @@ func newFixUpdateConfiguration(wd string, cmd command, args []string, cexts []co
 
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
-			fixUpdateUsage(fs)
+			if cmd == explainCmd {
+				explainUsage(fs)
+			} else if cmd == queryCmd {
+				queryUsage(fs)
+			} else if cmd == watchCmd {
+				watchUsage(fs)
+			} else if cmd == serveCmd {
+				serveUsage(fs)
+			} else {
+				fixUpdateUsage(fs)
+			}
 			return nil, err
 		}
 		// flag already prints the error; don't print it again.
@@ -448,6 +886,10 @@ output mode determines what Gazelle does with updated BUILD files.
   fix (default) - write updated BUILD files back to disk.
   print - print updated BUILD files to stdout.
   diff - diff updated BUILD files against existing files in unified format.
+  check - report BUILD files that would be changed without changing them.
+      The report is written in the format given by -report_format (json,
+      sarif, or junit) to stdout or to the file named by -report. Gazelle
+      exits with status 3 if any file is stale and 1 if an error occurs.
 
 Gazelle accepts a list of paths to Go package directories to process (defaults
 to the working directory if none are given). It recursively traverses
@@ -463,7 +905,8 @@ FLAGS:
 
 func fixRepoFiles(c *config.Config, loads []rule.LoadInfo) error {
 	uc := getUpdateConfig(c)
-	if !c.ShouldFix {
+	if !c.ShouldFix || uc.migrations != nil {
+		// Repository files are only fixed when all migrations are applied.
 		return nil
 	}
 	shouldFix := false
@@ -507,6 +950,28 @@ func removeLegacyGoRepository(f *rule.File) {
 	}
 }
 
+// loadWorkspaceFile reads a WORKSPACE file from c.FS.
+func loadWorkspaceFile(c *config.Config, path string) (*rule.File, error) {
+	data, err := c.FileSystem().ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return rule.LoadWorkspaceData(path, "", data)
+}
+
+// loadModuleFile reads MODULE.bazel in the repository root from c.FS. nil is
+// returned if the file doesn't exist.
+func loadModuleFile(c *config.Config) (*repo.ModuleFile, error) {
+	path := filepath.Join(c.RepoRoot, "MODULE.bazel")
+	data, err := c.FileSystem().ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return repo.LoadModuleData(path, data)
+}
+
 func findWorkspaceName(f *rule.File) string {
 	var name string
 	for _, r := range f.Rules {
@@ -17,26 +17,19 @@ package main
 
 import (
 	"bytes"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
+// fixFile stages f to be written. Files aren't written until all build files
+// have been emitted without errors; see fileTxn.
 func fixFile(c *config.Config, f *rule.File) error {
 	newContent := f.Format()
 	if bytes.Equal(f.Content, newContent) {
 		return nil
 	}
-	outPath := findOutputPath(c, f)
-	if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile(outPath, newContent, 0666); err != nil {
-		return err
-	}
+	getUpdateConfig(c).txn.write(findOutputPath(c, f), newContent)
 	f.Content = newContent
 	return nil
 }
@@ -90,6 +90,7 @@ func TestMain(m *testing.M) {
 	}
 	os.Setenv("GOCACHE", filepath.Join(tmpDir, "gocache"))
 	os.Setenv("GOPATH", filepath.Join(tmpDir, "gopath"))
+	os.Setenv(backupDirEnv, filepath.Join(tmpDir, "backups"))
 
 	status = m.Run()
 }
@@ -34,13 +34,25 @@ const (
 	fixCmd
 	updateReposCmd
 	helpCmd
+	explainCmd
+	queryCmd
+	configCmd
+	revertCmd
+	watchCmd
+	serveCmd
 )
 
 var commandFromName = map[string]command{
+	"config":       configCmd,
+	"explain":      explainCmd,
 	"fix":          fixCmd,
 	"help":         helpCmd,
+	"query":        queryCmd,
+	"revert":       revertCmd,
+	"serve":        serveCmd,
 	"update":       updateCmd,
 	"update-repos": updateReposCmd,
+	"watch":        watchCmd,
 }
 
 var nameFromCommand = []string{
@@ -49,6 +61,12 @@ var nameFromCommand = []string{
 	"fix",
 	"update-repos",
 	"help",
+	"explain",
+	"query",
+	"config",
+	"revert",
+	"watch",
+	"serve",
 }
 
 func (cmd command) String() string {
@@ -72,6 +90,8 @@ func main() {
 	if err := run(wd, os.Args[1:]); err != nil && err != flag.ErrHelp {
 		if err == exitError {
 			os.Exit(1)
+		} else if err == staleError {
+			os.Exit(staleExitCode)
 		} else {
 			log.Fatal(err)
 		}
@@ -91,12 +111,41 @@ func run(wd string, args []string) error {
 	}
 
 	switch cmd {
-	case fixCmd, updateCmd:
+	case fixCmd, updateCmd, explainCmd, queryCmd:
+		stop, err := startLangPlugins(wd, args)
+		if err != nil {
+			return err
+		}
+		defer stop()
 		return runFixUpdate(wd, cmd, args)
+	case watchCmd:
+		stop, err := startLangPlugins(wd, args)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		return runWatch(wd, args)
+	case serveCmd:
+		stop, err := startLangPlugins(wd, args)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		return runServe(wd, args)
 	case helpCmd:
+		if len(args) > 0 && args[0] == "directives" {
+			return helpDirectives(wd, args[1:])
+		}
+		if len(args) > 0 && args[0] == "fixes" {
+			return helpFixes(wd, args[1:])
+		}
 		return help()
 	case updateReposCmd:
 		return updateRepos(wd, args)
+	case configCmd:
+		return runConfig(wd, args)
+	case revertCmd:
+		return runRevert(wd, args)
 	default:
 		log.Panicf("unknown command: %v", cmd)
 	}
@@ -122,7 +171,21 @@ Gazelle defaults to "update".
       existing rules.
   update-repos - updates repository rules in the WORKSPACE file. Run with
       -h for details.
-  help - show this message.
+  explain - prints how dependencies of rules were resolved, without changing
+      any files. Run with -h for details.
+  query - prints which rules provide an import, own a source file, or embed
+      other rules, without changing any files. Run with -h for details.
+  config - prints the directives in effect in a directory and the build
+      files that set them. Run with -h for details.
+  watch - updates build files like update, then keeps running and updates
+      them again whenever files change. Run with -h for details.
+  serve - runs a JSON-RPC server on stdin and stdout that returns the edits
+      Gazelle would make to build files without writing them. Run with -h
+      for details.
+  revert - restores the files changed by the last run of Gazelle. Run with
+      -h for details.
+  help - show this message. Run "gazelle help directives" for documentation
+      on directives or "gazelle help fixes" for a list of fixes.
 
 For usage information for a specific command, run the command with the -h flag.
 For example:
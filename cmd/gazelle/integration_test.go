@@ -57,6 +57,12 @@ func TestHelp(t *testing.T) {
 		{"fix", "-h"},
 		{"update", "-h"},
 		{"update-repos", "-h"},
+		{"config", "-h"},
+		{"help", "directives", "-h"},
+		{"help", "fixes", "-h"},
+		{"revert", "-h"},
+		{"watch", "-h"},
+		{"serve", "-h"},
 	} {
 		t.Run(args[0], func(t *testing.T) {
 			if err := runGazelle(".", args); err == nil {
@@ -3139,6 +3145,149 @@ go_repository(
 	})
 }
 
+func TestUpdateReposToModule(t *testing.T) {
+	files := []testtools.FileSpec{
+		{
+			Path: "MODULE.bazel",
+			Content: `
+module(name = "importcases")
+
+bazel_dep(name = "gazelle", version = "0.30.0", repo_name = "bazel_gazelle")
+
+go_deps = use_extension("@bazel_gazelle//:extensions.bzl", "go_deps")
+go_deps.module(
+    path = "example.com/old",
+    version = "v1.0.0",
+)
+go_deps.module(
+    path = "github.com/Selvatico/go-mocket",
+    build_file_proto_mode = "disable",
+    version = "v1.0.6",
+)
+use_repo(go_deps, "com_example_old", mocket = "com_github_selvatico_go_mocket")
+`,
+		},
+		{
+			Path: "go.mod",
+			Content: `
+module github.com/linzhp/go_examples/importcases
+
+go 1.13
+
+require github.com/Selvatico/go-mocket v1.0.7
+`,
+		},
+		{
+			Path: "go.sum",
+			Content: `
+github.com/Selvatico/go-mocket v1.0.7/go.mod h1:4gO2v+uQmsL+jzQgLANy3tyEFzaEzHlymVbZ3GP2Oes=
+`,
+		},
+		{
+			Path: "BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "lib",
+    deps = ["@mocket//:go-mocket"],
+)
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	args := []string{"update-repos", "-from_file=go.mod", "-to_module", "-prune"}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "MODULE.bazel",
+			Content: `
+module(name = "importcases")
+
+bazel_dep(name = "gazelle", version = "0.30.0", repo_name = "bazel_gazelle")
+
+go_deps = use_extension("@bazel_gazelle//:extensions.bzl", "go_deps")
+
+go_deps.module(
+    path = "github.com/Selvatico/go-mocket",
+    build_file_proto_mode = "disable",
+    version = "v1.0.7",
+    sum = "h1:sXuFMnMfVL9b/Os8rGXPgbOFbr4HJm8aHsulD/uMTUk=",
+)
+use_repo(go_deps, mocket = "com_github_selvatico_go_mocket")
+`,
+		},
+		{Path: "WORKSPACE", NotExist: true},
+	})
+}
+
+func TestUpdateReposToModuleReplace(t *testing.T) {
+	moduleFile := testtools.FileSpec{
+		Path: "MODULE.bazel",
+		Content: `
+module(name = "importcases")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+`,
+	}
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		moduleFile,
+		{
+			Path: "go.mod",
+			Content: `
+module github.com/linzhp/go_examples/importcases
+
+go 1.13
+
+require github.com/selvatico/go-mocket v0.0.0-00010101000000-000000000000
+
+replace github.com/selvatico/go-mocket => github.com/Selvatico/go-mocket v1.0.7
+`,
+		},
+		{
+			Path: "go.sum",
+			Content: `
+github.com/Selvatico/go-mocket v1.0.7/go.mod h1:4gO2v+uQmsL+jzQgLANy3tyEFzaEzHlymVbZ3GP2Oes=
+`,
+		},
+	})
+	defer cleanup()
+
+	// go_deps.module tags can't express replacements, so nothing is written.
+	args := []string{"update-repos", "-from_file=go.mod", "-to_module"}
+	want := "github.com/selvatico/go-mocket is replaced by github.com/Selvatico/go-mocket"
+	if err := runGazelle(dir, args); err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v; want %q", err, want)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{moduleFile})
+}
+
+func TestUpdateReposToModuleErrors(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"update-repos", "-to_module", "example.com/m"},
+			want: "-to_module: MODULE.bazel not found",
+		}, {
+			args: []string{"update-repos", "-to_module", "-to_macro=deps.bzl%deps", "example.com/m"},
+			want: "-to_module and -to_macro may not be used together",
+		},
+	} {
+		if err := runGazelle(dir, tc.args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: got error %v; want %q", tc.args, err, tc.want)
+		}
+	}
+}
+
 // TestUpdateReposWithGlobalBuildTags is a regresion test for issue #711.
 // It also ensures that existings build_tags get merged with requested build_tags.
 func TestUpdateReposWithGlobalBuildTags(t *testing.T) {
@@ -3419,6 +3568,101 @@ go_library(
 	})
 }
 
+func TestExcludeLang(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:prefix example.com/repo
+# gazelle:exclude_lang proto pb
+# gazelle:exclude_lang go **/*_mock.go
+`},
+		{Path: "pb/pb.go", Content: "package pb"},
+		{Path: "pb/pb_mock.go", Content: "package pb"},
+		{Path: "pb/pb.proto", Content: `syntax = "proto3";`},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "pb/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "pb",
+    srcs = ["pb.go"],
+    importpath = "example.com/repo/pb",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+// TestModuleApparentNames checks that repositories declared in MODULE.bazel
+// are found without a WORKSPACE file and that dependencies on them use the
+// apparent names given in use_repo.
+func TestModuleApparentNames(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "MODULE.bazel", Content: `
+module(name = "repo")
+
+bazel_dep(name = "rules_go", version = "0.39.1", repo_name = "io_bazel_rules_go")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+go_deps.from_file(go_mod = "//:go.mod")
+go_deps.module(
+    path = "example.com/other",
+    version = "v1.0.0",
+)
+use_repo(
+    go_deps,
+    "com_example_other",
+    dep = "com_example_dep",
+)
+`},
+		{Path: "go.mod", Content: `module example.com/repo
+
+go 1.16
+
+require example.com/dep v1.2.3
+`},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+		{Path: "lib/lib.go", Content: `package lib
+
+import (
+	_ "example.com/dep/sub"
+	_ "example.com/other"
+)
+`},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(filepath.Join(dir, "lib"), []string{"-external=external"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "lib",
+    srcs = ["lib.go"],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+    deps = [
+        "@com_example_other//:other",
+        "@dep//sub",
+    ],
+)
+`,
+	}})
+}
+
 func TestUpdateRepos_LangFilter(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{Path: "WORKSPACE"},
@@ -4009,3 +4253,261 @@ package foo
 		},
 	})
 }
+
+// TestJobsDeterministic checks that build files generated with -jobs set
+// to a large number are the same as those generated with -jobs=1.
+func TestJobsDeterministic(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+	}
+	for i := 0; i < 20; i++ {
+		pkg := fmt.Sprintf("pkg%d", i)
+		var imports string
+		for j := 0; j < i; j += 3 {
+			imports += fmt.Sprintf("import _ \"example.com/repo/pkg%d/sub\"\n", j)
+		}
+		files = append(files, []testtools.FileSpec{
+			{
+				Path:    pkg + "/" + pkg + ".go",
+				Content: "package " + pkg + "\n" + imports,
+			}, {
+				Path:    pkg + "/sub/sub.go",
+				Content: "package sub\n\nimport _ \"example.com/repo/" + pkg + "/testdata\"\n",
+			}, {
+				Path:    pkg + "/testdata/data.go",
+				Content: "package testdata",
+			}, {
+				Path:    pkg + "/sub/sub_test.go",
+				Content: "package sub",
+			}, {
+				Path:    pkg + "/sub/testdata/data.txt",
+				Content: "data",
+			},
+		}...)
+	}
+
+	var results [][]byte
+	for _, jobs := range []int{1, 8} {
+		dir, cleanup := testtools.CreateFiles(t, files)
+		defer cleanup()
+		if err := runGazelle(dir, []string{fmt.Sprintf("-jobs=%d", jobs)}); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.Name() != "BUILD.bazel" {
+				return err
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			fmt.Fprintf(&buf, ">>> %s\n%s", filepath.ToSlash(rel), content)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, buf.Bytes())
+	}
+	if !bytes.Equal(results[0], results[1]) {
+		t.Errorf("-jobs=1:\n%s\n-jobs=8:\n%s", results[0], results[1])
+	}
+	if !bytes.Contains(results[0], []byte(`data = glob(["testdata/**"])`)) {
+		t.Errorf("testdata was not treated as data:\n%s", results[0])
+	}
+}
+
+// TestCache checks that rules restored from the cache match rules that are
+// generated, and that the cache is invalidated when sources or directives
+// in parent directories change.
+func TestCache(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+		{
+			Path: "a/a.go",
+			Content: `package a
+
+import _ "example.com/repo/b"
+`,
+		}, {
+			Path:    "b/b.go",
+			Content: "package b",
+		}, {
+			Path:    "b/b_test.go",
+			Content: "package b",
+		}, {
+			Path:    "b/testdata/x.txt",
+			Content: "x",
+		}, {
+			Path:    "c/c.proto",
+			Content: `syntax = "proto3";`,
+		},
+	})
+	defer cleanup()
+
+	args := []string{"-cache=gazelle.cache", "-go_naming_convention=import"}
+	wantA := `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = ["//b"],
+)
+`
+	wantB := `
+load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "b",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/b",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "b_test",
+    srcs = ["b_test.go"],
+    data = glob(["testdata/**"]),
+    embed = [":b"],
+)
+`
+	wantC := `
+load("@rules_proto//proto:defs.bzl", "proto_library")
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+proto_library(
+    name = "c_proto",
+    srcs = ["c.proto"],
+    visibility = ["//visibility:public"],
+)
+
+go_proto_library(
+    name = "c_go_proto",
+    importpath = "example.com/repo/c",
+    proto = ":c_proto",
+    visibility = ["//visibility:public"],
+)
+
+go_library(
+    name = "c",
+    embed = [":c_go_proto"],
+    importpath = "example.com/repo/c",
+    visibility = ["//visibility:public"],
+)
+`
+	for i := 0; i < 2; i++ {
+		if err := runGazelle(dir, args); err != nil {
+			t.Fatal(err)
+		}
+		testtools.CheckFiles(t, dir, []testtools.FileSpec{
+			{Path: "a/BUILD.bazel", Content: wantA},
+			{Path: "b/BUILD.bazel", Content: wantB},
+			{Path: "c/BUILD.bazel", Content: wantC},
+		})
+	}
+
+	// Replace a source file with one that has the same size and modification
+	// time. Gazelle should trust the cache and not read the file. Flags that
+	// don't affect generation, like -strict, shouldn't invalidate the cache.
+	bPath := filepath.Join(dir, "b", "b.go")
+	bInfo, err := os.Stat(bPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bPath, []byte("package c"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(bPath, bInfo.ModTime(), bInfo.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, append(args, "-strict")); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{Path: "b/BUILD.bazel", Content: wantB}})
+	if err := ioutil.WriteFile(bPath, []byte("package b"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change a source file. Only that package should change.
+	if err := ioutil.WriteFile(filepath.Join(dir, "a", "a.go"), []byte("package a\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "a/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+)
+`,
+		},
+		{Path: "b/BUILD.bazel", Content: wantB},
+	})
+
+	// Change a directive in the root directory. Subdirectories should be
+	// regenerated.
+	if err := ioutil.WriteFile(filepath.Join(dir, "BUILD.bazel"), []byte("# gazelle:prefix example.com/other\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, args); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "b/BUILD.bazel", Content: strings.Replace(wantB, "example.com/repo", "example.com/other", -1)},
+		{Path: "c/BUILD.bazel", Content: strings.Replace(wantC, "example.com/repo", "example.com/other", -1)},
+	})
+}
+
+func TestStrict(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:go_prefx example.com/typo
+`,
+		}, {
+			Path:    "a/x.go",
+			Content: "package x\n",
+		}, {
+			Path:    "a/y.go",
+			Content: "package y\n",
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	// Without -strict, warnings are printed, but Gazelle succeeds.
+	if err := runGazelle(dir, []string{"-mode=print"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantErr := "2 warning(s) or error(s) reported; failing because -strict is set"
+	if err := runGazelle(dir, []string{"-mode=print", "-strict"}); err == nil || err.Error() != wantErr {
+		t.Errorf("got error %v; want %q", err, wantErr)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a", "y.go"), []byte("package x\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	wantErr = "1 warning(s) or error(s) reported; failing because -strict is set"
+	if err := runGazelle(dir, []string{"-mode=print", "-strict"}); err == nil || err.Error() != wantErr {
+		t.Errorf("got error %v; want %q", err, wantErr)
+	}
+}
@@ -0,0 +1,102 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
+
+// hookContext returns a context to pass to lifecycle hooks. It's cancelled
+// when the returned function is called. If any language enabled in c
+// implements a hook, it's also cancelled when Gazelle receives SIGINT or
+// SIGTERM, so hooks can stop early. After the first signal, signals are
+// handled normally again, so a second interrupt stops Gazelle immediately.
+// Signals aren't intercepted otherwise, since nothing would notice.
+func hookContext(c *config.Config) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !hasHooks(c) {
+		return ctx, cancel
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigCh)
+	}()
+	return ctx, cancel
+}
+
+// hasHooks returns whether any language enabled in c implements a lifecycle
+// hook.
+func hasHooks(c *config.Config) bool {
+	for _, l := range filterLanguages(c, languages) {
+		switch l.(type) {
+		case language.BeforeWalker, language.AfterGenerator, language.AfterResolver:
+			return true
+		}
+	}
+	return false
+}
+
+// beforeWalk calls BeforeWalk on languages that implement
+// language.BeforeWalker.
+func beforeWalk(ctx context.Context, args language.BeforeWalkArgs) error {
+	for _, l := range filterLanguages(args.Config, languages) {
+		if h, ok := l.(language.BeforeWalker); ok {
+			if err := h.BeforeWalk(ctx, args); err != nil {
+				return fmt.Errorf("%s: before walk: %v", l.Name(), err)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// afterGenerate calls AfterGenerate on languages that implement
+// language.AfterGenerator.
+func afterGenerate(ctx context.Context, args language.AfterGenerateArgs) error {
+	for _, l := range filterLanguages(args.Config, languages) {
+		if h, ok := l.(language.AfterGenerator); ok {
+			if err := h.AfterGenerate(ctx, args); err != nil {
+				return fmt.Errorf("%s: after generate: %v", l.Name(), err)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// afterResolve calls AfterResolve on languages that implement
+// language.AfterResolver.
+func afterResolve(ctx context.Context, args language.AfterResolveArgs) error {
+	for _, l := range filterLanguages(args.Config, languages) {
+		if h, ok := l.(language.AfterResolver); ok {
+			if err := h.AfterResolve(ctx, args); err != nil {
+				return fmt.Errorf("%s: after resolve: %v", l.Name(), err)
+			}
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,192 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+// hookLang is a language that generates nothing and records calls to
+// lifecycle hooks.
+type hookLang struct {
+	calls    []string
+	ctxs     []context.Context
+	failOn   string
+	repoRoot string
+}
+
+func (*hookLang) Name() string                                                 { return "hook" }
+func (*hookLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {}
+func (*hookLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error          { return nil }
+func (*hookLang) KnownDirectives() []string                                    { return nil }
+func (*hookLang) Configure(c *config.Config, rel string, f *rule.File)         {}
+func (*hookLang) Kinds() map[string]rule.KindInfo                              { return nil }
+func (*hookLang) Loads() []rule.LoadInfo                                       { return nil }
+func (*hookLang) Fix(c *config.Config, f *rule.File)                           {}
+func (*hookLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	return language.GenerateResult{}
+}
+func (*hookLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	return nil
+}
+func (*hookLang) Embeds(r *rule.Rule, from label.Label) []label.Label { return nil }
+func (*hookLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+}
+
+func (l *hookLang) record(ctx context.Context, call string) error {
+	l.calls = append(l.calls, call)
+	l.ctxs = append(l.ctxs, ctx)
+	if strings.HasPrefix(call, l.failOn+" ") {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (l *hookLang) relFiles(files []*rule.File) string {
+	var rels []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(l.repoRoot, f.Path)
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	return strings.Join(rels, ",")
+}
+
+func (l *hookLang) BeforeWalk(ctx context.Context, args language.BeforeWalkArgs) error {
+	return l.record(ctx, fmt.Sprintf("before_walk %s dirs=%d", args.Cmd, len(args.Dirs)))
+}
+
+func (l *hookLang) AfterGenerate(ctx context.Context, args language.AfterGenerateArgs) error {
+	return l.record(ctx, fmt.Sprintf("after_generate %s files=%s index=%v", args.Cmd, l.relFiles(args.Files), args.Index != nil))
+}
+
+func (l *hookLang) AfterResolve(ctx context.Context, args language.AfterResolveArgs) error {
+	return l.record(ctx, fmt.Sprintf("after_resolve %s files=%s", args.Cmd, l.relFiles(args.Files)))
+}
+
+// withLang adds l to the list of languages until the returned function
+// is called.
+func withLang(l language.Language) func() {
+	old := languages
+	languages = append(append([]language.Language(nil), languages...), l)
+	return func() { languages = old }
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE", Content: "# gazelle:repo bazel_gazelle\n"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo\n"},
+		{Path: "a/a.go", Content: "package a\n"},
+		{
+			Path: "Gopkg.lock",
+			Content: `[[projects]]
+  name = "github.com/pkg/errors"
+  packages = ["."]
+  revision = "645ef00459ed84a119197bfb8d8205042c6df63d"
+`,
+		},
+	})
+	defer cleanup()
+
+	l := &hookLang{repoRoot: dir}
+	defer withLang(l)()
+
+	if err := runGazelle(dir, []string{"update"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGazelle(dir, []string{"update-repos", "-build_file_generation=off", "-from_file=Gopkg.lock"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"before_walk update dirs=1",
+		"after_generate update files=a/BUILD.bazel,BUILD.bazel index=true",
+		"after_resolve update files=a/BUILD.bazel,BUILD.bazel",
+		"before_walk update-repos dirs=0",
+		"after_generate update-repos files=WORKSPACE index=false",
+		"after_resolve update-repos files=WORKSPACE",
+	}
+	if !reflect.DeepEqual(l.calls, want) {
+		t.Errorf("got calls:\n%s\nwant:\n%s", strings.Join(l.calls, "\n"), strings.Join(want, "\n"))
+	}
+	for i, ctx := range l.ctxs {
+		if ctx.Err() == nil {
+			t.Errorf("context for %q was not cancelled after the run", l.calls[i])
+		}
+	}
+}
+
+func TestLifecycleHookError(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo\n"},
+		{Path: "a/a.go", Content: "package a\n"},
+	})
+	defer cleanup()
+
+	l := &hookLang{repoRoot: dir, failOn: "after_generate"}
+	defer withLang(l)()
+
+	err := runGazelle(dir, []string{"update"})
+	if err == nil || err.Error() != "hook: after generate: failed" {
+		t.Errorf("got error %v; want hook error", err)
+	}
+	if len(l.calls) != 2 {
+		t.Errorf("got calls %q; want hooks after the failure to be skipped", l.calls)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{Path: "a/BUILD.bazel", NotExist: true}})
+}
+
+func TestHookContextInterrupt(t *testing.T) {
+	c := config.New()
+	if hasHooks(c) {
+		t.Fatal("hasHooks: got true before a hook language was added")
+	}
+	defer withLang(&hookLang{})()
+	if !hasHooks(c) {
+		t.Fatal("hasHooks: got false after a hook language was added")
+	}
+	ctx, cancel := hookContext(c)
+	defer cancel()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skipf("can't send interrupt: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("context was not cancelled after interrupt")
+	}
+}
@@ -16,6 +16,8 @@ limitations under the License.
 package main
 
 import (
+	"sync"
+
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/repo"
@@ -23,8 +25,11 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
-// metaResolver provides a rule.Resolver for any rule.Rule.
+// metaResolver provides a rule.Resolver for any rule.Rule. It is safe for
+// concurrent use.
 type metaResolver struct {
+	mu sync.RWMutex
+
 	// builtins provides a map of the language kinds to their resolver.
 	builtins map[string]resolve.Resolver
 
@@ -47,6 +52,8 @@ func (mr *metaResolver) AddBuiltin(kindName string, resolver resolve.Resolver) {
 // MappedKind records the fact that the given mapping was applied while
 // processing the given package.
 func (mr *metaResolver) MappedKind(pkgRel string, kind config.MappedKind) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
 	mr.mappedKinds[pkgRel] = append(mr.mappedKinds[pkgRel], kind)
 }
 
@@ -54,6 +61,8 @@ func (mr *metaResolver) MappedKind(pkgRel string, kind config.MappedKind) {
 // indicating whether one was found. Empty string may be passed for pkgRel,
 // which results in consulting the builtin kinds only.
 func (mr *metaResolver) Resolver(r *rule.Rule, pkgRel string) resolve.Resolver {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
 	for _, mappedKind := range mr.mappedKinds[pkgRel] {
 		if mappedKind.KindName == r.Kind() {
 			fromKindResolver := mr.builtins[mappedKind.FromKind]
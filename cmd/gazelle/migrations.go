@@ -0,0 +1,231 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	gzflag "github.com/bazelbuild/bazel-gazelle/flag"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// langMigration is a migration along with the name of the language that
+// defines it.
+type langMigration struct {
+	lang string
+	language.Migration
+}
+
+// listMigrations returns the migrations defined by langs, in order. An
+// error is returned if two migrations have the same name.
+func listMigrations(langs []language.Language) ([]langMigration, error) {
+	var ms []langMigration
+	seen := make(map[string]string)
+	for _, l := range langs {
+		mr, ok := l.(language.Migrator)
+		if !ok {
+			continue
+		}
+		for _, m := range mr.Migrations() {
+			if other, ok := seen[m.Name]; ok {
+				return nil, fmt.Errorf("migration %q is defined by languages %s and %s", m.Name, other, l.Name())
+			}
+			seen[m.Name] = l.Name()
+			ms = append(ms, langMigration{lang: l.Name(), Migration: m})
+		}
+	}
+	return ms, nil
+}
+
+// selectMigrations parses the value of the -fix flag, a comma-separated list
+// of migration names, each optionally followed by "@version". Migrations
+// are returned in the order they're defined, not the order they're named.
+func selectMigrations(value string, langs []language.Language) ([]langMigration, error) {
+	all, err := listMigrations(langs)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]langMigration)
+	for _, m := range all {
+		byName[m.Name] = m
+	}
+
+	selected := make(map[string]bool)
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		name, version := s, 0
+		if i := strings.IndexByte(s, '@'); i >= 0 {
+			name = s[:i]
+			if version, err = strconv.Atoi(s[i+1:]); err != nil || version < 1 {
+				return nil, fmt.Errorf("-fix: %q: version must be a positive integer", s)
+			}
+		}
+		m, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("-fix: unknown migration %q; run 'gazelle help fixes' for a list", name)
+		}
+		if version != 0 && version != m.Version {
+			return nil, fmt.Errorf("-fix: %q: migration %s is at version %d", s, name, m.Version)
+		}
+		selected[name] = true
+	}
+
+	var ms []langMigration
+	for _, m := range all {
+		if selected[m.Name] {
+			ms = append(ms, m)
+		}
+	}
+	return ms, nil
+}
+
+// applyMigrations applies the migrations ms to f and records which ones
+// changed it in report. Migrations for languages excluded by c are skipped.
+func applyMigrations(c *config.Config, f *rule.File, ms []langMigration, report *migrationReport) {
+	langs := make(map[string]bool)
+	for _, l := range filterLanguages(c, languages) {
+		langs[l.Name()] = true
+	}
+
+	before := f.Format()
+	for _, m := range ms {
+		if !langs[m.lang] {
+			continue
+		}
+		m.Fix(c, f)
+		after := f.Format()
+		if !bytes.Equal(before, after) {
+			report.add(m.Name, c.RepoRoot, f.Path)
+			before = after
+		}
+	}
+}
+
+// migrationReport records the build files changed by each migration.
+// It's safe for concurrent use.
+type migrationReport struct {
+	mu      sync.Mutex
+	changed map[string][]string
+}
+
+func (r *migrationReport) add(name, repoRoot, path string) {
+	if rel, err := filepath.Rel(repoRoot, path); err == nil {
+		path = filepath.ToSlash(rel)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.changed == nil {
+		r.changed = make(map[string][]string)
+	}
+	r.changed[name] = append(r.changed[name], path)
+}
+
+// print writes a summary of the files changed by each migration in ms.
+func (r *migrationReport) print(w io.Writer, ms []langMigration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sb strings.Builder
+	for _, m := range ms {
+		paths := r.changed[m.Name]
+		sort.Strings(paths)
+		switch len(paths) {
+		case 0:
+			fmt.Fprintf(&sb, "%s: no files changed\n", m.Name)
+		case 1:
+			fmt.Fprintf(&sb, "%s: 1 file changed\n", m.Name)
+		default:
+			fmt.Fprintf(&sb, "%s: %d files changed\n", m.Name, len(paths))
+		}
+		for _, p := range paths {
+			fmt.Fprintf(&sb, "\t%s\n", p)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// helpFixes prints the migrations that may be selected with -fix.
+func helpFixes(wd string, args []string) error {
+	stop, err := startLangPlugins(wd, args)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	// Flag will call this on any parse error. Don't print usage unless
+	// -h or -help were passed explicitly.
+	fs.Usage = func() {}
+	var langPlugins []string
+	fs.Var(&gzflag.MultiFlag{Values: &langPlugins}, langPluginFlag, "executable that implements a language extension over the plugin protocol (can specify multiple times)")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			helpFixesUsage(fs)
+			return err
+		}
+		// flag already prints the error; don't print it again.
+		return errors.New("Try -help for more information")
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("help fixes: unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	ms, err := listMigrations(languages)
+	if err != nil {
+		return err
+	}
+	return printMigrations(os.Stdout, ms)
+}
+
+func printMigrations(w io.Writer, ms []langMigration) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tVERSION\tLANGUAGE\tDESCRIPTION\n")
+	for _, m := range ms {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", m.Name, m.Version, m.lang, m.Doc)
+	}
+	return tw.Flush()
+}
+
+func helpFixesUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle help fixes [flags...]
+
+Prints the migrations Gazelle may apply to fix deprecated usage in build
+files, in the order they're applied.
+
+"gazelle fix" applies all migrations. With -fix=name1,name2, it applies
+only the named migrations, then reports which files each one changed. This
+allows migrations to be rolled out and reviewed one at a time. A name may
+be followed by @version to fail if the migration has changed since it was
+last used.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,182 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+// migrateLang is a language with migrations that rename filegroups.
+type migrateLang struct {
+	hookLang
+}
+
+func (*migrateLang) Name() string { return "migrate" }
+
+func (*migrateLang) Migrations() []language.Migration {
+	rename := func(from, to string) func(c *config.Config, f *rule.File) {
+		return func(c *config.Config, f *rule.File) {
+			if !c.ShouldFix {
+				return
+			}
+			for _, r := range f.Rules {
+				if r.Kind() == "filegroup" && r.Name() == from {
+					r.SetName(to)
+				}
+			}
+		}
+	}
+	return []language.Migration{
+		{Name: "rename_a", Version: 1, Doc: "renames old_a", Fix: rename("old_a", "new_a")},
+		{Name: "rename_b", Version: 2, Doc: "renames old_b", Fix: rename("old_b", "new_b")},
+	}
+}
+
+func (l *migrateLang) Fix(c *config.Config, f *rule.File) {
+	for _, m := range l.Migrations() {
+		m.Fix(c, f)
+	}
+}
+
+func TestSelectMigrations(t *testing.T) {
+	langs := []language.Language{&migrateLang{}}
+	for _, tc := range []struct {
+		value, want, wantErr string
+	}{
+		{value: "rename_b, rename_a", want: "rename_a,rename_b"},
+		{value: "rename_b@2", want: "rename_b"},
+		{value: "rename_b@1", wantErr: `-fix: "rename_b@1": migration rename_b is at version 2`},
+		{value: "rename_b@x", wantErr: `-fix: "rename_b@x": version must be a positive integer`},
+		{value: "rename_c", wantErr: `-fix: unknown migration "rename_c"; run 'gazelle help fixes' for a list`},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			ms, err := selectMigrations(tc.value, langs)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("got error %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, m := range ms {
+				names = append(names, m.Name)
+			}
+			if got := strings.Join(names, ","); got != tc.want {
+				t.Errorf("got %s; want %s", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := selectMigrations("rename_a", []language.Language{&migrateLang{}, &migrateLang{}}); err == nil {
+		t.Error("got success with duplicate migrations; want error")
+	}
+}
+
+func TestFixSelectedMigrations(t *testing.T) {
+	buildFile := `filegroup(name = "old_a")
+
+filegroup(name = "old_b")
+`
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "x/BUILD.bazel", Content: buildFile},
+		{Path: "y/BUILD.bazel", Content: buildFile},
+	})
+	defer cleanup()
+	defer withLang(&migrateLang{})()
+
+	// Only fix selects migrations. update doesn't apply fixes that rename
+	// rules, so it doesn't accept -fix.
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	(&updateConfigurer{}).RegisterFlags(fs, "update", config.New())
+	if fs.Lookup("fix") != nil {
+		t.Fatal("update registers -fix; want it only registered for fix")
+	}
+
+	// Selected migrations are applied. Other migrations are not applied.
+	if err := runGazelle(dir, []string{"fix", "-fix=rename_a", "x"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{
+			Path: "x/BUILD.bazel",
+			Content: `filegroup(name = "new_a")
+
+filegroup(name = "old_b")
+`,
+		},
+		{Path: "y/BUILD.bazel", Content: buildFile},
+	})
+
+	// Without -fix, fix applies all migrations.
+	if err := runGazelle(dir, []string{"fix", "y"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "y/BUILD.bazel",
+		Content: `filegroup(name = "new_a")
+
+filegroup(name = "new_b")
+`,
+	}})
+}
+
+func TestMigrationReport(t *testing.T) {
+	defer withLang(&migrateLang{})()
+	ms, err := selectMigrations("rename_a,rename_b", languages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.New()
+	c.RepoRoot = "/repo"
+	c.ShouldFix = true
+	report := &migrationReport{}
+	for _, tc := range []struct{ pkg, content string }{
+		{"b", `filegroup(name = "old_a")`},
+		{"a", `filegroup(name = "old_a")`},
+		{"c", `filegroup(name = "other")`},
+	} {
+		f, err := rule.LoadData(filepath.Join("/repo", tc.pkg, "BUILD.bazel"), tc.pkg, []byte(tc.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyMigrations(c, f, ms, report)
+	}
+
+	var sb strings.Builder
+	if err := report.print(&sb, ms); err != nil {
+		t.Fatal(err)
+	}
+	want := `rename_a: 2 files changed
+	a/BUILD.bazel
+	b/BUILD.bazel
+rename_b: no files changed
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
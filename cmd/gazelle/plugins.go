@@ -0,0 +1,107 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/language/plugin"
+)
+
+const langPluginFlag = "lang_plugin"
+
+// langPluginPaths returns the values of -lang_plugin flags in args.
+//
+// Plugins must be started before the configuration is built, since each
+// plugin is added to the list of languages, and its kinds and known
+// directives are reported by the plugin process. Plugins can't register
+// flags of their own. So we look for -lang_plugin here, before flags are
+// parsed. The flag is also registered by updateConfigurer so it's accepted
+// by the flag parser and documented in -help.
+func langPluginPaths(args []string) []string {
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if name == langPluginFlag && i+1 < len(args) {
+			paths = append(paths, args[i+1])
+			i++
+		} else if strings.HasPrefix(name, langPluginFlag+"=") {
+			paths = append(paths, name[len(langPluginFlag)+1:])
+		}
+	}
+	return paths
+}
+
+// startLangPlugins starts the language plugins named with -lang_plugin in
+// args and adds them to the end of the languages list. The returned function
+// stops the plugins and restores the list. Relative paths are interpreted
+// relative to wd; names without a separator are looked up in PATH.
+func startLangPlugins(wd string, args []string) (stop func(), err error) {
+	paths := langPluginPaths(args)
+	if len(paths) == 0 {
+		return func() {}, nil
+	}
+
+	var plugins []*plugin.Language
+	stopPlugins := func() {
+		for _, p := range plugins {
+			if err := p.Close(); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+	oldLanguages := languages
+	langs := append([]language.Language(nil), languages...)
+	for _, path := range paths {
+		if !strings.ContainsRune(path, filepath.Separator) && !strings.ContainsRune(path, '/') {
+			if p, err := exec.LookPath(path); err == nil {
+				path = p
+			}
+		} else if !filepath.IsAbs(path) {
+			path = filepath.Join(wd, path)
+		}
+		p, err := plugin.Start(path)
+		if err != nil {
+			stopPlugins()
+			return nil, fmt.Errorf("-%s: %v", langPluginFlag, err)
+		}
+		plugins = append(plugins, p)
+		for _, l := range langs {
+			if l.Name() == p.Name() {
+				stopPlugins()
+				return nil, fmt.Errorf("-%s: plugin %s provides language %q, which is already defined", langPluginFlag, path, p.Name())
+			}
+		}
+		langs = append(langs, p)
+	}
+	languages = langs
+	return func() {
+		languages = oldLanguages
+		stopPlugins()
+	}, nil
+}
@@ -0,0 +1,45 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestLangPluginPaths(t *testing.T) {
+	args := []string{"-lang_plugin=a", "-go_prefix", "example.com/x", "--lang_plugin", "b", "dir", "--", "-lang_plugin=c"}
+	if got, want := langPluginPaths(args), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestLangPluginMissing(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	n := len(languages)
+	err := runGazelle(dir, []string{"-lang_plugin=./missing"})
+	if err == nil || !strings.Contains(err.Error(), "-lang_plugin: starting plugin") {
+		t.Errorf("got error %v; want error starting plugin", err)
+	}
+	if len(languages) != n {
+		t.Errorf("languages were not restored: got %d languages; want %d", len(languages), n)
+	}
+}
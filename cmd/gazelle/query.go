@@ -0,0 +1,289 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// query is a question asked with the query command.
+type query struct {
+	// kind is "import", "file", or "label".
+	kind string
+
+	// lang and imp are set for import queries.
+	lang, imp string
+
+	// file is set for file queries. It is a slash-separated path relative
+	// to the repository root.
+	file string
+
+	// label is set for label queries.
+	label label.Label
+}
+
+func (q query) String() string {
+	switch q.kind {
+	case "import":
+		return fmt.Sprintf("import %s %s", q.lang, q.imp)
+	case "file":
+		return "file " + q.file
+	default:
+		return "label " + q.label.String()
+	}
+}
+
+// parseQueries parses the positional arguments of the query command.
+// Each query is a keyword followed by its arguments:
+//
+//   import <lang> <import-string>
+//   file <path>
+//   label <label>
+//
+// File paths are interpreted relative to c.WorkDir.
+func parseQueries(c *config.Config, args []string) ([]query, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("query: no queries given")
+	}
+	var queries []query
+	for len(args) > 0 {
+		switch args[0] {
+		case "import":
+			if len(args) < 3 {
+				return nil, fmt.Errorf("query: import requires a language and an import string")
+			}
+			queries = append(queries, query{kind: "import", lang: args[1], imp: args[2]})
+			args = args[3:]
+
+		case "file":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("query: file requires a path")
+			}
+			p := args[1]
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(c.WorkDir, p)
+			}
+			if !isDescendingDir(p, c.RepoRoot) {
+				return nil, fmt.Errorf("query: %s: not in repo root %s", args[1], c.RepoRoot)
+			}
+			rel, err := filepath.Rel(c.RepoRoot, p)
+			if err != nil {
+				return nil, fmt.Errorf("query: %s: %v", args[1], err)
+			}
+			queries = append(queries, query{kind: "file", file: filepath.ToSlash(rel)})
+			args = args[2:]
+
+		case "label":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("query: label requires a label")
+			}
+			l, err := label.Parse(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("query: %v", err)
+			}
+			if l.Relative {
+				return nil, fmt.Errorf("query: label %q must be absolute", args[1])
+			}
+			queries = append(queries, query{kind: "label", label: l})
+			args = args[2:]
+
+		default:
+			return nil, fmt.Errorf("query: unknown query %q; expected import, file, or label", args[0])
+		}
+	}
+	return queries, nil
+}
+
+// queryResult is the answer to one query. It's printed as JSON with
+// -format=json.
+type queryResult struct {
+	Query string      `json:"query"`
+	Rules []queryRule `json:"rules"`
+}
+
+type queryRule struct {
+	Label      string        `json:"label"`
+	Kind       string        `json:"kind,omitempty"`
+	ImportedAs []queryImport `json:"imported_as,omitempty"`
+	Embeds     []string      `json:"embeds,omitempty"`
+}
+
+type queryImport struct {
+	Lang string `json:"lang"`
+	Imp  string `json:"import"`
+}
+
+// runQueries answers queries using the rule index and the generated build
+// files, then prints the results to stdout.
+func runQueries(c *config.Config, ix *resolve.RuleIndex, files []*rule.File, queries []query, format string) error {
+	results := make([]queryResult, len(queries))
+	for i, q := range queries {
+		results[i] = queryResult{Query: q.String(), Rules: []queryRule{}}
+		switch q.kind {
+		case "import":
+			imp := resolve.ImportSpec{Lang: q.lang, Imp: q.imp}
+			for _, m := range ix.FindRulesByImportWithConfig(c, imp, q.lang) {
+				results[i].Rules = append(results[i].Rules, newQueryRule(ix, m.Label, ""))
+			}
+		case "file":
+			for _, f := range files {
+				for _, r := range findFileOwners(f, q.file) {
+					l := label.New(c.RepoName, f.Pkg, r.Name())
+					results[i].Rules = append(results[i].Rules, newQueryRule(ix, l, r.Kind()))
+				}
+			}
+		case "label":
+			l := q.label
+			if l.Repo == "" {
+				l.Repo = c.RepoName
+			}
+			if _, ok := ix.FindRuleByLabel(l); ok {
+				results[i].Rules = append(results[i].Rules, newQueryRule(ix, l, ""))
+			}
+		}
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	return printQueryResults(os.Stdout, results)
+}
+
+func newQueryRule(ix *resolve.RuleIndex, l label.Label, kind string) queryRule {
+	qr := queryRule{Label: l.String(), Kind: kind}
+	ir, ok := ix.FindRuleByLabel(l)
+	if !ok {
+		return qr
+	}
+	qr.Kind = ir.Kind
+	seen := make(map[resolve.ImportSpec]bool)
+	for _, imp := range ir.ImportedAs {
+		if !seen[imp] {
+			seen[imp] = true
+			qr.ImportedAs = append(qr.ImportedAs, queryImport{Lang: imp.Lang, Imp: imp.Imp})
+		}
+	}
+	embedSet := make(map[string]bool)
+	for _, e := range ir.Embeds {
+		embedSet[e.String()] = true
+	}
+	for e := range embedSet {
+		qr.Embeds = append(qr.Embeds, e)
+	}
+	sort.Strings(qr.Embeds)
+	return qr
+}
+
+// findFileOwners returns rules in f that list the file at the
+// slash-separated, repository-relative path rel in any attribute.
+func findFileOwners(f *rule.File, rel string) []*rule.Rule {
+	if f.Pkg != "" && !pathtools.HasPrefix(rel, f.Pkg) {
+		return nil
+	}
+	var owners []*rule.Rule
+	for _, r := range f.Rules {
+		owned := false
+		for _, key := range r.AttrKeys() {
+			bzl.Walk(r.Attr(key), func(e bzl.Expr, _ []bzl.Expr) {
+				str, ok := e.(*bzl.StringExpr)
+				if !ok || owned {
+					return
+				}
+				l, err := label.Parse(str.Value)
+				if err != nil || l.Repo != "" {
+					return
+				}
+				l = l.Abs("", f.Pkg)
+				if path.Join(l.Pkg, l.Name) == rel {
+					owned = true
+				}
+			})
+			if owned {
+				break
+			}
+		}
+		if owned {
+			owners = append(owners, r)
+		}
+	}
+	return owners
+}
+
+func printQueryResults(w io.Writer, results []queryResult) error {
+	var sb strings.Builder
+	for _, res := range results {
+		fmt.Fprintln(&sb, res.Query)
+		if len(res.Rules) == 0 {
+			fmt.Fprintln(&sb, "  no rules found")
+		}
+		for _, r := range res.Rules {
+			if r.Kind != "" {
+				fmt.Fprintf(&sb, "  %s (%s)\n", r.Label, r.Kind)
+			} else {
+				fmt.Fprintf(&sb, "  %s\n", r.Label)
+			}
+			for _, imp := range r.ImportedAs {
+				fmt.Fprintf(&sb, "    imported as: %s %s\n", imp.Lang, imp.Imp)
+			}
+			for _, e := range r.Embeds {
+				fmt.Fprintf(&sb, "    embeds: %s\n", e)
+			}
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func queryUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle query [flags...] query...
+
+The query command generates rules for the whole repository the same way
+update does and builds an index of importable rules. It then answers
+queries using the index and prints the results, without changing any
+files. Each query is one of:
+
+  import <lang> <import-string> - lists rules that provide the import
+      string in the given language, for example,
+      "import go example.com/foo" or "import proto foo/bar.proto".
+  file <path> - lists rules that own the given source file.
+  label <label> - shows how the indexed rule with the given label may be
+      imported and what it embeds.
+
+Several queries may be given. Results are printed as text or, with
+-format=json, as a JSON list with one result per query.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,181 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestQueryText(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:go_naming_convention import
+`,
+		}, {
+			Path:    "a/a.go",
+			Content: "package a\n",
+		}, {
+			Path:    "a/a_test.go",
+			Content: "package a\n",
+		}, {
+			Path: "p/p.proto",
+			Content: `syntax = "proto3";
+
+package p;
+
+option go_package = "example.com/repo/p";
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	got, err := captureStdout(t, func() error {
+		return runGazelle(dir, []string{
+			"query",
+			"import", "go", "example.com/repo/a",
+			"file", "a/a_test.go",
+			"label", "//p:p",
+			"import", "go", "example.com/missing",
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `import go example.com/repo/a
+  //a (go_library)
+    imported as: go example.com/repo/a
+file a/a_test.go
+  //a:a_test (go_test)
+label //p
+  //p (go_library)
+    imported as: go example.com/repo/p
+    imported as: proto p/p.proto
+    embeds: //p:p_go_proto
+    embeds: //p:p_proto
+import go example.com/missing
+  no rules found
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Nothing should be written.
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "a/BUILD.bazel", NotExist: true},
+		{Path: "p/BUILD.bazel", NotExist: true},
+	})
+}
+
+func TestQueryJSON(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{
+			Path: "BUILD.bazel",
+			Content: `# gazelle:prefix example.com/repo
+# gazelle:go_naming_convention import
+`,
+		}, {
+			Path:    "a/a.go",
+			Content: "package a\n",
+		}, {
+			Path:    "a/a_test.go",
+			Content: "package a\n",
+		}, {
+			Path: "p/p.proto",
+			Content: `syntax = "proto3";
+
+package p;
+
+option go_package = "example.com/repo/p";
+`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	out, err := captureStdout(t, func() error {
+		return runGazelle(dir, []string{"query", "-format=json", "import", "proto", "p/p.proto", "file", "p/p.proto"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []queryResult
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []queryResult{
+		{
+			Query: "import proto p/p.proto",
+			Rules: []queryRule{{
+				Label:      "//p:p_proto",
+				Kind:       "proto_library",
+				ImportedAs: []queryImport{{Lang: "proto", Imp: "p/p.proto"}},
+			}},
+		}, {
+			Query: "file p/p.proto",
+			Rules: []queryRule{
+				{
+					Label:      "//p:p_proto",
+					Kind:       "proto_library",
+					ImportedAs: []queryImport{{Lang: "proto", Imp: "p/p.proto"}},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	for _, tc := range []struct {
+		args    []string
+		wantErr string
+	}{
+		{
+			args:    []string{"query"},
+			wantErr: "query: no queries given",
+		}, {
+			args:    []string{"query", "import", "go"},
+			wantErr: "query: import requires a language and an import string",
+		}, {
+			args:    []string{"query", "owner", "a.go"},
+			wantErr: `query: unknown query "owner"`,
+		}, {
+			args:    []string{"query", "label", ":a"},
+			wantErr: `query: label ":a" must be absolute`,
+		}, {
+			args:    []string{"query", "-format=xml", "file", "a.go"},
+			wantErr: `unrecognized query format: "xml"`,
+		},
+	} {
+		if err := runGazelle(dir, tc.args); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%v: got error %v; want %q", tc.args, err, tc.wantErr)
+		}
+	}
+}
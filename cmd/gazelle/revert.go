@@ -0,0 +1,141 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+// runRevert restores the files written by the last run of Gazelle in the
+// repository from the backup saved when they were written.
+func runRevert(wd string, args []string) error {
+	c := config.New()
+	c.WorkDir = wd
+	cexts := []config.Configurer{&config.CommonConfigurer{}}
+	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	// Flag will call this on any parse error. Don't print usage unless
+	// -h or -help were passed explicitly.
+	fs.Usage = func() {}
+	var force bool
+	fs.BoolVar(&force, "force", false, "restore files even if they were changed after Gazelle wrote them")
+	for _, cext := range cexts {
+		cext.RegisterFlags(fs, "revert", c)
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			revertUsage(fs)
+			return err
+		}
+		// flag already prints the error; don't print it again.
+		return errors.New("Try -help for more information")
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("revert: unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	for _, cext := range cexts {
+		if err := cext.CheckFlags(fs, c); err != nil {
+			return err
+		}
+	}
+
+	backupDir, err := backupDirForRepo(c.RepoRoot)
+	if err != nil {
+		return err
+	}
+	m, err := readBackup(backupDir)
+	if err != nil {
+		return err
+	}
+
+	txn := newFileTxn()
+	var changed []string
+	for _, bf := range m.Files {
+		if !force && !unchangedSinceBackup(bf) {
+			rel, err := filepath.Rel(c.RepoRoot, bf.Path)
+			if err != nil {
+				rel = bf.Path
+			}
+			changed = append(changed, filepath.ToSlash(rel))
+			continue
+		}
+		if !bf.Existed {
+			txn.remove(bf.Path)
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(backupDir, bf.Backup))
+		if err != nil {
+			return fmt.Errorf("reading backup of %s: %v", bf.Path, err)
+		}
+		txn.write(bf.Path, content)
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("revert: files were changed after Gazelle wrote them; use -force to overwrite them:\n\t%s", strings.Join(changed, "\n\t"))
+	}
+
+	// Files are restored together, like they were written. No new backup is
+	// saved; the backup being restored is removed afterward, so revert can't
+	// be applied twice.
+	if err := txn.commit(""); err != nil {
+		return fmt.Errorf("restoring files: %v", err)
+	}
+	if err := os.RemoveAll(backupDir); err != nil {
+		return err
+	}
+	if len(m.Files) == 1 {
+		log.Print("restored 1 file")
+	} else {
+		log.Printf("restored %d files", len(m.Files))
+	}
+	return nil
+}
+
+// unchangedSinceBackup returns whether the file described by bf still has
+// the contents Gazelle wrote (or still doesn't exist, if Gazelle deleted it).
+func unchangedSinceBackup(bf backupFile) bool {
+	content, err := ioutil.ReadFile(bf.Path)
+	if bf.NewHash == "" {
+		return os.IsNotExist(err)
+	}
+	return err == nil && hashContent(content) == bf.NewHash
+}
+
+func revertUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle revert [flags...]
+
+The revert command undoes the last run of Gazelle that changed files in the
+repository, for example, "gazelle fix" or "gazelle update-repos". Files are
+restored to their contents before that run, and files that run created are
+deleted.
+
+Gazelle saves a backup of the files it replaces in the user's cache
+directory, or in the directory named by the GAZELLE_BACKUP_DIR environment
+variable. Only the last run is saved. revert fails if any of the files were
+changed after Gazelle wrote them, unless -force is given.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
@@ -0,0 +1,458 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// The serve command speaks JSON-RPC 2.0 over stdin and stdout. Each message
+// is a single line of JSON.
+
+// serveProtocolVersion is returned by the "initialize" method. It's
+// incremented when methods or their parameters change incompatibly.
+const serveProtocolVersion = 1
+
+// Methods accepted by the serve command.
+const (
+	serveMethodInitialize = "initialize"
+	serveMethodUpdate     = "update"
+	serveMethodShutdown   = "shutdown"
+)
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	serveParseError     = -32700
+	serveInvalidRequest = -32600
+	serveMethodNotFound = -32601
+	serveInvalidParams  = -32602
+	serveInternalError  = -32603
+)
+
+type serveRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type serveResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *serveError     `json:"error,omitempty"`
+}
+
+type serveError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type serveInitializeResult struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	RepoRoot        string `json:"repo_root"`
+}
+
+// serveUpdateParams are sent with the "update" method.
+type serveUpdateParams struct {
+	// Dir is the directory to update, either absolute or relative to the
+	// repository root.
+	Dir string `json:"dir"`
+
+	// Overlay maps paths of files, absolute or relative to the repository
+	// root, to contents that should be used instead of what's on disk, for
+	// example, unsaved editor buffers. Both build files and source files may
+	// be replaced.
+	Overlay map[string]string `json:"overlay,omitempty"`
+
+	// Format is "edits" (the default) to return a list of edits, or
+	// "content" to return the whole new content of the build file.
+	Format string `json:"format,omitempty"`
+}
+
+// serveUpdateResult is the response to "update". Nothing is written to disk.
+type serveUpdateResult struct {
+	// Path is the absolute path of the build file. It's empty if there's no
+	// build file in the directory and Gazelle wouldn't create one.
+	Path string `json:"path"`
+
+	// Exists is whether the build file exists, on disk or in the overlay.
+	Exists bool `json:"exists"`
+
+	// Changed is whether Gazelle would change the build file.
+	Changed bool `json:"changed"`
+
+	// Content is the new content of the build file, if Format is "content".
+	Content *string `json:"content,omitempty"`
+
+	// Edits transform the current content of the build file into the new
+	// content, if Format is "edits". Edits don't overlap and are sorted.
+	Edits []serveTextEdit `json:"edits,omitempty"`
+}
+
+// serveTextEdit replaces the text between Start and End with NewText.
+type serveTextEdit struct {
+	Start   servePosition `json:"start"`
+	End     servePosition `json:"end"`
+	NewText string        `json:"new_text"`
+}
+
+// servePosition is a position in a file. Line and Character are 0-based.
+// As in the Language Server Protocol, Character counts UTF-16 code units.
+type servePosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// server holds state kept in memory between requests: the configuration
+// and the cache of remote repository lookups.
+type server struct {
+	c         *config.Config
+	cexts     []config.Configurer
+	kinds     map[string]rule.KindInfo
+	loads     []rule.LoadInfo
+	exts      []interface{}
+	mrslv     *metaResolver
+	rc        *repo.RemoteCache
+	cleanupRc func() error
+}
+
+func runServe(wd string, args []string) error {
+	s, err := newServer(wd, args)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	return s.serve(os.Stdin, os.Stdout)
+}
+
+func newServer(wd string, args []string) (*server, error) {
+	s := &server{
+		kinds: make(map[string]rule.KindInfo),
+		loads: genericLoads,
+		mrslv: newMetaResolver(),
+	}
+	s.cexts = append(s.cexts,
+		&config.CommonConfigurer{},
+		&updateConfigurer{},
+		&walk.Configurer{},
+		&resolve.Configurer{})
+	for _, lang := range languages {
+		s.cexts = append(s.cexts, lang)
+		for kind, info := range lang.Kinds() {
+			s.mrslv.AddBuiltin(kind, lang)
+			s.kinds[kind] = info
+		}
+		s.loads = append(s.loads, lang.Loads()...)
+		s.exts = append(s.exts, lang)
+	}
+
+	c, err := newFixUpdateConfiguration(wd, serveCmd, args, s.cexts)
+	if err != nil {
+		return nil, err
+	}
+	s.c = c
+	s.rc, s.cleanupRc = repo.NewRemoteCache(getUpdateConfig(c).repos)
+	return s, nil
+}
+
+func (s *server) close() error {
+	return s.cleanupRc()
+}
+
+// serve reads requests from r and writes responses to w until r is closed
+// or a "shutdown" request is received.
+func (s *server) serve(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	enc := json.NewEncoder(w)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req serveRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			if err := enc.Encode(serveResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &serveError{serveParseError, err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		result, rerr := s.handle(req)
+		if req.ID == nil {
+			// Notifications don't get responses.
+			if req.Method == serveMethodShutdown {
+				return nil
+			}
+			continue
+		}
+		resp := serveResponse{JSONRPC: "2.0", ID: req.ID, Error: rerr}
+		if rerr == nil {
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			resp.Result = data
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+		if req.Method == serveMethodShutdown && rerr == nil {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *server) handle(req serveRequest) (interface{}, *serveError) {
+	if req.JSONRPC != "2.0" {
+		return nil, &serveError{serveInvalidRequest, `jsonrpc must be "2.0"`}
+	}
+	switch req.Method {
+	case serveMethodInitialize:
+		return serveInitializeResult{ProtocolVersion: serveProtocolVersion, RepoRoot: s.c.RepoRoot}, nil
+
+	case serveMethodUpdate:
+		var params serveUpdateParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &serveError{serveInvalidParams, err.Error()}
+		}
+		result, err := s.update(params)
+		if err != nil {
+			return nil, &serveError{serveInternalError, err.Error()}
+		}
+		return result, nil
+
+	case serveMethodShutdown:
+		return nil, nil
+
+	default:
+		return nil, &serveError{serveMethodNotFound, fmt.Sprintf("unknown method: %q", req.Method)}
+	}
+}
+
+// overlayFS returns a file system that reads files from base, except that
+// files in overlay are replaced with the given contents. vfs.NewOverlayFS
+// reads replacements from files, so the contents are written to a
+// temporary directory, which cleanup removes.
+func (s *server) overlayFS(base vfs.FS, overlay map[string]string) (fsys vfs.FS, cleanup func(), err error) {
+	tmpDir, err := ioutil.TempDir("", "gazelle-serve-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
+	replace := make(map[string]string, len(overlay))
+	i := 0
+	for path, content := range overlay {
+		to := filepath.Join(tmpDir, strconv.Itoa(i))
+		i++
+		if err := ioutil.WriteFile(to, []byte(content), 0666); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		replace[s.absPath(path)] = to
+	}
+	return vfs.NewOverlayFS(base, replace), cleanup, nil
+}
+
+// update computes the build file Gazelle would write in a directory,
+// without writing anything. The whole repository is indexed on each request
+// so that dependencies are resolved against the current state of the tree.
+func (s *server) update(params serveUpdateParams) (*serveUpdateResult, error) {
+	if params.Format == "" {
+		params.Format = "edits"
+	}
+	if params.Format != "edits" && params.Format != "content" {
+		return nil, fmt.Errorf("unrecognized format: %q", params.Format)
+	}
+	dir := s.absPath(params.Dir)
+	if !isDescendingDir(dir, s.c.RepoRoot) {
+		return nil, fmt.Errorf("%s: not a subdirectory of repo root %s", params.Dir, s.c.RepoRoot)
+	}
+	rel, _ := filepath.Rel(s.c.RepoRoot, dir)
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+
+	// Walk mutates the root configuration, so each request gets a copy.
+	// Files in the overlay are read instead of files in the configured
+	// file system.
+	c := s.c.Clone()
+	if len(params.Overlay) > 0 {
+		fsys, cleanup, err := s.overlayFS(c.FileSystem(), params.Overlay)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		c.FS = fsys
+	}
+	uc := getUpdateConfig(c)
+
+	ctx, cancel := hookContext(c)
+	defer cancel()
+	if err := beforeWalk(ctx, language.BeforeWalkArgs{Config: c, Cmd: serveCmd.String(), Dirs: []string{dir}}); err != nil {
+		return nil, err
+	}
+	mode := walk.UpdateDirsMode
+	if c.IndexLibraries {
+		mode = walk.VisitAllUpdateDirsMode
+	}
+	tasks := generateTasks(c, s.cexts, []string{dir}, mode, nil, s.kinds, s.mrslv)
+	ruleIndex := resolve.NewRuleIndex(s.mrslv.Resolver, s.exts...)
+	visits := indexTasks(c, ruleIndex, tasks)
+	ruleIndex.Finish()
+
+	// Only the requested directory is resolved and returned. Other build
+	// files were generated only so that rules are indexed correctly.
+	var v *visitRecord
+	for i := range visits {
+		if visits[i].pkgRel == rel {
+			v = &visits[i]
+			break
+		}
+	}
+	if v == nil {
+		return &serveUpdateResult{}, nil
+	}
+	files := []*rule.File{v.file}
+	if err := afterGenerate(ctx, language.AfterGenerateArgs{Config: c, Cmd: serveCmd.String(), Files: files, Index: ruleIndex}); err != nil {
+		return nil, err
+	}
+	resolveVisits(c, ruleIndex, s.rc, s.mrslv, s.kinds, []visitRecord{*v}, uc.jobs)
+	if err := afterResolve(ctx, language.AfterResolveArgs{Config: c, Cmd: serveCmd.String(), Files: files}); err != nil {
+		return nil, err
+	}
+	merger.FixLoads(v.file, applyKindMappings(v.mappedKinds, s.loads))
+
+	oldContent := v.file.Content
+	newContent := v.file.Format()
+	result := &serveUpdateResult{
+		Path:    v.file.Path,
+		Exists:  oldContent != nil,
+		Changed: !bytes.Equal(oldContent, newContent),
+	}
+	if params.Format == "content" {
+		content := string(newContent)
+		result.Content = &content
+	} else if result.Changed {
+		result.Edits = []serveTextEdit{lineEdit(oldContent, newContent)}
+	}
+	return result, nil
+}
+
+// absPath returns p as an absolute path. Relative paths are interpreted
+// relative to the repository root.
+func (s *server) absPath(p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(s.c.RepoRoot, filepath.FromSlash(p))
+}
+
+// lineEdit returns a single edit that replaces the lines that differ
+// between oldContent and newContent. Lines at the beginning and end that are
+// the same are not included, so editors keep cursor positions and folds
+// outside the changed region.
+func lineEdit(oldContent, newContent []byte) serveTextEdit {
+	oldLines := splitLines(string(oldContent))
+	newLines := splitLines(string(newContent))
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix && oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	end := servePosition{Line: len(oldLines) - suffix}
+	if suffix == 0 && len(oldLines) > 0 && !strings.HasSuffix(oldLines[len(oldLines)-1], "\n") {
+		// The last line has no newline, so the edit ends at the end of that
+		// line instead of the beginning of the next one.
+		last := oldLines[len(oldLines)-1]
+		end = servePosition{Line: len(oldLines) - 1, Character: len(utf16.Encode([]rune(last)))}
+	}
+	return serveTextEdit{
+		Start:   servePosition{Line: prefix},
+		End:     end,
+		NewText: strings.Join(newLines[prefix:len(newLines)-suffix], ""),
+	}
+}
+
+// splitLines splits s into lines, each including its trailing newline.
+// The last line has no newline if s doesn't end with one.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+func serveUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle serve [flags...]
+
+The serve command runs a server for editors and other tools that want to
+know what Gazelle would do to a build file without any files being written.
+Requests are read from stdin and responses are written to stdout using
+JSON-RPC 2.0, one message per line.
+
+Methods:
+
+  initialize - returns {"protocol_version": 1, "repo_root": "..."}.
+  update - params: {"dir": "pkg/a", "overlay": {"pkg/a/BUILD.bazel": "..."},
+      "format": "edits"}. Generates rules for dir, resolves dependencies
+      against an index of the whole repository, and returns
+      {"path", "exists", "changed", "edits"}. With "format": "content", the
+      whole new content is returned in "content" instead of edits. Overlay
+      replaces the contents of build files and source files, for example,
+      with unsaved buffers.
+  shutdown - stops the server.
+
+serve accepts the same flags as update.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
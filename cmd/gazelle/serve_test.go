@@ -0,0 +1,173 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestServe(t *testing.T) {
+	aBuild := `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+)
+`
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+		{Path: "a/BUILD.bazel", Content: aBuild},
+		{Path: "a/a.go", Content: `package a
+
+import _ "example.com/repo/b"
+`},
+		{Path: "b/BUILD.bazel", Content: `go_library(
+    name = "b",
+    srcs = ["b.go"],
+    importpath = "example.com/repo/b",
+)
+`},
+		{Path: "b/b.go", Content: "package b"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	s, err := newServer(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	in := strings.Join([]string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "initialize"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "update", "params": {"dir": "a"}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "update", "params": {"dir": "a", "format": "content", "overlay": {"a/BUILD.bazel": "# unsaved\n", "a/a.go": "package a\n", "a/c.go": "package a\n"}}}`,
+		`{"jsonrpc": "2.0", "method": "update", "params": {"dir": "a"}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "bogus"}`,
+		`not json`,
+		`{"jsonrpc": "2.0", "id": 5, "method": "shutdown"}`,
+		`{"jsonrpc": "2.0", "id": 6, "method": "initialize"}`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := s.serve(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	var resps []serveResponse
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp serveResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, resp)
+	}
+	var ids []string
+	for _, resp := range resps {
+		ids = append(ids, string(resp.ID))
+	}
+	if want := []string{"1", "2", "3", "4", "null", "5"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got responses with ids %v; want %v", ids, want)
+	}
+
+	var init serveInitializeResult
+	if err := json.Unmarshal(resps[0].Result, &init); err != nil {
+		t.Fatal(err)
+	}
+	if init.RepoRoot != s.c.RepoRoot || init.ProtocolVersion != serveProtocolVersion {
+		t.Errorf("initialize: got %#v", init)
+	}
+
+	// The new dependency is inserted with a single edit.
+	var update serveUpdateResult
+	if resps[1].Error != nil {
+		t.Fatalf("update: %s", resps[1].Error.Message)
+	}
+	if err := json.Unmarshal(resps[1].Result, &update); err != nil {
+		t.Fatal(err)
+	}
+	wantEdits := []serveTextEdit{{
+		Start:   servePosition{Line: 7},
+		End:     servePosition{Line: 7},
+		NewText: "    deps = [\"//b\"],\n",
+	}}
+	if !update.Exists || !update.Changed || !reflect.DeepEqual(update.Edits, wantEdits) {
+		t.Errorf("update: got %#v; want edits %#v", update, wantEdits)
+	}
+
+	// The overlay is used instead of files on disk. Source files in the
+	// overlay are read, too: a.go no longer imports b, and c.go is new.
+	update = serveUpdateResult{}
+	if err := json.Unmarshal(resps[2].Result, &update); err != nil {
+		t.Fatal(err)
+	}
+	if update.Content == nil || !strings.Contains(*update.Content, "# unsaved\n") || !strings.Contains(*update.Content, `"c.go"`) || strings.Contains(*update.Content, "//b") {
+		t.Errorf("update with overlay: got %#v", update)
+	}
+
+	if resps[3].Error == nil || resps[3].Error.Code != serveMethodNotFound {
+		t.Errorf("bogus method: got error %#v; want code %d", resps[3].Error, serveMethodNotFound)
+	}
+	if resps[4].Error == nil || resps[4].Error.Code != serveParseError {
+		t.Errorf("invalid JSON: got error %#v; want code %d", resps[4].Error, serveParseError)
+	}
+
+	// Nothing is written.
+	testtools.CheckFiles(t, dir, files)
+}
+
+func TestLineEdit(t *testing.T) {
+	for _, tc := range []struct {
+		desc, old, new string
+		want           serveTextEdit
+	}{
+		{
+			desc: "insert",
+			old:  "a\nc\n",
+			new:  "a\nb\nc\n",
+			want: serveTextEdit{Start: servePosition{Line: 1}, End: servePosition{Line: 1}, NewText: "b\n"},
+		}, {
+			desc: "replace",
+			old:  "a\nb\nc\n",
+			new:  "a\nx\ny\nc\n",
+			want: serveTextEdit{Start: servePosition{Line: 1}, End: servePosition{Line: 2}, NewText: "x\ny\n"},
+		}, {
+			desc: "create",
+			old:  "",
+			new:  "a\n",
+			want: serveTextEdit{NewText: "a\n"},
+		}, {
+			desc: "no_final_newline",
+			old:  "a\nbé😀",
+			new:  "a\nb\n",
+			want: serveTextEdit{Start: servePosition{Line: 1}, End: servePosition{Line: 1, Character: 4}, NewText: "b\n"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := lineEdit([]byte(tc.old), []byte(tc.new)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func writeTestTar(t *testing.T, path string, files map[string]string) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourceTar(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, nil)
+	defer cleanup()
+	writeTestTar(t, filepath.Join(dir, "src.tar"), map[string]string{
+		"WORKSPACE":      "",
+		"BUILD.bazel":    "# gazelle:prefix example.com/hello\n",
+		"hello/hello.go": "package hello\n",
+	})
+
+	wantError := "encountered changes while running diff"
+	args := []string{"-source=tar:src.tar", "-mode=diff", "-patch=p"}
+	if err := runGazelle(dir, args); err == nil || err.Error() != wantError {
+		t.Fatalf("got %v; want %q", err, wantError)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "hello/BUILD.bazel", NotExist: true},
+		{
+			Path: "p",
+			Content: `
+--- /dev/null	1970-01-01 00:00:00.000000000 +0000
++++ hello/BUILD.bazel	1970-01-01 00:00:00.000000000 +0000
+@@ -0,0 +1,9 @@
++load("@io_bazel_rules_go//go:def.bzl", "go_library")
++
++go_library(
++    name = "hello",
++    srcs = ["hello.go"],
++    importpath = "example.com/hello/hello",
++    visibility = ["//visibility:public"],
++)
++
+`,
+		},
+	})
+}
+
+func TestSourceFlagErrors(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, nil)
+	defer cleanup()
+	writeTestTar(t, filepath.Join(dir, "src.tar"), map[string]string{"WORKSPACE": ""})
+
+	for _, tc := range []struct {
+		desc, wantErr string
+		args          []string
+	}{
+		{
+			desc:    "fix",
+			args:    []string{"-source=tar:src.tar"},
+			wantErr: "-source may only be used with -mode=print, diff, or check",
+		}, {
+			desc:    "cache",
+			args:    []string{"-source=tar:src.tar", "-mode=print", "-cache=c"},
+			wantErr: "-cache may not be used with -source or -overlay",
+		}, {
+			desc:    "unknown",
+			args:    []string{"-source=svn:1", "-mode=print"},
+			wantErr: "-source: expected git:REV or tar:FILE",
+		}, {
+			desc:    "missing",
+			args:    []string{"-source=tar:missing.tar", "-mode=print"},
+			wantErr: "missing.tar",
+		}, {
+			desc:    "update-repos",
+			args:    []string{"update-repos", "-source=tar:src.tar", "example.com/m"},
+			wantErr: "-source may not be used with update-repos",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := runGazelle(dir, tc.args)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got %v; want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/hello\n"},
+		{Path: "dep/dep.go", Content: "package dep\n"},
+		{
+			Path: "dep/BUILD.bazel",
+			Content: `
+go_library(
+    name = "dep",
+    srcs = ["dep.go"],
+    importpath = "example.com/hello/dep",
+)
+`,
+		},
+		{Path: "hello/hello.go", Content: "package hello\n"},
+		{Path: "hello/old.go", Content: "package hello\n"},
+		{Path: "edits/hello.go", Content: "package hello\n\nimport _ \"example.com/hello/dep\"\n"},
+		{Path: "edits/new.go", Content: "package hello\n"},
+		{
+			Path: "overlay.json",
+			Content: `{"Replace": {
+  "hello/hello.go": "edits/hello.go",
+  "hello/new.go": "edits/new.go",
+  "hello/old.go": ""
+}}`,
+		},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	if err := runGazelle(dir, []string{"-overlay=overlay.json", "-exclude=edits", "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "hello/new.go", NotExist: true},
+		{Path: "hello/old.go", Content: "package hello\n"},
+		{
+			Path: "hello/BUILD.bazel",
+			Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "hello",
+    srcs = [
+        "hello.go",
+        "new.go",
+    ],
+    importpath = "example.com/hello/hello",
+    visibility = ["//visibility:public"],
+    deps = ["//dep"],
+)
+`,
+		},
+	})
+}
@@ -0,0 +1,374 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// fileTxn collects files to be written so that either all of them are
+// written or none of them are. Files are written by commit, each to a
+// temporary file in the same directory, which is then renamed over the
+// original. Before any file is replaced, the original contents are saved
+// to a backup, so the run may be undone with "gazelle revert".
+//
+// fileTxn is safe for concurrent use.
+type fileTxn struct {
+	mu     sync.Mutex
+	staged map[string]stagedFile
+}
+
+type stagedFile struct {
+	content []byte
+
+	// remove indicates the file should be deleted instead of written.
+	remove bool
+}
+
+func newFileTxn() *fileTxn {
+	return &fileTxn{staged: make(map[string]stagedFile)}
+}
+
+// write stages content to be written to path. If path is staged more than
+// once, the last content wins.
+func (t *fileTxn) write(path string, content []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.staged[path] = stagedFile{content: content}
+}
+
+// remove stages path to be deleted.
+func (t *fileTxn) remove(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.staged[path] = stagedFile{remove: true}
+}
+
+// pending returns the contents of staged files, by path. Files staged to be
+// deleted have nil contents.
+func (t *fileTxn) pending() map[string][]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	files := make(map[string][]byte, len(t.staged))
+	for path, sf := range t.staged {
+		files[path] = sf.content
+	}
+	return files
+}
+
+// commitWithBackup commits staged files, saving a backup of the files it
+// replaces so that "gazelle revert" can restore them. If the backup
+// directory can't be determined, files are written without a backup.
+func (t *fileTxn) commitWithBackup(repoRoot string) error {
+	backupDir, err := backupDirForRepo(repoRoot)
+	if err != nil {
+		log.Printf("not saving a backup: %v", err)
+		backupDir = ""
+	}
+	if err := t.commit(backupDir); err != nil {
+		return fmt.Errorf("writing files: %v", err)
+	}
+	return nil
+}
+
+// pendingFile is a staged file being committed.
+type pendingFile struct {
+	stagedFile
+	path    string
+	existed bool
+	old     []byte
+	mode    os.FileMode // permissions of the original file, if it existed
+	tmpPath string
+	done    bool
+}
+
+// commit writes all staged files. If backupDir is not empty, the original
+// contents of the files are saved there first, replacing any earlier
+// backup. If any file can't be written, files that were already replaced
+// are restored, and an error is returned.
+//
+// Interrupts are deferred while files are being replaced, so a Ctrl-C
+// can't leave some files updated and others not.
+func (t *fileTxn) commit(backupDir string) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.staged) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(t.staged))
+	for path := range t.staged {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	files := make([]*pendingFile, len(paths))
+	for i, path := range paths {
+		pf := &pendingFile{path: path, stagedFile: t.staged[path]}
+		if fi, err := os.Stat(path); err == nil {
+			pf.existed = true
+			pf.mode = fi.Mode().Perm()
+			if pf.old, err = ioutil.ReadFile(path); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		files[i] = pf
+	}
+
+	if backupDir != "" {
+		if err := writeBackup(backupDir, files); err != nil {
+			return fmt.Errorf("saving backup: %v", err)
+		}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(sigCh)
+		select {
+		case sig := <-sigCh:
+			if err == nil {
+				err = fmt.Errorf("received %v; all files were written", sig)
+			}
+		default:
+		}
+	}()
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, pf := range files {
+			if pf.tmpPath != "" {
+				os.Remove(pf.tmpPath)
+			}
+			if pf.done {
+				if rerr := pf.rollback(); rerr != nil {
+					err = fmt.Errorf("%v; restoring %s: %v", err, pf.path, rerr)
+				}
+			}
+		}
+	}()
+
+	// Write new contents to temporary files. Nothing is replaced yet.
+	for _, pf := range files {
+		if pf.remove {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(pf.path), 0777); err != nil {
+			return err
+		}
+		if pf.tmpPath, err = writeTempFile(pf.path, pf.content, pf.mode); err != nil {
+			return err
+		}
+	}
+
+	// Replace the original files.
+	for _, pf := range files {
+		if pf.remove {
+			if pf.existed {
+				if err := os.Remove(pf.path); err != nil {
+					return err
+				}
+			}
+		} else {
+			if err := os.Rename(pf.tmpPath, pf.path); err != nil {
+				return err
+			}
+			pf.tmpPath = ""
+		}
+		pf.done = true
+	}
+	t.staged = make(map[string]stagedFile)
+	return nil
+}
+
+// rollback restores the original contents of a file that was replaced.
+func (pf *pendingFile) rollback() error {
+	if !pf.existed {
+		if pf.remove {
+			return nil
+		}
+		return os.Remove(pf.path)
+	}
+	tmpPath, err := writeTempFile(pf.path, pf.old, pf.mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, pf.path)
+}
+
+// writeTempFile writes content to a new temporary file in the same
+// directory as path and returns the temporary file's name. The file's
+// permissions are set to mode. If mode is 0, the file is created with mode
+// 0666 before the umask, like files created by os.Create.
+func writeTempFile(path string, content []byte, mode os.FileMode) (string, error) {
+	perm := mode
+	if perm == 0 {
+		perm = 0666
+	}
+	var tmp *os.File
+	var err error
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.gazelle-tmp-%d", filepath.Base(path), rand.Uint32()))
+		tmp, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && mode != 0 {
+		// Keep the original file's permissions, which the umask may mask.
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// backupManifest describes the files changed by a run, so they may be
+// restored by "gazelle revert".
+type backupManifest struct {
+	Files []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	// Path is the absolute path of the file that was changed.
+	Path string `json:"path"`
+
+	// Existed indicates whether the file existed before the run. If not,
+	// revert deletes the file.
+	Existed bool `json:"existed"`
+
+	// Backup is the name of the file in the backup directory that holds
+	// the original contents.
+	Backup string `json:"backup,omitempty"`
+
+	// NewHash is the SHA-256 hash of the contents Gazelle wrote, or empty if
+	// Gazelle deleted the file. revert checks that the file hasn't been
+	// changed since.
+	NewHash string `json:"new_hash,omitempty"`
+}
+
+const backupManifestName = "manifest.json"
+
+// backupDirEnv names an environment variable that overrides the directory
+// where backups are kept.
+const backupDirEnv = "GAZELLE_BACKUP_DIR"
+
+// backupDirForRepo returns the directory where the backup for the last run
+// in repoRoot is kept. Backups are stored outside the repository, in the
+// user's cache directory, so they don't show up in version control.
+func backupDirForRepo(repoRoot string) (string, error) {
+	base := os.Getenv(backupDirEnv)
+	if base == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(cacheDir, "gazelle", "backups")
+	}
+	sum := sha256.Sum256([]byte(repoRoot))
+	return filepath.Join(base, hex.EncodeToString(sum[:8])), nil
+}
+
+// writeBackup saves the original contents of files and a manifest to dir,
+// replacing any backup already there.
+func writeBackup(dir string, files []*pendingFile) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0777); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dir), filepath.Base(dir)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var m backupManifest
+	for i, pf := range files {
+		bf := backupFile{Path: pf.path, Existed: pf.existed}
+		if pf.existed {
+			bf.Backup = strconv.Itoa(i)
+			if err := ioutil.WriteFile(filepath.Join(tmpDir, bf.Backup), pf.old, 0666); err != nil {
+				return err
+			}
+		}
+		if !pf.remove {
+			bf.NewHash = hashContent(pf.content)
+		}
+		m.Files = append(m.Files, bf)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, backupManifestName), data, 0666); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dir)
+}
+
+// readBackup reads the manifest of the backup in dir.
+func readBackup(dir string) (backupManifest, error) {
+	var m backupManifest
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifestName))
+	if os.IsNotExist(err) {
+		return m, errNoBackup
+	} else if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("reading backup: %v", err)
+	}
+	return m, nil
+}
+
+var errNoBackup = errors.New("no backup found; there is nothing to revert")
+
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,181 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestFileTxnCommit(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/BUILD", Content: "old a"},
+		{Path: "b/BUILD", Content: "old b"},
+	})
+	defer cleanup()
+	backupDir := filepath.Join(dir, "backup")
+
+	txn := newFileTxn()
+	txn.write(filepath.Join(dir, "a/BUILD"), []byte("discarded"))
+	txn.write(filepath.Join(dir, "a/BUILD"), []byte("new a"))
+	txn.remove(filepath.Join(dir, "b/BUILD"))
+	txn.write(filepath.Join(dir, "c/d/BUILD"), []byte("new c"))
+	if err := txn.commit(backupDir); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "a/BUILD", Content: "new a"},
+		{Path: "b/BUILD", NotExist: true},
+		{Path: "c/d/BUILD", Content: "new c"},
+	})
+
+	m, err := readBackup(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 3 {
+		t.Fatalf("got %d files in backup; want 3", len(m.Files))
+	}
+	for _, bf := range m.Files {
+		if !unchangedSinceBackup(bf) {
+			t.Errorf("%s: changed since backup", bf.Path)
+		}
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(backupDir, m.Files[0].Backup)); err != nil {
+		t.Fatal(err)
+	} else if string(content) != "old a" {
+		t.Errorf("got backup %q; want %q", content, "old a")
+	}
+}
+
+func TestFileTxnCommitMode(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/BUILD", Content: "old a"},
+	})
+	defer cleanup()
+	oldPath := filepath.Join(dir, "a/BUILD")
+	if err := os.Chmod(oldPath, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// New files are created like other files, with the umask applied.
+	refPath := filepath.Join(dir, "ref")
+	if err := ioutil.WriteFile(refPath, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := os.Stat(refPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := newFileTxn()
+	txn.write(oldPath, []byte("new a"))
+	txn.write(filepath.Join(dir, "b/BUILD"), []byte("new b"))
+	if err := txn.commit(""); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(oldPath); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("a/BUILD: got mode %v; want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "b/BUILD")); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != ref.Mode().Perm() {
+		t.Errorf("b/BUILD: got mode %v; want %v", fi.Mode().Perm(), ref.Mode().Perm())
+	}
+}
+
+func TestFileTxnCommitFailure(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/BUILD", Content: "old a"},
+		{Path: "b", Content: "not a directory"},
+	})
+	defer cleanup()
+
+	// b/BUILD can't be written, since b is a file. a/BUILD must not be
+	// written either.
+	txn := newFileTxn()
+	txn.write(filepath.Join(dir, "a/BUILD"), []byte("new a"))
+	txn.write(filepath.Join(dir, "b/BUILD"), []byte("new b"))
+	if err := txn.commit(""); err == nil {
+		t.Fatal("got success; want error")
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "a/BUILD", Content: "old a"},
+		{Path: "b", Content: "not a directory"},
+	})
+	fis, err := ioutil.ReadDir(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		if fi.Name() != "BUILD" {
+			t.Errorf("unexpected file left behind: a/%s", fi.Name())
+		}
+	}
+}
+
+func TestRevert(t *testing.T) {
+	buildFile := `filegroup(name = "old_a")
+`
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "x/BUILD.bazel", Content: buildFile},
+		{Path: "y/y.go", Content: "package y"},
+	})
+	defer cleanup()
+	defer withLang(&migrateLang{})()
+
+	if err := runGazelle(dir, []string{"fix", "-go_prefix=example.com/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "y/BUILD.bazel")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files changed after Gazelle wrote them aren't restored without -force.
+	yBuild := filepath.Join(dir, "y/BUILD.bazel")
+	if err := ioutil.WriteFile(yBuild, []byte("# edited\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err := runGazelle(dir, []string{"revert"})
+	if err == nil || !strings.Contains(err.Error(), "y/BUILD.bazel") {
+		t.Fatalf("got error %v; want error mentioning y/BUILD.bazel", err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "x/BUILD.bazel", Content: `filegroup(name = "new_a")
+`},
+	})
+
+	if err := runGazelle(dir, []string{"revert", "-force"}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{
+		{Path: "x/BUILD.bazel", Content: buildFile},
+		{Path: "y/BUILD.bazel", NotExist: true},
+	})
+
+	// The backup is removed after it's restored.
+	if err := runGazelle(dir, []string{"revert"}); err != errNoBackup {
+		t.Errorf("got error %v; want %v", err, errNoBackup)
+	}
+}
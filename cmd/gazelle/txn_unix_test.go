@@ -0,0 +1,44 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+func TestFileTxnCommitUmask(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "WORKSPACE"}})
+	defer cleanup()
+
+	defer syscall.Umask(syscall.Umask(027))
+	txn := newFileTxn()
+	txn.write(filepath.Join(dir, "BUILD"), []byte("new"))
+	if err := txn.commit(""); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "BUILD")); err != nil {
+		t.Fatal(err)
+	} else if got, want := fi.Mode().Perm(), os.FileMode(0640); got != want {
+		t.Errorf("got mode %v; want %v", got, want)
+	}
+}
@@ -31,6 +31,8 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/merger"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 type updateReposConfig struct {
@@ -39,8 +41,13 @@ type updateReposConfig struct {
 	macroFileName string
 	macroDefName  string
 	pruneRules    bool
+	toModule      bool
+	check         bool
+	reportFormat  string
+	reportPath    string
 	workspace     *rule.File
 	repoFileMap   map[string]*rule.File
+	moduleFile    *repo.ModuleFile
 }
 
 const updateReposName = "_update-repos"
@@ -76,13 +83,22 @@ func (f macroFlag) String() string {
 func (*updateReposConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 	uc := &updateReposConfig{}
 	c.Exts[updateReposName] = uc
-	fs.StringVar(&uc.repoFilePath, "from_file", "", "Gazelle will translate repositories listed in this file into repository rules in WORKSPACE or a .bzl macro function. Gopkg.lock and go.mod files are supported")
+	fs.StringVar(&uc.repoFilePath, "from_file", "", "Gazelle will translate repositories listed in this file into repository rules in WORKSPACE or a .bzl macro function. Gopkg.lock, go.mod, and go.work files are supported")
 	fs.Var(macroFlag{macroFileName: &uc.macroFileName, macroDefName: &uc.macroDefName}, "to_macro", "Tells Gazelle to write repository rules into a .bzl macro function rather than the WORKSPACE file. . The expected format is: macroFile%defName")
-	fs.BoolVar(&uc.pruneRules, "prune", false, "When enabled, Gazelle will remove rules that no longer have equivalent repos in the Gopkg.lock/go.mod file. Can only used with -from_file.")
+	fs.BoolVar(&uc.pruneRules, "prune", false, "When enabled, Gazelle will remove rules that no longer have equivalent repos in the Gopkg.lock/go.mod/go.work file. Can only used with -from_file.")
+	fs.BoolVar(&uc.check, "check", false, "When enabled, Gazelle will report differences between repository rules imported with -from_file and existing rules without changing any files. Gazelle exits with a non-zero status if there are differences.")
+	fs.StringVar(&uc.reportFormat, "report_format", "text", "when set with -check, format of the report: text or json")
+	fs.StringVar(&uc.reportPath, "report", "", "when set with -check, gazelle will write the report to a file instead of stdout")
+	fs.BoolVar(&uc.toModule, "to_module", false, "When enabled, Gazelle will write Go modules into MODULE.bazel as go_deps module extension tags rather than writing go_repository rules, and it will update use_repo so the main module imports exactly the repositories referenced by BUILD files.")
 }
 
 func (*updateReposConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
 	uc := getUpdateReposConfig(c)
+	// Repository rules are written back to the working tree, so they can't be
+	// read from another source.
+	if source := fs.Lookup("source"); source != nil && source.Value.String() != "" {
+		return fmt.Errorf("-source may not be used with update-repos")
+	}
 	switch {
 	case uc.repoFilePath != "":
 		if len(fs.Args()) != 0 {
@@ -102,16 +118,54 @@ func (*updateReposConfigurer) CheckFlags(fs *flag.FlagSet, c *config.Config) err
 		uc.importPaths = fs.Args()
 	}
 
+	if uc.toModule && uc.macroFileName != "" {
+		return fmt.Errorf("-to_module and -to_macro may not be used together")
+	}
+	if uc.check {
+		if uc.repoFilePath == "" {
+			return fmt.Errorf("the -check option can only be used with -from_file")
+		}
+		if uc.toModule {
+			return fmt.Errorf("-check and -to_module may not be used together")
+		}
+		switch uc.reportFormat {
+		case "text", "json":
+		default:
+			return fmt.Errorf("unrecognized report format: %q", uc.reportFormat)
+		}
+		if uc.reportPath != "" && !filepath.IsAbs(uc.reportPath) {
+			uc.reportPath = filepath.Join(c.WorkDir, uc.reportPath)
+		}
+	} else if uc.reportPath != "" {
+		return fmt.Errorf("-report may only be used with -check")
+	}
+
 	var err error
-	workspacePath := wspace.FindWORKSPACEFile(c.RepoRoot)
-	uc.workspace, err = rule.LoadWorkspaceFile(workspacePath, "")
-	if err != nil {
+	workspacePath := wspace.FindWORKSPACEFileFS(c.FileSystem(), c.RepoRoot)
+	uc.workspace, err = loadWorkspaceFile(c, workspacePath)
+	if os.IsNotExist(err) && uc.toModule {
+		// WORKSPACE is optional with Bzlmod.
+		uc.workspace = rule.EmptyFile(workspacePath, "")
+	} else if err != nil {
 		return fmt.Errorf("loading WORKSPACE file: %v", err)
 	}
-	c.Repos, uc.repoFileMap, err = repo.ListRepositories(uc.workspace)
+	c.Repos, uc.repoFileMap, err = repo.ListRepositoriesFS(c.FileSystem(), uc.workspace)
 	if err != nil {
 		return fmt.Errorf("loading WORKSPACE file: %v", err)
 	}
+	uc.moduleFile, err = loadModuleFile(c)
+	if err != nil {
+		return fmt.Errorf("loading MODULE.bazel file: %v", err)
+	}
+	if uc.moduleFile != nil {
+		moduleRepos, err := repo.ListModuleRepositories(c.FileSystem(), uc.moduleFile)
+		if err != nil {
+			return fmt.Errorf("loading MODULE.bazel file: %v", err)
+		}
+		c.Repos = repo.MergeModuleRepositories(c.Repos, moduleRepos)
+	} else if uc.toModule {
+		return fmt.Errorf("-to_module: MODULE.bazel not found in %s", c.RepoRoot)
+	}
 
 	return nil
 }
@@ -122,8 +176,8 @@ func (*updateReposConfigurer) Configure(c *config.Config, rel string, f *rule.Fi
 
 func updateRepos(wd string, args []string) (err error) {
 	// Build configuration with all languages.
-	cexts := make([]config.Configurer, 0, len(languages)+2)
-	cexts = append(cexts, &config.CommonConfigurer{}, &updateReposConfigurer{})
+	cexts := make([]config.Configurer, 0, len(languages)+3)
+	cexts = append(cexts, &config.CommonConfigurer{}, &updateReposConfigurer{}, &walk.Configurer{})
 	kinds := make(map[string]rule.KindInfo)
 	loads := []rule.LoadInfo{}
 	for _, lang := range languages {
@@ -163,6 +217,14 @@ func updateRepos(wd string, args []string) (err error) {
 		lang.Fix(c, uc.workspace)
 	}
 
+	// Languages that implement lifecycle hooks are called with ctx between
+	// phases. ctx is cancelled on SIGINT or SIGTERM, or when we return.
+	ctx, cancel := hookContext(c)
+	defer cancel()
+	if err := beforeWalk(ctx, language.BeforeWalkArgs{Config: c, Cmd: updateReposCmd.String()}); err != nil {
+		return err
+	}
+
 	// Generate rules from command language arguments or by importing a file.
 	var gen, empty []*rule.Rule
 	if uc.repoFilePath == "" {
@@ -174,6 +236,24 @@ func updateRepos(wd string, args []string) (err error) {
 		return err
 	}
 
+	if uc.toModule {
+		if err := updateModuleFile(c, cexts, gen); err != nil {
+			return err
+		}
+		if err := afterGenerate(ctx, language.AfterGenerateArgs{Config: c, Cmd: updateReposCmd.String()}); err != nil {
+			return err
+		}
+		if err := afterResolve(ctx, language.AfterResolveArgs{Config: c, Cmd: updateReposCmd.String()}); err != nil {
+			return err
+		}
+		txn := newFileTxn()
+		txn.write(uc.moduleFile.Path, uc.moduleFile.Format())
+		if err := txn.commitWithBackup(c.RepoRoot); err != nil {
+			return err
+		}
+		return c.CheckStrict()
+	}
+
 	// Organize generated and empty rules by file. A rule should go into the file
 	// it came from (by name). New rules should go into WORKSPACE or the file
 	// specified with -to_macro.
@@ -221,8 +301,10 @@ func updateRepos(wd string, args []string) (err error) {
 		if uc.macroFileName == "" {
 			newGenFile = uc.workspace
 		} else {
-			var err error
-			newGenFile, err = rule.LoadMacroFile(macroPath, "", uc.macroDefName)
+			data, err := c.FileSystem().ReadFile(macroPath)
+			if err == nil {
+				newGenFile, err = rule.LoadMacroData(macroPath, "", uc.macroDefName, data)
+			}
 			if os.IsNotExist(err) {
 				newGenFile, err = rule.EmptyMacroFile(macroPath, "", uc.macroDefName)
 				if err != nil {
@@ -233,6 +315,16 @@ func updateRepos(wd string, args []string) (err error) {
 			}
 		}
 	}
+	if uc.check {
+		cr := compareRepos(c, gen, newGenFile)
+		if err := writeCheckReport(cr, uc.reportFormat, uc.reportPath); err != nil {
+			return err
+		}
+		if cr.isStale() {
+			return staleError
+		}
+		return nil
+	}
 	genForFiles[newGenFile] = append(genForFiles[newGenFile], newGen...)
 
 	workspaceInsertIndex := findWorkspaceInsertIndex(uc.workspace, kinds, loads)
@@ -270,7 +362,7 @@ func updateRepos(wd string, args []string) (err error) {
 
 	updatedFiles := make(map[string]*rule.File)
 	for _, f := range sortedFiles {
-		merger.MergeFile(f, emptyForFiles[f], genForFiles[f], merger.PreResolve, kinds)
+		merger.MergeFileWithDiagnostics(f, emptyForFiles[f], genForFiles[f], merger.PreResolve, kinds, c.Diagnostics)
 		merger.FixLoads(f, loads)
 		if f == uc.workspace {
 			if err := merger.CheckGazelleLoaded(f); err != nil {
@@ -285,20 +377,30 @@ func updateRepos(wd string, args []string) (err error) {
 		}
 	}
 
-	// Write updated files to disk.
+	if err := afterGenerate(ctx, language.AfterGenerateArgs{Config: c, Cmd: updateReposCmd.String(), Files: sortedFiles}); err != nil {
+		return err
+	}
+	if err := afterResolve(ctx, language.AfterResolveArgs{Config: c, Cmd: updateReposCmd.String(), Files: sortedFiles}); err != nil {
+		return err
+	}
+
+	// Write updated files to disk. Files are written together, so a failure
+	// doesn't leave WORKSPACE and macro files out of sync.
+	txn := newFileTxn()
 	for _, f := range sortedFiles {
 		if uf := updatedFiles[f.Path]; uf != nil {
 			if f.DefName != "" {
 				uf.SortMacro()
 			}
-			if err := uf.Save(uf.Path); err != nil {
-				return err
-			}
+			txn.write(uf.Path, uf.Format())
 			delete(updatedFiles, f.Path)
 		}
 	}
+	if err := txn.commitWithBackup(c.RepoRoot); err != nil {
+		return err
+	}
 
-	return nil
+	return c.CheckStrict()
 }
 
 func newUpdateReposConfiguration(wd string, args []string, cexts []config.Configurer) (*config.Config, error) {
@@ -336,10 +438,17 @@ gazelle update-repos example.com/repo1 example.com/repo2
 # Import repositories from lock file
 gazelle update-repos -from_file=file
 
+# Import modules into MODULE.bazel
+gazelle update-repos -from_file=go.mod -to_module
+
+# Report differences between go.mod and repository rules without changes
+gazelle update-repos -from_file=go.mod -check
+
 The update-repos command updates repository rules in the WORKSPACE file.
 update-repos can add or update repositories explicitly by import path.
 update-repos can also import repository rules from a vendoring tool's lock
-file (currently only deps' Gopkg.lock is supported).
+file (currently only deps' Gopkg.lock is supported). With -to_module,
+update-repos writes go_deps module extension tags into MODULE.bazel instead.
 
 FLAGS:
 
@@ -390,14 +499,127 @@ func importRepos(c *config.Config, rc *repo.RemoteCache) (gen, empty []*rule.Rul
 		}
 	}
 	res := importer.ImportRepos(language.ImportReposArgs{
-		Config: c,
-		Path:   uc.repoFilePath,
-		Prune:  uc.pruneRules,
-		Cache:  rc,
+		Config:   c,
+		Path:     uc.repoFilePath,
+		Prune:    uc.pruneRules,
+		Cache:    rc,
+		ReadOnly: uc.check,
 	})
 	return res.Gen, res.Empty, res.Error
 }
 
+// updateModuleFile writes Go modules described by go_repository rules in gen
+// into MODULE.bazel as go_deps.module tags. Existing tags for the same
+// modules are updated. With -prune, tags for other modules are removed.
+// use_repo for go_deps is then updated to import exactly the repositories
+// referenced by BUILD files in the repository.
+func updateModuleFile(c *config.Config, cexts []config.Configurer, gen []*rule.Rule) error {
+	uc := getUpdateReposConfig(c)
+	mf := uc.moduleFile
+	ext := mf.Extension(repo.GoDepsExtensionName)
+	if ext == nil {
+		ext = mf.AddExtension(gazelleExtensionsLabel(mf), repo.GoDepsExtensionName, repo.GoDepsExtensionName)
+	}
+
+	genPaths := make(map[string]bool)
+	for _, r := range gen {
+		if r.Kind() != "go_repository" {
+			continue
+		}
+		path := r.AttrString("importpath")
+		if r.AttrString("version") == "" {
+			return fmt.Errorf("-to_module: %s: only Go modules with versions may be written to MODULE.bazel", path)
+		}
+		if replace := r.AttrString("replace"); replace != "" {
+			// go_deps.module has no attribute for replacements. The extension
+			// only applies replace directives it reads from go.mod.
+			return fmt.Errorf("-to_module: %s is replaced by %s, which go_deps.module tags can't express; use go_deps.from_file to read replace directives from go.mod instead", path, replace)
+		}
+		genPaths[path] = true
+		mf.SetTag(ext, "module", "path", map[string]string{
+			"path":    path,
+			"version": r.AttrString("version"),
+			"sum":     r.AttrString("sum"),
+		})
+	}
+	if uc.pruneRules {
+		var prunePaths []string
+		for _, tag := range ext.Tags {
+			if path := tag.Attrs["path"]; tag.Name == "module" && !genPaths[path] {
+				prunePaths = append(prunePaths, path)
+			}
+		}
+		for _, path := range prunePaths {
+			mf.DeleteTag(ext, "module", "path", path)
+		}
+	}
+
+	// Find the repositories the extension declares. Each may be imported under
+	// its own name or an alias that's already in use_repo.
+	moduleRepos, err := repo.ListModuleRepositories(c.FileSystem(), mf)
+	if err != nil {
+		return err
+	}
+	candidates := make(map[string]string)
+	for apparent, name := range ext.Repos {
+		candidates[apparent] = name
+	}
+	for _, r := range moduleRepos {
+		if r.Kind() != "go_repository" {
+			continue
+		}
+		name := label.ImportPathToBazelRepoName(r.AttrString("importpath"))
+		if _, ok := ext.Repos[r.Name()]; !ok {
+			candidates[name] = name
+		}
+	}
+
+	referenced := findReferencedRepos(c, cexts)
+	useRepos := make(map[string]string)
+	for apparent, name := range candidates {
+		if referenced[apparent] {
+			useRepos[apparent] = name
+		}
+	}
+	mf.SetUseRepo(ext, useRepos)
+	return nil
+}
+
+// gazelleExtensionsLabel returns the label of the .bzl file that declares
+// Gazelle's module extensions, using the apparent name of the gazelle module
+// if the main module depends on it.
+func gazelleExtensionsLabel(mf *repo.ModuleFile) string {
+	name := "gazelle"
+	for _, dep := range mf.Deps {
+		if dep.Name == "gazelle" {
+			name = dep.RepoName
+			break
+		}
+	}
+	return "@" + name + "//:extensions.bzl"
+}
+
+// findReferencedRepos returns the set of repository names used in labels in
+// build files in the repository.
+func findReferencedRepos(c *config.Config, cexts []config.Configurer) map[string]bool {
+	referenced := make(map[string]bool)
+	walk.Walk(c, cexts, []string{c.RepoRoot}, walk.VisitAllUpdateSubdirsMode, func(_, _ string, _ *config.Config, _ bool, f *rule.File, _, _, _ []string) {
+		if f == nil {
+			return
+		}
+		bzl.Walk(f.File, func(x bzl.Expr, _ []bzl.Expr) {
+			s, ok := x.(*bzl.StringExpr)
+			if !ok || !strings.HasPrefix(s.Value, "@") {
+				return
+			}
+			if l, err := label.Parse(s.Value); err == nil && l.Repo != "" {
+				referenced[l.Repo] = true
+			}
+		})
+	})
+	return referenced
+}
+
 // findWorkspaceInsertIndex reads a WORKSPACE file and finds an index within
 // f.File.Stmt where new direct dependencies should be inserted. In general, new
 // dependencies should be inserted after repository rules are loaded (described
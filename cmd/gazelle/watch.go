@@ -0,0 +1,672 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay is how long watch waits after a file system event for more
+// events before updating build files. Editors often write several files
+// (or one file several times) when saving.
+const watchDelay = 100 * time.Millisecond
+
+// watchState holds everything watch keeps in memory between updates: the
+// configuration, the rules generated in each directory, and the build files
+// they were merged into. When files change, rules are generated again only
+// in the affected directories. The index is rebuilt from memory, and
+// dependencies are resolved again in the affected directories and in
+// directories with rules that depend on rules whose imports changed. Only
+// build files whose contents change are written.
+type watchState struct {
+	wd   string
+	args []string
+
+	c         *config.Config
+	cexts     []config.Configurer
+	kinds     map[string]rule.KindInfo
+	loads     []rule.LoadInfo
+	exts      []interface{}
+	mrslv     *metaResolver
+	rc        *repo.RemoteCache
+	cleanupRc func() error
+
+	// tasks holds the most recent task for each visited directory, by
+	// slash-separated path relative to the repository root.
+	tasks map[string]*dirTask
+
+	// written maps paths of build files written by watch to hashes of their
+	// contents, so that events caused by those writes are ignored.
+	written map[string]string
+
+	// watched is the set of directories added to the file system watcher.
+	watched map[string]bool
+
+	// importKeys maps each directory to the importKey of each rule in its
+	// build file, as of the last update. It's nil until all directories have
+	// been resolved once.
+	importKeys map[string]map[string]string
+
+	// rdeps maps labels of rules in the repository, without the repository
+	// name, to the directories whose build files refer to them. deps holds
+	// the labels each directory refers to, so rdeps can be updated.
+	rdeps map[label.Label]map[string]bool
+	deps  map[string][]label.Label
+}
+
+// errReload is returned by watchState.update when the repository
+// configuration changed, and the state needs to be rebuilt from scratch.
+var errReload = errors.New("repository configuration changed; reloading")
+
+func runWatch(wd string, args []string) error {
+	ws, err := newWatchState(wd, args)
+	if err != nil {
+		return err
+	}
+	defer ws.close()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := ws.updateAll(); err != nil {
+		return err
+	}
+	ws.addWatches(w)
+	log.Printf("watching %d directories for changes", len(ws.watched))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	return watchLoop(ws, w, stop)
+}
+
+// watchLoop updates build files each time files in the directories watched
+// by w change, until a value is received from stop. If the repository
+// configuration changes, ws is loaded again.
+func watchLoop(ws *watchState, w *fsnotify.Watcher, stop <-chan os.Signal) error {
+	pending := make(map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			pending[ev.Name] = true
+			timer = time.After(watchDelay)
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Print(err)
+
+		case <-timer:
+			timer = nil
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			pending = make(map[string]bool)
+			err := ws.update(paths)
+			if err == errReload {
+				log.Print(err)
+				err = ws.reload()
+			}
+			if err != nil {
+				log.Print(err)
+			}
+			ws.addWatches(w)
+
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// newWatchState loads the configuration for watch. No rules are generated
+// until updateAll is called.
+func newWatchState(wd string, args []string) (*watchState, error) {
+	ws := &watchState{
+		wd:      wd,
+		args:    args,
+		kinds:   make(map[string]rule.KindInfo),
+		loads:   genericLoads,
+		mrslv:   newMetaResolver(),
+		tasks:   make(map[string]*dirTask),
+		written: make(map[string]string),
+		watched: make(map[string]bool),
+	}
+	ws.cexts = append(ws.cexts,
+		&config.CommonConfigurer{},
+		&updateConfigurer{},
+		&walk.Configurer{},
+		&resolve.Configurer{})
+	for _, lang := range languages {
+		ws.cexts = append(ws.cexts, lang)
+		for kind, info := range lang.Kinds() {
+			ws.mrslv.AddBuiltin(kind, lang)
+			ws.kinds[kind] = info
+		}
+		ws.loads = append(ws.loads, lang.Loads()...)
+		ws.exts = append(ws.exts, lang)
+	}
+
+	c, err := newFixUpdateConfiguration(wd, watchCmd, args, ws.cexts)
+	if err != nil {
+		return nil, err
+	}
+	uc := getUpdateConfig(c)
+	if uc.txn == nil {
+		return nil, errors.New("watch: -mode must be fix")
+	}
+	if uc.cachePath != "" {
+		return nil, errors.New("watch: -cache can't be used; generated rules are kept in memory")
+	}
+	ws.c = c
+	ws.rc, ws.cleanupRc = repo.NewRemoteCache(uc.repos)
+	return ws, nil
+}
+
+// reload loads the configuration again and regenerates rules in all
+// directories.
+func (ws *watchState) reload() error {
+	nws, err := newWatchState(ws.wd, ws.args)
+	if err != nil {
+		return err
+	}
+	nws.watched = ws.watched
+	nws.written = ws.written
+	ws.close()
+	*ws = *nws
+	return ws.updateAll()
+}
+
+func (ws *watchState) close() {
+	if err := ws.cleanupRc(); err != nil {
+		log.Print(err)
+	}
+}
+
+// updateAll generates rules in all directories given on the command line,
+// then resolves dependencies and writes build files.
+func (ws *watchState) updateAll() error {
+	uc := getUpdateConfig(ws.c)
+	ws.tasks = make(map[string]*dirTask)
+	ws.importKeys = nil
+	return ws.run([]walkRequest{{uc.dirs, uc.walkMode}})
+}
+
+// update generates rules again in directories affected by changes to paths,
+// then resolves dependencies and writes build files. If the repository
+// configuration changed, errReload is returned.
+func (ws *watchState) update(paths []string) error {
+	uc := getUpdateConfig(ws.c)
+	workspacePath := wspace.FindWORKSPACEFile(ws.c.RepoRoot)
+	isBuildFile := make(map[string]bool)
+	for _, name := range ws.c.ValidBuildFileNames {
+		isBuildFile[name] = true
+	}
+
+	dirs := make(map[string]bool)
+	subtrees := make(map[string]bool)
+	for _, p := range paths {
+		rel, err := filepath.Rel(ws.c.RepoRoot, p)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		base := path.Base(rel)
+		if strings.Contains(base, ".gazelle-tmp-") || rel == ".git" || strings.HasPrefix(rel, ".git/") {
+			continue
+		}
+		if p == workspacePath {
+			return errReload
+		}
+		if rel == ".bazelignore" || base == ".gitignore" {
+			// Walk may skip or visit different directories.
+			return errReload
+		}
+		for _, f := range uc.workspaceFiles {
+			if p == f.Path {
+				return errReload
+			}
+		}
+
+		parent := path.Dir(rel)
+		if parent == "." {
+			parent = ""
+		}
+		fi, statErr := os.Stat(p)
+		switch {
+		case statErr == nil && fi.IsDir():
+			// A directory was created. Its parent's list of subdirectories
+			// changed too.
+			subtrees[rel] = true
+			dirs[parent] = true
+
+		case isBuildFile[base]:
+			if statErr == nil {
+				if content, err := ioutil.ReadFile(p); err == nil && ws.written[p] == hashContent(content) {
+					// This is a build file we wrote.
+					continue
+				}
+			}
+			// Directives in a build file may affect subdirectories.
+			if !ws.shouldUpdate(parent) {
+				return errReload
+			}
+			subtrees[parent] = true
+
+		case os.IsNotExist(statErr) && ws.tasks[rel] != nil:
+			// A directory was deleted.
+			for r := range ws.tasks {
+				if pathtools.HasPrefix(r, rel) {
+					delete(ws.watched, ws.tasks[r].dir)
+					delete(ws.tasks, r)
+				}
+			}
+			dirs[parent] = true
+
+		default:
+			dirs[parent] = true
+		}
+	}
+
+	// Directories in changed subtrees are all generated again. Directories
+	// outside the update roots are only indexed, and their build files
+	// didn't change, so they're skipped.
+	var subtreeDirs, dirDirs []string
+	for rel := range subtrees {
+		if ws.shouldUpdate(rel) && !ws.coveredBy(rel, subtrees) {
+			subtreeDirs = append(subtreeDirs, filepath.Join(ws.c.RepoRoot, filepath.FromSlash(rel)))
+		}
+	}
+	for rel := range dirs {
+		if ws.shouldUpdate(rel) && !subtrees[rel] && !ws.coveredBy(rel, subtrees) {
+			dir := filepath.Join(ws.c.RepoRoot, filepath.FromSlash(rel))
+			if _, err := os.Stat(dir); err == nil {
+				dirDirs = append(dirDirs, dir)
+			}
+		}
+	}
+	if len(subtreeDirs) == 0 && len(dirDirs) == 0 {
+		return nil
+	}
+	sort.Strings(subtreeDirs)
+	sort.Strings(dirDirs)
+
+	subtreeMode := walk.UpdateDirsMode
+	if uc.walkMode == walk.UpdateSubdirsMode || uc.walkMode == walk.VisitAllUpdateSubdirsMode {
+		subtreeMode = walk.UpdateSubdirsMode
+	}
+	var regen []walkRequest
+	if len(subtreeDirs) > 0 {
+		regen = append(regen, walkRequest{subtreeDirs, subtreeMode})
+	}
+	if len(dirDirs) > 0 {
+		regen = append(regen, walkRequest{dirDirs, walk.UpdateDirsMode})
+	}
+	return ws.run(regen)
+}
+
+// walkRequest is a set of directories to walk in a mode.
+type walkRequest struct {
+	dirs []string
+	mode walk.Mode
+}
+
+// run generates rules in the directories in each request. Rules generated
+// earlier in other directories are kept. Dependencies are then resolved in
+// the regenerated directories and their dependents (see resolveDirs), and
+// changed build files are written.
+func (ws *watchState) run(regen []walkRequest) error {
+	start := time.Now()
+	uc := getUpdateConfig(ws.c)
+
+	// Files staged by an earlier update that failed are discarded, so they
+	// aren't written along with this one.
+	uc.txn = newFileTxn()
+
+	// Languages that implement lifecycle hooks are called with ctx between
+	// phases. ctx is cancelled on SIGINT or SIGTERM, or when we return.
+	ctx, cancel := hookContext(ws.c)
+	defer cancel()
+	var allDirs []string
+	for _, req := range regen {
+		allDirs = append(allDirs, req.dirs...)
+	}
+	if err := beforeWalk(ctx, language.BeforeWalkArgs{Config: ws.c, Cmd: watchCmd.String(), Dirs: allDirs}); err != nil {
+		return err
+	}
+
+	// Walk mutates the root configuration, so each walk gets a copy.
+	regenerated := make(map[string]bool)
+	for _, req := range regen {
+		tasks := generateTasks(ws.c.Clone(), ws.cexts, req.dirs, req.mode, nil, ws.kinds, ws.mrslv)
+		for _, t := range tasks {
+			<-t.done
+			ws.tasks[t.rel] = t
+			regenerated[t.rel] = true
+		}
+	}
+
+	// Rebuild the index from rules in memory. Directories are indexed in
+	// order so the output doesn't depend on which ones were regenerated.
+	rels := make([]string, 0, len(ws.tasks))
+	for rel := range ws.tasks {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	tasks := make([]*dirTask, len(rels))
+	for i, rel := range rels {
+		tasks[i] = ws.tasks[rel]
+	}
+	ruleIndex := resolve.NewRuleIndex(ws.mrslv.Resolver, ws.exts...)
+	visits := indexTasks(ws.c, ruleIndex, tasks)
+	ruleIndex.Finish()
+
+	// Only directories whose dependencies may resolve differently are
+	// resolved and written again. Build files in other directories already
+	// have dependencies resolved from the last update.
+	if dirs := ws.resolveDirs(regenerated); dirs != nil {
+		var affected []visitRecord
+		for _, v := range visits {
+			if dirs[v.pkgRel] {
+				affected = append(affected, v)
+			}
+		}
+		visits = affected
+	}
+
+	files := make([]*rule.File, 0, len(visits))
+	for _, v := range visits {
+		files = append(files, v.file)
+	}
+	if err := afterGenerate(ctx, language.AfterGenerateArgs{Config: ws.c, Cmd: watchCmd.String(), Files: files, Index: ruleIndex}); err != nil {
+		return err
+	}
+
+	resolveVisits(ws.c, ruleIndex, ws.rc, ws.mrslv, ws.kinds, visits, uc.jobs)
+	if err := afterResolve(ctx, language.AfterResolveArgs{Config: ws.c, Cmd: watchCmd.String(), Files: files}); err != nil {
+		return err
+	}
+	for _, v := range visits {
+		ws.setDeps(v.pkgRel, ruleLabels(v.file))
+	}
+
+	for _, v := range visits {
+		merger.FixLoads(v.file, applyKindMappings(v.mappedKinds, ws.loads))
+		if err := uc.emit(v.c, v.file); err != nil {
+			return err
+		}
+	}
+	written := uc.txn.pending()
+	if err := uc.txn.commitWithBackup(ws.c.RepoRoot); err != nil {
+		return err
+	}
+	var writtenRels []string
+	for p, content := range written {
+		ws.written[p] = hashContent(content)
+		if rel, err := filepath.Rel(ws.c.RepoRoot, p); err == nil {
+			writtenRels = append(writtenRels, filepath.ToSlash(rel))
+		}
+	}
+	sort.Strings(writtenRels)
+	for _, rel := range writtenRels {
+		log.Printf("updated %s", rel)
+	}
+	if len(writtenRels) > 0 {
+		log.Printf("updated %d build files in %v", len(writtenRels), time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// resolveDirs returns the set of directories where dependencies should be
+// resolved again after rules were generated in the regenerated directories.
+// That's the regenerated directories, plus directories with rules that
+// depend on rules that were removed or now provide different imports. The
+// import keys recorded for each directory are updated.
+//
+// nil is returned if all directories should be resolved: on the first
+// update, or when rules provide new imports, since any directory might
+// import them.
+func (ws *watchState) resolveDirs(regenerated map[string]bool) map[string]bool {
+	newKeys := make(map[string]map[string]string)
+	for rel := range regenerated {
+		t := ws.tasks[rel]
+		c, f := t.c, t.file
+		if t.visit != nil {
+			c, f = t.visit.c, t.visit.file
+		}
+		keys := make(map[string]string)
+		if f != nil {
+			for _, r := range f.Rules {
+				keys[r.Name()] = importKey(c, ws.mrslv, rel, r, f)
+			}
+		}
+		newKeys[rel] = keys
+	}
+	if ws.importKeys == nil {
+		ws.importKeys = newKeys
+		return nil
+	}
+
+	// Directories that were deleted no longer have any rules.
+	for rel := range ws.importKeys {
+		if _, ok := ws.tasks[rel]; !ok {
+			newKeys[rel] = nil
+		}
+	}
+
+	dirs := make(map[string]bool)
+	all := false
+	for rel, keys := range newKeys {
+		dirs[rel] = true
+		oldKeys := ws.importKeys[rel]
+		for name, oldKey := range oldKeys {
+			if newKey, ok := keys[name]; !ok || newKey != oldKey {
+				for dep := range ws.rdeps[label.New("", rel, name)] {
+					dirs[dep] = true
+				}
+			}
+		}
+		for name, newKey := range keys {
+			if providesNewImports(oldKeys[name], newKey) {
+				all = true
+			}
+		}
+		if keys == nil {
+			delete(ws.importKeys, rel)
+			ws.setDeps(rel, nil)
+		} else {
+			ws.importKeys[rel] = keys
+		}
+	}
+	if all {
+		return nil
+	}
+	return dirs
+}
+
+// providesNewImports returns whether the import key newKey includes imports
+// that oldKey doesn't. Both keys are returned by importKey; oldKey is empty
+// if the rule is new.
+func providesNewImports(oldKey, newKey string) bool {
+	old := make(map[string]bool)
+	for _, spec := range importKeySpecs(oldKey) {
+		old[spec] = true
+	}
+	for _, spec := range importKeySpecs(newKey) {
+		if !old[spec] {
+			return true
+		}
+	}
+	return false
+}
+
+// importKeySpecs returns the imports listed in a key returned by importKey.
+func importKeySpecs(key string) []string {
+	fields := strings.Fields(key)
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields[1:]
+}
+
+// setDeps records the labels the build file in rel refers to, replacing
+// labels recorded earlier.
+func (ws *watchState) setDeps(rel string, labels []label.Label) {
+	if ws.rdeps == nil {
+		ws.rdeps = make(map[label.Label]map[string]bool)
+		ws.deps = make(map[string][]label.Label)
+	}
+	for _, l := range ws.deps[rel] {
+		delete(ws.rdeps[l], rel)
+	}
+	var deps []label.Label
+	for _, l := range labels {
+		if l.Repo != "" && l.Repo != ws.c.RepoName {
+			continue
+		}
+		l = label.New("", l.Pkg, l.Name)
+		if ws.rdeps[l] == nil {
+			ws.rdeps[l] = make(map[string]bool)
+		}
+		ws.rdeps[l][rel] = true
+		deps = append(deps, l)
+	}
+	if deps == nil {
+		delete(ws.deps, rel)
+	} else {
+		ws.deps[rel] = deps
+	}
+}
+
+// shouldUpdate returns whether rel is in a directory given on the command
+// line (or a subdirectory, with -r).
+func (ws *watchState) shouldUpdate(rel string) bool {
+	uc := getUpdateConfig(ws.c)
+	recursive := uc.walkMode == walk.UpdateSubdirsMode || uc.walkMode == walk.VisitAllUpdateSubdirsMode
+	for _, dir := range uc.dirs {
+		root, err := filepath.Rel(ws.c.RepoRoot, dir)
+		if err != nil {
+			continue
+		}
+		root = filepath.ToSlash(root)
+		if root == "." {
+			root = ""
+		}
+		if rel == root || recursive && pathtools.HasPrefix(rel, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// coveredBy returns whether rel is a strict subdirectory of a directory in
+// subtrees.
+func (ws *watchState) coveredBy(rel string, subtrees map[string]bool) bool {
+	for rel != "" {
+		rel = path.Dir(rel)
+		if rel == "." {
+			rel = ""
+		}
+		if subtrees[rel] {
+			return true
+		}
+	}
+	return false
+}
+
+// addWatches adds directories visited by Walk to w.
+func (ws *watchState) addWatches(w *fsnotify.Watcher) {
+	for _, t := range ws.tasks {
+		if ws.watched[t.dir] {
+			continue
+		}
+		if err := w.Add(t.dir); err != nil {
+			log.Print(err)
+			continue
+		}
+		ws.watched[t.dir] = true
+	}
+	// The repository root is also watched for changes to WORKSPACE, even if
+	// it's not visited.
+	if !ws.watched[ws.c.RepoRoot] {
+		if err := w.Add(ws.c.RepoRoot); err != nil {
+			log.Print(err)
+		} else {
+			ws.watched[ws.c.RepoRoot] = true
+		}
+	}
+}
+
+func watchUsage(fs *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: gazelle watch [flags...] [package-dirs...]
+
+The watch command updates build files like the update command, then keeps
+running and updates build files again whenever files in the repository
+change. It's meant to be left running in a terminal while editing code.
+
+Rules, the dependency index, and the configuration for each directory are
+kept in memory. When a file changes, rules are generated again only in its
+directory; when a build file changes, in its directory and subdirectories.
+Dependencies are then resolved again in those directories and in
+directories with rules that depend on rules that were removed or whose
+imports changed. When rules provide new imports, dependencies are resolved
+again throughout the repository. Only build files whose contents change are
+written. Changes to WORKSPACE, .bazelignore, or .gitignore files cause all
+directories to be reloaded.
+
+Files are written with -mode=fix; other modes are not supported. Stop
+watching with Ctrl-C.
+
+FLAGS:
+
+`)
+	fs.PrintDefaults()
+}
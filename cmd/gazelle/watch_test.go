@@ -0,0 +1,241 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatchUpdate(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+		{Path: "a/a.go", Content: `package a
+
+import _ "example.com/repo/b"
+`},
+		{Path: "b/b.go", Content: "package b"},
+	})
+	defer cleanup()
+
+	ws, err := newWatchState(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.close()
+	if err := ws.updateAll(); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "a/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = ["//b"],
+)
+`,
+	}})
+
+	// A new source file is added to its directory's build file.
+	cPath := filepath.Join(dir, "b/c.go")
+	if err := ioutil.WriteFile(cPath, []byte("package b"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.update([]string{cPath}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "b/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "b",
+    srcs = [
+        "b.go",
+        "c.go",
+    ],
+    importpath = "example.com/repo/b",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+
+	// When the package moves, a is not regenerated, but its dependencies are
+	// resolved again.
+	files := []testtools.FileSpec{
+		{Path: "lib/BUILD.bazel", Content: "# gazelle:prefix example.com/repo/b"},
+		{Path: "lib/b.go", Content: "package b"},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.Content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.update([]string{filepath.Join(dir, "lib"), filepath.Join(dir, "b")}); err != nil {
+		t.Fatal(err)
+	}
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "a/BUILD.bazel",
+		Content: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "a",
+    srcs = ["a.go"],
+    importpath = "example.com/repo/a",
+    visibility = ["//visibility:public"],
+    deps = ["//lib:b"],
+)
+`,
+	}})
+
+	// Files staged by an update that failed aren't written by the next one.
+	stale := filepath.Join(dir, "stale/BUILD.bazel")
+	getUpdateConfig(ws.c).txn.write(stale, []byte("stale"))
+	if err := ws.update([]string{filepath.Join(dir, "a/a.go")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale file was written: %v", err)
+	}
+
+	// Events caused by our own writes are ignored.
+	written := filepath.Join(dir, "a/BUILD.bazel")
+	if err := ws.update([]string{written}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to WORKSPACE and ignore files cause a reload.
+	for _, name := range []string{"WORKSPACE", ".bazelignore", "a/.gitignore"} {
+		if err := ws.update([]string{filepath.Join(dir, filepath.FromSlash(name))}); err != errReload {
+			t.Errorf("%s: got %v; want %v", name, err, errReload)
+		}
+	}
+}
+
+func TestWatchResolveDirs(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+		{Path: "a/a.go", Content: `package a
+
+import _ "example.com/repo/b"
+`},
+		{Path: "b/b.go", Content: "package b"},
+		{Path: "x/x.go", Content: "package x"},
+	})
+	defer cleanup()
+
+	ws, err := newWatchState(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.close()
+	if err := ws.updateAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rules in b provide the same imports, so only b is resolved again.
+	if got, want := ws.resolveDirs(map[string]bool{"b": true}), map[string]bool{"b": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unchanged: got %v; want %v", got, want)
+	}
+
+	// A rule a depends on was removed, so a is resolved again. x isn't.
+	f := ws.tasks["b"].visit.file
+	f.Rules[0].Delete()
+	f.Sync()
+	if got, want := ws.resolveDirs(map[string]bool{"b": true}), map[string]bool{"a": true, "b": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("removed: got %v; want %v", got, want)
+	}
+
+	// A rule provides a new import, so everything is resolved again.
+	ws.tasks["x"].visit.file.Rules[0].SetAttr("importpath", "example.com/repo/y")
+	if got := ws.resolveDirs(map[string]bool{"x": true}); got != nil {
+		t.Errorf("new import: got %v; want nil", got)
+	}
+}
+
+func TestWatchLoop(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: "# gazelle:prefix example.com/repo"},
+		{Path: "a/a.go", Content: "package a"},
+	})
+	defer cleanup()
+
+	ws, err := newWatchState(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.close()
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := ws.updateAll(); err != nil {
+		t.Fatal(err)
+	}
+	ws.addWatches(w)
+
+	stop := make(chan os.Signal)
+	done := make(chan error)
+	go func() { done <- watchLoop(ws, w, stop) }()
+	defer func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a/b.go"), []byte("package a"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	buildPath := filepath.Join(dir, "a/BUILD.bazel")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		content, err := ioutil.ReadFile(buildPath)
+		if err == nil && strings.Contains(string(content), `"b.go"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("a/BUILD.bazel was not updated; got:\n%s", content)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
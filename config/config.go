@@ -29,13 +29,14 @@ package config
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/internal/wspace"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 // Config holds information about how Gazelle should run. This is based on
@@ -87,14 +88,32 @@ type Config struct {
 	KindMap map[string]MappedKind
 
 	// Repos is a list of repository rules declared in the main WORKSPACE file
-	// or in macros called by the main WORKSPACE file. This may affect rule
-	// generation and dependency resolution.
+	// or in macros called by the main WORKSPACE file. When Bzlmod is used,
+	// it also includes rules describing repositories visible to the main
+	// module through MODULE.bazel (see repo.ListModuleRepositories). This may
+	// affect rule generation and dependency resolution.
 	Repos []*rule.Rule
 
 	// Langs is a list of language names which Gazelle should process.
 	// An empty list means "all languages".
 	Langs []string
 
+	// Diagnostics collects problems found while running Gazelle, like
+	// unknown directives. Extensions should report non-fatal problems here
+	// instead of calling log.Print. Diagnostics is shared by all
+	// configurations derived from the root configuration.
+	Diagnostics *Diagnostics
+
+	// Strict determines whether Gazelle should exit with an error if any
+	// warnings are reported to Diagnostics.
+	Strict bool
+
+	// FS is the file system Gazelle reads source files and build files from
+	// and writes build files to. It's the operating system's file system
+	// unless -source or -overlay is set. If FS is nil, the operating system's
+	// file system is used; read it with FileSystem.
+	FS vfs.FS
+
 	// Exts is a set of configurable extensions. Generally, each language
 	// has its own set of extensions, but other modules may provide their own
 	// extensions as well. Values in here may be populated by command line
@@ -110,10 +129,22 @@ type MappedKind struct {
 func New() *Config {
 	return &Config{
 		ValidBuildFileNames: DefaultValidBuildFileNames,
+		Diagnostics:         &Diagnostics{},
+		FS:                  vfs.OS,
 		Exts:                make(map[string]interface{}),
 	}
 }
 
+// FileSystem returns the file system Gazelle reads and writes files with:
+// c.FS, or vfs.OS if c.FS is nil, as it is in configurations that weren't
+// created with New.
+func (c *Config) FileSystem() vfs.FS {
+	if c.FS == nil {
+		return vfs.OS
+	}
+	return c.FS
+}
+
 // Clone creates a copy of the configuration for use in a subdirectory.
 // Note that the Exts map is copied, but its contents are not.
 // Configurer.Configure should do this, if needed.
@@ -187,7 +218,7 @@ type Configurer interface {
 type CommonConfigurer struct {
 	repoRoot, buildFileNames, readBuildFilesDir, writeBuildFilesDir string
 	indexLibraries                                                  bool
-	langCsv                                                         string
+	langCsv, source, overlay                                        string
 }
 
 func (cc *CommonConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *Config) {
@@ -197,10 +228,21 @@ func (cc *CommonConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *Confi
 	fs.StringVar(&cc.readBuildFilesDir, "experimental_read_build_files_dir", "", "path to a directory where build files should be read from (instead of -repo_root)")
 	fs.StringVar(&cc.writeBuildFilesDir, "experimental_write_build_files_dir", "", "path to a directory where build files should be written to (instead of -repo_root)")
 	fs.StringVar(&cc.langCsv, "lang", "", "if non-empty, process only these languages (e.g. \"go,proto\")")
+	fs.StringVar(&cc.source, "source", "", "if non-empty, read files from this source instead of the working tree: git:REV for a revision of the git repository, or tar:FILE for a tar archive of the repository, optionally gzipped")
+	fs.StringVar(&cc.overlay, "overlay", "", "JSON file in the format of 'go build -overlay' that replaces the contents of files, for example, with unsaved editor buffers")
+	fs.BoolVar(&c.Strict, "strict", false, "when true, gazelle will exit with an error if any warnings are reported, for example, for unknown directives")
 }
 
 func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
 	var err error
+	sourceKind, sourceArg := splitSource(cc.source)
+	if sourceKind != "" && sourceKind != "git" && sourceKind != "tar" || sourceKind != "" && sourceArg == "" {
+		return fmt.Errorf("-source: expected git:REV or tar:FILE, got %q", cc.source)
+	}
+	if cc.repoRoot == "" && sourceKind == "tar" {
+		// The archive is the repository, so there's nothing to search for.
+		cc.repoRoot = c.WorkDir
+	}
 	if cc.repoRoot == "" {
 		if wsDir := os.Getenv("BUILD_WORKSPACE_DIRECTORY"); wsDir != "" {
 			cc.repoRoot = wsDir
@@ -219,6 +261,36 @@ func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
 	if err != nil {
 		return fmt.Errorf("%s: failed to resolve symlinks: %v", cc.repoRoot, err)
 	}
+	switch sourceKind {
+	case "git":
+		if c.FS, err = vfs.NewGitFS(c.RepoRoot, sourceArg); err != nil {
+			return fmt.Errorf("-source: %v", err)
+		}
+	case "tar":
+		if !filepath.IsAbs(sourceArg) {
+			sourceArg = filepath.Join(c.WorkDir, sourceArg)
+		}
+		f, err := os.Open(sourceArg)
+		if err != nil {
+			return fmt.Errorf("-source: %v", err)
+		}
+		c.FS, err = vfs.NewTarFS(c.RepoRoot, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("-source: %s: %v", sourceArg, err)
+		}
+	}
+	if cc.overlay != "" {
+		overlayPath := cc.overlay
+		if !filepath.IsAbs(overlayPath) {
+			overlayPath = filepath.Join(c.WorkDir, overlayPath)
+		}
+		replace, err := vfs.ReadOverlayFile(overlayPath, c.WorkDir)
+		if err != nil {
+			return fmt.Errorf("-overlay: %v", err)
+		}
+		c.FS = vfs.NewOverlayFS(c.FileSystem(), replace)
+	}
 	c.ValidBuildFileNames = strings.Split(cc.buildFileNames, ",")
 	if cc.readBuildFilesDir != "" {
 		if filepath.IsAbs(cc.readBuildFilesDir) {
@@ -241,15 +313,75 @@ func (cc *CommonConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error {
 	return nil
 }
 
+// splitSource splits the value of -source into its kind and argument.
+func splitSource(source string) (kind, arg string) {
+	if source == "" {
+		return "", ""
+	}
+	if i := strings.IndexByte(source, ':'); i >= 0 {
+		return source[:i], source[i+1:]
+	}
+	return source, ""
+}
+
 func (cc *CommonConfigurer) KnownDirectives() []string {
 	return []string{"build_file_name", "map_kind", "lang"}
 }
 
+func (cc *CommonConfigurer) DescribeDirectives() []DirectiveInfo {
+	return []DirectiveInfo{
+		{
+			Key: "build_file_name",
+			Doc: "Sets the file names Gazelle recognizes as build files. New files are created with the first name in the list.",
+			Args: []DirectiveArg{
+				{Name: "names", Type: ArgString, List: true},
+			},
+		},
+		{
+			Key: "map_kind",
+			Doc: "Replaces the kind of generated rules of from_kind with to_kind, loaded from to_kind_load. to_kind must accept the same attributes as from_kind.",
+			Args: []DirectiveArg{
+				{Name: "from_kind", Type: ArgString},
+				{Name: "to_kind", Type: ArgString},
+				{Name: "to_kind_load", Type: ArgLabel},
+			},
+			Scope: ScopeAccumulated,
+		},
+		{
+			Key: "lang",
+			Doc: "Limits the languages Gazelle indexes and generates rules for. An empty value selects all languages.",
+			Args: []DirectiveArg{
+				{Name: "languages", Type: ArgString, Optional: true, List: true},
+			},
+		},
+	}
+}
+
+func (cc *CommonConfigurer) DirectiveValues(c *Config, key string) []string {
+	switch key {
+	case "build_file_name":
+		return []string{strings.Join(c.ValidBuildFileNames, ",")}
+	case "map_kind":
+		var values []string
+		for _, mk := range c.KindMap {
+			values = append(values, mk.FromKind+" "+mk.KindName+" "+mk.KindLoad)
+		}
+		sort.Strings(values)
+		return values
+	case "lang":
+		if len(c.Langs) == 0 {
+			return nil
+		}
+		return []string{strings.Join(c.Langs, ",")}
+	}
+	return nil
+}
+
 func (cc *CommonConfigurer) Configure(c *Config, rel string, f *rule.File) {
 	if f == nil {
 		return
 	}
-	for _, d := range f.Directives {
+	for i, d := range f.Directives {
 		switch d.Key {
 		case "build_file_name":
 			c.ValidBuildFileNames = strings.Split(d.Value, ",")
@@ -257,7 +389,7 @@ func (cc *CommonConfigurer) Configure(c *Config, rel string, f *rule.File) {
 		case "map_kind":
 			vals := strings.Fields(d.Value)
 			if len(vals) != 3 {
-				log.Printf("expected three arguments (gazelle:map_kind from_kind to_kind load_file), got %v", vals)
+				c.Diagnostics.Warnf(Position{Path: f.Path, Line: f.DirectiveLine(i)}, DiagInvalidDirective, "expected three arguments (gazelle:map_kind from_kind to_kind load_file), got %v", vals)
 				continue
 			}
 			if c.KindMap == nil {
@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 func TestCommonConfigurerFlags(t *testing.T) {
@@ -87,3 +88,50 @@ func TestCommonConfigurerDirectives(t *testing.T) {
 		t.Errorf("for Langs, got %#v, want %#v", c.Langs, wantLangs)
 	}
 }
+
+func TestDiagnostics(t *testing.T) {
+	c := New()
+	c.Diagnostics.Infof(Position{Path: "a/BUILD.bazel", Line: 3}, "info-code", "not a problem")
+	c.Strict = true
+	if err := c.CheckStrict(); err != nil {
+		t.Errorf("CheckStrict with only info diagnostics: got error %v; want nil", err)
+	}
+
+	c.Diagnostics.Warnf(Position{Path: "a/BUILD.bazel", Line: 4}, DiagUnknownDirective, "unknown directive: gazelle:%s", "foo")
+	c.Diagnostics.Errorf(Position{Path: "b"}, DiagPackageSelection, "multiple packages")
+	want := []string{
+		"a/BUILD.bazel:3: info: not a problem [info-code]",
+		"a/BUILD.bazel:4: warning: unknown directive: gazelle:foo [unknown-directive]",
+		"b: error: multiple packages [package-selection]",
+	}
+	var got []string
+	for _, d := range c.Diagnostics.List() {
+		got = append(got, d.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if n := c.Diagnostics.Count(SeverityWarning); n != 2 {
+		t.Errorf("got %d warnings or errors; want 2", n)
+	}
+	if err := c.CheckStrict(); err == nil {
+		t.Error("CheckStrict: got nil; want error")
+	}
+	c.Strict = false
+	if err := c.CheckStrict(); err != nil {
+		t.Errorf("CheckStrict without -strict: got error %v; want nil", err)
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	// Configurations that weren't created with New read the OS's file system.
+	c := &Config{}
+	if got := c.FileSystem(); got != vfs.OS {
+		t.Errorf("nil FS: got %v; want vfs.OS", got)
+	}
+	m := vfs.NewMapFS("/repo")
+	c.FS = m
+	if got := c.FileSystem(); got != m {
+		t.Errorf("got %v; want %v", got, m)
+	}
+}
@@ -0,0 +1,189 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Severity indicates how serious a Diagnostic is.
+type Severity int
+
+const (
+	// SeverityInfo is used for diagnostics that don't indicate a problem.
+	// They are collected but not printed.
+	SeverityInfo Severity = iota
+
+	// SeverityWarning is used for problems that Gazelle can recover from,
+	// like unknown directives. With -strict, warnings cause Gazelle to exit
+	// with an error.
+	SeverityWarning
+
+	// SeverityError is used for problems that prevent Gazelle from
+	// generating or updating part of a build file.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Codes for diagnostics reported by Gazelle itself. Codes are stable, so
+// they may be used to filter diagnostics in tools. Extensions may report
+// diagnostics with their own codes.
+const (
+	// DiagUnknownDirective is reported for directives that no Configurer
+	// recognizes.
+	DiagUnknownDirective = "unknown-directive"
+
+	// DiagInvalidDirective is reported for directives with values that
+	// can't be parsed.
+	DiagInvalidDirective = "invalid-directive"
+
+	// DiagDuplicateRule is reported when more than one rule with the same
+	// label is indexed.
+	DiagDuplicateRule = "duplicate-rule"
+
+	// DiagAmbiguousMatch is reported when a generated rule can't be merged
+	// because it matches more than one existing rule.
+	DiagAmbiguousMatch = "ambiguous-match"
+
+	// DiagPackageSelection is reported when Gazelle can't choose which
+	// package in a directory to generate rules for.
+	DiagPackageSelection = "package-selection"
+)
+
+// Position is a location in a file where a Diagnostic was reported.
+type Position struct {
+	// Path is the path to the file or directory, usually absolute.
+	Path string
+
+	// Line is the 1-based line number, or 0 if the line is not known.
+	Line int
+}
+
+func (p Position) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d", p.Path, p.Line)
+	}
+	return p.Path
+}
+
+// Diagnostic describes a problem found while running Gazelle.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Pos      Position
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+	if d.Pos.Path != "" {
+		sb.WriteString(d.Pos.String())
+		sb.WriteString(": ")
+	}
+	fmt.Fprintf(&sb, "%s: %s", d.Severity, d.Message)
+	if d.Code != "" {
+		fmt.Fprintf(&sb, " [%s]", d.Code)
+	}
+	return sb.String()
+}
+
+// Diagnostics collects diagnostics reported while Gazelle runs. Warnings
+// and errors are also printed with log.Print when they are reported.
+//
+// Diagnostics is safe for concurrent use. Methods may be called on a nil
+// *Diagnostics; in that case, diagnostics are printed but not collected.
+type Diagnostics struct {
+	mu   sync.Mutex
+	list []Diagnostic
+}
+
+// Report records d.
+func (ds *Diagnostics) Report(d Diagnostic) {
+	if d.Severity >= SeverityWarning {
+		log.Print(d)
+	}
+	if ds == nil {
+		return
+	}
+	ds.mu.Lock()
+	ds.list = append(ds.list, d)
+	ds.mu.Unlock()
+}
+
+// Infof reports a diagnostic with SeverityInfo.
+func (ds *Diagnostics) Infof(pos Position, code, format string, args ...interface{}) {
+	ds.Report(Diagnostic{Severity: SeverityInfo, Code: code, Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf reports a diagnostic with SeverityWarning.
+func (ds *Diagnostics) Warnf(pos Position, code, format string, args ...interface{}) {
+	ds.Report(Diagnostic{Severity: SeverityWarning, Code: code, Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// Errorf reports a diagnostic with SeverityError.
+func (ds *Diagnostics) Errorf(pos Position, code, format string, args ...interface{}) {
+	ds.Report(Diagnostic{Severity: SeverityError, Code: code, Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// List returns the diagnostics reported so far, in the order they were
+// reported.
+func (ds *Diagnostics) List() []Diagnostic {
+	if ds == nil {
+		return nil
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return append([]Diagnostic(nil), ds.list...)
+}
+
+// Count returns the number of diagnostics reported with at least the given
+// severity.
+func (ds *Diagnostics) Count(min Severity) int {
+	n := 0
+	for _, d := range ds.List() {
+		if d.Severity >= min {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckStrict returns an error if c.Strict is set and any warnings or
+// errors were reported.
+func (c *Config) CheckStrict() error {
+	if !c.Strict {
+		return nil
+	}
+	if n := c.Diagnostics.Count(SeverityWarning); n > 0 {
+		return fmt.Errorf("%d warning(s) or error(s) reported; failing because -strict is set", n)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// DirectiveScope describes which directories a directive applies to.
+type DirectiveScope int
+
+const (
+	// ScopeInherited directives apply to the directory where they're written
+	// and its subdirectories. A directive in a subdirectory replaces the
+	// value inherited from its parent.
+	ScopeInherited DirectiveScope = iota
+
+	// ScopeAccumulated directives apply to the directory where they're
+	// written and its subdirectories. Each occurrence adds to the values
+	// inherited from parent directories.
+	ScopeAccumulated
+
+	// ScopeLocal directives only apply to the directory where they're written.
+	ScopeLocal
+)
+
+func (s DirectiveScope) String() string {
+	switch s {
+	case ScopeInherited:
+		return "inherited"
+	case ScopeAccumulated:
+		return "accumulated"
+	case ScopeLocal:
+		return "local"
+	default:
+		return fmt.Sprintf("DirectiveScope(%d)", int(s))
+	}
+}
+
+// ArgType is the type of a directive argument.
+type ArgType int
+
+const (
+	// ArgString arguments may have any value.
+	ArgString ArgType = iota
+
+	// ArgEnum arguments must have one of the values in DirectiveArg.Allowed.
+	ArgEnum
+
+	// ArgBool arguments must be "true" or "false" (or another value accepted
+	// by strconv.ParseBool).
+	ArgBool
+
+	// ArgLabel arguments must be Bazel labels.
+	ArgLabel
+
+	// ArgPath arguments are slash-separated paths or path patterns. Their
+	// meaning depends on the directive, so they are not checked.
+	ArgPath
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case ArgString:
+		return "string"
+	case ArgEnum:
+		return "enum"
+	case ArgBool:
+		return "bool"
+	case ArgLabel:
+		return "label"
+	case ArgPath:
+		return "path"
+	default:
+		return fmt.Sprintf("ArgType(%d)", int(t))
+	}
+}
+
+// DirectiveArg describes an argument of a directive. Arguments are
+// separated by whitespace in the directive's value.
+type DirectiveArg struct {
+	// Name is a short name for the argument, used in usage messages.
+	Name string
+
+	// Type is the type of the argument.
+	Type ArgType
+
+	// Allowed lists the values an ArgEnum argument may have.
+	Allowed []string
+
+	// Optional indicates the argument may be omitted. When fewer arguments
+	// are given than a directive accepts, optional arguments are omitted
+	// from right to left.
+	Optional bool
+
+	// List indicates the argument is a comma-separated list of values, each
+	// of which must have type Type. Only the last argument may be a list.
+	// Whitespace around commas is ignored.
+	List bool
+}
+
+// DirectiveInfo describes a directive: what it does, what arguments it
+// accepts, and where it applies.
+type DirectiveInfo struct {
+	// Key is the name of the directive, as in "# gazelle:key value".
+	Key string
+
+	// Doc is a description of the directive. The first sentence should
+	// summarize it.
+	Doc string
+
+	// Args describes the arguments accepted by the directive. If Args is
+	// empty, the directive's value must be empty.
+	Args []DirectiveArg
+
+	// Scope describes which directories the directive applies to.
+	Scope DirectiveScope
+}
+
+// Usage returns a short summary of the directive's syntax, for example,
+// "gazelle:resolve source-lang [import-lang] import-string label".
+func (di DirectiveInfo) Usage() string {
+	var sb strings.Builder
+	sb.WriteString("gazelle:")
+	sb.WriteString(di.Key)
+	for _, a := range di.Args {
+		sb.WriteByte(' ')
+		name := a.Name
+		if a.List {
+			name += ",..."
+		}
+		if a.Optional {
+			name = "[" + name + "]"
+		}
+		sb.WriteString(name)
+	}
+	return sb.String()
+}
+
+// DirectiveDescriber may be implemented by a Configurer to describe the
+// directives it interprets. Gazelle uses descriptions to check directive
+// values before Configure is called and to generate documentation.
+// Directives listed by KnownDirectives but not described here are not
+// checked.
+//
+// EXPERIMENTAL: this may change or be removed.
+type DirectiveDescriber interface {
+	DescribeDirectives() []DirectiveInfo
+}
+
+// DirectiveValuer may be implemented by a Configurer to report the
+// effective values of the directives it interprets, including defaults and
+// values set with command line flags. "gazelle config" uses this to print
+// the configuration of a directory.
+//
+// EXPERIMENTAL: this may change or be removed.
+type DirectiveValuer interface {
+	// DirectiveValues returns the values of the directive key in c,
+	// formatted as they could be written in a directive. Accumulated
+	// directives may have several values. DirectiveValues returns nil if
+	// the directive has no value.
+	DirectiveValues(c *Config, key string) []string
+}
+
+// DirectiveRegistry holds descriptions of the directives known to a list
+// of Configurers.
+type DirectiveRegistry struct {
+	infos     map[string]DirectiveInfo
+	described map[string]bool
+}
+
+// NewDirectiveRegistry builds a registry of the directives known to cexts.
+// If more than one Configurer describes a directive, the first description
+// is used.
+func NewDirectiveRegistry(cexts []Configurer) *DirectiveRegistry {
+	r := &DirectiveRegistry{
+		infos:     make(map[string]DirectiveInfo),
+		described: make(map[string]bool),
+	}
+	for _, cext := range cexts {
+		if dd, ok := cext.(DirectiveDescriber); ok {
+			for _, info := range dd.DescribeDirectives() {
+				if !r.described[info.Key] {
+					r.infos[info.Key] = info
+					r.described[info.Key] = true
+				}
+			}
+		}
+		for _, key := range cext.KnownDirectives() {
+			if _, ok := r.infos[key]; !ok {
+				r.infos[key] = DirectiveInfo{Key: key}
+			}
+		}
+	}
+	return r
+}
+
+// Lookup returns the description of the directive with the given key.
+// It returns false if no Configurer knows the directive.
+func (r *DirectiveRegistry) Lookup(key string) (DirectiveInfo, bool) {
+	info, ok := r.infos[key]
+	return info, ok
+}
+
+// IsDescribed returns whether the directive with the given key was
+// described by a DirectiveDescriber, as opposed to only being listed by
+// KnownDirectives.
+func (r *DirectiveRegistry) IsDescribed(key string) bool {
+	return r.described[key]
+}
+
+// Directives returns descriptions of all known directives, sorted by key.
+func (r *DirectiveRegistry) Directives() []DirectiveInfo {
+	infos := make([]DirectiveInfo, 0, len(r.infos))
+	for _, info := range r.infos {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
+
+// Check returns an error if the directive d is unknown or if its value
+// doesn't match the arguments in its description.
+func (r *DirectiveRegistry) Check(d rule.Directive) error {
+	info, ok := r.infos[d.Key]
+	if !ok {
+		if s := r.Suggest(d.Key); s != "" {
+			return fmt.Errorf("unknown directive: gazelle:%s; did you mean gazelle:%s?", d.Key, s)
+		}
+		return fmt.Errorf("unknown directive: gazelle:%s", d.Key)
+	}
+	if !r.described[d.Key] {
+		return nil
+	}
+	return checkDirectiveArgs(info, d.Value)
+}
+
+// Suggest returns the key of a known directive that's close to key, for
+// use in error messages about typos. It returns "" if there's no close
+// match.
+func (r *DirectiveRegistry) Suggest(key string) string {
+	keys := make([]string, 0, len(r.infos))
+	for k := range r.infos {
+		keys = append(keys, k)
+	}
+	return closestMatch(key, keys)
+}
+
+func checkDirectiveArgs(info DirectiveInfo, value string) error {
+	fields := strings.Fields(value)
+	nRequired := 0
+	for _, a := range info.Args {
+		if !a.Optional {
+			nRequired++
+		}
+	}
+	hasList := len(info.Args) > 0 && info.Args[len(info.Args)-1].List
+	if len(fields) < nRequired || (len(fields) > len(info.Args) && !hasList) {
+		if len(info.Args) == 0 {
+			return fmt.Errorf("expected no arguments, got %q", value)
+		}
+		return fmt.Errorf("expected arguments %q, got %q", info.Usage(), value)
+	}
+
+	// Omit optional arguments from right to left until the remaining
+	// arguments match the fields.
+	args := info.Args
+	for nOmit := len(args) - len(fields); nOmit > 0; nOmit-- {
+		for i := len(args) - 1; i >= 0; i-- {
+			if args[i].Optional {
+				args = append(args[:i:i], args[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for i, a := range args {
+		if a.List {
+			// The list consumes the rest of the value.
+			rest := strings.Join(fields[i:], " ")
+			for _, v := range strings.Split(rest, ",") {
+				v = strings.TrimSpace(v)
+				if v == "" {
+					return fmt.Errorf("%s: empty value in list %q", a.Name, rest)
+				}
+				if err := checkDirectiveArg(a, v); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err := checkDirectiveArg(a, fields[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDirectiveArg(a DirectiveArg, v string) error {
+	switch a.Type {
+	case ArgEnum:
+		for _, allowed := range a.Allowed {
+			if v == allowed {
+				return nil
+			}
+		}
+		msg := fmt.Sprintf("%s: %q is not one of %s", a.Name, v, strings.Join(a.Allowed, ", "))
+		if s := closestMatch(v, a.Allowed); s != "" {
+			msg += fmt.Sprintf("; did you mean %q?", s)
+		}
+		return errors.New(msg)
+
+	case ArgBool:
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("%s: %q is not true or false", a.Name, v)
+		}
+
+	case ArgLabel:
+		if _, err := label.Parse(v); err != nil {
+			return fmt.Errorf("%s: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// closestMatch returns the string in candidates with the smallest edit
+// distance from s, if that distance is small enough that s is likely a typo.
+// It returns "" if there's no such string.
+func closestMatch(s string, candidates []string) string {
+	maxDist := len(s) / 3
+	if maxDist > 3 {
+		maxDist = 3
+	} else if maxDist < 1 {
+		maxDist = 1
+	}
+	best, bestDist := "", maxDist+1
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < bestDist || d == bestDist && c < best {
+			best, bestDist = c, d
+		}
+	}
+	if bestDist > maxDist {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the number of single-character insertions,
+// deletions, substitutions, and transpositions of adjacent characters
+// needed to change a into b.
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between a[:i] and b[:j].
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,142 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+type describingConfigurer struct{}
+
+func (*describingConfigurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *Config) {}
+func (*describingConfigurer) CheckFlags(fs *flag.FlagSet, c *Config) error          { return nil }
+func (*describingConfigurer) Configure(c *Config, rel string, f *rule.File)         {}
+
+func (*describingConfigurer) KnownDirectives() []string {
+	return []string{"mode", "undescribed"}
+}
+
+func (*describingConfigurer) DescribeDirectives() []DirectiveInfo {
+	return []DirectiveInfo{
+		{
+			Key:  "mode",
+			Args: []DirectiveArg{{Name: "mode", Type: ArgEnum, Allowed: []string{"fast", "slow"}}},
+		},
+		{
+			Key: "enable",
+			Args: []DirectiveArg{
+				{Name: "enabled", Type: ArgBool},
+				{Name: "targets", Type: ArgLabel, Optional: true, List: true},
+			},
+		},
+	}
+}
+
+func TestDirectiveRegistryCheck(t *testing.T) {
+	r := NewDirectiveRegistry([]Configurer{&CommonConfigurer{}, &describingConfigurer{}})
+	for _, tc := range []struct {
+		key, value, wantErr string
+	}{
+		{key: "map_kind", value: "go_binary go_deployable //tools/go:def.bzl"},
+		{key: "map_kind", value: "go_binary go_deployable", wantErr: `expected arguments "gazelle:map_kind from_kind to_kind to_kind_load", got "go_binary go_deployable"`},
+		{key: "map_kind", value: "go_binary go_deployable //tools:x.bzl extra", wantErr: `expected arguments "gazelle:map_kind from_kind to_kind to_kind_load", got "go_binary go_deployable //tools:x.bzl extra"`},
+		{key: "map_kind", value: "a b //tools:", wantErr: `to_kind_load: label parse error: empty name: "//tools:"`},
+		{key: "lang", value: ""},
+		{key: "lang", value: "go, proto"},
+		{key: "lang", value: "go,,proto", wantErr: `languages: empty value in list "go,,proto"`},
+		{key: "mode", value: "fast"},
+		{key: "mode", value: "fsat", wantErr: `mode: "fsat" is not one of fast, slow; did you mean "fast"?`},
+		{key: "mode", value: "medium", wantErr: `mode: "medium" is not one of fast, slow`},
+		{key: "enable", value: "true"},
+		{key: "enable", value: "true //a, //b"},
+		{key: "enable", value: "yes", wantErr: `enabled: "yes" is not true or false`},
+		{key: "undescribed", value: "anything at all"},
+		{key: "build_file_nam", value: "BUILD", wantErr: "unknown directive: gazelle:build_file_nam; did you mean gazelle:build_file_name?"},
+		{key: "zzz", value: "", wantErr: "unknown directive: gazelle:zzz"},
+	} {
+		t.Run(tc.key+" "+tc.value, func(t *testing.T) {
+			err := r.Check(rule.Directive{Key: tc.key, Value: tc.value})
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("got error %v; want success", err)
+			} else if tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr) {
+				t.Errorf("got error %v; want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDirectiveRegistryDirectives(t *testing.T) {
+	r := NewDirectiveRegistry([]Configurer{&describingConfigurer{}})
+	var keys []string
+	for _, info := range r.Directives() {
+		keys = append(keys, info.Key)
+	}
+	if got, want := keys, []string{"enable", "mode", "undescribed"}; !equalStrings(got, want) {
+		t.Errorf("got keys %q; want %q", got, want)
+	}
+	if r.IsDescribed("undescribed") {
+		t.Error("undescribed directive reported as described")
+	}
+	info, _ := r.Lookup("enable")
+	if got, want := info.Usage(), "gazelle:enable enabled [targets,...]"; got != want {
+		t.Errorf("got usage %q; want %q", got, want)
+	}
+}
+
+func TestResolveUsage(t *testing.T) {
+	// Optional arguments in the middle are omitted before required
+	// arguments are matched.
+	r := NewDirectiveRegistry([]Configurer{&optionalMiddleConfigurer{}})
+	for _, value := range []string{"go x //x", "proto go x //x"} {
+		if err := r.Check(rule.Directive{Key: "resolve", Value: value}); err != nil {
+			t.Errorf("%s: %v", value, err)
+		}
+	}
+	if err := r.Check(rule.Directive{Key: "resolve", Value: "go x //x:"}); err == nil {
+		t.Error("got success for invalid label; want error")
+	}
+}
+
+type optionalMiddleConfigurer struct{ describingConfigurer }
+
+func (*optionalMiddleConfigurer) KnownDirectives() []string { return nil }
+
+func (*optionalMiddleConfigurer) DescribeDirectives() []DirectiveInfo {
+	return []DirectiveInfo{{
+		Key: "resolve",
+		Args: []DirectiveArg{
+			{Name: "source-lang"},
+			{Name: "import-lang", Optional: true},
+			{Name: "import-string"},
+			{Name: "label", Type: ArgLabel},
+		},
+	}}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
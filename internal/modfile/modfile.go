@@ -0,0 +1,74 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package modfile reads directives from go.mod and go.work files.
+package modfile
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Directives returns the arguments of each directive named verb in data,
+// the contents of a go.mod or go.work file. Directives may be written on
+// one line or grouped in a parenthesized block. Comments are removed, and
+// quoted arguments are unquoted.
+func Directives(data []byte, verb string) [][]string {
+	var directives [][]string
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case !inBlock && fields[0] == verb:
+			if len(fields) == 2 && fields[1] == "(" {
+				inBlock = true
+				continue
+			}
+			fields = fields[1:]
+		case !inBlock:
+			if len(fields) >= 2 && fields[1] == "(" {
+				// Skip blocks of other directives.
+				for scanner.Scan() && strings.TrimSpace(scanner.Text()) != ")" {
+				}
+			}
+			continue
+		}
+		args := make([]string, len(fields))
+		for i, f := range fields {
+			args[i] = unquote(f)
+		}
+		directives = append(directives, args)
+	}
+	return directives
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
@@ -0,0 +1,42 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package modfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDirectives(t *testing.T) {
+	data := []byte(`go 1.18
+
+use ./a // comment
+use (
+	"./b"
+	// ./c
+	../d
+)
+
+replace (
+	use => ./e
+)
+`)
+	got := Directives(data, "use")
+	want := [][]string{{"./a"}, {"./b"}, {"../d"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -17,10 +17,16 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 var workspaceFiles = []string{"WORKSPACE.bazel", "WORKSPACE"}
 
+// repoRootFiles are files that mark the root directory of a repository.
+// MODULE.bazel and REPO.bazel may be used instead of WORKSPACE with Bzlmod.
+var repoRootFiles = append([]string{"MODULE.bazel", "REPO.bazel"}, workspaceFiles...)
+
 // IsWORKSPACE checks whether path is a WORKSPACE or WORKSPACE.bazel file
 func IsWORKSPACE(path string) bool {
 	base := filepath.Base(path)
@@ -36,17 +42,24 @@ func IsWORKSPACE(path string) bool {
 // either to an existing WORKSPACE or WORKSPACE.bazel file, or to root/WORKSPACE
 // if neither exists. Note that this function does NOT recursively check parent directories.
 func FindWORKSPACEFile(root string) string {
+	return FindWORKSPACEFileFS(vfs.OS, root)
+}
+
+// FindWORKSPACEFileFS is like FindWORKSPACEFile, but it looks for files in
+// fsys.
+func FindWORKSPACEFileFS(fsys vfs.FS, root string) string {
 	for _, workspaceFile := range workspaceFiles {
 		path := filepath.Join(root, workspaceFile)
-		if _, err := os.Stat(path); err == nil {
+		if _, err := fsys.Stat(path); err == nil {
 			return path
 		}
 	}
 	return filepath.Join(root, "WORKSPACE")
 }
 
-// FindRepoRoot searches from the given dir and up for a directory containing a WORKSPACE file
-// returning the directory containing it, or an error if none found in the tree.
+// FindRepoRoot searches from the given dir and up for a directory containing a WORKSPACE,
+// MODULE.bazel, or REPO.bazel file returning the directory containing it, or an error if
+// none found in the tree.
 func FindRepoRoot(dir string) (string, error) {
 	dir, err := filepath.Abs(dir)
 	if err != nil {
@@ -54,8 +67,8 @@ func FindRepoRoot(dir string) (string, error) {
 	}
 
 	for {
-		for _, workspaceFile := range workspaceFiles {
-			filepath := filepath.Join(dir, workspaceFile)
+		for _, rootFile := range repoRootFiles {
+			filepath := filepath.Join(dir, rootFile)
 			_, err = os.Stat(filepath)
 			if err == nil {
 				return dir, nil
@@ -40,6 +40,8 @@ func TestFind(t *testing.T) {
 		{filepath.Join(tmp, "WORKSPACE"), tmp, true},
 		{filepath.Join(tmp, "WORKSPACE.bazel"), tmp, true},
 		{filepath.Join(tmp, "WORKSPACE.bazel"), filepath.Join(tmp, "dir1"), true},
+		{filepath.Join(tmp, "MODULE.bazel"), filepath.Join(tmp, "dir1"), true},
+		{filepath.Join(tmp, "REPO.bazel"), tmp, true},
 	} {
 		t.Run(tc.file, func(t *testing.T) {
 			if err := os.RemoveAll(tmp); err != nil {
@@ -71,6 +73,12 @@ func TestFind(t *testing.T) {
 				t.Errorf("FindRoot(%q): got error %v, wanted %v", tc.testdir, err, tc.file)
 			}
 
+			if dir != filepath.Dir(tc.file) {
+				t.Errorf("FindRoot(%q): got %v, wanted %v", tc.testdir, dir, filepath.Dir(tc.file))
+			}
+			if !IsWORKSPACE(tc.file) {
+				return
+			}
 			file := FindWORKSPACEFile(dir)
 			if file != tc.file {
 				t.Errorf("FindWorkspaceFile(FindRoot(%q)): got %v, wanted %v", tc.testdir, file, tc.file)
@@ -32,11 +32,20 @@ import (
 // A Label represents a label of a build target in Bazel. Labels have three
 // parts: a repository name, a package name, and a target name, formatted
 // as @repo//pkg:target.
+//
+// The repository name may be an apparent name, which is resolved using the
+// repository mapping of the repository the label appears in, or a canonical
+// name, formatted as @@repo//pkg:target, which is unique within a build.
+// See RepoMapping.
 type Label struct {
 	// Repo is the repository name. If omitted, the label refers to a target
-	// in the current repository.
+	// in the current repository, or in the main repository if Canonical is
+	// true.
 	Repo string
 
+	// Canonical indicates whether Repo is a canonical repository name.
+	Canonical bool
+
 	// Pkg is the package name, which is usually the directory that contains
 	// the target. If both Repo and Pkg are omitted, the label is relative.
 	Pkg string
@@ -62,9 +71,10 @@ func New(repo, pkg, name string) Label {
 var NoLabel = Label{}
 
 var (
-	labelRepoRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
-	labelPkgRegexp  = regexp.MustCompile(`^[A-Za-z0-9/._-]*$`)
-	labelNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_/.+=,@~-]*$`)
+	labelRepoRegexp          = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+	labelCanonicalRepoRegexp = regexp.MustCompile(`^[A-Za-z0-9_.~+-]*$`)
+	labelPkgRegexp           = regexp.MustCompile(`^[A-Za-z0-9/._-]*$`)
+	labelNameRegexp          = regexp.MustCompile(`^[A-Za-z0-9_/.+=,@~-]*$`)
 )
 
 // Parse reads a label from a string.
@@ -74,6 +84,7 @@ func Parse(s string) (Label, error) {
 
 	relative := true
 	var repo string
+	canonical := false
 	if strings.HasPrefix(s, "@") {
 		relative = false
 		endRepo := strings.Index(s, "//")
@@ -81,7 +92,13 @@ func Parse(s string) (Label, error) {
 			return NoLabel, fmt.Errorf("label parse error: repository does not end with '//': %q", origStr)
 		}
 		repo = s[len("@"):endRepo]
-		if !labelRepoRegexp.MatchString(repo) {
+		repoRegexp := labelRepoRegexp
+		if strings.HasPrefix(repo, "@") {
+			repo = repo[len("@"):]
+			canonical = true
+			repoRegexp = labelCanonicalRepoRegexp
+		}
+		if !repoRegexp.MatchString(repo) {
 			return NoLabel, fmt.Errorf("label parse error: repository has invalid characters: %q", origStr)
 		}
 		s = s[endRepo:]
@@ -119,10 +136,11 @@ func Parse(s string) (Label, error) {
 	}
 
 	return Label{
-		Repo:     repo,
-		Pkg:      pkg,
-		Name:     name,
-		Relative: relative,
+		Repo:      repo,
+		Canonical: canonical,
+		Pkg:       pkg,
+		Name:      name,
+		Relative:  relative,
 	}, nil
 }
 
@@ -132,7 +150,9 @@ func (l Label) String() string {
 	}
 
 	var repo string
-	if l.Repo != "" {
+	if l.Canonical {
+		repo = fmt.Sprintf("@@%s", l.Repo)
+	} else if l.Repo != "" {
 		repo = fmt.Sprintf("@%s", l.Repo)
 	}
 
@@ -143,8 +163,8 @@ func (l Label) String() string {
 }
 
 // Abs computes an absolute label (one with a repository and package name)
-// from this label. If this label is already absolute, it is returned
-// unchanged.
+// from this label. repo is the apparent name of the repository the label
+// appears in. If this label is already absolute, it is returned unchanged.
 func (l Label) Abs(repo, pkg string) Label {
 	if !l.Relative {
 		return l
@@ -152,11 +172,23 @@ func (l Label) Abs(repo, pkg string) Label {
 	return Label{Repo: repo, Pkg: pkg, Name: l.Name}
 }
 
-// Rel attempts to compute a relative label from this label. If this label
+// Rel attempts to compute a relative label from this label. repo is the
+// apparent name of the repository the label appears in. If this label
 // is already relative or is in a different package, this label may be
 // returned unchanged.
+//
+// A label with a canonical repository name is only made relative if it
+// refers to the main repository and repo is empty, since canonical names
+// of other repositories can't be compared with apparent names.
 func (l Label) Rel(repo, pkg string) Label {
-	if l.Relative || l.Repo != repo {
+	if l.Relative {
+		return l
+	}
+	if l.Canonical {
+		if l.Repo != "" || repo != "" {
+			return l
+		}
+	} else if l.Repo != repo {
 		return l
 	}
 	if l.Pkg == pkg {
@@ -169,11 +201,30 @@ func (l Label) Rel(repo, pkg string) Label {
 // true for different labels that refer to the same target.
 func (l Label) Equal(other Label) bool {
 	return l.Repo == other.Repo &&
+		l.Canonical == other.Canonical &&
 		l.Pkg == other.Pkg &&
 		l.Name == other.Name &&
 		l.Relative == other.Relative
 }
 
+// Normalize returns a string that's equal for labels that are written
+// differently but refer to the same target from the same package, like
+// "//x", "//x:x", and "@@//x:x". Strings that can't be parsed as labels are
+// returned unchanged. Apparent and canonical names of repositories other
+// than the main repository can't be compared without a repository mapping,
+// so they remain distinct.
+func Normalize(s string) string {
+	l, err := Parse(s)
+	if err != nil {
+		return s
+	}
+	if l.Canonical && l.Repo == "" {
+		// The canonical name of the main repository is empty.
+		l.Canonical = false
+	}
+	return l.String()
+}
+
 // Contains returns whether other is contained by the package of l or a
 // sub-package. Neither label may be relative.
 func (l Label) Contains(other Label) bool {
@@ -183,7 +234,7 @@ func (l Label) Contains(other Label) bool {
 	if other.Relative {
 		log.Panicf("other must not be relative: %s", other)
 	}
-	result := l.Repo == other.Repo && pathtools.HasPrefix(other.Pkg, l.Pkg)
+	result := l.Repo == other.Repo && l.Canonical == other.Canonical && pathtools.HasPrefix(other.Pkg, l.Pkg)
 	return result
 }
 
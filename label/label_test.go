@@ -43,6 +43,12 @@ func TestLabelString(t *testing.T) {
 		}, {
 			l:    Label{Relative: true, Name: "foo"},
 			want: ":foo",
+		}, {
+			l:    Label{Repo: "rules_go~0.39.1", Canonical: true, Pkg: "go", Name: "def.bzl"},
+			want: "@@rules_go~0.39.1//go:def.bzl",
+		}, {
+			l:    Label{Canonical: true, Pkg: "foo", Name: "foo"},
+			want: "@@//foo",
 		},
 	} {
 		if got, want := spec.l.String(), spec.want; got != want {
@@ -69,6 +75,12 @@ func TestParse(t *testing.T) {
 		{str: "//a:b", want: Label{Pkg: "a", Name: "b"}},
 		{str: "@a//b", want: Label{Repo: "a", Pkg: "b", Name: "b"}},
 		{str: "@a//b:c", want: Label{Repo: "a", Pkg: "b", Name: "c"}},
+		{str: "@a.b-c//d", want: Label{Repo: "a.b-c", Pkg: "d", Name: "d"}},
+		{str: "@a~b//c", wantErr: true},
+		{str: "@@a~1.0//b:c", want: Label{Repo: "a~1.0", Canonical: true, Pkg: "b", Name: "c"}},
+		{str: "@@gazelle~~go_deps~com_example_m//:m", want: Label{Repo: "gazelle~~go_deps~com_example_m", Canonical: true, Name: "m"}},
+		{str: "@@//a", want: Label{Canonical: true, Pkg: "a", Name: "a"}},
+		{str: "@@a/b//c", wantErr: true},
 		{str: "//api_proto:api.gen.pb.go_checkshtest", want: Label{Pkg: "api_proto", Name: "api.gen.pb.go_checkshtest"}},
 	} {
 		got, err := Parse(tc.str)
@@ -86,6 +98,84 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestAbsRel(t *testing.T) {
+	for _, tc := range []struct {
+		str, repo, pkg, abs, rel string
+	}{
+		{str: ":a", pkg: "x", abs: "//x:a", rel: ":a"},
+		{str: ":a", repo: "r", pkg: "x", abs: "@r//x:a", rel: ":a"},
+		{str: "//x:a", pkg: "x", abs: "//x:a", rel: ":a"},
+		{str: "//x:a", pkg: "y", abs: "//x:a", rel: "//x:a"},
+		{str: "@r//x:a", repo: "r", pkg: "x", abs: "@r//x:a", rel: ":a"},
+		{str: "@r//x:a", pkg: "x", abs: "@r//x:a", rel: "@r//x:a"},
+		{str: "@@//x:a", pkg: "x", abs: "@@//x:a", rel: ":a"},
+		{str: "@@//x:a", pkg: "y", abs: "@@//x:a", rel: "//x:a"},
+		{str: "@@r~1.0//x:a", repo: "r", pkg: "x", abs: "@@r~1.0//x:a", rel: "@@r~1.0//x:a"},
+	} {
+		l, err := Parse(tc.str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.Abs(tc.repo, tc.pkg).String(); got != tc.abs {
+			t.Errorf("Abs(%q, %q) of %s: got %s; want %s", tc.repo, tc.pkg, tc.str, got, tc.abs)
+		}
+		if got := l.Rel(tc.repo, tc.pkg).String(); got != tc.rel {
+			t.Errorf("Rel(%q, %q) of %s: got %s; want %s", tc.repo, tc.pkg, tc.str, got, tc.rel)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		str, want string
+	}{
+		{str: "//x", want: "//x"},
+		{str: "//x:x", want: "//x"},
+		{str: "@@//x:x", want: "//x"},
+		{str: "@r//x:a", want: "@r//x:a"},
+		{str: "@@r~1.0//x:a", want: "@@r~1.0//x:a"},
+		{str: "x", want: ":x"},
+	} {
+		if got := Normalize(tc.str); got != tc.want {
+			t.Errorf("Normalize(%q): got %q; want %q", tc.str, got, tc.want)
+		}
+	}
+}
+
+func TestRepoMapping(t *testing.T) {
+	m := RepoMapping{
+		"io_bazel_rules_go": "rules_go~0.39.1",
+		"rules_go":          "rules_go~0.39.1",
+		"com_example_m":     "gazelle~~go_deps~com_example_m",
+	}
+	for _, tc := range []struct {
+		str, canonical, apparent string
+		ok                       bool
+	}{
+		{str: ":a", canonical: ":a", apparent: ":a", ok: true},
+		{str: "//x:a", canonical: "@@//x:a", apparent: "//x:a", ok: true},
+		{str: "@io_bazel_rules_go//go:def.bzl", canonical: "@@rules_go~0.39.1//go:def.bzl", apparent: "@io_bazel_rules_go//go:def.bzl", ok: true},
+		{str: "@@rules_go~0.39.1//go:def.bzl", canonical: "@@rules_go~0.39.1//go:def.bzl", apparent: "@io_bazel_rules_go//go:def.bzl", ok: true},
+		{str: "@@gazelle~~go_deps~com_example_m//:m", canonical: "@@gazelle~~go_deps~com_example_m//:m", apparent: "@com_example_m//:m", ok: true},
+		{str: "@@//x:a", canonical: "@@//x:a", apparent: "//x:a", ok: true},
+		{str: "@unknown//x:a", canonical: "@unknown//x:a", apparent: "@unknown//x:a"},
+		{str: "@@unknown~//x:a", canonical: "@@unknown~//x:a", apparent: "@@unknown~//x:a"},
+	} {
+		l, err := Parse(tc.str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		canonical, ok := m.ToCanonical(l)
+		if canonical.String() != tc.canonical || ok != (tc.ok || l.Canonical) {
+			t.Errorf("ToCanonical(%s): got %s, %v; want %s", tc.str, canonical, ok, tc.canonical)
+		}
+		apparent, ok := m.ToApparent(l)
+		if apparent.String() != tc.apparent || ok != (tc.ok || !l.Canonical) {
+			t.Errorf("ToApparent(%s): got %s, %v; want %s", tc.str, apparent, ok, tc.apparent)
+		}
+	}
+}
+
 func TestImportPathToBazelRepoName(t *testing.T) {
 	for path, want := range map[string]string{
 		"git.sr.ht/~urandom/errors": "ht_sr_git_urandom_errors",
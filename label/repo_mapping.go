@@ -0,0 +1,68 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package label
+
+// RepoMapping maps apparent repository names visible to one repository to
+// the canonical names of those repositories. With Bzlmod, each module sees
+// its dependencies under apparent names it chooses, like "io_bazel_rules_go",
+// while Bazel refers to them by canonical names, like "rules_go~0.39.1".
+//
+// The main repository always has the apparent name "" and the canonical
+// name "", whether or not it's in the mapping.
+type RepoMapping map[string]string
+
+// ToCanonical returns a label equivalent to l that uses a canonical
+// repository name. Relative labels and labels that are already canonical are
+// returned unchanged. false is returned if l's repository is not in the
+// mapping.
+func (m RepoMapping) ToCanonical(l Label) (Label, bool) {
+	if l.Relative || l.Canonical {
+		return l, true
+	}
+	canonical, ok := m[l.Repo]
+	if !ok {
+		if l.Repo != "" {
+			return l, false
+		}
+		canonical = ""
+	}
+	l.Repo = canonical
+	l.Canonical = true
+	return l, true
+}
+
+// ToApparent returns a label equivalent to l that uses an apparent
+// repository name. Relative labels and labels that are already apparent are
+// returned unchanged. If more than one apparent name maps to l's repository,
+// the lexicographically least name is used. false is returned if l's
+// repository is not in the mapping.
+func (m RepoMapping) ToApparent(l Label) (Label, bool) {
+	if l.Relative || !l.Canonical {
+		return l, true
+	}
+	apparent, found := "", l.Repo == ""
+	for a, c := range m {
+		if c == l.Repo && (!found || a < apparent) {
+			apparent, found = a, true
+		}
+	}
+	if !found {
+		return l, false
+	}
+	l.Repo = apparent
+	l.Canonical = false
+	return l, true
+}
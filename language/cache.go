@@ -0,0 +1,42 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package language
+
+// GenerateCacher may be implemented by languages whose GenerateRules results
+// may be cached between runs. When Gazelle is run with a cache, and none of
+// the inputs for a directory have changed since the last run (source files,
+// the build file, and the effective configuration), Gazelle reuses rules
+// generated in that run instead of calling GenerateRules. Directories are
+// only cached if all languages processing them implement this interface.
+//
+// Generated rules are stored in build file syntax, so private attributes
+// (other than imports, which are stored separately) are not preserved.
+//
+// EXPERIMENTAL: this may change or be removed.
+type GenerateCacher interface {
+	// EncodeImports serializes a value returned in GenerateResult.Imports.
+	EncodeImports(imports interface{}) ([]byte, error)
+
+	// DecodeImports deserializes a value encoded with EncodeImports.
+	DecodeImports(data []byte) (interface{}, error)
+
+	// RestoreGenerateResult is called instead of GenerateRules when a cached
+	// result is used for a directory. res contains the rules and imports that
+	// GenerateRules returned in an earlier run. Languages that record state
+	// in GenerateRules (for example, information about subdirectories that
+	// is used in parent directories) should update that state here.
+	RestoreGenerateResult(args GenerateArgs, res GenerateResult)
+}
@@ -22,10 +22,10 @@ import (
 	"go/build"
 	"io/ioutil"
 	"log"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -338,10 +338,98 @@ func (*goLang) KnownDirectives() []string {
 	}
 }
 
+var validNamingConventions = []string{"go_default_library", "import", "import_alias"}
+
+func (*goLang) DescribeDirectives() []config.DirectiveInfo {
+	return []config.DirectiveInfo{
+		{
+			Key:   "build_tags",
+			Doc:   "Adds Go build tags Gazelle will consider to be true when generating rules.",
+			Args:  []config.DirectiveArg{{Name: "tags", Type: config.ArgString, Optional: true, List: true}},
+			Scope: config.ScopeAccumulated,
+		},
+		{
+			Key:  "go_generate_proto",
+			Doc:  "Controls whether go_proto_library rules are generated for proto_library rules.",
+			Args: []config.DirectiveArg{{Name: "enabled", Type: config.ArgBool}},
+		},
+		{
+			Key:  "go_grpc_compilers",
+			Doc:  "Sets the compilers used to build Go bindings for gRPC services. An empty value resets the default.",
+			Args: []config.DirectiveArg{{Name: "compilers", Type: config.ArgLabel, Optional: true, List: true}},
+		},
+		{
+			Key:  "go_naming_convention",
+			Doc:  "Controls the names of generated Go targets. If not set, the convention is inferred from existing build files.",
+			Args: []config.DirectiveArg{{Name: "convention", Type: config.ArgEnum, Allowed: validNamingConventions}},
+		},
+		{
+			Key:  "go_naming_convention_external",
+			Doc:  "Controls the naming convention used when resolving libraries in external repositories with unknown conventions.",
+			Args: []config.DirectiveArg{{Name: "convention", Type: config.ArgEnum, Allowed: validNamingConventions}},
+		},
+		{
+			Key:  "go_proto_compilers",
+			Doc:  "Sets the compilers used to build Go bindings for protos. An empty value resets the default.",
+			Args: []config.DirectiveArg{{Name: "compilers", Type: config.ArgLabel, Optional: true, List: true}},
+		},
+		{
+			Key:   "go_visibility",
+			Doc:   "Adds a label to the visibility of internal packages.",
+			Args:  []config.DirectiveArg{{Name: "label", Type: config.ArgLabel}},
+			Scope: config.ScopeAccumulated,
+		},
+		{
+			Key:  "importmap_prefix",
+			Doc:  "Sets a prefix for importmap attributes on library rules in this directory and below.",
+			Args: []config.DirectiveArg{{Name: "path", Type: config.ArgString, Optional: true}},
+		},
+		{
+			Key:  "prefix",
+			Doc:  "Sets a prefix for importpath attributes on library rules in this directory and below.",
+			Args: []config.DirectiveArg{{Name: "path", Type: config.ArgString, Optional: true}},
+		},
+	}
+}
+
+func (*goLang) DirectiveValues(c *config.Config, key string) []string {
+	gc := getGoConfig(c)
+	var value string
+	switch key {
+	case "build_tags":
+		var tags []string
+		for t := range gc.genericTags {
+			tags = append(tags, t)
+		}
+		sort.Strings(tags)
+		return tags
+	case "go_generate_proto":
+		value = strconv.FormatBool(gc.goGenerateProto)
+	case "go_grpc_compilers":
+		value = strings.Join(gc.goGrpcCompilers, ",")
+	case "go_naming_convention":
+		value = gc.goNamingConvention.String()
+	case "go_naming_convention_external":
+		value = gc.goNamingConventionExternal.String()
+	case "go_proto_compilers":
+		value = strings.Join(gc.goProtoCompilers, ",")
+	case "go_visibility":
+		return gc.goVisibility
+	case "importmap_prefix":
+		value = gc.importMapPrefix
+	case "prefix":
+		value = gc.prefix
+	}
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
 func (*goLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 	gc := newGoConfig()
 	switch cmd {
-	case "fix", "update":
+	case "fix", "update", "explain", "query", "config", "watch", "serve":
 		fs.Var(
 			tagsFlag(gc.setBuildTags),
 			"build_tags",
@@ -487,7 +575,7 @@ Update io_bazel_rules_go to a newer version in your WORKSPACE file.`
 	}
 
 	if !gc.moduleMode {
-		st, err := os.Stat(filepath.Join(c.RepoRoot, filepath.FromSlash(rel), "go.mod"))
+		st, err := c.FileSystem().Stat(filepath.Join(c.RepoRoot, filepath.FromSlash(rel), "go.mod"))
 		if err == nil && !st.IsDir() {
 			gc.moduleMode = true
 		}
@@ -501,44 +589,39 @@ Update io_bazel_rules_go to a newer version in your WORKSPACE file.`
 	}
 
 	if f != nil {
-		setPrefix := func(prefix string) {
+		setPrefix := func(prefix string, line int) {
 			if err := checkPrefix(prefix); err != nil {
-				log.Print(err)
+				c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: line}, config.DiagInvalidDirective, "%v", err)
 				return
 			}
 			gc.prefix = prefix
 			gc.prefixSet = true
 			gc.prefixRel = rel
 		}
-		for _, d := range f.Directives {
+		for i, d := range f.Directives {
 			switch d.Key {
 			case "build_tags":
 				if err := gc.setBuildTags(d.Value); err != nil {
-					log.Print(err)
+					c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: f.DirectiveLine(i)}, config.DiagInvalidDirective, "%v", err)
 					continue
 				}
 				gc.preprocessTags()
 				gc.setBuildTags(d.Value)
 
 			case "go_generate_proto":
+				// Invalid values are reported by Walk before Configure is called.
 				if goGenerateProto, err := strconv.ParseBool(d.Value); err == nil {
 					gc.goGenerateProto = goGenerateProto
-				} else {
-					log.Printf("parsing go_generate_proto: %v", err)
 				}
 
 			case "go_naming_convention":
 				if nc, err := namingConventionFromString(d.Value); err == nil {
 					gc.goNamingConvention = nc
-				} else {
-					log.Print(err)
 				}
 
 			case "go_naming_convention_external":
 				if nc, err := namingConventionFromString(d.Value); err == nil {
 					gc.goNamingConventionExternal = nc
-				} else {
-					log.Print(err)
 				}
 
 			case "go_grpc_compilers":
@@ -569,7 +652,7 @@ Update io_bazel_rules_go to a newer version in your WORKSPACE file.`
 				gc.importMapPrefixRel = rel
 
 			case "prefix":
-				setPrefix(d.Value)
+				setPrefix(d.Value, f.DirectiveLine(i))
 			}
 		}
 
@@ -585,11 +668,11 @@ Update io_bazel_rules_go to a newer version in your WORKSPACE file.`
 					if !ok {
 						continue
 					}
-					setPrefix(s.Value)
+					setPrefix(s.Value, s.Start.Line)
 
 				case "gazelle":
 					if prefix := r.AttrString("prefix"); prefix != "" {
-						setPrefix(prefix)
+						setPrefix(prefix, 0)
 					}
 				}
 			}
@@ -622,6 +705,10 @@ func splitValue(value string) []string {
 	return values
 }
 
+// bcrVersionRe matches the numeric prefix of a Bazel Central Registry module
+// version, like "0.41.0" in "0.41.0.bcr.1".
+var bcrVersionRe = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)*)`)
+
 // findRulesGoVersion attempts to infer the version of io_bazel_rules_go.
 // It can read the external directory (if bazel has fetched it), or it can
 // read WORKSPACE. Neither method is completely reliable.
@@ -658,6 +745,14 @@ Update io_bazel_rules_go to a newer version in your WORKSPACE file.`
 					}
 				}
 			}
+			if r.Kind() == "bazel_dep" && r.AttrString("module_name") == "rules_go" {
+				// Versions in the Bazel Central Registry may have a suffix
+				// like ".bcr.1".
+				if m := bcrVersionRe.FindStringSubmatch(r.AttrString("version")); m != nil {
+					vstr = m[1]
+					break RepoLoop
+				}
+			}
 		}
 	}
 
@@ -707,7 +802,7 @@ func detectNamingConvention(c *config.Config, rootFile *rule.File) namingConvent
 		var f *rule.File
 		for _, name := range c.ValidBuildFileNames {
 			fpath := filepath.Join(dir, name)
-			data, err := ioutil.ReadFile(fpath)
+			data, err := c.FileSystem().ReadFile(fpath)
 			if err != nil {
 				continue
 			}
@@ -729,7 +824,7 @@ func detectNamingConvention(c *config.Config, rootFile *rule.File) namingConvent
 		}
 	}
 
-	infos, err := ioutil.ReadDir(c.RepoRoot)
+	infos, err := c.FileSystem().ReadDir(c.RepoRoot)
 	if err != nil {
 		return importNamingConvention
 	}
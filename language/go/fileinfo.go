@@ -24,7 +24,6 @@ import (
 	"go/parser"
 	"go/token"
 	"log"
-	"os"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -36,6 +35,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/internal/version"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 // fileInfo holds information used to decide how to build a file. This
@@ -256,13 +256,13 @@ func fileNameInfo(path_ string) fileInfo {
 // otherFileInfo returns information about a non-.go file. It will parse
 // part of the file to determine build tags. If the file can't be read, an
 // error will be logged, and partial information will be returned.
-func otherFileInfo(path string) fileInfo {
+func otherFileInfo(fsys vfs.FS, path string) fileInfo {
 	info := fileNameInfo(path)
 	if info.ext == unknownExt {
 		return info
 	}
 
-	tags, err := readTags(info.path)
+	tags, err := readTags(fsys, info.path)
 	if err != nil {
 		log.Printf("%s: error reading file: %v", info.path, err)
 		return info
@@ -277,10 +277,15 @@ func otherFileInfo(path string) fileInfo {
 // will be returned.
 // This function is intended to match go/build.Context.Import.
 // TODD(#53): extract canonical import path
-func goFileInfo(path, rel string) fileInfo {
+func goFileInfo(fsys vfs.FS, path, rel string) fileInfo {
 	info := fileNameInfo(path)
+	data, err := fsys.ReadFile(info.path)
+	if err != nil {
+		log.Printf("%s: error reading go file: %v", info.path, err)
+		return info
+	}
 	fset := token.NewFileSet()
-	pf, err := parser.ParseFile(fset, info.path, nil, parser.ImportsOnly|parser.ParseComments)
+	pf, err := parser.ParseFile(fset, info.path, data, parser.ImportsOnly|parser.ParseComments)
 	if err != nil {
 		log.Printf("%s: error reading go file: %v", info.path, err)
 		return info
@@ -329,7 +334,7 @@ func goFileInfo(path, rel string) fileInfo {
 		}
 	}
 
-	tags, err := readTags(info.path)
+	tags, err := readTags(fsys, info.path)
 	if err != nil {
 		log.Printf("%s: error reading go file: %v", info.path, err)
 		return info
@@ -525,13 +530,12 @@ func safeCgoName(s string, spaces bool) bool {
 // rest of the file by a blank line. Each string in the returned slice
 // is the trimmed text of a line after a "+build" prefix.
 // Based on go/build.Context.shouldBuild.
-func readTags(path string) ([]tagLine, error) {
-	f, err := os.Open(path)
+func readTags(fsys vfs.FS, path string) ([]tagLine, error) {
+	data, err := fsys.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 
 	// Pass 1: Identify leading run of // comments and blank lines,
 	// which must be followed by a blank line.
@@ -22,6 +22,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 func TestGoFileInfo(t *testing.T) {
@@ -148,7 +150,7 @@ package route
 				t.Fatal(err)
 			}
 
-			got := goFileInfo(path, "")
+			got := goFileInfo(vfs.OS, path, "")
 			// Clear fields we don't care about for testing.
 			got = fileInfo{
 				packageName: got.packageName,
@@ -177,7 +179,7 @@ func TestGoFileInfoFailure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got := goFileInfo(path, "")
+	got := goFileInfo(vfs.OS, path, "")
 	want := fileInfo{
 		path:   path,
 		name:   name,
@@ -300,7 +302,7 @@ import ("C")
 				t.Fatal(err)
 			}
 
-			got := goFileInfo(path, "")
+			got := goFileInfo(vfs.OS, path, "")
 
 			// Clear fields we don't care about for testing.
 			got = fileInfo{
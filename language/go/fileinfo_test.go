@@ -21,6 +21,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 func TestOtherFileInfo(t *testing.T) {
@@ -51,7 +53,7 @@ func TestOtherFileInfo(t *testing.T) {
 			}
 			defer os.Remove(tc.name)
 
-			got := otherFileInfo(filepath.Join(dir, tc.name))
+			got := otherFileInfo(vfs.OS, filepath.Join(dir, tc.name))
 
 			// Only check that we can extract tags. Everything else is covered
 			// by other tests.
@@ -328,7 +330,7 @@ package main`,
 			t.Fatal(err)
 		}
 
-		if got, err := readTags(path); err != nil {
+		if got, err := readTags(vfs.OS, path); err != nil {
 			t.Fatal(err)
 		} else if !reflect.DeepEqual(got, tc.want) {
 			t.Errorf("case %q: got %#v; want %#v", tc.desc, got, tc.want)
@@ -523,7 +525,7 @@ import "C"
 				t.Fatal(err)
 			}
 
-			fi := goFileInfo(path, "")
+			fi := goFileInfo(vfs.OS, path, "")
 			var cgoTags tagLine
 			if len(fi.copts) > 0 {
 				cgoTags = fi.copts[0].tags
@@ -19,20 +19,73 @@ import (
 	"log"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/language/proto"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
+// goMigrations lists the fixes applied by goLang.Fix, in the order they're
+// applied.
+var goMigrations = []language.Migration{
+	{
+		Name:    "library_embed",
+		Version: 1,
+		Doc:     "converts library attributes on Go rules to embed attributes",
+		Fix:     migrateLibraryEmbed,
+	},
+	{
+		Name:    "grpc_compilers",
+		Version: 1,
+		Doc:     "converts go_grpc_library rules to go_proto_library rules with compilers",
+		Fix:     migrateGrpcCompilers,
+	},
+	{
+		Name:    "flatten_srcs",
+		Version: 1,
+		Doc:     "flattens srcs of Go rules built from lists and selects into one sorted list",
+		Fix:     flattenSrcs,
+	},
+	{
+		Name:    "squash_cgo_library",
+		Version: 1,
+		Doc:     "merges cgo_library rules into go_library",
+		Fix:     squashCgoLibrary,
+	},
+	{
+		Name:    "squash_xtest",
+		Version: 1,
+		Doc:     "merges go_default_xtest rules into go_default_test",
+		Fix:     squashXtest,
+	},
+	{
+		Name:    "remove_legacy_proto",
+		Version: 1,
+		Doc:     "removes rules and loads for go_proto_library.bzl",
+		Fix:     removeLegacyProto,
+	},
+	{
+		Name:    "remove_legacy_gazelle",
+		Version: 1,
+		Doc:     "removes loads of the gazelle macro from @io_bazel_rules_go//go:def.bzl",
+		Fix:     removeLegacyGazelle,
+	},
+	{
+		Name:    "naming_convention",
+		Version: 1,
+		Doc:     "renames Go libraries and tests according to go_naming_convention",
+		Fix:     migrateNamingConvention,
+	},
+}
+
+func (_ *goLang) Migrations() []language.Migration {
+	return goMigrations
+}
+
 func (_ *goLang) Fix(c *config.Config, f *rule.File) {
-	migrateLibraryEmbed(c, f)
-	migrateGrpcCompilers(c, f)
-	flattenSrcs(c, f)
-	squashCgoLibrary(c, f)
-	squashXtest(c, f)
-	removeLegacyProto(c, f)
-	removeLegacyGazelle(c, f)
-	migrateNamingConvention(c, f)
+	for _, m := range goMigrations {
+		m.Fix(c, f)
+	}
 }
 
 // migrateNamingConvention renames rules according to go_naming_convention
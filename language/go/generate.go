@@ -110,7 +110,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 	var hasTestdata bool
 	for _, sub := range args.Subdirs {
 		if sub == "testdata" {
-			hasTestdata = !gl.goPkgRels[path.Join(args.Rel, "testdata")]
+			hasTestdata = !gl.isGoPkgRel(path.Join(args.Rel, "testdata"))
 			break
 		}
 	}
@@ -145,7 +145,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 				pkg = emptyPackage(c, args.Dir, args.Rel, args.File)
 			}
 		} else {
-			log.Print(err)
+			c.Diagnostics.Warnf(config.Position{Path: args.Dir}, config.DiagPackageSelection, "%v", err)
 		}
 	}
 
@@ -219,7 +219,7 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 
 		// Process the other static files.
 		for _, file := range otherFiles {
-			info := otherFileInfo(filepath.Join(args.Dir, file))
+			info := otherFileInfo(c.FileSystem(), filepath.Join(args.Dir, file))
 			if err := pkg.addFile(c, info, cgo); err != nil {
 				log.Print(err)
 			}
@@ -287,18 +287,41 @@ func (gl *goLang) GenerateRules(args language.GenerateArgs) language.GenerateRes
 		}
 	}
 
+	gl.recordGoPkgRel(args, res)
+
+	return res
+}
+
+// isGoPkgRel returns whether the directory rel or any of its subdirectories
+// contains buildable Go code. GenerateRules must have already been called for
+// rel.
+func (gl *goLang) isGoPkgRel(rel string) bool {
+	gl.goPkgRelsMu.Lock()
+	defer gl.goPkgRelsMu.Unlock()
+	return gl.goPkgRels[rel]
+}
+
+// recordGoPkgRel records whether the directory being processed by
+// GenerateRules (or one of its subdirectories) contains buildable Go code.
+func (gl *goLang) recordGoPkgRel(args language.GenerateArgs, res language.GenerateResult) {
 	if args.File != nil || len(res.Gen) > 0 {
-		gl.goPkgRels[args.Rel] = true
-	} else {
-		for _, sub := range args.Subdirs {
-			if gl.goPkgRels[path.Join(args.Rel, sub)] {
-				gl.goPkgRels[args.Rel] = true
-				break
-			}
+		gl.setGoPkgRel(args.Rel)
+		return
+	}
+	for _, sub := range args.Subdirs {
+		if gl.isGoPkgRel(path.Join(args.Rel, sub)) {
+			gl.setGoPkgRel(args.Rel)
+			return
 		}
 	}
+}
 
-	return res
+// setGoPkgRel records that the directory rel or one of its subdirectories
+// contains buildable Go code.
+func (gl *goLang) setGoPkgRel(rel string) {
+	gl.goPkgRelsMu.Lock()
+	defer gl.goPkgRelsMu.Unlock()
+	gl.goPkgRels[rel] = true
 }
 
 func filterFiles(files *[]string, pred func(string) bool) {
@@ -318,7 +341,7 @@ func buildPackages(c *config.Config, dir, rel string, goFiles []string, hasTestd
 	packageMap = make(map[string]*goPackage)
 	for _, f := range goFiles {
 		path := filepath.Join(dir, f)
-		info := goFileInfo(path, rel)
+		info := goFileInfo(c.FileSystem(), path, rel)
 		if info.packageName == "" {
 			goFilesWithUnknownPackage = append(goFilesWithUnknownPackage, info)
 			continue
@@ -53,14 +53,21 @@ limitations under the License.
 // Known Types and Google APIs. rules_go declares canonical rules for these.
 package golang
 
-import "github.com/bazelbuild/bazel-gazelle/language"
+import (
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
 
 const goName = "go"
 
 type goLang struct {
 	// goPkgDirs is a set of relative paths to directories containing buildable
-	// Go code, including in subdirectories.
-	goPkgRels map[string]bool
+	// Go code, including in subdirectories. GenerateRules may be called
+	// concurrently for different directories, so access is guarded by
+	// goPkgRelsMu.
+	goPkgRels   map[string]bool
+	goPkgRelsMu sync.Mutex
 }
 
 func (_ *goLang) Name() string { return goName }
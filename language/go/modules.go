@@ -37,8 +37,16 @@ import (
 
 func importReposFromModules(args language.ImportReposArgs) language.ImportReposResult {
 	dir := filepath.Dir(args.Path)
+	goSumPaths := []string{filepath.Join(dir, "go.sum")}
+	return importReposFromModuleGraph(dir, goSumPaths, args.ReadOnly)
+}
 
-	// List all modules except for the main module, including implicit indirect
+// importReposFromModuleGraph lists the build list of the main modules in dir
+// with "go list" and translates it into go_repository rules. Sums are read
+// from the files in goSumPaths; any that are still missing are obtained with
+// "go mod download", which does not touch go.sum files when readOnly is set.
+func importReposFromModuleGraph(dir string, goSumPaths []string, readOnly bool) language.ImportReposResult {
+	// List all modules except for the main modules, including implicit indirect
 	// dependencies.
 	type module struct {
 		Path, Version, Sum string
@@ -73,22 +81,23 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 			pathToModule[mod.Path+"@"+mod.Version] = mod
 		}
 	}
-	// Load sums from go.sum. Ideally, they're all there.
-	goSumPath := filepath.Join(filepath.Dir(args.Path), "go.sum")
-	data, _ = ioutil.ReadFile(goSumPath)
-	lines := bytes.Split(data, []byte("\n"))
-	for _, line := range lines {
-		line = bytes.TrimSpace(line)
-		fields := bytes.Fields(line)
-		if len(fields) != 3 {
-			continue
-		}
-		path, version, sum := string(fields[0]), string(fields[1]), string(fields[2])
-		if strings.HasSuffix(version, "/go.mod") {
-			continue
-		}
-		if mod, ok := pathToModule[path+"@"+version]; ok {
-			mod.Sum = sum
+	// Load sums from go.sum files. Ideally, they're all there.
+	for _, goSumPath := range goSumPaths {
+		data, _ = ioutil.ReadFile(goSumPath)
+		lines := bytes.Split(data, []byte("\n"))
+		for _, line := range lines {
+			line = bytes.TrimSpace(line)
+			fields := bytes.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			path, version, sum := string(fields[0]), string(fields[1]), string(fields[2])
+			if strings.HasSuffix(version, "/go.mod") {
+				continue
+			}
+			if mod, ok := pathToModule[path+"@"+version]; ok && mod.Sum == "" {
+				mod.Sum = sum
+			}
 		}
 	}
 	// If sums are missing, run go mod download to get them.
@@ -99,7 +108,18 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 		}
 	}
 	if len(missingSumArgs) > 0 {
-		data, err := goModDownload(dir, missingSumArgs)
+		downloadDir := dir
+		if readOnly {
+			// go mod download adds sums to go.sum when it's run in a module.
+			// Run it outside the module instead.
+			tmpDir, err := ioutil.TempDir("", "gazelle-download")
+			if err != nil {
+				return language.ImportReposResult{Error: err}
+			}
+			defer os.RemoveAll(tmpDir)
+			downloadDir = tmpDir
+		}
+		data, err := goModDownload(downloadDir, missingSumArgs)
 		if err != nil {
 			return language.ImportReposResult{Error: err}
 		}
@@ -139,7 +159,8 @@ func importReposFromModules(args language.ImportReposArgs) language.ImportReposR
 	return language.ImportReposResult{Gen: gen}
 }
 
-// goListModules invokes "go list" in a directory containing a go.mod file.
+// goListModules invokes "go list" in a directory containing a go.mod or
+// go.work file.
 var goListModules = func(dir string) ([]byte, error) {
 	return runGoCommandForOutput(dir, "list", "-mod=readonly", "-m", "-json", "all")
 }
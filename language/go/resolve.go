@@ -85,17 +85,20 @@ func (gl *goLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remo
 	}
 	imports := importsRaw.(rule.PlatformStrings)
 	r.DelAttr("deps")
-	var resolve func(*config.Config, *resolve.RuleIndex, *repo.RemoteCache, string, label.Label) (label.Label, error)
+	var resolveFunc func(*config.Config, *resolve.RuleIndex, *repo.RemoteCache, string, label.Label, *resolve.Explanation) (label.Label, error)
+	impLang := "go"
 	switch r.Kind() {
 	case "go_proto_library":
-		resolve = resolveProto
+		resolveFunc = resolveProto
+		impLang = "proto"
 	case "go_tool_library":
-		resolve = resolveGoTool
+		resolveFunc = resolveGoTool
 	default:
-		resolve = ResolveGo
+		resolveFunc = resolveGo
 	}
 	deps, errs := imports.Map(func(imp string) (string, error) {
-		l, err := resolve(c, ix, rc, imp, from)
+		x := resolve.StartExplanation(c, r, from, resolve.ImportSpec{Lang: impLang, Imp: imp})
+		l, err := resolveFunc(c, ix, rc, imp, from, x)
 		if err == skipImportError {
 			return "", nil
 		} else if err != nil {
@@ -103,6 +106,7 @@ func (gl *goLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remo
 		}
 		for _, embed := range gl.Embeds(r, from) {
 			if embed.Equal(l) {
+				x.Skip("embed", fmt.Sprintf("%s is embedded by %s", l, from))
 				return "", nil
 			}
 		}
@@ -136,24 +140,36 @@ var (
 // This may be used directly by other language extensions related to Go
 // (gomock). Gazelle calls Language.Resolve instead.
 func ResolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, error) {
+	return resolveGo(c, ix, rc, imp, from, nil)
+}
+
+// resolveGo implements ResolveGo. Decisions are recorded in x, which may
+// be nil.
+func resolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label, x *resolve.Explanation) (label.Label, error) {
 	gc := getGoConfig(c)
 	if build.IsLocalImport(imp) {
 		cleanRel := path.Clean(path.Join(from.Pkg, imp))
 		if build.IsLocalImport(cleanRel) {
-			return label.NoLabel, fmt.Errorf("relative import path %q from %q points outside of repository", imp, from.Pkg)
+			err := fmt.Errorf("relative import path %q from %q points outside of repository", imp, from.Pkg)
+			x.Fail("relative", nil, err)
+			return label.NoLabel, err
 		}
 		imp = path.Join(gc.prefix, cleanRel)
+		x.Tried("relative", nil, fmt.Sprintf("relative import treated as %q", imp))
 	}
 
 	if IsStandard(imp) {
+		x.Skip("std", "package is in the standard library")
 		return label.NoLabel, skipImportError
 	}
 
 	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: "go", Imp: imp}, "go"); ok {
+		x.Chose("override", nil, l, "matched a # gazelle:resolve directive")
 		return l, nil
 	}
+	x.Tried("override", nil, "no # gazelle:resolve directive matched")
 
-	if l, err := resolveWithIndexGo(c, ix, imp, from); err == nil || err == skipImportError {
+	if l, err := resolveWithIndexGo(c, ix, imp, from, x); err == nil || err == skipImportError {
 		return l, err
 	} else if err != notFoundError {
 		return label.NoLabel, err
@@ -165,10 +181,14 @@ func ResolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, im
 	// won't recognize them.
 	if pathtools.HasPrefix(imp, "github.com/bazelbuild/rules_go") {
 		pkg := pathtools.TrimPrefix(imp, "github.com/bazelbuild/rules_go")
-		return label.New("io_bazel_rules_go", pkg, "go_default_library"), nil
+		l := label.New("io_bazel_rules_go", pkg, "go_default_library")
+		x.Chose("special", nil, l, "rules_go is resolved to @io_bazel_rules_go")
+		return l, nil
 	} else if pathtools.HasPrefix(imp, "github.com/bazelbuild/bazel-gazelle") {
 		pkg := pathtools.TrimPrefix(imp, "github.com/bazelbuild/bazel-gazelle")
-		return label.New("bazel_gazelle", pkg, "go_default_library"), nil
+		l := label.New("bazel_gazelle", pkg, "go_default_library")
+		x.Chose("special", nil, l, "Gazelle is resolved to @bazel_gazelle")
+		return l, nil
 	}
 
 	if !c.IndexLibraries {
@@ -177,14 +197,16 @@ func ResolveGo(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, im
 		if pathtools.HasPrefix(imp, gc.prefix) {
 			pkg := path.Join(gc.prefixRel, pathtools.TrimPrefix(imp, gc.prefix))
 			libName := libNameByConvention(gc.goNamingConvention, imp, "")
-			return label.New("", pkg, libName), nil
+			l := label.New("", pkg, libName)
+			x.Chose("prefix", nil, l, fmt.Sprintf("indexing is disabled and the import path starts with the prefix %q", gc.prefix))
+			return l, nil
 		}
 	}
 
 	if gc.depMode == externalMode {
-		return resolveExternal(c, rc, imp)
+		return resolveExternal(c, rc, imp, x)
 	} else {
-		return resolveVendored(gc, imp)
+		return resolveVendored(gc, imp, x)
 	}
 }
 
@@ -193,8 +215,10 @@ func IsStandard(imp string) bool {
 	return stdPackages[imp]
 }
 
-func resolveWithIndexGo(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+func resolveWithIndexGo(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label, x *resolve.Explanation) (label.Label, error) {
 	matches := ix.FindRulesByImportWithConfig(c, resolve.ImportSpec{Lang: "go", Imp: imp}, "go")
+	method := indexMethod(ix, resolve.ImportSpec{Lang: "go", Imp: imp}, "go", matches, x)
+	candidates := resolve.FindResultLabels(matches)
 	var bestMatch resolve.FindResult
 	var bestMatchIsVendored bool
 	var bestMatchVendorRoot string
@@ -236,20 +260,47 @@ func resolveWithIndexGo(c *config.Config, ix *resolve.RuleIndex, imp string, fro
 		}
 	}
 	if matchError != nil {
+		x.Fail(method, candidates, matchError)
 		return label.NoLabel, matchError
 	}
 	if bestMatch.Label.Equal(label.NoLabel) {
+		if len(matches) > 0 {
+			x.Tried(method, candidates, "no matching rule is visible from "+from.String())
+		} else {
+			x.Tried(method, nil, "no indexed rule provides this import")
+		}
 		return label.NoLabel, notFoundError
 	}
 	if bestMatch.IsSelfImport(from) {
+		x.Skip(method, fmt.Sprintf("%s is provided by %s itself or a rule it embeds", imp, from))
 		return label.NoLabel, skipImportError
 	}
+	var reason string
+	switch {
+	case len(matches) == 1:
+		reason = "only rule in the index that provides this import"
+	case bestMatchIsVendored:
+		reason = fmt.Sprintf("closest vendored rule visible from %s", from)
+	default:
+		reason = fmt.Sprintf("other matching rules are in vendor directories not visible from %s", from)
+	}
+	x.Chose(method, candidates, bestMatch.Label, reason)
 	return bestMatch.Label, nil
 }
 
+// indexMethod returns the name recorded in x for a RuleIndex lookup:
+// "index" if matches were found in the index itself or "cross_resolve" if
+// they were provided by a resolve.CrossResolver.
+func indexMethod(ix *resolve.RuleIndex, imp resolve.ImportSpec, lang string, matches []resolve.FindResult, x *resolve.Explanation) string {
+	if x == nil || len(matches) == 0 || len(ix.FindRulesByImport(imp, lang)) > 0 {
+		return "index"
+	}
+	return "cross_resolve"
+}
+
 var modMajorRex = regexp.MustCompile(`/v\d+(?:/|$)`)
 
-func resolveExternal(c *config.Config, rc *repo.RemoteCache, imp string) (label.Label, error) {
+func resolveExternal(c *config.Config, rc *repo.RemoteCache, imp string, x *resolve.Explanation) (label.Label, error) {
 	// If we're in module mode, use "go list" to find the module path and
 	// repository name. Otherwise, use special cases (for github.com, golang.org)
 	// or send a GET with ?go-get=1 to find the root. If the path contains
@@ -272,6 +323,7 @@ func resolveExternal(c *config.Config, rc *repo.RemoteCache, imp string) (label.
 		prefix, repo, err = rc.Root(imp)
 	}
 	if err != nil {
+		x.Fail("external", nil, err)
 		return label.NoLabel, err
 	}
 
@@ -307,24 +359,37 @@ func resolveExternal(c *config.Config, rc *repo.RemoteCache, imp string) (label.
 	}
 
 	name := libNameByConvention(nc, imp, "")
-	return label.New(repo, pkg, name), nil
+	l := label.New(repo, pkg, name)
+	if x != nil {
+		kind := "repository"
+		if moduleMode {
+			kind = "module"
+		}
+		x.Chose("external", nil, l, fmt.Sprintf("%s %q is provided by repository @%s", kind, prefix, repo))
+	}
+	return l, nil
 }
 
-func resolveVendored(gc *goConfig, imp string) (label.Label, error) {
+func resolveVendored(gc *goConfig, imp string, x *resolve.Explanation) (label.Label, error) {
 	name := libNameByConvention(gc.goNamingConvention, imp, "")
-	return label.New("", path.Join("vendor", imp), name), nil
+	l := label.New("", path.Join("vendor", imp), name)
+	x.Chose("vendored", nil, l, "external dependencies are vendored (-external=vendored)")
+	return l, nil
 }
 
-func resolveProto(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, error) {
+func resolveProto(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label, x *resolve.Explanation) (label.Label, error) {
 	if wellKnownProtos[imp] {
+		x.Skip("well_known", "well known types are provided by rules_go")
 		return label.NoLabel, skipImportError
 	}
 
 	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: "proto", Imp: imp}, "go"); ok {
+		x.Chose("override", nil, l, "matched a # gazelle:resolve directive")
 		return l, nil
 	}
+	x.Tried("override", nil, "no # gazelle:resolve directive matched")
 
-	if l, err := resolveWithIndexProto(c, ix, imp, from); err == nil || err == skipImportError {
+	if l, err := resolveWithIndexProto(c, ix, imp, from, x); err == nil || err == skipImportError {
 		return l, err
 	} else if err != notFoundError {
 		return label.NoLabel, err
@@ -342,10 +407,12 @@ func resolveProto(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache,
 		rel = path.Join("vendor", rel)
 	}
 	libName := libNameByConvention(getGoConfig(c).goNamingConvention, imp, "")
-	return label.New("", rel, libName), nil
+	l := label.New("", rel, libName)
+	x.Chose("convention", nil, l, "guessed from the directory of the imported .proto file")
+	return l, nil
 }
 
-func resolveGoTool(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label) (label.Label, error) {
+func resolveGoTool(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, imp string, from label.Label, x *resolve.Explanation) (label.Label, error) {
 	if isToolLibImportPath(imp) {
 		gc := getGoConfig(c)
 		var repo string
@@ -353,9 +420,11 @@ func resolveGoTool(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache
 			repo = "org_golang_x_tools"
 		}
 		pkg := strings.TrimPrefix(imp, "golang.org/x/tools/")
-		return label.Label{Repo: repo, Pkg: pkg, Name: "go_tool_library"}, nil
+		l := label.Label{Repo: repo, Pkg: pkg, Name: "go_tool_library"}
+		x.Chose("tool", nil, l, "go_tool_library dependencies in golang.org/x/tools use go_tool_library rules")
+		return l, nil
 	}
-	return ResolveGo(c, ix, rc, imp, from)
+	return resolveGo(c, ix, rc, imp, from, x)
 }
 
 // wellKnownProtos is the set of proto sets for which we don't need to add
@@ -377,17 +446,24 @@ var wellKnownProtos = map[string]bool{
 	"google/protobuf/wrappers.proto":        true,
 }
 
-func resolveWithIndexProto(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+func resolveWithIndexProto(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label, x *resolve.Explanation) (label.Label, error) {
 	matches := ix.FindRulesByImportWithConfig(c, resolve.ImportSpec{Lang: "proto", Imp: imp}, "go")
+	method := indexMethod(ix, resolve.ImportSpec{Lang: "proto", Imp: imp}, "go", matches, x)
+	candidates := resolve.FindResultLabels(matches)
 	if len(matches) == 0 {
+		x.Tried(method, nil, "no indexed rule provides this import")
 		return label.NoLabel, notFoundError
 	}
 	if len(matches) > 1 {
-		return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
+		err := fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
+		x.Fail(method, candidates, err)
+		return label.NoLabel, err
 	}
 	if matches[0].IsSelfImport(from) {
+		x.Skip(method, fmt.Sprintf("%s is provided by %s itself or a rule it embeds", imp, from))
 		return label.NoLabel, skipImportError
 	}
+	x.Chose(method, candidates, matches[0].Label, "only rule in the index that provides this import")
 	return matches[0].Label, nil
 }
 
@@ -14,6 +14,11 @@ limitations under the License.
 */
 package golang
 
+import (
+	"os"
+	"path/filepath"
+)
+
 func init() {
 	// Replace some functions with test stubs. This avoids a dependency on
 	// the go command in the actual test, which is sandboxed.
@@ -22,6 +27,9 @@ func init() {
 }
 
 func goListModulesStub(dir string) ([]byte, error) {
+	if _, err := os.Stat(filepath.Join(dir, "go.work")); err == nil {
+		return goListWorkModulesStub(dir)
+	}
 	return []byte(`{
 	"Path": "github.com/bazelbuild/bazel-gazelle",
 	"Main": true,
@@ -98,6 +106,57 @@ func goListModulesStub(dir string) ([]byte, error) {
 `), nil
 }
 
+func goListWorkModulesStub(dir string) ([]byte, error) {
+	return []byte(`{
+	"Path": "example.com/a",
+	"Main": true,
+	"Dir": "` + filepath.ToSlash(filepath.Join(dir, "a")) + `",
+	"GoMod": "` + filepath.ToSlash(filepath.Join(dir, "a", "go.mod")) + `"
+}
+{
+	"Path": "example.com/b",
+	"Main": true,
+	"Dir": "` + filepath.ToSlash(filepath.Join(dir, "b")) + `",
+	"GoMod": "` + filepath.ToSlash(filepath.Join(dir, "b", "go.mod")) + `"
+}
+{
+	"Path": "example.com/tools",
+	"Main": true,
+	"Dir": "` + filepath.ToSlash(filepath.Join(dir, "tools")) + `",
+	"GoMod": "` + filepath.ToSlash(filepath.Join(dir, "tools", "go.mod")) + `"
+}
+{
+	"Path": "github.com/google/go-cmp",
+	"Version": "v0.5.0",
+	"Time": "2020-06-15T19:23:30Z",
+	"Dir": "/home/user/go/pkg/mod/github.com/google/go-cmp@v0.5.0",
+	"GoMod": "/home/user/go/pkg/mod/cache/download/github.com/google/go-cmp/@v/v0.5.0.mod"
+}
+{
+	"Path": "github.com/pkg/errors",
+	"Version": "v0.9.1",
+	"Time": "2020-01-14T19:47:44Z",
+	"Replace": {
+		"Path": "github.com/fork/errors",
+		"Version": "v0.9.2",
+		"Time": "2020-03-02T10:11:12Z",
+		"Dir": "/home/user/go/pkg/mod/github.com/fork/errors@v0.9.2",
+		"GoMod": "/home/user/go/pkg/mod/cache/download/github.com/fork/errors/@v/v0.9.2.mod"
+	},
+	"Dir": "/home/user/go/pkg/mod/github.com/fork/errors@v0.9.2",
+	"GoMod": "/home/user/go/pkg/mod/cache/download/github.com/fork/errors/@v/v0.9.2.mod"
+}
+{
+	"Path": "golang.org/x/text",
+	"Version": "v0.3.0",
+	"Time": "2017-12-14T13:08:43Z",
+	"Indirect": true,
+	"Dir": "/home/user/go/pkg/mod/golang.org/x/text@v0.3.0",
+	"GoMod": "/home/user/go/pkg/mod/cache/download/golang.org/x/text/@v/v0.3.0.mod"
+}
+`), nil
+}
+
 func goModDownloadStub(dir string, args []string) ([]byte, error) {
 	return []byte(`{
 	"Path": "golang.org/x/tools",
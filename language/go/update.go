@@ -61,6 +61,7 @@ func (*goLang) UpdateRepos(args language.UpdateReposArgs) language.UpdateReposRe
 var repoImportFuncs = map[string]func(args language.ImportReposArgs) language.ImportReposResult{
 	"Gopkg.lock":  importReposFromDep,
 	"go.mod":      importReposFromModules,
+	"go.work":     importReposFromWork,
 	"Godeps.json": importReposFromGodep,
 }
 
@@ -219,6 +219,102 @@ go_repository(
     sum = "h1:FkAkwuYWQw+IArrnmhGlisKHQF4MsZ2Nu/fX4ttW55o=",
     version = "v0.0.0-20190122202912-9c309ee22fab",
 )
+`,
+		}, {
+			desc: "workspace",
+			files: []testtools.FileSpec{
+				{
+					Path: "go.work",
+					Content: `
+go 1.18
+
+use (
+	./a
+	"./b" // quoted
+)
+
+use ./tools
+
+replace github.com/pkg/errors => github.com/fork/errors v0.9.2
+`,
+				}, {
+					Path: "a/go.mod",
+					Content: `
+module example.com/a
+
+go 1.18
+
+require (
+	example.com/b v0.0.0
+	github.com/pkg/errors v0.9.1
+)
+`,
+				}, {
+					Path: "a/go.sum",
+					Content: `
+github.com/pkg/errors v0.9.1 h1:FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4=
+github.com/pkg/errors v0.9.1/go.mod h1:bwawxfHBFNV+L2hUp1rHADufV3IMtnDRdf1r5NINEl0=
+`,
+				}, {
+					Path: "b/go.mod",
+					Content: `
+module example.com/b
+
+go 1.18
+
+require golang.org/x/text v0.3.0 // indirect
+`,
+				}, {
+					Path: "b/go.sum",
+					Content: `
+golang.org/x/text v0.3.0 h1:g61tztE5qeGQ89tm6NTjjM9VPIm088od1l6aSorWRWg=
+golang.org/x/text v0.3.0/go.mod h1:NqM8EUOU14njkJ3fqMW+pc6Ldnwhi/IjpwHt7yyuwOQ=
+`,
+				}, {
+					Path: "tools/go.mod",
+					Content: `
+module example.com/tools
+
+go 1.18
+
+require github.com/google/go-cmp v0.5.0
+`,
+				}, {
+					Path: "tools/go.sum",
+					Content: `
+github.com/google/go-cmp v0.5.0 h1:/QaMHBdZ26BB3SSst0Iwl10Epc+xhTquomWX0oZEB6w=
+github.com/google/go-cmp v0.5.0/go.mod h1:v8dTdLbMG2kIc/vJvl+f65V22dbkXbowE6jgT/gNBxE=
+`,
+				}, {
+					Path: "go.work.sum",
+					Content: `
+github.com/fork/errors v0.9.2 h1:3Zr2pNhTqXfS0Zv7E5m6ZLyCGnUaH0nFp9wq8YbF0zE=
+github.com/fork/errors v0.9.2/go.mod h1:bwawxfHBFNV+L2hUp1rHADufV3IMtnDRdf1r5NINEl0=
+`,
+				},
+			},
+			want: `
+go_repository(
+    name = "com_github_google_go_cmp",
+    importpath = "github.com/google/go-cmp",
+    sum = "h1:/QaMHBdZ26BB3SSst0Iwl10Epc+xhTquomWX0oZEB6w=",
+    version = "v0.5.0",
+)
+
+go_repository(
+    name = "com_github_pkg_errors",
+    importpath = "github.com/pkg/errors",
+    replace = "github.com/fork/errors",
+    sum = "h1:3Zr2pNhTqXfS0Zv7E5m6ZLyCGnUaH0nFp9wq8YbF0zE=",
+    version = "v0.9.2",
+)
+
+go_repository(
+    name = "org_golang_x_text",
+    importpath = "golang.org/x/text",
+    sum = "h1:g61tztE5qeGQ89tm6NTjjM9VPIm088od1l6aSorWRWg=",
+    version = "v0.3.0",
+)
 `,
 		}, {
 			desc: "godep",
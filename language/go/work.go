@@ -0,0 +1,60 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package golang
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/bazelbuild/bazel-gazelle/internal/modfile"
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
+
+// importReposFromWork imports repositories from a go.work file. "go list"
+// run next to go.work merges the requirements of all used modules and
+// applies workspace-level replace directives. Used modules are main modules,
+// so they don't get go_repository rules. Sums are taken from the go.sum file
+// of each used module and from go.work.sum.
+func importReposFromWork(args language.ImportReposArgs) language.ImportReposResult {
+	data, err := ioutil.ReadFile(args.Path)
+	if err != nil {
+		return language.ImportReposResult{Error: err}
+	}
+	dir := filepath.Dir(args.Path)
+	var goSumPaths []string
+	for _, use := range parseGoWorkUses(data) {
+		useDir := filepath.FromSlash(use)
+		if !filepath.IsAbs(useDir) {
+			useDir = filepath.Join(dir, useDir)
+		}
+		goSumPaths = append(goSumPaths, filepath.Join(useDir, "go.sum"))
+	}
+	goSumPaths = append(goSumPaths, filepath.Join(dir, "go.work.sum"))
+	return importReposFromModuleGraph(dir, goSumPaths, args.ReadOnly)
+}
+
+// parseGoWorkUses returns the module directories listed in use directives
+// in the contents of a go.work file. Directories are relative to the
+// directory containing go.work unless they are absolute.
+func parseGoWorkUses(data []byte) []string {
+	var uses []string
+	for _, args := range modfile.Directives(data, "use") {
+		if len(args) > 0 {
+			uses = append(uses, args[0])
+		}
+	}
+	return uses
+}
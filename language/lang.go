@@ -37,7 +37,9 @@ import (
 //
 // A single instance of Language is created for each fix / update run. Some
 // state may be stored in this instance, but stateless behavior is encouraged,
-// especially since some operations may be concurrent in the future.
+// since GenerateRules and Resolve may be called concurrently for different
+// directories when the -jobs flag is greater than 1. Languages that support
+// that must guard any state accordingly.
 //
 // Tasks languages are used for
 //
@@ -77,7 +79,9 @@ type Language interface {
 
 	// GenerateRules extracts build metadata from source files in a directory.
 	// GenerateRules is called in each directory where an update is requested
-	// in depth-first post-order.
+	// in depth-first post-order. GenerateRules may be called concurrently for
+	// different directories, but it is not called for a directory until it
+	// has returned for all of that directory's subdirectories.
 	//
 	// args contains the arguments for GenerateRules. This is passed as a
 	// struct to avoid breaking implementations in the future when new
@@ -86,13 +90,14 @@ type Language interface {
 	// A GenerateResult struct is returned. Optional fields may be added to this
 	// type in the future.
 	//
-	// Any non-fatal errors this function encounters should be logged using
-	// log.Print.
+	// Any non-fatal errors this function encounters should be reported to
+	// args.Config.Diagnostics, which also prints them.
 	GenerateRules(args GenerateArgs) GenerateResult
 
 	// Fix repairs deprecated usage of language-specific rules in f. This is
 	// called before the file is indexed. Unless c.ShouldFix is true, fixes
-	// that delete or rename rules should not be performed.
+	// that delete or rename rules should not be performed. Languages may
+	// implement Migrator so that fixes can be applied one at a time.
 	Fix(c *config.Config, f *rule.File)
 }
 
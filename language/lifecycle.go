@@ -0,0 +1,119 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package language
+
+import (
+	"context"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// The interfaces below may be implemented by languages that need to do
+// work once per run rather than once per directory, for example, loading
+// a manifest that describes the whole repository, validating invariants
+// that span packages, or writing a summary file.
+//
+// Gazelle calls each hook for each language that implements it, in the
+// order languages were listed, on a single goroutine. If a hook returns an
+// error, Gazelle stops and reports it; later hooks are not called.
+//
+// The context passed to each hook is the same for the whole run. It's
+// cancelled when the command returns, so work started in the background by
+// one hook should stop when it's done.
+
+// BeforeWalker is implemented by languages that need to do something
+// before Gazelle visits any directories. For update-repos, BeforeWalk is
+// called before repository rules are generated.
+//
+// EXPERIMENTAL: this may change or be removed.
+type BeforeWalker interface {
+	BeforeWalk(ctx context.Context, args BeforeWalkArgs) error
+}
+
+// BeforeWalkArgs contains arguments for BeforeWalker.BeforeWalk.
+//
+// EXPERIMENTAL: this may change or be removed.
+type BeforeWalkArgs struct {
+	// Config is the configuration for the repository root, after command
+	// line flags have been applied. Directives have not been read yet.
+	Config *config.Config
+
+	// Cmd is the name of the command being run, for example, "update" or
+	// "update-repos".
+	Cmd string
+
+	// Dirs lists the absolute paths of directories Gazelle was asked to
+	// update. It's empty for update-repos.
+	Dirs []string
+}
+
+// AfterGenerator is implemented by languages that need to do something
+// after rules have been generated for all directories and indexed, but
+// before dependencies are resolved. For update-repos, AfterGenerate is
+// called after repository rules have been merged into the files they will
+// be written to.
+//
+// EXPERIMENTAL: this may change or be removed.
+type AfterGenerator interface {
+	AfterGenerate(ctx context.Context, args AfterGenerateArgs) error
+}
+
+// AfterGenerateArgs contains arguments for AfterGenerator.AfterGenerate.
+//
+// EXPERIMENTAL: this may change or be removed.
+type AfterGenerateArgs struct {
+	// Config is the configuration for the repository root.
+	Config *config.Config
+
+	// Cmd is the name of the command being run.
+	Cmd string
+
+	// Files lists the build files that will be written, with generated rules
+	// merged into them.
+	Files []*rule.File
+
+	// Index contains the indexed rules. It's nil for update-repos.
+	Index *resolve.RuleIndex
+}
+
+// AfterResolver is implemented by languages that need to do something after
+// dependencies have been resolved for all rules, but before any files are
+// written. Hooks may still change Files at this point. update-repos doesn't
+// resolve dependencies, so AfterResolve is called right after AfterGenerate.
+// Since query doesn't resolve dependencies, AfterResolve is not called for
+// query.
+//
+// EXPERIMENTAL: this may change or be removed.
+type AfterResolver interface {
+	AfterResolve(ctx context.Context, args AfterResolveArgs) error
+}
+
+// AfterResolveArgs contains arguments for AfterResolver.AfterResolve.
+//
+// EXPERIMENTAL: this may change or be removed.
+type AfterResolveArgs struct {
+	// Config is the configuration for the repository root.
+	Config *config.Config
+
+	// Cmd is the name of the command being run.
+	Cmd string
+
+	// Files lists the build files that will be written, after dependencies
+	// have been resolved.
+	Files []*rule.File
+}
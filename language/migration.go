@@ -0,0 +1,59 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package language
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Migration is a named fix for deprecated usage in build files, for example,
+// renaming rules after a naming convention changes, or replacing a rule
+// kind that was removed.
+//
+// EXPERIMENTAL: this may change or be removed.
+type Migration struct {
+	// Name identifies the migration in the -fix flag. Names must be unique
+	// among all languages.
+	Name string
+
+	// Version is incremented when the migration's behavior changes, so that
+	// scripts that select a migration with -fix=name@version fail instead of
+	// applying changes that weren't reviewed. Versions start at 1.
+	Version int
+
+	// Doc is a one-line description of the migration.
+	Doc string
+
+	// Fix applies the migration to f. Like Language.Fix, migrations that
+	// delete or rename rules should only do so if c.ShouldFix is true.
+	// When a migration is selected explicitly with -fix, c.ShouldFix is true.
+	Fix func(c *config.Config, f *rule.File)
+}
+
+// Migrator may be implemented by languages that split Fix into separate
+// migrations. Users may then apply migrations one at a time with
+// "gazelle fix -fix=name" and review the changes from each separately.
+//
+// When -fix is not set, Gazelle calls Language.Fix as usual, which should
+// apply all migrations in the order they're returned here. When -fix is set,
+// Gazelle calls the selected migrations instead, in that order, and doesn't
+// call Fix for any language.
+//
+// EXPERIMENTAL: this may change or be removed.
+type Migrator interface {
+	Migrations() []Migration
+}
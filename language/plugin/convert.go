@@ -0,0 +1,153 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func rulesToJSON(rs []*rule.Rule) []Rule {
+	if len(rs) == 0 {
+		return nil
+	}
+	jrs := make([]Rule, len(rs))
+	for i, r := range rs {
+		jrs[i] = ruleToJSON(r)
+	}
+	return jrs
+}
+
+// ruleToJSON converts r to its JSON form. Attributes with values that can't
+// be represented in JSON are omitted.
+func ruleToJSON(r *rule.Rule) Rule {
+	jr := Rule{Kind: r.Kind(), Name: r.Name()}
+	for _, key := range r.AttrKeys() {
+		if key == "name" {
+			continue
+		}
+		if v, ok := valueFromExpr(r.Attr(key)); ok {
+			if jr.Attrs == nil {
+				jr.Attrs = make(map[string]interface{})
+			}
+			jr.Attrs[key] = v
+		}
+	}
+	return jr
+}
+
+func valueFromExpr(e bzl.Expr) (interface{}, bool) {
+	switch e := e.(type) {
+	case *bzl.StringExpr:
+		return e.Value, true
+	case *bzl.Ident:
+		switch e.Name {
+		case "True":
+			return true, true
+		case "False":
+			return false, true
+		}
+	case *bzl.LiteralExpr:
+		switch e.Token {
+		case "True":
+			return true, true
+		case "False":
+			return false, true
+		}
+		if n, err := strconv.ParseInt(e.Token, 0, 64); err == nil {
+			return n, true
+		}
+	case *bzl.ListExpr:
+		list := make([]interface{}, 0, len(e.List))
+		for _, elem := range e.List {
+			v, ok := valueFromExpr(elem)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, v)
+		}
+		return list, true
+	case *bzl.DictExpr:
+		dict := make(map[string]interface{}, len(e.List))
+		for _, kv := range e.List {
+			k, ok := kv.Key.(*bzl.StringExpr)
+			if !ok {
+				return nil, false
+			}
+			v, ok := valueFromExpr(kv.Value)
+			if !ok {
+				return nil, false
+			}
+			dict[k.Value] = v
+		}
+		return dict, true
+	}
+	return nil, false
+}
+
+// ruleFromJSON converts a rule returned by a plugin to a *rule.Rule.
+func ruleFromJSON(jr Rule) *rule.Rule {
+	r := rule.NewRule(jr.Kind, jr.Name)
+	for _, key := range sortedKeys(jr.Attrs) {
+		if key == "name" || jr.Attrs[key] == nil {
+			continue
+		}
+		r.SetAttr(key, valueFromJSON(jr.Attrs[key]))
+	}
+	return r
+}
+
+// valueFromJSON converts a value decoded from JSON to a value that can be
+// passed to rule.SetAttr. Integral numbers are converted to int64 so they
+// are formatted without a fractional part.
+func valueFromJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return v
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = valueFromJSON(elem)
+		}
+		return list
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			dict[k] = valueFromJSON(elem)
+		}
+		return dict
+	case nil:
+		return &bzl.Ident{Name: "None"}
+	default:
+		return v
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
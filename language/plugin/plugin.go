@@ -0,0 +1,409 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin provides a language.Language that is implemented by an
+// external executable. This lets Gazelle support a new language without
+// building a new Gazelle binary with gazelle_binary.
+//
+// Gazelle starts the plugin executable and talks to it over stdin and
+// stdout. Each message is a JSON object (see Message) on a single line.
+// Gazelle sends a request and waits for the response before sending
+// the next request, so plugins don't need to handle requests concurrently.
+// Anything the plugin writes to stderr is passed through to Gazelle's stderr.
+//
+// The first request is always "initialize", which carries the protocol
+// version. The plugin replies with the same version, the name of its
+// language, and the kinds of rules it generates. Then Gazelle sends
+// "known_directives", followed by requests that correspond to methods of
+// language.Language: "configure", "generate_rules", "imports", "embeds", and
+// "resolve". While handling "resolve", the plugin may send
+// "find_rules_by_import" requests to Gazelle to look up rules in the index.
+// When Gazelle is done, it sends "shutdown" and closes the plugin's stdin.
+//
+// The types in protocol.go describe the parameters and results of each
+// method. Plugins written in Go may use them directly.
+//
+// EXPERIMENTAL: this may change or be removed.
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// DiagPlugin is the code of diagnostics reported when a plugin fails to
+// handle a request.
+const DiagPlugin = "plugin"
+
+// Language is a language.Language implemented by a plugin process. Close
+// should be called when the Language is no longer needed.
+type Language struct {
+	path       string
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	enc        *json.Encoder
+	dec        *json.Decoder
+	name       string
+	kinds      map[string]rule.KindInfo
+	loads      []rule.LoadInfo
+	directives []string
+	known      map[string]bool
+
+	// mu guards the fields below and serializes requests. GenerateRules and
+	// Resolve may be called concurrently, but the plugin handles one request
+	// at a time.
+	mu     sync.Mutex
+	nextID int
+	err    error
+
+	// diags is where problems are reported from methods that don't receive
+	// a configuration, like Embeds. It's set by Configure.
+	diags *config.Diagnostics
+}
+
+var _ language.Language = (*Language)(nil)
+
+// Start starts the plugin executable at path with the given arguments and
+// initializes it.
+func Start(path string, args ...string) (*Language, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting plugin %s: %v", path, err)
+	}
+	l := &Language{
+		path:  path,
+		cmd:   cmd,
+		stdin: stdin,
+		enc:   json.NewEncoder(stdin),
+		dec:   json.NewDecoder(stdout),
+	}
+	if err := l.initialize(); err != nil {
+		l.stdin.Close()
+		l.cmd.Process.Kill()
+		l.cmd.Wait()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Language) initialize() error {
+	var init InitializeResult
+	params := InitializeParams{ProtocolVersion: ProtocolVersion}
+	if err := l.call(MethodInitialize, params, &init, nil); err != nil {
+		return err
+	}
+	if init.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("plugin %s: uses protocol version %d; Gazelle supports version %d", l.path, init.ProtocolVersion, ProtocolVersion)
+	}
+	if init.Name == "" {
+		return fmt.Errorf("plugin %s: initialize did not return a language name", l.path)
+	}
+	l.name = init.Name
+	l.kinds = make(map[string]rule.KindInfo)
+	for kind, ki := range init.Kinds {
+		l.kinds[kind] = rule.KindInfo{
+			MatchAny:        ki.MatchAny,
+			MatchAttrs:      ki.MatchAttrs,
+			NonEmptyAttrs:   stringSet(ki.NonEmptyAttrs),
+			SubstituteAttrs: stringSet(ki.SubstituteAttrs),
+			MergeableAttrs:  stringSet(ki.MergeableAttrs),
+			ResolveAttrs:    stringSet(ki.ResolveAttrs),
+		}
+	}
+	for _, li := range init.Loads {
+		l.loads = append(l.loads, rule.LoadInfo{Name: li.Name, Symbols: li.Symbols, After: li.After})
+	}
+
+	var kd KnownDirectivesResult
+	if err := l.call(MethodKnownDirectives, struct{}{}, &kd, nil); err != nil {
+		return err
+	}
+	l.directives = kd.Directives
+	l.known = stringSet(kd.Directives)
+	return nil
+}
+
+// Close asks the plugin to shut down and waits for it to exit.
+func (l *Language) Close() error {
+	shutdownErr := l.call(MethodShutdown, struct{}{}, nil, nil)
+	l.stdin.Close()
+	waitErr := l.cmd.Wait()
+	if shutdownErr != nil {
+		return shutdownErr
+	}
+	if waitErr != nil {
+		return fmt.Errorf("plugin %s: %v", l.path, waitErr)
+	}
+	return nil
+}
+
+// callbackFunc handles a request sent by the plugin while Gazelle is waiting
+// for a response.
+type callbackFunc func(method string, params json.RawMessage) (interface{}, error)
+
+// call sends a request to the plugin and decodes the result into result,
+// which may be nil. If the plugin sends requests of its own before
+// responding, they are handled by callback.
+//
+// Errors reported by the plugin are returned, but the plugin may still be
+// used. Errors in the protocol itself are sticky: once one happens, all
+// later calls fail.
+func (l *Language) call(method string, params, result interface{}, callback callbackFunc) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	l.nextID++
+	id := l.nextID
+	if err := l.enc.Encode(Message{ID: id, Method: method, Params: data}); err != nil {
+		return l.fail(fmt.Errorf("sending %s request: %v", method, err))
+	}
+
+	for {
+		var msg Message
+		if err := l.dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				err = errors.New("plugin exited")
+			}
+			return l.fail(fmt.Errorf("reading %s response: %v", method, err))
+		}
+		if msg.Method != "" {
+			reply := Message{ID: msg.ID}
+			var res interface{}
+			var err error
+			if callback == nil {
+				err = fmt.Errorf("%s may not be called while handling %s", msg.Method, method)
+			} else {
+				res, err = callback(msg.Method, msg.Params)
+			}
+			if err == nil {
+				// The plugin is waiting for a reply, so a result that can't be
+				// encoded is reported to it as an error.
+				reply.Result, err = json.Marshal(res)
+			}
+			if err != nil {
+				reply.Result = nil
+				reply.Error = err.Error()
+			}
+			if err := l.enc.Encode(reply); err != nil {
+				return l.fail(fmt.Errorf("sending %s response: %v", msg.Method, err))
+			}
+			continue
+		}
+		if msg.ID != id {
+			return l.fail(fmt.Errorf("%s response has id %d; expected %d", method, msg.ID, id))
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("plugin %s: %s: %s", l.path, method, msg.Error)
+		}
+		if result != nil && len(msg.Result) > 0 {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("plugin %s: decoding %s result: %v", l.path, method, err)
+			}
+		}
+		return nil
+	}
+}
+
+func (l *Language) fail(err error) error {
+	l.err = fmt.Errorf("plugin %s: %v", l.path, err)
+	return l.err
+}
+
+func (l *Language) Name() string { return l.name }
+
+func (l *Language) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {}
+
+func (l *Language) CheckFlags(fs *flag.FlagSet, c *config.Config) error { return nil }
+
+func (l *Language) KnownDirectives() []string { return l.directives }
+
+func (l *Language) Configure(c *config.Config, rel string, f *rule.File) {
+	l.mu.Lock()
+	l.diags = c.Diagnostics
+	l.mu.Unlock()
+	params := ConfigureParams{Rel: rel}
+	pos := config.Position{Path: filepath.Join(c.RepoRoot, filepath.FromSlash(rel))}
+	if f != nil {
+		pos.Path = f.Path
+		for _, d := range f.Directives {
+			if l.known[d.Key] {
+				params.Directives = append(params.Directives, Directive{Key: d.Key, Value: d.Value})
+			}
+		}
+	}
+	if err := l.call(MethodConfigure, params, nil, nil); err != nil {
+		c.Diagnostics.Errorf(pos, DiagPlugin, "%v", err)
+	}
+}
+
+func (l *Language) Kinds() map[string]rule.KindInfo { return l.kinds }
+
+func (l *Language) Loads() []rule.LoadInfo { return l.loads }
+
+func (l *Language) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	params := GenerateRulesParams{
+		Rel:          args.Rel,
+		Dir:          args.Dir,
+		Subdirs:      args.Subdirs,
+		RegularFiles: args.RegularFiles,
+		GenFiles:     args.GenFiles,
+		OtherGen:     rulesToJSON(args.OtherGen),
+	}
+	if args.File != nil {
+		params.File = &File{Path: args.File.Path, Rules: rulesToJSON(args.File.Rules)}
+	}
+	var res GenerateRulesResult
+	if err := l.call(MethodGenerateRules, params, &res, nil); err != nil {
+		args.Config.Diagnostics.Errorf(config.Position{Path: args.Dir}, DiagPlugin, "%v", err)
+		return language.GenerateResult{}
+	}
+	if len(res.Imports) > len(res.Gen) {
+		args.Config.Diagnostics.Errorf(config.Position{Path: args.Dir}, DiagPlugin, "plugin %s: generate_rules returned %d imports for %d rules", l.path, len(res.Imports), len(res.Gen))
+		return language.GenerateResult{}
+	}
+
+	var gr language.GenerateResult
+	for i, jr := range res.Gen {
+		gr.Gen = append(gr.Gen, ruleFromJSON(jr))
+		var imports json.RawMessage
+		if i < len(res.Imports) {
+			imports = res.Imports[i]
+		}
+		gr.Imports = append(gr.Imports, imports)
+	}
+	for _, jr := range res.Empty {
+		gr.Empty = append(gr.Empty, ruleFromJSON(jr))
+	}
+	return gr
+}
+
+func (l *Language) Fix(c *config.Config, f *rule.File) {}
+
+func (l *Language) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	var res ImportsResult
+	if err := l.call(MethodImports, ImportsParams{Rel: f.Pkg, Rule: ruleToJSON(r)}, &res, nil); err != nil {
+		c.Diagnostics.Errorf(config.Position{Path: f.Path}, DiagPlugin, "%v", err)
+		return nil
+	}
+	if res.Imports == nil {
+		return nil
+	}
+	specs := make([]resolve.ImportSpec, len(res.Imports))
+	for i, imp := range res.Imports {
+		specs[i] = resolve.ImportSpec{Lang: imp.Lang, Imp: imp.Imp}
+	}
+	return specs
+}
+
+func (l *Language) Embeds(r *rule.Rule, from label.Label) []label.Label {
+	l.mu.Lock()
+	diags := l.diags
+	l.mu.Unlock()
+	pos := config.Position{Path: from.String()}
+	var res EmbedsResult
+	if err := l.call(MethodEmbeds, EmbedsParams{From: from.String(), Rule: ruleToJSON(r)}, &res, nil); err != nil {
+		diags.Errorf(pos, DiagPlugin, "%v", err)
+		return nil
+	}
+	var embeds []label.Label
+	for _, s := range res.Embeds {
+		e, err := label.Parse(s)
+		if err != nil {
+			diags.Errorf(pos, DiagPlugin, "plugin %s: embeds: %v", l.path, err)
+			continue
+		}
+		embeds = append(embeds, e.Abs(from.Repo, from.Pkg))
+	}
+	return embeds
+}
+
+func (l *Language) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+	params := ResolveParams{Rel: from.Pkg, From: from.String(), Rule: ruleToJSON(r)}
+	if raw, ok := imports.(json.RawMessage); ok {
+		params.Imports = raw
+	}
+	callback := func(method string, data json.RawMessage) (interface{}, error) {
+		if method != MethodFindRulesByImport {
+			return nil, fmt.Errorf("unknown method %q", method)
+		}
+		var p FindRulesByImportParams
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, err
+		}
+		res := FindRulesByImportResult{Results: []FindResult{}}
+		imp := resolve.ImportSpec{Lang: p.Lang, Imp: p.Imp}
+		for _, m := range ix.FindRulesByImportWithConfig(c, imp, l.name) {
+			fr := FindResult{Label: m.Label.String()}
+			for _, e := range m.Embeds {
+				fr.Embeds = append(fr.Embeds, e.String())
+			}
+			res.Results = append(res.Results, fr)
+		}
+		return res, nil
+	}
+	var res ResolveResult
+	if err := l.call(MethodResolve, params, &res, callback); err != nil {
+		c.Diagnostics.Errorf(config.Position{Path: filepath.Join(c.RepoRoot, filepath.FromSlash(from.Pkg))}, DiagPlugin, "%v", err)
+		return
+	}
+	for _, key := range sortedKeys(res.Attrs) {
+		if value := res.Attrs[key]; value == nil {
+			r.DelAttr(key)
+		} else {
+			r.SetAttr(key, valueFromJSON(value))
+		}
+	}
+}
+
+func stringSet(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
@@ -0,0 +1,425 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+)
+
+// The test binary doubles as a fake plugin. When fakePluginEnv is set,
+// TestMain runs the plugin instead of the tests.
+const (
+	fakePluginEnv        = "GAZELLE_FAKE_PLUGIN"
+	fakePluginVersionEnv = "GAZELLE_FAKE_PLUGIN_VERSION"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakePluginEnv) != "" {
+		if err := runFakePlugin(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runFakePlugin implements a language named "fake". Each directory with
+// .fake files gets a fake_library. Lines like "import x" in .fake files are
+// resolved to fake_libraries whose import path is x. The import path of
+// a library is the fake_prefix directive joined with the directory path.
+func runFakePlugin() error {
+	in := json.NewDecoder(bufio.NewReader(os.Stdin))
+	out := json.NewEncoder(os.Stdout)
+	prefixes := make(map[string]string)
+	callbackID := 1000
+
+	reply := func(id int, result interface{}, err error) error {
+		msg := Message{ID: id}
+		if err != nil {
+			msg.Error = err.Error()
+		} else {
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			msg.Result = data
+		}
+		return out.Encode(msg)
+	}
+	findRules := func(imp string) ([]FindResult, error) {
+		callbackID++
+		params, _ := json.Marshal(FindRulesByImportParams{Lang: "fake", Imp: imp})
+		if err := out.Encode(Message{ID: callbackID, Method: MethodFindRulesByImport, Params: params}); err != nil {
+			return nil, err
+		}
+		var msg Message
+		if err := in.Decode(&msg); err != nil {
+			return nil, err
+		}
+		if msg.ID != callbackID || msg.Error != "" {
+			return nil, fmt.Errorf("bad callback response: %#v", msg)
+		}
+		var res FindRulesByImportResult
+		err := json.Unmarshal(msg.Result, &res)
+		return res.Results, err
+	}
+
+	for {
+		var msg Message
+		if err := in.Decode(&msg); err != nil {
+			return err
+		}
+		var result interface{}
+		var err error
+		switch msg.Method {
+		case MethodInitialize:
+			version := ProtocolVersion
+			if v := os.Getenv(fakePluginVersionEnv); v != "" {
+				version, _ = strconv.Atoi(v)
+			}
+			result = InitializeResult{
+				ProtocolVersion: version,
+				Name:            "fake",
+				Kinds: map[string]KindInfo{
+					"fake_library": {
+						MatchAny:       true,
+						NonEmptyAttrs:  []string{"srcs"},
+						MergeableAttrs: []string{"srcs"},
+						ResolveAttrs:   []string{"deps"},
+					},
+				},
+				Loads: []LoadInfo{{Name: "@fake//:def.bzl", Symbols: []string{"fake_library"}}},
+			}
+
+		case MethodKnownDirectives:
+			result = KnownDirectivesResult{Directives: []string{"fake_prefix"}}
+
+		case MethodConfigure:
+			var p ConfigureParams
+			json.Unmarshal(msg.Params, &p)
+			if p.Rel != "" {
+				parent := path.Dir(p.Rel)
+				if parent == "." {
+					parent = ""
+				}
+				prefixes[p.Rel] = prefixes[parent]
+			}
+			for _, d := range p.Directives {
+				prefixes[p.Rel] = d.Value
+			}
+			result = struct{}{}
+
+		case MethodGenerateRules:
+			var p GenerateRulesParams
+			json.Unmarshal(msg.Params, &p)
+			if path.Base(p.Rel) == "bad" {
+				err = fmt.Errorf("can't generate rules in %s", p.Rel)
+				break
+			}
+			name := path.Base(p.Rel)
+			if p.Rel == "" {
+				name = "root"
+			}
+			var srcs, imports []string
+			for _, f := range p.RegularFiles {
+				if !strings.HasSuffix(f, ".fake") {
+					continue
+				}
+				srcs = append(srcs, f)
+				data, err := ioutil.ReadFile(filepath.Join(p.Dir, f))
+				if err != nil {
+					return err
+				}
+				for _, line := range strings.Split(string(data), "\n") {
+					if strings.HasPrefix(line, "import ") {
+						imports = append(imports, strings.TrimPrefix(line, "import "))
+					}
+				}
+			}
+			var res GenerateRulesResult
+			if len(srcs) == 0 {
+				res.Empty = []Rule{{Kind: "fake_library", Name: name}}
+			} else {
+				imp, _ := json.Marshal(imports)
+				res.Gen = []Rule{{
+					Kind:  "fake_library",
+					Name:  name,
+					Attrs: map[string]interface{}{"srcs": srcs, "size": 1, "testonly": false},
+				}}
+				res.Imports = []json.RawMessage{imp}
+			}
+			result = res
+
+		case MethodImports:
+			var p ImportsParams
+			json.Unmarshal(msg.Params, &p)
+			result = ImportsResult{Imports: []ImportSpec{{Lang: "fake", Imp: path.Join(prefixes[p.Rel], p.Rel)}}}
+
+		case MethodEmbeds:
+			var p EmbedsParams
+			json.Unmarshal(msg.Params, &p)
+			var embeds []string
+			if e, ok := p.Rule.Attrs["embed"].(string); ok {
+				embeds = append(embeds, e)
+			}
+			result = EmbedsResult{Embeds: embeds}
+
+		case MethodResolve:
+			var p ResolveParams
+			json.Unmarshal(msg.Params, &p)
+			var imports []string
+			json.Unmarshal(p.Imports, &imports)
+			var deps []string
+			for _, imp := range imports {
+				var results []FindResult
+				results, err = findRules(imp)
+				if err != nil {
+					break
+				}
+				for _, r := range results {
+					deps = append(deps, r.Label)
+				}
+			}
+			sort.Strings(deps)
+			attrs := map[string]interface{}{"deps": nil}
+			if len(deps) > 0 {
+				attrs["deps"] = deps
+			}
+			result = ResolveResult{Attrs: attrs}
+
+		case MethodShutdown:
+			return reply(msg.ID, struct{}{}, nil)
+
+		default:
+			err = fmt.Errorf("unknown method %q", msg.Method)
+		}
+		if err := reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func startFakePlugin(t *testing.T) *Language {
+	os.Setenv(fakePluginEnv, "1")
+	defer os.Unsetenv(fakePluginEnv)
+	l, err := Start(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestPlugin(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "BUILD.bazel", Content: "# gazelle:fake_prefix example.com/repo\n"},
+		{Path: "a/a.fake", Content: "import example.com/repo/b\n"},
+		{Path: "a/BUILD.bazel", Content: "fake_library(\n    name = \"a\",\n    deps = [\"//old\"],\n)\n"},
+		{Path: "b/b.fake"},
+		{Path: "c/c.txt"},
+		{Path: "bad/bad.fake"},
+	})
+	defer cleanup()
+
+	l := startFakePlugin(t)
+	if got := l.Name(); got != "fake" {
+		t.Errorf("Name: got %q; want %q", got, "fake")
+	}
+	if got, want := l.KnownDirectives(), []string{"fake_prefix"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("KnownDirectives: got %v; want %v", got, want)
+	}
+	if ki, ok := l.Kinds()["fake_library"]; !ok || !ki.MatchAny || !ki.ResolveAttrs["deps"] {
+		t.Errorf("Kinds: got %#v", l.Kinds())
+	}
+	if got := l.Loads(); len(got) != 1 || got[0].Name != "@fake//:def.bzl" {
+		t.Errorf("Loads: got %#v", got)
+	}
+
+	c := config.New()
+	c.RepoRoot = dir
+	loadFile := func(rel string) *rule.File {
+		p := filepath.Join(dir, filepath.FromSlash(rel), "BUILD.bazel")
+		if _, err := os.Stat(p); err != nil {
+			return nil
+		}
+		f, err := rule.LoadFile(p, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	files := make(map[string]*rule.File)
+	results := make(map[string]language.GenerateResult)
+	for _, rel := range []string{"", "a", "b", "c", "bad"} {
+		f := loadFile(rel)
+		l.Configure(c, rel, f)
+		if rel == "" {
+			continue
+		}
+		abs := filepath.Join(dir, rel)
+		fis, err := ioutil.ReadDir(abs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var regularFiles []string
+		for _, fi := range fis {
+			regularFiles = append(regularFiles, fi.Name())
+		}
+		results[rel] = l.GenerateRules(language.GenerateArgs{
+			Config:       c,
+			Dir:          abs,
+			Rel:          rel,
+			File:         f,
+			RegularFiles: regularFiles,
+		})
+		if f == nil {
+			f = rule.EmptyFile(filepath.Join(abs, "BUILD.bazel"), rel)
+		}
+		files[rel] = f
+	}
+
+	if got := results["c"]; len(got.Gen) != 0 || len(got.Empty) != 1 || got.Empty[0].Name() != "c" {
+		t.Errorf("GenerateRules(c): got %#v", got)
+	}
+	if got := results["bad"]; len(got.Gen) != 0 || len(got.Empty) != 0 {
+		t.Errorf("GenerateRules(bad): got %#v", got)
+	}
+	diags := c.Diagnostics.List()
+	if len(diags) != 1 || diags[0].Code != DiagPlugin || !strings.Contains(diags[0].Message, "can't generate rules in bad") {
+		t.Errorf("got diagnostics %v; want one error for bad", diags)
+	}
+
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, pkgRel string) resolve.Resolver {
+		if r.Kind() == "fake_library" {
+			return l
+		}
+		return nil
+	})
+	for _, rel := range []string{"a", "b"} {
+		f := files[rel]
+		if len(f.Rules) > 0 {
+			f.Rules[0].Delete()
+			f.Sync()
+		}
+		for _, r := range results[rel].Gen {
+			r.Insert(f)
+		}
+		for _, r := range f.Rules {
+			ix.AddRule(c, r, f)
+		}
+	}
+	ix.Finish()
+
+	a := files["a"]
+	l.Resolve(c, ix, nil, a.Rules[0], results["a"].Imports[0], label.New("", "a", "a"))
+	b := files["b"]
+	l.Resolve(c, ix, nil, b.Rules[0], results["b"].Imports[0], label.New("", "b", "b"))
+	a.Sync()
+	b.Sync()
+
+	wantA := `fake_library(
+    name = "a",
+    size = 1,
+    testonly = False,
+    srcs = ["a.fake"],
+    deps = ["//b"],
+)
+`
+	if got := string(a.Format()); got != wantA {
+		t.Errorf("a/BUILD.bazel: got:\n%s\nwant:\n%s", got, wantA)
+	}
+	wantB := `fake_library(
+    name = "b",
+    size = 1,
+    testonly = False,
+    srcs = ["b.fake"],
+)
+`
+	if got := string(b.Format()); got != wantB {
+		t.Errorf("b/BUILD.bazel: got:\n%s\nwant:\n%s", got, wantB)
+	}
+
+	r := rule.NewRule("fake_library", "e")
+	r.SetAttr("embed", ":a")
+	if got, want := l.Embeds(r, label.New("", "x", "e")), []label.Label{label.New("", "x", "a")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Embeds: got %v; want %v", got, want)
+	}
+	r.SetAttr("embed", "@@bad label")
+	if got := l.Embeds(r, label.New("", "x", "e")); len(got) != 0 {
+		t.Errorf("Embeds: got %v; want none", got)
+	}
+	diags = c.Diagnostics.List()
+	if len(diags) != 2 || diags[1].Code != DiagPlugin || !strings.Contains(diags[1].Message, "embeds") {
+		t.Errorf("got diagnostics %v; want an error for the bad embed", diags)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPluginVersionMismatch(t *testing.T) {
+	os.Setenv(fakePluginVersionEnv, "2")
+	defer os.Unsetenv(fakePluginVersionEnv)
+	os.Setenv(fakePluginEnv, "1")
+	defer os.Unsetenv(fakePluginEnv)
+	_, err := Start(os.Args[0])
+	if err == nil || !strings.Contains(err.Error(), "uses protocol version 2") {
+		t.Errorf("got error %v; want protocol version mismatch", err)
+	}
+}
+
+func TestPluginCallbackEncodeError(t *testing.T) {
+	l := startFakePlugin(t)
+	defer l.Close()
+
+	// A callback result that can't be encoded is reported to the plugin,
+	// which fails the request. Later requests still work.
+	imports, _ := json.Marshal([]string{"example.com/x"})
+	callback := func(method string, params json.RawMessage) (interface{}, error) {
+		return math.Inf(1), nil
+	}
+	err := l.call(MethodResolve, ResolveParams{Imports: imports}, &ResolveResult{}, callback)
+	if err == nil || !strings.Contains(err.Error(), "bad callback response") {
+		t.Errorf("resolve: got error %v; want callback error reported by the plugin", err)
+	}
+	var kd KnownDirectivesResult
+	if err := l.call(MethodKnownDirectives, struct{}{}, &kd, nil); err != nil {
+		t.Fatalf("known_directives: %v", err)
+	}
+	if want := []string{"fake_prefix"}; !reflect.DeepEqual(kd.Directives, want) {
+		t.Errorf("known_directives: got %v; want %v", kd.Directives, want)
+	}
+}
@@ -0,0 +1,205 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import "encoding/json"
+
+// ProtocolVersion is the version of the plugin protocol implemented by this
+// package. Gazelle sends it in the "initialize" request, and plugins must
+// reply with the same version.
+const ProtocolVersion = 1
+
+// Method names used in requests sent by Gazelle.
+const (
+	MethodInitialize      = "initialize"
+	MethodKnownDirectives = "known_directives"
+	MethodConfigure       = "configure"
+	MethodGenerateRules   = "generate_rules"
+	MethodImports         = "imports"
+	MethodEmbeds          = "embeds"
+	MethodResolve         = "resolve"
+	MethodShutdown        = "shutdown"
+)
+
+// MethodFindRulesByImport is the name of the callback a plugin may send
+// while handling a "resolve" request to look up rules in Gazelle's index.
+const MethodFindRulesByImport = "find_rules_by_import"
+
+// Message is a single line of JSON exchanged between Gazelle and a plugin.
+//
+// A request has Method and Params set. A response has the ID of the request
+// it answers and either Result or Error set. Gazelle sends requests to the
+// plugin and waits for a response. While handling a "resolve" request, the
+// plugin may send its own requests (callbacks) to Gazelle before responding.
+type Message struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// InitializeParams are sent with the "initialize" request, which is always
+// the first request.
+type InitializeParams struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// InitializeResult describes the plugin's language.
+type InitializeResult struct {
+	ProtocolVersion int                 `json:"protocol_version"`
+	Name            string              `json:"name"`
+	Kinds           map[string]KindInfo `json:"kinds,omitempty"`
+	Loads           []LoadInfo          `json:"loads,omitempty"`
+}
+
+// KindInfo is the JSON form of rule.KindInfo. Attribute sets are given as
+// lists of attribute names.
+type KindInfo struct {
+	MatchAny        bool     `json:"match_any,omitempty"`
+	MatchAttrs      []string `json:"match_attrs,omitempty"`
+	NonEmptyAttrs   []string `json:"non_empty_attrs,omitempty"`
+	SubstituteAttrs []string `json:"substitute_attrs,omitempty"`
+	MergeableAttrs  []string `json:"mergeable_attrs,omitempty"`
+	ResolveAttrs    []string `json:"resolve_attrs,omitempty"`
+}
+
+// LoadInfo is the JSON form of rule.LoadInfo.
+type LoadInfo struct {
+	Name    string   `json:"name"`
+	Symbols []string `json:"symbols"`
+	After   []string `json:"after,omitempty"`
+}
+
+// KnownDirectivesResult lists the directives the plugin understands.
+type KnownDirectivesResult struct {
+	Directives []string `json:"directives"`
+}
+
+// ConfigureParams are sent for each directory Gazelle visits, parents before
+// children. Directives only includes directives listed in
+// KnownDirectivesResult. Plugins that keep per-directory configuration
+// should inherit it from the parent directory, path.Dir(Rel) ("" for
+// top-level directories).
+type ConfigureParams struct {
+	Rel        string      `json:"rel"`
+	Directives []Directive `json:"directives,omitempty"`
+}
+
+// Directive is a "# gazelle:key value" comment in a build file.
+type Directive struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Rule is the JSON form of a rule. Attrs maps attribute names to values,
+// which may be strings, numbers, booleans, lists, or objects with string
+// keys. Attributes of existing rules that can't be represented this way
+// (for example, select expressions) are omitted.
+type Rule struct {
+	Kind  string                 `json:"kind"`
+	Name  string                 `json:"name"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// File is the JSON form of an existing build file.
+type File struct {
+	Path  string `json:"path"`
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// GenerateRulesParams are sent with the "generate_rules" request.
+type GenerateRulesParams struct {
+	Rel          string   `json:"rel"`
+	Dir          string   `json:"dir"`
+	File         *File    `json:"file,omitempty"`
+	Subdirs      []string `json:"subdirs,omitempty"`
+	RegularFiles []string `json:"regular_files,omitempty"`
+	GenFiles     []string `json:"gen_files,omitempty"`
+	OtherGen     []Rule   `json:"other_gen,omitempty"`
+}
+
+// GenerateRulesResult is the response to "generate_rules". Imports is
+// parallel to Gen; each value is opaque to Gazelle and is sent back to the
+// plugin in the "resolve" request for the corresponding rule.
+type GenerateRulesResult struct {
+	Gen     []Rule            `json:"gen,omitempty"`
+	Empty   []Rule            `json:"empty,omitempty"`
+	Imports []json.RawMessage `json:"imports,omitempty"`
+}
+
+// ImportsParams are sent with the "imports" request.
+type ImportsParams struct {
+	Rel  string `json:"rel"`
+	Rule Rule   `json:"rule"`
+}
+
+// ImportsResult lists the imports a rule may be resolved by. If Imports is
+// null, the rule is not indexed.
+type ImportsResult struct {
+	Imports []ImportSpec `json:"imports"`
+}
+
+// ImportSpec is the JSON form of resolve.ImportSpec.
+type ImportSpec struct {
+	Lang string `json:"lang"`
+	Imp  string `json:"imp"`
+}
+
+// EmbedsParams are sent with the "embeds" request. From is the label of the
+// rule.
+type EmbedsParams struct {
+	From string `json:"from"`
+	Rule Rule   `json:"rule"`
+}
+
+// EmbedsResult lists labels of rules embedded by a rule.
+type EmbedsResult struct {
+	Embeds []string `json:"embeds,omitempty"`
+}
+
+// ResolveParams are sent with the "resolve" request. Imports is the value
+// the plugin returned for the rule from "generate_rules".
+type ResolveParams struct {
+	Rel     string          `json:"rel"`
+	From    string          `json:"from"`
+	Rule    Rule            `json:"rule"`
+	Imports json.RawMessage `json:"imports,omitempty"`
+}
+
+// ResolveResult lists attributes to set on the rule. A null value deletes
+// the attribute.
+type ResolveResult struct {
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// FindRulesByImportParams are sent by the plugin with the
+// "find_rules_by_import" callback.
+type FindRulesByImportParams struct {
+	Lang string `json:"lang"`
+	Imp  string `json:"imp"`
+}
+
+// FindRulesByImportResult lists indexed rules that provide an import.
+type FindRulesByImportResult struct {
+	Results []FindResult `json:"results"`
+}
+
+// FindResult is the JSON form of resolve.FindResult.
+type FindResult struct {
+	Label  string   `json:"label"`
+	Embeds []string `json:"embeds,omitempty"`
+}
@@ -0,0 +1,46 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proto
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
+
+var _ language.GenerateCacher = (*protoLang)(nil)
+
+func (*protoLang) EncodeImports(imports interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(imports.([]string)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*protoLang) DecodeImports(data []byte) (interface{}, error) {
+	var imports []string
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&imports); err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+// RestoreGenerateResult does nothing, since GenerateRules does not record
+// any state.
+func (*protoLang) RestoreGenerateResult(args language.GenerateArgs, res language.GenerateResult) {
+}
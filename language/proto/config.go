@@ -197,17 +197,68 @@ func (_ *protoLang) KnownDirectives() []string {
 	return []string{"proto", "proto_group", "proto_strip_import_prefix", "proto_import_prefix"}
 }
 
+func (_ *protoLang) DescribeDirectives() []config.DirectiveInfo {
+	return []config.DirectiveInfo{
+		{
+			Key: "proto",
+			Doc: "Tells Gazelle how to generate rules for .proto files.",
+			Args: []config.DirectiveArg{{
+				Name:    "mode",
+				Type:    config.ArgEnum,
+				Allowed: []string{"default", "package", "legacy", "disable", "disable_global"},
+			}},
+		},
+		{
+			Key:  "proto_group",
+			Doc:  "Sets an option used to group .proto files into rules in package mode. If empty, files are grouped by package.",
+			Args: []config.DirectiveArg{{Name: "option", Type: config.ArgString, Optional: true}},
+		},
+		{
+			Key:  "proto_strip_import_prefix",
+			Doc:  "Sets the strip_import_prefix attribute of generated proto_library rules. The prefix should start with a slash.",
+			Args: []config.DirectiveArg{{Name: "path", Type: config.ArgPath, Optional: true}},
+		},
+		{
+			Key:  "proto_import_prefix",
+			Doc:  "Sets the import_prefix attribute of generated proto_library rules.",
+			Args: []config.DirectiveArg{{Name: "path", Type: config.ArgPath, Optional: true}},
+		},
+	}
+}
+
+func (_ *protoLang) DirectiveValues(c *config.Config, key string) []string {
+	pc := GetProtoConfig(c)
+	if pc == nil {
+		return nil
+	}
+	var value string
+	switch key {
+	case "proto":
+		value = pc.Mode.String()
+	case "proto_group":
+		value = pc.groupOption
+	case "proto_strip_import_prefix":
+		value = pc.StripImportPrefix
+	case "proto_import_prefix":
+		value = pc.ImportPrefix
+	}
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
 func (_ *protoLang) Configure(c *config.Config, rel string, f *rule.File) {
 	pc := &ProtoConfig{}
 	*pc = *GetProtoConfig(c)
 	c.Exts[protoName] = pc
 	if f != nil {
-		for _, d := range f.Directives {
+		for i, d := range f.Directives {
 			switch d.Key {
 			case "proto":
+				// Invalid values are reported by Walk before Configure is called.
 				mode, err := ModeFromString(d.Value)
 				if err != nil {
-					log.Print(err)
 					continue
 				}
 				pc.Mode = mode
@@ -217,7 +268,7 @@ func (_ *protoLang) Configure(c *config.Config, rel string, f *rule.File) {
 			case "proto_strip_import_prefix":
 				pc.StripImportPrefix = d.Value
 				if err := checkStripImportPrefix(pc.StripImportPrefix, rel); err != nil {
-					log.Print(err)
+					c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: f.DirectiveLine(i)}, config.DiagInvalidDirective, "%v", err)
 				}
 			case "proto_import_prefix":
 				pc.ImportPrefix = d.Value
@@ -17,13 +17,14 @@ package proto
 
 import (
 	"bytes"
-	"io/ioutil"
 	"log"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 // FileInfo contains metadata extracted from a .proto file.
@@ -46,12 +47,12 @@ type Option struct {
 
 var protoRe = buildProtoRegexp()
 
-func protoFileInfo(dir, name string) FileInfo {
+func protoFileInfo(fsys vfs.FS, dir, name string) FileInfo {
 	info := FileInfo{
 		Path: filepath.Join(dir, name),
 		Name: name,
 	}
-	content, err := ioutil.ReadFile(info.Path)
+	content, err := fsys.ReadFile(info.Path)
 	if err != nil {
 		log.Printf("%s: error reading proto file: %v", info.Path, err)
 		return info
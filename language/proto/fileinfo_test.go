@@ -21,6 +21,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 func TestProtoRegexpGroupNames(t *testing.T) {
@@ -130,7 +132,7 @@ import "second.proto";`,
 				t.Fatal(err)
 			}
 
-			got := protoFileInfo(dir, tc.name)
+			got := protoFileInfo(vfs.OS, dir, tc.name)
 
 			// Clear fields we don't care about for testing.
 			got = FileInfo{
@@ -24,6 +24,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 func (_ *protoLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
@@ -66,7 +67,7 @@ func (_ *protoLang) GenerateRules(args language.GenerateArgs) language.GenerateR
 			}
 		}
 	}
-	pkgs := buildPackages(pc, args.Dir, args.Rel, regularProtoFiles, genProtoFilesNotConsumed)
+	pkgs := buildPackages(c.FileSystem(), pc, args.Dir, args.Rel, regularProtoFiles, genProtoFilesNotConsumed)
 	shouldSetVisibility := args.File == nil || !args.File.HasDefaultVisibility()
 	var res language.GenerateResult
 	for _, pkg := range pkgs {
@@ -114,10 +115,10 @@ func RuleName(names ...string) string {
 // buildPackage extracts metadata from the .proto files in a directory and
 // constructs possibly several packages, then selects a package to generate
 // a proto_library rule for.
-func buildPackages(pc *ProtoConfig, dir, rel string, protoFiles, genFiles []string) []*Package {
+func buildPackages(fsys vfs.FS, pc *ProtoConfig, dir, rel string, protoFiles, genFiles []string) []*Package {
 	packageMap := make(map[string]*Package)
 	for _, name := range protoFiles {
-		info := protoFileInfo(dir, name)
+		info := protoFileInfo(fsys, dir, name)
 		key := info.PackageName
 		if pc.groupOption != "" {
 			for _, opt := range info.Options {
@@ -27,6 +27,7 @@ var protoKinds = map[string]rule.KindInfo{
 			"strip_import_prefix": true,
 		},
 		ResolveAttrs: map[string]bool{"deps": true},
+		LabelAttrs:   map[string]bool{"srcs": true},
 	},
 }
 
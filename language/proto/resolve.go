@@ -83,7 +83,8 @@ func (_ *protoLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Re
 	r.DelAttr("deps")
 	depSet := make(map[string]bool)
 	for _, imp := range imports {
-		l, err := resolveProto(c, ix, r, imp, from)
+		x := resolve.StartExplanation(c, r, from, resolve.ImportSpec{Lang: "proto", Imp: imp})
+		l, err := resolveProto(c, ix, r, imp, from, x)
 		if err == skipImportError {
 			continue
 		} else if err != nil {
@@ -108,25 +109,35 @@ var (
 	notFoundError   = errors.New("not found")
 )
 
-func resolveProto(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imp string, from label.Label) (label.Label, error) {
+func resolveProto(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imp string, from label.Label, x *resolve.Explanation) (label.Label, error) {
 	pc := GetProtoConfig(c)
 	if !strings.HasSuffix(imp, ".proto") {
-		return label.NoLabel, fmt.Errorf("can't import non-proto: %q", imp)
+		err := fmt.Errorf("can't import non-proto: %q", imp)
+		x.Fail("proto", nil, err)
+		return label.NoLabel, err
 	}
 
 	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Imp: imp, Lang: "proto"}, "proto"); ok {
+		x.Chose("override", nil, l, "matched a # gazelle:resolve directive")
 		return l, nil
 	}
+	x.Tried("override", nil, "no # gazelle:resolve directive matched")
 
 	if l, ok := knownImports[imp]; ok && pc.Mode.ShouldUseKnownImports() {
 		if l.Equal(from) {
+			x.Skip("known_imports", fmt.Sprintf("%s provides %s itself", from, imp))
 			return label.NoLabel, skipImportError
 		} else {
+			x.Chose("known_imports", nil, l, "listed in Gazelle's table of well known proto imports")
 			return l, nil
 		}
+	} else if ok {
+		x.Tried("known_imports", []label.Label{l}, fmt.Sprintf("known imports are not used in %s mode", pc.Mode))
+	} else {
+		x.Tried("known_imports", nil, "not a known import")
 	}
 
-	if l, err := resolveWithIndex(c, ix, imp, from); err == nil || err == skipImportError {
+	if l, err := resolveWithIndex(c, ix, imp, from, x); err == nil || err == skipImportError {
 		return l, err
 	} else if err != notFoundError {
 		return label.NoLabel, err
@@ -137,20 +148,28 @@ func resolveProto(c *config.Config, ix *resolve.RuleIndex, r *rule.Rule, imp str
 		rel = ""
 	}
 	name := RuleName(rel)
-	return label.New("", rel, name), nil
+	l := label.New("", rel, name)
+	x.Chose("convention", nil, l, "guessed from the directory of the imported file")
+	return l, nil
 }
 
-func resolveWithIndex(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+func resolveWithIndex(c *config.Config, ix *resolve.RuleIndex, imp string, from label.Label, x *resolve.Explanation) (label.Label, error) {
 	matches := ix.FindRulesByImportWithConfig(c, resolve.ImportSpec{Lang: "proto", Imp: imp}, "proto")
+	candidates := resolve.FindResultLabels(matches)
 	if len(matches) == 0 {
+		x.Tried("index", nil, "no indexed rule provides this import")
 		return label.NoLabel, notFoundError
 	}
 	if len(matches) > 1 {
-		return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
+		err := fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
+		x.Fail("index", candidates, err)
+		return label.NoLabel, err
 	}
 	if matches[0].IsSelfImport(from) {
+		x.Skip("index", fmt.Sprintf("%s is provided by %s itself", imp, from))
 		return label.NoLabel, skipImportError
 	}
+	x.Chose("index", candidates, matches[0].Label, "only rule in the index that provides this import")
 	return matches[0].Label, nil
 }
 
@@ -100,6 +100,10 @@ type ImportReposArgs struct {
 	// Cache stores information fetched from the network and ensures that
 	// the same request isn't made multiple times.
 	Cache *repo.RemoteCache
+
+	// ReadOnly indicates that the importer must not modify any files in the
+	// workspace, like go.sum. It's set by update-repos -check.
+	ReadOnly bool
 }
 
 // ImportReposResult contains return values for RepoImporter.ImportRepos.
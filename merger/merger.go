@@ -41,6 +41,8 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
@@ -106,7 +108,28 @@ const UnstableInsertIndexKey = "_gazelle_insert_index"
 // If an attribute is marked with a "# keep" comment, it will not be merged.
 // If a rule is marked with a "# keep" comment, the whole rule will not
 // be modified.
+//
+// Generated rules that match more than one existing rule are not merged.
+// MergeFile ignores these errors; use MergeFileWithDiagnostics to report them.
 func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo) {
+	mergeFile(oldFile, emptyRules, genRules, phase, kinds, nil)
+}
+
+// MergeFileWithDiagnostics is like MergeFile, but in the PreResolve phase,
+// it reports generated rules that could not be merged to diags with the code
+// config.DiagAmbiguousMatch. These are reported at config.SeverityInfo, since
+// they're too chatty to print by default.
+func MergeFileWithDiagnostics(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo, diags *config.Diagnostics) {
+	var report func(error)
+	if phase == PreResolve {
+		report = func(err error) {
+			diags.Infof(config.Position{Path: oldFile.Path}, config.DiagAmbiguousMatch, "%v", err)
+		}
+	}
+	mergeFile(oldFile, emptyRules, genRules, phase, kinds, report)
+}
+
+func mergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phase, kinds map[string]rule.KindInfo, report func(error)) {
 	getMergeAttrs := func(r *rule.Rule) map[string]bool {
 		if phase == PreResolve {
 			return kinds[r.Kind()].MergeableAttrs
@@ -121,7 +144,7 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phas
 			if oldRule.ShouldKeep() {
 				continue
 			}
-			rule.MergeRules(emptyRule, oldRule, getMergeAttrs(emptyRule), oldFile.Path)
+			rule.MergeRulesWithLabelAttrs(emptyRule, oldRule, getMergeAttrs(emptyRule), labelAttrs(kinds[emptyRule.Kind()]), oldFile.Path)
 			if oldRule.IsEmpty(kinds[oldRule.Kind()]) {
 				oldRule.Delete()
 			}
@@ -137,8 +160,9 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phas
 	for i, genRule := range genRules {
 		oldRule, err := Match(oldFile.Rules, genRule, kinds[genRule.Kind()])
 		if err != nil {
-			// TODO(jayconrod): add a verbose mode and log errors. They are too chatty
-			// to print by default.
+			if report != nil {
+				report(err)
+			}
 			matchErrors[i] = err
 			continue
 		}
@@ -169,7 +193,7 @@ func MergeFile(oldFile *rule.File, emptyRules, genRules []*rule.Rule, phase Phas
 				genRule.Insert(oldFile)
 			}
 		} else {
-			rule.MergeRules(genRule, matchRules[i], getMergeAttrs(genRule), oldFile.Path)
+			rule.MergeRulesWithLabelAttrs(genRule, matchRules[i], getMergeAttrs(genRule), labelAttrs(kinds[genRule.Kind()]), oldFile.Path)
 		}
 	}
 }
@@ -238,7 +262,7 @@ func Match(rules []*rule.Rule, x *rule.Rule, info rule.KindInfo) (*rule.Rule, er
 	for _, key := range info.MatchAttrs {
 		var attrMatches []*rule.Rule
 		for _, y := range kindMatches {
-			if attrMatch(x, y, key) {
+			if attrMatch(x, y, key, labelAttrs(info)[key]) {
 				attrMatches = append(attrMatches, y)
 			}
 		}
@@ -260,9 +284,15 @@ func Match(rules []*rule.Rule, x *rule.Rule, info rule.KindInfo) (*rule.Rule, er
 	return nil, nil
 }
 
-func attrMatch(x, y *rule.Rule, key string) bool {
+func attrMatch(x, y *rule.Rule, key string, isLabel bool) bool {
+	normalize := func(s string) string {
+		if isLabel {
+			return label.Normalize(s)
+		}
+		return s
+	}
 	xValue := x.AttrString(key)
-	if xValue != "" && xValue == y.AttrString(key) {
+	if xValue != "" && normalize(xValue) == normalize(y.AttrString(key)) {
 		return true
 	}
 	xValues := x.AttrStrings(key)
@@ -270,12 +300,34 @@ func attrMatch(x, y *rule.Rule, key string) bool {
 	if xValues == nil || yValues == nil || len(xValues) != len(yValues) {
 		return false
 	}
-	sort.Strings(xValues)
-	sort.Strings(yValues)
-	for i, v := range xValues {
-		if v != yValues[i] {
+	xNorm := make([]string, len(xValues))
+	yNorm := make([]string, len(yValues))
+	for i := range xValues {
+		xNorm[i] = normalize(xValues[i])
+		yNorm[i] = normalize(yValues[i])
+	}
+	sort.Strings(xNorm)
+	sort.Strings(yNorm)
+	for i, v := range xNorm {
+		if v != yNorm[i] {
 			return false
 		}
 	}
 	return true
 }
+
+// labelAttrs returns the set of attributes of rules described by info whose
+// values are labels.
+func labelAttrs(info rule.KindInfo) map[string]bool {
+	attrs := make(map[string]bool, len(info.LabelAttrs)+len(info.ResolveAttrs)+len(info.SubstituteAttrs))
+	for key := range info.LabelAttrs {
+		attrs[key] = true
+	}
+	for key := range info.ResolveAttrs {
+		attrs[key] = true
+	}
+	for key := range info.SubstituteAttrs {
+		attrs[key] = true
+	}
+	return attrs
+}
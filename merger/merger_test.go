@@ -799,6 +799,69 @@ go_library(
         "lib.go",  # keep
     ],
 )
+`,
+	}, {
+		desc: "label forms dedupe",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    embed = [
+        "@@//foo:foo",  # comment
+        "//bar:bar",
+    ],
+)
+`,
+		current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    embed = [
+        "//bar",
+        "//foo",
+    ],
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    embed = [
+        "@@//foo:foo",  # comment
+        "//bar:bar",
+    ],
+)
+`,
+	}, {
+		desc: "non-label forms differ",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    copts = [":x"],
+)
+`,
+		current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    copts = ["x"],
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    copts = ["x"],
+)
 `,
 	}, {
 		desc: "match and rename",
@@ -966,6 +1029,16 @@ go_binary(name = "z")
 			gen:       `proto_library(name = "proto1", srcs = ["foo.proto", "bar.proto"])`,
 			old:       `proto_library(name = "proto2", srcs = ["bar.proto", "foo.proto"])`,
 			wantIndex: 0,
+		}, {
+			desc:      "srcs label forms match",
+			gen:       `proto_library(name = "proto1", srcs = ["//p:foo.proto", "//q", "bar.proto"])`,
+			old:       `proto_library(name = "proto2", srcs = [":bar.proto", "@@//p:foo.proto", "//q:q"])`,
+			wantIndex: 0,
+		}, {
+			desc:      "canonical repo mismatch",
+			gen:       `proto_library(name = "proto1", srcs = ["@r//:foo.proto"])`,
+			old:       `proto_library(name = "proto2", srcs = ["@@r~1.0//:foo.proto"])`,
+			wantIndex: -1,
 		}, {
 			desc: "importpath match",
 			gen:  `go_proto_library(name = "go_proto1", importpath="example.com/foo")`,
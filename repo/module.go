@@ -0,0 +1,573 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/internal/modfile"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// GoDepsExtensionName is the name of the module extension that declares
+// go_repository rules for Go modules when Bzlmod is used. Extensions with
+// this name are recognized regardless of the .bzl file they're defined in.
+const GoDepsExtensionName = "go_deps"
+
+// ModuleFile is a parsed MODULE.bazel file, which declares the dependencies
+// of the main module when Bzlmod is enabled.
+type ModuleFile struct {
+	// Path is the absolute path to the file.
+	Path string
+
+	// File is the file's syntax tree.
+	File *bzl.File
+
+	// Name is the name of the module, set with module(name = ...).
+	Name string
+
+	// RepoName is the apparent name the main module uses for its own
+	// repository, set with module(repo_name = ...). It defaults to Name.
+	RepoName string
+
+	// Deps lists modules declared with bazel_dep.
+	Deps []BazelDep
+
+	// Extensions lists module extensions used with use_extension, in the
+	// order they're declared.
+	Extensions []*ExtensionUsage
+}
+
+// BazelDep is a module the main module depends on, declared with bazel_dep.
+type BazelDep struct {
+	Name, Version string
+
+	// RepoName is the apparent name of the module's repository as seen by the
+	// main module. It defaults to Name.
+	RepoName string
+}
+
+// ExtensionUsage is a module extension used by the main module, for example:
+//
+//	go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+//	go_deps.module(path = "example.com/m", version = "v1.0.0")
+//	use_repo(go_deps, "com_example_m")
+type ExtensionUsage struct {
+	// Bzl is the label of the .bzl file that defines the extension. Name is
+	// the extension's name in that file.
+	Bzl, Name string
+
+	// Var is the name of the variable the extension proxy is assigned to.
+	Var string
+
+	// Tags lists calls to tag classes of the extension, like
+	// go_deps.module(...), in order.
+	Tags []ExtensionTag
+
+	// Repos maps apparent names of repositories imported with use_repo to
+	// the names the extension gives them. Those are the same unless
+	// use_repo was called with a keyword argument.
+	Repos map[string]string
+
+	// stmt is the use_extension assignment. tagCalls holds the calls for
+	// Tags, in the same order. useRepoCalls holds calls to use_repo.
+	stmt         bzl.Expr
+	tagCalls     []*bzl.CallExpr
+	useRepoCalls []*bzl.CallExpr
+}
+
+// ExtensionTag is a call to a tag class of a module extension.
+type ExtensionTag struct {
+	// Name is the name of the tag class, for example, "module".
+	Name string
+
+	// Attrs holds string-valued keyword arguments. Other arguments are
+	// ignored.
+	Attrs map[string]string
+}
+
+// LoadModuleData parses the contents of a MODULE.bazel file. path is used
+// for error messages and to locate files the module file refers to.
+func LoadModuleData(path string, data []byte) (*ModuleFile, error) {
+	f, err := bzl.ParseDefault(path, data)
+	if err != nil {
+		return nil, err
+	}
+	m := &ModuleFile{Path: path, File: f}
+	extByVar := make(map[string]*ExtensionUsage)
+	for _, stmt := range f.Stmt {
+		if assign, ok := stmt.(*bzl.AssignExpr); ok {
+			lhs, ok := assign.LHS.(*bzl.Ident)
+			call, isCall := assign.RHS.(*bzl.CallExpr)
+			if !ok || !isCall || callName(call) != "use_extension" {
+				continue
+			}
+			args, _ := callArgs(call)
+			if len(args) < 2 {
+				return nil, fmt.Errorf("%s:%d: use_extension: expected .bzl label and extension name", path, call.ListStart.Line)
+			}
+			ext := &ExtensionUsage{Bzl: args[0], Name: args[1], Var: lhs.Name, Repos: make(map[string]string), stmt: assign}
+			m.Extensions = append(m.Extensions, ext)
+			extByVar[ext.Var] = ext
+			continue
+		}
+
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		if dot, ok := call.X.(*bzl.DotExpr); ok {
+			// A tag of an extension, like go_deps.module(...).
+			if x, ok := dot.X.(*bzl.Ident); ok && extByVar[x.Name] != nil {
+				_, kwargs := callArgs(call)
+				ext := extByVar[x.Name]
+				ext.Tags = append(ext.Tags, ExtensionTag{Name: dot.Name, Attrs: kwargs})
+				ext.tagCalls = append(ext.tagCalls, call)
+			}
+			continue
+		}
+
+		args, kwargs := callArgs(call)
+		switch callName(call) {
+		case "module":
+			m.Name = kwargs["name"]
+			m.RepoName = kwargs["repo_name"]
+			if m.RepoName == "" {
+				m.RepoName = m.Name
+			}
+
+		case "bazel_dep":
+			dep := BazelDep{Name: kwargs["name"], Version: kwargs["version"], RepoName: kwargs["repo_name"]}
+			if dep.RepoName == "" {
+				dep.RepoName = dep.Name
+			}
+			m.Deps = append(m.Deps, dep)
+
+		case "use_repo":
+			if len(call.List) == 0 {
+				continue
+			}
+			x, ok := call.List[0].(*bzl.Ident)
+			if !ok || extByVar[x.Name] == nil {
+				continue
+			}
+			ext := extByVar[x.Name]
+			ext.useRepoCalls = append(ext.useRepoCalls, call)
+			// The first positional argument is the extension proxy itself.
+			for _, name := range args {
+				ext.Repos[name] = name
+			}
+			for apparent, name := range kwargs {
+				ext.Repos[apparent] = name
+			}
+		}
+	}
+	return m, nil
+}
+
+// ListModuleRepositories returns rules describing repositories visible to the
+// main module declared in m. Files m refers to, like go.mod files used by
+// go_deps.from_file, are read from fsys.
+//
+// Each bazel_dep is returned as a "bazel_dep" rule named after the apparent
+// repository name, with "module_name" and "version" attributes. Each Go
+// module declared with a go_deps extension is returned as a go_repository
+// rule with "importpath" and, if known, "version" and "sum" attributes. Go
+// module repositories are named with the apparent names given in use_repo.
+// Modules not imported with use_repo are named the way the extension names
+// them.
+func ListModuleRepositories(fsys vfs.FS, m *ModuleFile) ([]*rule.Rule, error) {
+	var repos []*rule.Rule
+	for _, dep := range m.Deps {
+		r := rule.NewRule("bazel_dep", dep.RepoName)
+		r.SetAttr("module_name", dep.Name)
+		if dep.Version != "" {
+			r.SetAttr("version", dep.Version)
+		}
+		repos = append(repos, r)
+	}
+
+	for _, ext := range m.Extensions {
+		if ext.Name != GoDepsExtensionName {
+			continue
+		}
+		apparentNames := make(map[string]string)
+		for apparent, name := range ext.Repos {
+			apparentNames[name] = apparent
+		}
+		modules := make(map[string]map[string]string)
+		var paths []string
+		addModule := func(attrs map[string]string) {
+			path := attrs["path"]
+			if _, ok := modules[path]; !ok {
+				paths = append(paths, path)
+			}
+			modules[path] = attrs
+		}
+		for _, tag := range ext.Tags {
+			switch tag.Name {
+			case "from_file":
+				goMod := tag.Attrs["go_mod"]
+				if goMod == "" {
+					continue
+				}
+				requires, err := readGoModRequires(fsys, m, goMod)
+				if err != nil {
+					return nil, err
+				}
+				for _, req := range requires {
+					addModule(req)
+				}
+			case "module":
+				if tag.Attrs["path"] != "" {
+					addModule(tag.Attrs)
+				}
+			}
+		}
+
+		for _, path := range paths {
+			attrs := modules[path]
+			name := label.ImportPathToBazelRepoName(path)
+			if apparent, ok := apparentNames[name]; ok {
+				name = apparent
+			}
+			r := rule.NewRule("go_repository", name)
+			r.SetAttr("importpath", path)
+			for _, key := range []string{"version", "sum"} {
+				if attrs[key] != "" {
+					r.SetAttr(key, attrs[key])
+				}
+			}
+			repos = append(repos, r)
+		}
+	}
+	return repos, nil
+}
+
+// MergeModuleRepositories returns workspaceRepos, listed in WORKSPACE, and
+// moduleRepos, returned by ListModuleRepositories, as one list. Rules in
+// moduleRepos take precedence: workspace rules with the same name, and
+// go_repository rules with the same importpath, are dropped.
+func MergeModuleRepositories(workspaceRepos, moduleRepos []*rule.Rule) []*rule.Rule {
+	names := make(map[string]bool)
+	importPaths := make(map[string]bool)
+	for _, r := range moduleRepos {
+		names[r.Name()] = true
+		if r.Kind() == "go_repository" {
+			importPaths[r.AttrString("importpath")] = true
+		}
+	}
+	var repos []*rule.Rule
+	for _, r := range workspaceRepos {
+		if names[r.Name()] || (r.Kind() == "go_repository" && importPaths[r.AttrString("importpath")]) {
+			continue
+		}
+		repos = append(repos, r)
+	}
+	return append(repos, moduleRepos...)
+}
+
+// readGoModRequires reads the modules required by the go.mod file with the
+// label goMod in the main module. Each module is returned as a map with
+// "path" and "version" keys. Versions are also read from a go.sum file next
+// to go.mod, if there is one.
+func readGoModRequires(fsys vfs.FS, m *ModuleFile, goMod string) ([]map[string]string, error) {
+	l, err := label.Parse(goMod)
+	if err != nil || l.Repo != "" {
+		return nil, fmt.Errorf("%s: go_mod must be a label in the main module, got %q", m.Path, goMod)
+	}
+	path := filepath.Join(filepath.Dir(m.Path), filepath.FromSlash(l.Pkg), l.Name)
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	requires := parseGoModRequires(data)
+
+	sumData, err := fsys.ReadFile(filepath.Join(filepath.Dir(path), "go.sum"))
+	if err == nil {
+		sums := parseGoSum(sumData)
+		for _, req := range requires {
+			if sum, ok := sums[req["path"]+"@"+req["version"]]; ok {
+				req["sum"] = sum
+			}
+		}
+	}
+	return requires, nil
+}
+
+// parseGoModRequires returns modules listed in require directives in the
+// contents of a go.mod file.
+func parseGoModRequires(data []byte) []map[string]string {
+	var requires []map[string]string
+	for _, args := range modfile.Directives(data, "require") {
+		if len(args) < 2 {
+			continue
+		}
+		requires = append(requires, map[string]string{"path": args[0], "version": args[1]})
+	}
+	return requires
+}
+
+// parseGoSum returns a map from "path@version" to module sums in the
+// contents of a go.sum file.
+func parseGoSum(data []byte) map[string]string {
+	sums := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		sums[fields[0]+"@"+fields[1]] = fields[2]
+	}
+	return sums
+}
+
+// Extension returns the first usage of the module extension with the given
+// name, or nil if the extension isn't used.
+func (m *ModuleFile) Extension(name string) *ExtensionUsage {
+	for _, ext := range m.Extensions {
+		if ext.Name == name {
+			return ext
+		}
+	}
+	return nil
+}
+
+// AddExtension adds a use_extension call at the end of the file, assigning
+// the extension proxy to a variable named varName.
+func (m *ModuleFile) AddExtension(bzlLabel, name, varName string) *ExtensionUsage {
+	// Separate the new statement from the ones before it with a blank line.
+	// New statements after this have no positions, so they're printed
+	// without blank lines.
+	var pos bzl.Position
+	if n := len(m.File.Stmt); n > 0 {
+		_, end := m.File.Stmt[n-1].Span()
+		pos.Line = end.Line + 2
+	}
+	assign := &bzl.AssignExpr{
+		LHS: &bzl.Ident{Name: varName, NamePos: pos},
+		Op:  "=",
+		RHS: &bzl.CallExpr{
+			X:    &bzl.Ident{Name: "use_extension"},
+			List: []bzl.Expr{&bzl.StringExpr{Value: bzlLabel}, &bzl.StringExpr{Value: name}},
+		},
+	}
+	m.File.Stmt = append(m.File.Stmt, assign)
+	ext := &ExtensionUsage{Bzl: bzlLabel, Name: name, Var: varName, Repos: make(map[string]string), stmt: assign}
+	m.Extensions = append(m.Extensions, ext)
+	return ext
+}
+
+// SetTag updates the first tag of ext named name whose attribute key has the
+// same value as in attrs. If there is no such tag, a new tag is added after
+// the last tag of the extension. Attributes in attrs with empty values are
+// removed from the tag; other attributes of an existing tag are preserved.
+func (m *ModuleFile) SetTag(ext *ExtensionUsage, name, key string, attrs map[string]string) {
+	for i, tag := range ext.Tags {
+		if tag.Name != name || tag.Attrs[key] != attrs[key] {
+			continue
+		}
+		call := ext.tagCalls[i]
+		keys := make([]string, 0, len(attrs))
+		for k := range attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := attrs[k]
+			setCallKwarg(call, k, v)
+			if v == "" {
+				delete(tag.Attrs, k)
+			} else {
+				tag.Attrs[k] = v
+			}
+		}
+		return
+	}
+
+	// Add a new tag. The key attribute comes first; others are sorted.
+	keys := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		if k != key && v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	keys = append([]string{key}, keys...)
+	call := &bzl.CallExpr{
+		X:              &bzl.DotExpr{X: &bzl.Ident{Name: ext.Var}, Name: name},
+		ForceMultiLine: true,
+	}
+	tagAttrs := make(map[string]string)
+	for _, k := range keys {
+		setCallKwarg(call, k, attrs[k])
+		tagAttrs[k] = attrs[k]
+	}
+	var after bzl.Expr = ext.stmt
+	if n := len(ext.tagCalls); n > 0 {
+		after = ext.tagCalls[n-1]
+	}
+	m.insertAfter(after, call)
+	ext.Tags = append(ext.Tags, ExtensionTag{Name: name, Attrs: tagAttrs})
+	ext.tagCalls = append(ext.tagCalls, call)
+}
+
+// DeleteTag deletes tags of ext named name whose attribute key has the
+// given value.
+func (m *ModuleFile) DeleteTag(ext *ExtensionUsage, name, key, value string) {
+	var tags []ExtensionTag
+	var calls []*bzl.CallExpr
+	for i, tag := range ext.Tags {
+		if tag.Name == name && tag.Attrs[key] == value {
+			m.delete(ext.tagCalls[i])
+			continue
+		}
+		tags = append(tags, tag)
+		calls = append(calls, ext.tagCalls[i])
+	}
+	ext.Tags, ext.tagCalls = tags, calls
+}
+
+// SetUseRepo replaces the use_repo calls for ext with a single call that
+// imports repos, a map from apparent names to the names the extension gives
+// them. If repos is empty, use_repo calls are removed. The new call is placed
+// after the last tag of the extension.
+func (m *ModuleFile) SetUseRepo(ext *ExtensionUsage, repos map[string]string) {
+	for _, call := range ext.useRepoCalls {
+		m.delete(call)
+	}
+	ext.useRepoCalls = nil
+	ext.Repos = make(map[string]string)
+	if len(repos) == 0 {
+		return
+	}
+
+	var names, aliases []string
+	for apparent, name := range repos {
+		if apparent == name {
+			names = append(names, apparent)
+		} else {
+			aliases = append(aliases, apparent)
+		}
+		ext.Repos[apparent] = name
+	}
+	sort.Strings(names)
+	sort.Strings(aliases)
+	call := &bzl.CallExpr{
+		X:              &bzl.Ident{Name: "use_repo"},
+		List:           []bzl.Expr{&bzl.Ident{Name: ext.Var}},
+		ForceMultiLine: len(repos) > 1,
+	}
+	for _, name := range names {
+		call.List = append(call.List, &bzl.StringExpr{Value: name})
+	}
+	for _, apparent := range aliases {
+		setCallKwarg(call, apparent, repos[apparent])
+	}
+	var after bzl.Expr = ext.stmt
+	if n := len(ext.tagCalls); n > 0 {
+		after = ext.tagCalls[n-1]
+	}
+	m.insertAfter(after, call)
+	ext.useRepoCalls = []*bzl.CallExpr{call}
+}
+
+// Format returns the contents of the file, formatted.
+func (m *ModuleFile) Format() []byte {
+	return bzl.Format(m.File)
+}
+
+func (m *ModuleFile) insertAfter(after, stmt bzl.Expr) {
+	i := len(m.File.Stmt)
+	for j, s := range m.File.Stmt {
+		if s == after {
+			i = j + 1
+			break
+		}
+	}
+	m.File.Stmt = append(m.File.Stmt, nil)
+	copy(m.File.Stmt[i+1:], m.File.Stmt[i:])
+	m.File.Stmt[i] = stmt
+}
+
+func (m *ModuleFile) delete(stmt bzl.Expr) {
+	for i, s := range m.File.Stmt {
+		if s == stmt {
+			m.File.Stmt = append(m.File.Stmt[:i], m.File.Stmt[i+1:]...)
+			return
+		}
+	}
+}
+
+// setCallKwarg sets a string-valued keyword argument of call. If value is
+// empty, the argument is removed.
+func setCallKwarg(call *bzl.CallExpr, key, value string) {
+	for i, arg := range call.List {
+		assign, ok := arg.(*bzl.AssignExpr)
+		if !ok {
+			continue
+		}
+		if lhs, ok := assign.LHS.(*bzl.Ident); !ok || lhs.Name != key {
+			continue
+		}
+		if value == "" {
+			call.List = append(call.List[:i], call.List[i+1:]...)
+		} else {
+			assign.RHS = &bzl.StringExpr{Value: value}
+		}
+		return
+	}
+	if value != "" {
+		call.List = append(call.List, &bzl.AssignExpr{
+			LHS: &bzl.Ident{Name: key},
+			Op:  "=",
+			RHS: &bzl.StringExpr{Value: value},
+		})
+	}
+}
+
+func callName(call *bzl.CallExpr) string {
+	if x, ok := call.X.(*bzl.Ident); ok {
+		return x.Name
+	}
+	return ""
+}
+
+// callArgs returns the string-valued positional and keyword arguments of a
+// call. Arguments with other values are skipped.
+func callArgs(call *bzl.CallExpr) (args []string, kwargs map[string]string) {
+	kwargs = make(map[string]string)
+	for _, arg := range call.List {
+		if assign, ok := arg.(*bzl.AssignExpr); ok {
+			key, ok := assign.LHS.(*bzl.Ident)
+			value, isStr := assign.RHS.(*bzl.StringExpr)
+			if ok && isStr {
+				kwargs[key.Name] = value.Value
+			}
+			continue
+		}
+		if s, ok := arg.(*bzl.StringExpr); ok {
+			args = append(args, s.Value)
+		}
+	}
+	return args, kwargs
+}
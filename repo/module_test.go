@@ -0,0 +1,236 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/repo"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
+)
+
+func TestListModuleRepositories(t *testing.T) {
+	for _, tc := range []struct {
+		desc, module, goMod, goSum, want string
+		repoName                         string
+	}{
+		{
+			desc:   "empty",
+			module: `module(name = "m")`,
+			want:   "",
+		}, {
+			desc: "bazel_dep",
+			module: `
+module(
+    name = "m",
+    repo_name = "com_example_m",
+)
+
+bazel_dep(name = "rules_go", version = "0.39.1", repo_name = "io_bazel_rules_go")
+bazel_dep(name = "gazelle", version = "0.30.0")
+`,
+			repoName: "com_example_m",
+			want: `bazel_dep io_bazel_rules_go rules_go 0.39.1
+bazel_dep gazelle gazelle 0.30.0`,
+		}, {
+			desc: "go_deps_module",
+			module: `
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+go_deps.module(
+    path = "example.com/a",
+    version = "v1.0.0",
+    sum = "h1:a",
+)
+go_deps.module(
+    path = "example.com/b",
+    version = "v2.0.0",
+)
+use_repo(
+    go_deps,
+    "com_example_a",
+    b = "com_example_b",
+)
+`,
+			want: `go_repository com_example_a example.com/a v1.0.0 h1:a
+go_repository b example.com/b v2.0.0`,
+		}, {
+			desc: "go_deps_from_file",
+			module: `
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+go_deps.from_file(go_mod = "//:go.mod")
+go_deps.module(
+    path = "example.com/c",
+    version = "v1.1.0",
+)
+use_repo(go_deps, "com_example_a", mod_b = "com_example_b")
+
+other = use_extension("//:other.bzl", "other")
+other.module(path = "example.com/ignored")
+use_repo(other, "ignored")
+`,
+			goMod: `module example.com/m
+
+go 1.16
+
+require example.com/a v1.0.0 // indirect
+
+require (
+	example.com/b v1.2.0
+	"example.com/c" v1.0.0
+)
+
+replace (
+	example.com/d => ../d
+)
+`,
+			goSum: `example.com/a v1.0.0 h1:a
+example.com/a v1.0.0/go.mod h1:amod
+example.com/b v1.1.0 h1:old
+example.com/b v1.2.0 h1:b
+`,
+			want: `go_repository com_example_a example.com/a v1.0.0 h1:a
+go_repository mod_b example.com/b v1.2.0 h1:b
+go_repository com_example_c example.com/c v1.1.0`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := filepath.Abs("/repo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fsys := vfs.NewMapFS(dir)
+			if tc.goMod != "" {
+				if err := fsys.WriteFile(filepath.Join(dir, "go.mod"), []byte(tc.goMod), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.goSum != "" {
+				if err := fsys.WriteFile(filepath.Join(dir, "go.sum"), []byte(tc.goSum), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m, err := repo.LoadModuleData(filepath.Join(dir, "MODULE.bazel"), []byte(tc.module))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.RepoName != tc.repoName && tc.repoName != "" {
+				t.Errorf("RepoName: got %q; want %q", m.RepoName, tc.repoName)
+			}
+			repos, err := repo.ListModuleRepositories(fsys, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := moduleReposToString(repos); got != tc.want {
+				t.Errorf("got\n%s\n\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeModuleRepositories(t *testing.T) {
+	workspace, err := rule.LoadData("WORKSPACE", "", []byte(`
+go_repository(
+    name = "com_example_a",
+    importpath = "example.com/a",
+)
+
+go_repository(
+    name = "old_b",
+    importpath = "example.com/b",
+)
+
+go_repository(
+    name = "com_example_c",
+    importpath = "example.com/c",
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, err := repo.LoadModuleData("MODULE.bazel", []byte(`
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+go_deps.module(path = "example.com/a", version = "v1.0.0")
+go_deps.module(path = "example.com/b", version = "v1.0.0")
+use_repo(go_deps, "com_example_a", b = "com_example_b")
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	moduleRepos, err := repo.ListModuleRepositories(vfs.OS, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := repo.MergeModuleRepositories(workspace.Rules, moduleRepos)
+	got := reposToString(repos)
+	want := `com_example_c example.com/c
+com_example_a example.com/a
+b example.com/b`
+	if got != want {
+		t.Errorf("got\n%s\n\nwant:\n%s", got, want)
+	}
+}
+
+func moduleReposToString(repos []*rule.Rule) string {
+	var lines []string
+	for _, r := range repos {
+		var line string
+		if r.Kind() == "bazel_dep" {
+			line = fmt.Sprintf("%s %s %s %s", r.Kind(), r.Name(), r.AttrString("module_name"), r.AttrString("version"))
+		} else {
+			line = fmt.Sprintf("%s %s %s %s %s", r.Kind(), r.Name(), r.AttrString("importpath"), r.AttrString("version"), r.AttrString("sum"))
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestModuleFileEdit(t *testing.T) {
+	m, err := repo.LoadModuleData("MODULE.bazel", []byte(`module(name = "m")
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := m.AddExtension("@gazelle//:extensions.bzl", "go_deps", "go_deps")
+	m.SetTag(ext, "module", "path", map[string]string{"path": "example.com/a", "version": "v1.0.0", "sum": "h1:a"})
+	m.SetTag(ext, "module", "path", map[string]string{"path": "example.com/b", "version": "v1.0.0"})
+	m.SetTag(ext, "module", "path", map[string]string{"path": "example.com/a", "version": "v1.1.0", "sum": ""})
+	m.SetUseRepo(ext, map[string]string{"com_example_a": "com_example_a", "b": "com_example_b"})
+	m.DeleteTag(ext, "module", "path", "example.com/b")
+
+	want := `module(name = "m")
+
+go_deps = use_extension("@gazelle//:extensions.bzl", "go_deps")
+go_deps.module(
+    path = "example.com/a",
+    version = "v1.1.0",
+)
+use_repo(
+    go_deps,
+    "com_example_a",
+    b = "com_example_b",
+)
+`
+	if got := string(m.Format()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := m.Extension("go_deps").Repos["b"]; got != "com_example_b" {
+		t.Errorf("Repos[b]: got %q; want %q", got, "com_example_b")
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 type byRuleName []*rule.Rule
@@ -69,6 +70,12 @@ func FindExternalRepo(repoRoot, name string) (string, error) {
 // ListRepositories extracts metadata about repositories declared in a
 // file.
 func ListRepositories(workspace *rule.File) (repos []*rule.Rule, repoFileMap map[string]*rule.File, err error) {
+	return ListRepositoriesFS(vfs.OS, workspace)
+}
+
+// ListRepositoriesFS is like ListRepositories, but it reads macro files named
+// in repository_macro directives from fsys.
+func ListRepositoriesFS(fsys vfs.FS, workspace *rule.File) (repos []*rule.Rule, repoFileMap map[string]*rule.File, err error) {
 	repoIndexMap := make(map[string]int)
 	repoFileMap = make(map[string]*rule.File)
 	for _, repo := range workspace.Rules {
@@ -99,7 +106,11 @@ func ListRepositories(workspace *rule.File) (repos []*rule.Rule, repoFileMap map
 				return nil, nil, err
 			}
 			f = filepath.Join(filepath.Dir(workspace.Path), filepath.Clean(f))
-			macroFile, err := rule.LoadMacroFile(f, "", defName)
+			data, err := fsys.ReadFile(f)
+			if err != nil {
+				return nil, nil, err
+			}
+			macroFile, err := rule.LoadMacroData(f, "", defName, data)
 			if err != nil {
 				return nil, nil, err
 			}
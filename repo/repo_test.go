@@ -27,6 +27,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 )
 
 func TestFindExternalRepo(t *testing.T) {
@@ -212,6 +213,35 @@ extra_repo example.com/extra`
 	}
 }
 
+func TestListRepositoriesFS(t *testing.T) {
+	dir, err := filepath.Abs("/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := vfs.NewMapFS(dir)
+	macro := `
+def go_repositories():
+    go_repository(
+        name = "go_repo",
+        importpath = "example.com/go",
+    )
+`
+	if err := fsys.WriteFile(filepath.Join(dir, "repos.bzl"), []byte(macro), 0666); err != nil {
+		t.Fatal(err)
+	}
+	workspace, err := rule.LoadData(filepath.Join(dir, "WORKSPACE"), "", []byte("# gazelle:repository_macro repos.bzl%go_repositories"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, _, err := repo.ListRepositoriesFS(fsys, workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reposToString(repos), "go_repo example.com/go"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
 func reposToString(repos []*rule.Rule) string {
 	buf := &strings.Builder{}
 	sep := ""
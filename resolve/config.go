@@ -17,7 +17,7 @@ package resolve
 
 import (
 	"flag"
-	"log"
+	"fmt"
 	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -74,6 +74,35 @@ func (_ *Configurer) KnownDirectives() []string {
 	return []string{"resolve"}
 }
 
+func (_ *Configurer) DescribeDirectives() []config.DirectiveInfo {
+	return []config.DirectiveInfo{{
+		Key: "resolve",
+		Doc: "Maps an import string to the label Gazelle should use in deps. import-lang may be omitted if it's the same as source-lang.",
+		Args: []config.DirectiveArg{
+			{Name: "source-lang", Type: config.ArgString},
+			{Name: "import-lang", Type: config.ArgString, Optional: true},
+			{Name: "import-string", Type: config.ArgString},
+			{Name: "label", Type: config.ArgLabel},
+		},
+		Scope: config.ScopeAccumulated,
+	}}
+}
+
+func (_ *Configurer) DirectiveValues(c *config.Config, key string) []string {
+	if key != "resolve" {
+		return nil
+	}
+	var values []string
+	for _, o := range getResolveConfig(c).overrides {
+		if o.lang == "" {
+			values = append(values, fmt.Sprintf("%s %s %s", o.imp.Lang, o.imp.Imp, o.dep))
+		} else {
+			values = append(values, fmt.Sprintf("%s %s %s %s", o.imp.Lang, o.lang, o.imp.Imp, o.dep))
+		}
+	}
+	return values
+}
+
 func (_ *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	rc := getResolveConfig(c)
 	rcCopy := &resolveConfig{
@@ -81,7 +110,7 @@ func (_ *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	}
 
 	if f != nil {
-		for _, d := range f.Directives {
+		for i, d := range f.Directives {
 			if d.Key == "resolve" {
 				parts := strings.Fields(d.Value)
 				o := overrideSpec{}
@@ -96,13 +125,13 @@ func (_ *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 					o.imp.Imp = parts[2]
 					lbl = parts[3]
 				} else {
-					log.Printf("could not parse directive: %s\n\texpected gazelle:resolve source-language [import-language] import-string label", d.Value)
+					c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: f.DirectiveLine(i)}, config.DiagInvalidDirective, "could not parse directive: %s\n\texpected gazelle:resolve source-language [import-language] import-string label", d.Value)
 					continue
 				}
 				var err error
 				o.dep, err = label.Parse(lbl)
 				if err != nil {
-					log.Printf("gazelle:resolve %s: %v", d.Value, err)
+					c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: f.DirectiveLine(i)}, config.DiagInvalidDirective, "gazelle:resolve %s: %v", d.Value, err)
 					continue
 				}
 				o.dep = o.dep.Abs("", rel)
@@ -0,0 +1,217 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolve
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Explainer collects explanations of how imports were resolved to
+// dependencies. Resolvers record explanations with StartExplanation.
+// Explainer is safe for concurrent use.
+//
+// EXPERIMENTAL: this may change or be removed.
+type Explainer struct {
+	targets []label.Label
+	imports map[string]bool
+
+	mu           sync.Mutex
+	explanations []*Explanation
+}
+
+// NewExplainer returns an Explainer that records explanations for imports
+// in the rules named by targets and for the import strings in imports. If
+// both are empty, explanations are recorded for all imports. Targets without
+// a repository name refer to rules in the main repository.
+func NewExplainer(targets []label.Label, imports []string) *Explainer {
+	e := &Explainer{targets: targets, imports: make(map[string]bool)}
+	for _, imp := range imports {
+		e.imports[imp] = true
+	}
+	return e
+}
+
+func (e *Explainer) wants(repoName string, from label.Label, imp ImportSpec) bool {
+	if len(e.targets) == 0 && len(e.imports) == 0 {
+		return true
+	}
+	if e.imports[imp.Imp] {
+		return true
+	}
+	for _, t := range e.targets {
+		if t.Repo == "" && !t.Canonical {
+			t.Repo = repoName
+		}
+		if t.Equal(from) {
+			return true
+		}
+	}
+	return false
+}
+
+// Explanations returns the explanations recorded so far, sorted by the
+// label of the importing rule, then by import.
+func (e *Explainer) Explanations() []*Explanation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	xs := append([]*Explanation(nil), e.explanations...)
+	sort.SliceStable(xs, func(i, j int) bool {
+		if fi, fj := xs[i].From.String(), xs[j].From.String(); fi != fj {
+			return fi < fj
+		}
+		if xs[i].Imp.Lang != xs[j].Imp.Lang {
+			return xs[i].Imp.Lang < xs[j].Imp.Lang
+		}
+		return xs[i].Imp.Imp < xs[j].Imp.Imp
+	})
+	return xs
+}
+
+// Explanation describes how one import in one rule was resolved.
+//
+// Methods on Explanation may be called on a nil pointer, in which case they
+// do nothing. This lets resolvers record decisions unconditionally.
+type Explanation struct {
+	// From is the label of the rule containing the import.
+	From label.Label
+
+	// Kind is the kind of the rule containing the import.
+	Kind string
+
+	// Imp is the import being resolved.
+	Imp ImportSpec
+
+	// Steps lists the resolution methods that were tried, in order.
+	Steps []ExplainStep
+
+	// Result is the label the import was resolved to. It is label.NoLabel if
+	// the import was skipped or could not be resolved.
+	Result label.Label
+
+	// Method is the method that produced Result.
+	Method string
+
+	// Reason explains why Result was chosen, why the import was skipped, or
+	// why resolution failed.
+	Reason string
+
+	// Skipped is true if no dependency is needed for the import, for example,
+	// because it's provided by the standard library or by the rule itself.
+	Skipped bool
+
+	// Err is set if the import could not be resolved.
+	Err error
+}
+
+// ExplainStep describes one resolution method tried for an import.
+type ExplainStep struct {
+	// Method identifies the resolution method, for example, "override" for
+	// # gazelle:resolve directives or "index" for the RuleIndex.
+	Method string
+
+	// Candidates lists labels found by this method.
+	Candidates []label.Label
+
+	// Note describes the outcome of this step.
+	Note string
+}
+
+const explainName = "_explain"
+
+// SetExplainer configures c so that resolvers record explanations in e.
+// Configurations derived from c after this is called share e.
+func SetExplainer(c *config.Config, e *Explainer) {
+	c.Exts[explainName] = e
+}
+
+// StartExplanation returns a new Explanation for imp in the rule r with label
+// from, if an Explainer is configured in c and it's interested in this import.
+// Otherwise, StartExplanation returns nil.
+func StartExplanation(c *config.Config, r *rule.Rule, from label.Label, imp ImportSpec) *Explanation {
+	e, ok := c.Exts[explainName].(*Explainer)
+	if !ok || e == nil || !e.wants(c.RepoName, from, imp) {
+		return nil
+	}
+	x := &Explanation{From: from, Kind: r.Kind(), Imp: imp}
+	e.mu.Lock()
+	e.explanations = append(e.explanations, x)
+	e.mu.Unlock()
+	return x
+}
+
+// Tried records that a resolution method was tried without producing a
+// result.
+func (x *Explanation) Tried(method string, candidates []label.Label, note string) {
+	if x == nil {
+		return
+	}
+	x.Steps = append(x.Steps, ExplainStep{Method: method, Candidates: candidates, Note: note})
+}
+
+// Chose records that a resolution method produced l.
+func (x *Explanation) Chose(method string, candidates []label.Label, l label.Label, reason string) {
+	if x == nil {
+		return
+	}
+	x.Steps = append(x.Steps, ExplainStep{Method: method, Candidates: candidates, Note: "chose " + l.String()})
+	x.Result = l
+	x.Method = method
+	x.Reason = reason
+	x.Skipped = false
+	x.Err = nil
+}
+
+// Skip records that no dependency is needed for the import.
+func (x *Explanation) Skip(method, reason string) {
+	if x == nil {
+		return
+	}
+	x.Steps = append(x.Steps, ExplainStep{Method: method, Note: "skipped"})
+	x.Result = label.NoLabel
+	x.Method = method
+	x.Reason = reason
+	x.Skipped = true
+}
+
+// Fail records that resolution failed with err.
+func (x *Explanation) Fail(method string, candidates []label.Label, err error) {
+	if x == nil {
+		return
+	}
+	x.Steps = append(x.Steps, ExplainStep{Method: method, Candidates: candidates, Note: err.Error()})
+	x.Result = label.NoLabel
+	x.Method = method
+	x.Reason = err.Error()
+	x.Err = err
+}
+
+// FindResultLabels returns the labels of a list of FindResults. It's useful
+// for recording candidates in an Explanation.
+func FindResultLabels(results []FindResult) []label.Label {
+	if len(results) == 0 {
+		return nil
+	}
+	labels := make([]label.Label, len(results))
+	for i, r := range results {
+		labels[i] = r.Label
+	}
+	return labels
+}
@@ -16,7 +16,7 @@ limitations under the License.
 package resolve
 
 import (
-	"log"
+	"sync"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
@@ -58,6 +58,8 @@ type Resolver interface {
 	// language.GenerateResult.Imports. Resolve generates a "deps" attribute (or
 	// the appropriate language-specific equivalent) for each import according to
 	// language-specific rules and heuristics.
+	//
+	// Resolve may be called concurrently for rules in different build files.
 	Resolve(c *config.Config, ix *RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label)
 }
 
@@ -72,7 +74,13 @@ type CrossResolver interface {
 
 // RuleIndex is a table of rules in a workspace, indexed by label and by
 // import path. Used by Resolver to map import paths to labels.
+//
+// AddRule and Finish may be called from multiple goroutines, but rules are
+// indexed in the order they are added, so callers that need deterministic
+// results should add rules in a deterministic order. After Finish returns,
+// the index is read-only, and lookup methods may be called concurrently.
 type RuleIndex struct {
+	mu             sync.Mutex
 	rules          []*ruleRecord
 	labelMap       map[label.Label]*ruleRecord
 	importMap      map[ImportSpec][]*ruleRecord
@@ -154,8 +162,10 @@ func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
 		importedAs: imps,
 		lang:       lang,
 	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
 	if _, ok := ix.labelMap[record.label]; ok {
-		log.Printf("multiple rules found with label %s", record.label)
+		c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: r.Line()}, config.DiagDuplicateRule, "multiple rules found with label %s", record.label)
 		return
 	}
 	ix.rules = append(ix.rules, record)
@@ -169,6 +179,8 @@ func (ix *RuleIndex) AddRule(c *config.Config, r *rule.Rule, f *rule.File) {
 // Finish must be called after all AddRule calls and before any
 // FindRulesByImport calls.
 func (ix *RuleIndex) Finish() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
 	for _, r := range ix.rules {
 		ix.collectEmbeds(r)
 	}
@@ -221,6 +233,51 @@ func (ix *RuleIndex) findRuleByLabel(label label.Label, from label.Label) (*rule
 	return r, ok
 }
 
+// IndexedRule describes a rule in a RuleIndex.
+//
+// EXPERIMENTAL: this may change or be removed.
+type IndexedRule struct {
+	// Label is the absolute label of the rule.
+	Label label.Label
+
+	// Kind is the kind of the rule, for example, "go_library".
+	Kind string
+
+	// Lang is the name of the language that indexed the rule.
+	Lang string
+
+	// ImportedAs lists the imports the rule may be imported with, including
+	// imports of rules it embeds.
+	ImportedAs []ImportSpec
+
+	// Embeds is the transitive closure of labels for rules that this rule
+	// embeds.
+	Embeds []label.Label
+
+	// Embedded is true if another rule of the same language embeds this rule.
+	// Embedded rules are not returned by FindRulesByImport.
+	Embedded bool
+}
+
+// FindRuleByLabel returns information about the indexed rule with the given
+// absolute label. FindRuleByLabel may only be called after Finish.
+//
+// EXPERIMENTAL: this may change or be removed.
+func (ix *RuleIndex) FindRuleByLabel(l label.Label) (IndexedRule, bool) {
+	r, ok := ix.labelMap[l]
+	if !ok {
+		return IndexedRule{}, false
+	}
+	return IndexedRule{
+		Label:      r.label,
+		Kind:       r.rule.Kind(),
+		Lang:       r.lang,
+		ImportedAs: r.importedAs,
+		Embeds:     r.embeds,
+		Embedded:   r.embedded,
+	}, true
+}
+
 type FindResult struct {
 	// Label is the absolute label (including repository and package name) for
 	// a matched rule.
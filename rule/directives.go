@@ -39,18 +39,21 @@ type Directive struct {
 // is returned. Errors are reported for unrecognized directives and directives
 // out of place (after the first statement).
 func ParseDirectives(f *bzl.File) []Directive {
-	return parseDirectives(f.Stmt)
+	directives, _ := parseDirectives(f.Stmt)
+	return directives
 }
 
 // ParseDirectivesFromMacro scans a macro body for Gazelle directives. The
 // full list of directives is returned. Errors are reported for unrecognized
 // directives and directives out of place (after the first statement).
 func ParseDirectivesFromMacro(f *bzl.DefStmt) []Directive {
-	return parseDirectives(f.Body)
+	directives, _ := parseDirectives(f.Body)
+	return directives
 }
 
-func parseDirectives(stmt []bzl.Expr) []Directive {
-	var directives []Directive
+// parseDirectives returns the directives in comments on stmt and the line
+// where each one appears.
+func parseDirectives(stmt []bzl.Expr) (directives []Directive, lines []int) {
 	parseComment := func(com bzl.Comment) {
 		match := directiveRe.FindStringSubmatch(com.Token)
 		if match == nil {
@@ -58,6 +61,7 @@ func parseDirectives(stmt []bzl.Expr) []Directive {
 		}
 		key, value := match[1], match[2]
 		directives = append(directives, Directive{key, value})
+		lines = append(lines, com.Start.Line)
 	}
 
 	for _, s := range stmt {
@@ -69,7 +73,7 @@ func parseDirectives(stmt []bzl.Expr) []Directive {
 			parseComment(com)
 		}
 	}
-	return directives
+	return directives, lines
 }
 
 var directiveRe = regexp.MustCompile(`^#\s*gazelle:(\w+)\s*(.*?)\s*$`)
@@ -54,10 +54,44 @@ foo(
 				t.Fatal(err)
 			}
 
-			got := parseDirectives(f.Stmt)
+			got, _ := parseDirectives(f.Stmt)
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("got %#v ; want %#v", got, tc.want)
 			}
 		})
 	}
 }
+
+func TestDirectiveLine(t *testing.T) {
+	f, err := LoadData("BUILD.bazel", "", []byte(`# gazelle:prefix example.com/a
+
+# gazelle:ignore
+
+foo(name = "foo")
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{1, 3} {
+		if got := f.DirectiveLine(i); got != want {
+			t.Errorf("DirectiveLine(%d): got %d; want %d", i, got, want)
+		}
+	}
+	if got := f.Rules[0].Line(); got != 5 {
+		t.Errorf("Line: got %d; want 5", got)
+	}
+
+	fc := f.FilterDirectives(func(i int) bool { return i == 1 })
+	if got, want := fc.Directives, []Directive{{"ignore", ""}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FilterDirectives: got %v; want %v", got, want)
+	}
+	if got := fc.DirectiveLine(0); got != 3 {
+		t.Errorf("FilterDirectives: DirectiveLine(0): got %d; want 3", got)
+	}
+
+	// Lines aren't known after Directives is modified.
+	f.Directives = append(f.Directives, Directive{"ignore", ""})
+	if got := f.DirectiveLine(0); got != 0 {
+		t.Errorf("DirectiveLine(0) after append: got %d; want 0", got)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"log"
 	"sort"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
 	bzl "github.com/bazelbuild/buildtools/build"
 )
 
@@ -42,6 +43,14 @@ import (
 // a "# keep" comment will be dropped. If the attribute is empty afterward,
 // it will be deleted.
 func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
+	MergeRulesWithLabelAttrs(src, dst, mergeable, nil, filename)
+}
+
+// MergeRulesWithLabelAttrs is like MergeRules, but strings in the attributes
+// named in labelAttrs are compared as labels. Labels that are written
+// differently but refer to the same target, like "//x" and "//x:x", are
+// merged as one value.
+func MergeRulesWithLabelAttrs(src, dst *Rule, mergeable, labelAttrs map[string]bool, filename string) {
 	if dst.ShouldKeep() {
 		return
 	}
@@ -52,7 +61,7 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 			continue
 		}
 		dstValue := dstAttr.RHS
-		if mergedValue, err := mergeExprs(nil, dstValue); err != nil {
+		if mergedValue, err := mergeExprs(nil, dstValue, labelAttrs[key]); err != nil {
 			start, end := dstValue.Span()
 			log.Printf("%s:%d.%d-%d.%d: could not merge expression", filename, start.Line, start.LineRune, end.Line, end.LineRune)
 		} else if mergedValue == nil {
@@ -69,7 +78,7 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 			dst.SetAttr(key, srcValue)
 		} else if mergeable[key] && !ShouldKeep(dstAttr) {
 			dstValue := dstAttr.RHS
-			if mergedValue, err := mergeExprs(srcValue, dstValue); err != nil {
+			if mergedValue, err := mergeExprs(srcValue, dstValue, labelAttrs[key]); err != nil {
 				start, end := dstValue.Span()
 				log.Printf("%s:%d.%d-%d.%d: could not merge expression", filename, start.Line, start.LineRune, end.Line, end.LineRune)
 			} else {
@@ -93,9 +102,11 @@ func MergeRules(src, dst *Rule, mergeable map[string]bool, filename string) {
 //   * a list of strings combined with a select call using +. The list must
 //     be the left operand.
 //
+// If labels is true, strings are compared as labels.
+//
 // An error is returned if the expressions can't be merged, for example
 // because they are not in one of the above formats.
-func mergeExprs(src, dst bzl.Expr) (bzl.Expr, error) {
+func mergeExprs(src, dst bzl.Expr, labels bool) (bzl.Expr, error) {
 	if ShouldKeep(dst) {
 		return nil, nil
 	}
@@ -114,30 +125,30 @@ func mergeExprs(src, dst bzl.Expr) (bzl.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
-	mergedExprs, err := mergePlatformStringsExprs(srcExprs, dstExprs)
+	mergedExprs, err := mergePlatformStringsExprs(srcExprs, dstExprs, labels)
 	if err != nil {
 		return nil, err
 	}
 	return makePlatformStringsExpr(mergedExprs), nil
 }
 
-func mergePlatformStringsExprs(src, dst platformStringsExprs) (platformStringsExprs, error) {
+func mergePlatformStringsExprs(src, dst platformStringsExprs, labels bool) (platformStringsExprs, error) {
 	var ps platformStringsExprs
 	var err error
-	ps.generic = mergeList(src.generic, dst.generic)
-	if ps.os, err = mergeDict(src.os, dst.os); err != nil {
+	ps.generic = mergeList(src.generic, dst.generic, labels)
+	if ps.os, err = mergeDict(src.os, dst.os, labels); err != nil {
 		return platformStringsExprs{}, err
 	}
-	if ps.arch, err = mergeDict(src.arch, dst.arch); err != nil {
+	if ps.arch, err = mergeDict(src.arch, dst.arch, labels); err != nil {
 		return platformStringsExprs{}, err
 	}
-	if ps.platform, err = mergeDict(src.platform, dst.platform); err != nil {
+	if ps.platform, err = mergeDict(src.platform, dst.platform, labels); err != nil {
 		return platformStringsExprs{}, err
 	}
 	return ps, nil
 }
 
-func mergeList(src, dst *bzl.ListExpr) *bzl.ListExpr {
+func mergeList(src, dst *bzl.ListExpr, labels bool) *bzl.ListExpr {
 	if dst == nil {
 		return src
 	}
@@ -147,11 +158,19 @@ func mergeList(src, dst *bzl.ListExpr) *bzl.ListExpr {
 
 	// Build a list of strings from the src list and keep matching strings
 	// in the dst list. This preserves comments. Also keep anything with
-	// a "# keep" comment, whether or not it's in the src list.
+	// a "# keep" comment, whether or not it's in the src list. If labels is
+	// true, labels that are written differently but refer to the same target
+	// match.
+	key := func(s string) string {
+		if labels && s != "" {
+			return label.Normalize(s)
+		}
+		return s
+	}
 	srcSet := make(map[string]bool)
 	for _, v := range src.List {
 		if s := stringValue(v); s != "" {
-			srcSet[s] = true
+			srcSet[key(s)] = true
 		}
 	}
 
@@ -159,7 +178,7 @@ func mergeList(src, dst *bzl.ListExpr) *bzl.ListExpr {
 	kept := make(map[string]bool)
 	keepComment := false
 	for _, v := range dst.List {
-		s := stringValue(v)
+		s := key(stringValue(v))
 		if keep := ShouldKeep(v); keep || srcSet[s] {
 			keepComment = keepComment || keep
 			merged = append(merged, v)
@@ -171,7 +190,7 @@ func mergeList(src, dst *bzl.ListExpr) *bzl.ListExpr {
 
 	// Add anything in the src list that wasn't kept.
 	for _, v := range src.List {
-		if s := stringValue(v); kept[s] {
+		if s := stringValue(v); kept[key(s)] {
 			continue
 		}
 		merged = append(merged, v)
@@ -186,7 +205,7 @@ func mergeList(src, dst *bzl.ListExpr) *bzl.ListExpr {
 	}
 }
 
-func mergeDict(src, dst *bzl.DictExpr) (*bzl.DictExpr, error) {
+func mergeDict(src, dst *bzl.DictExpr, labels bool) (*bzl.DictExpr, error) {
 	if dst == nil {
 		return src, nil
 	}
@@ -227,7 +246,7 @@ func mergeDict(src, dst *bzl.DictExpr) (*bzl.DictExpr, error) {
 	keys := make([]string, 0, len(entries))
 	haveDefault := false
 	for _, e := range entries {
-		e.mergedValue = mergeList(e.srcValue, e.dstValue)
+		e.mergedValue = mergeList(e.srcValue, e.dstValue, labels)
 		if e.key == "//conditions:default" {
 			// Keep the default case, even if it's empty.
 			haveDefault = true
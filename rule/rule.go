@@ -33,6 +33,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/bazelbuild/bazel-gazelle/vfs"
 	bzl "github.com/bazelbuild/buildtools/build"
 	bt "github.com/bazelbuild/buildtools/tables"
 )
@@ -64,6 +65,10 @@ type File struct {
 	// comments in the file. This should not be modified after the file is read.
 	Directives []Directive
 
+	// directiveLines holds the line where each directive in Directives
+	// appears, if known.
+	directiveLines []int
+
 	// Loads is a list of load statements within the file. This should not
 	// be modified directly; use Load methods instead.
 	Loads []*Load
@@ -227,9 +232,9 @@ func ScanASTBody(pkg, defName string, bzlFile *bzl.File) *File {
 		}
 	}
 	if f.function != nil {
-		f.Directives = ParseDirectivesFromMacro(f.function.stmt)
+		f.Directives, f.directiveLines = parseDirectives(f.function.stmt.Body)
 	} else {
-		f.Directives = ParseDirectives(bzlFile)
+		f.Directives, f.directiveLines = parseDirectives(bzlFile.Stmt)
 	}
 	return f
 }
@@ -289,6 +294,32 @@ func (f *File) MacroName() string {
 	return ""
 }
 
+// DirectiveLine returns the line where f.Directives[i] appears in the file.
+// It returns 0 if the line is not known, for example, because Directives was
+// modified after the file was read.
+func (f *File) DirectiveLine(i int) int {
+	if len(f.directiveLines) != len(f.Directives) || i < 0 || i >= len(f.directiveLines) {
+		return 0
+	}
+	return f.directiveLines[i]
+}
+
+// FilterDirectives returns a shallow copy of f with only the directives
+// f.Directives[i] for which keep(i) returns true. DirectiveLine reports the
+// same lines for kept directives as it does for f. f is not modified.
+func (f *File) FilterDirectives(keep func(i int) bool) *File {
+	fc := *f
+	fc.Directives = nil
+	fc.directiveLines = nil
+	for i, d := range f.Directives {
+		if keep(i) {
+			fc.Directives = append(fc.Directives, d)
+			fc.directiveLines = append(fc.directiveLines, f.DirectiveLine(i))
+		}
+	}
+	return &fc
+}
+
 // Sync writes all changes back to the wrapped syntax tree. This should be
 // called after editing operations, before reading the syntax tree again.
 func (f *File) Sync() {
@@ -404,9 +435,14 @@ func (f *File) SortMacro() {
 
 // Save writes the build file to disk. This method calls Sync internally.
 func (f *File) Save(path string) error {
+	return f.SaveTo(vfs.OS, path)
+}
+
+// SaveTo is like Save, but it writes the build file to fsys.
+func (f *File) SaveTo(fsys vfs.FS, path string) error {
 	f.Sync()
 	f.Content = bzl.Format(f.File)
-	return ioutil.WriteFile(path, f.Content, 0666)
+	return fsys.WriteFile(path, f.Content, 0666)
 }
 
 // HasDefaultVisibility returns whether the File contains a "package" rule with
@@ -435,6 +471,13 @@ type stmt struct {
 // rules, this is the index of the original statement.
 func (s *stmt) Index() int { return s.index }
 
+// Line returns the line where this statement starts in the build file. It
+// returns 0 for statements that weren't read from a file.
+func (s *stmt) Line() int {
+	start, _ := s.expr.Span()
+	return start.Line
+}
+
 // Delete marks this statement for deletion. It will be removed from the
 // syntax tree when File.Sync is called.
 func (s *stmt) Delete() { s.deleted = true }
@@ -53,4 +53,10 @@ type KindInfo struct {
 	// ResolveAttrs is a set of attributes that should be merged after
 	// dependency resolution. See rule.Merge.
 	ResolveAttrs map[string]bool
+
+	// LabelAttrs is a set of attributes whose values are labels, in addition
+	// to those in ResolveAttrs and SubstituteAttrs, which are always labels.
+	// When rules are matched and merged, strings in these attributes are
+	// compared as labels, so "//x" and "//x:x" are the same value.
+	LabelAttrs map[string]bool
 }
@@ -0,0 +1,49 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NewGitFS reads the tree at revision rev of the git repository containing
+// root into a file system rooted at root. root may be a subdirectory of the
+// repository; only files below it are read. The git command must be
+// installed.
+func NewGitFS(root, rev string) (*MapFS, error) {
+	cmd := exec.Command("git", "archive", "--format=tar", rev)
+	cmd.Dir = root
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	m, readErr := NewTarFS(root, stdout)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("reading revision %s of %s: %v: %s", rev, root, err, strings.TrimSpace(stderr.String()))
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("reading revision %s of %s: %v", rev, root, readErr)
+	}
+	return m, nil
+}
@@ -0,0 +1,327 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MapFS is a file system held in memory. It contains the directory root
+// and files and directories below it. Directories are created implicitly
+// when files are written.
+type MapFS struct {
+	root string
+
+	mu sync.RWMutex
+
+	// entries maps slash-separated paths relative to root to files,
+	// directories, and symbolic links. "" is root itself.
+	entries map[string]*mapEntry
+}
+
+type mapEntry struct {
+	mode    os.FileMode
+	modTime time.Time
+
+	// data is the content of a regular file.
+	data []byte
+
+	// target is the destination of a symbolic link, as it was written.
+	target string
+
+	// children is the set of base names of entries in a directory.
+	children map[string]bool
+}
+
+var (
+	errIsDir      = errors.New("is a directory")
+	errNotDir     = errors.New("not a directory")
+	errTooManySym = errors.New("too many levels of symbolic links")
+)
+
+// NewMapFS returns an empty file system rooted at root, which must be
+// an absolute path.
+func NewMapFS(root string) *MapFS {
+	return &MapFS{
+		root: filepath.Clean(root),
+		entries: map[string]*mapEntry{
+			"": {mode: os.ModeDir | 0777, children: make(map[string]bool)},
+		},
+	}
+}
+
+// Root returns the directory the file system is rooted at.
+func (m *MapFS) Root() string {
+	return m.root
+}
+
+func (m *MapFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rel, e, err := m.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return m.info(rel, e), nil
+}
+
+func (m *MapFS) Lstat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rel, e, err := m.lookup(name, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return m.info(rel, e), nil
+}
+
+func (m *MapFS) ReadDir(name string) ([]os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rel, e, err := m.lookup(name, true)
+	if err == nil && !e.mode.IsDir() {
+		err = errNotDir
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	names := make([]string, 0, len(e.children))
+	for child := range e.children {
+		names = append(names, child)
+	}
+	sort.Strings(names)
+	infos := make([]os.FileInfo, len(names))
+	for i, child := range names {
+		childRel := path.Join(rel, child)
+		infos[i] = m.info(childRel, m.entries[childRel])
+	}
+	return infos, nil
+}
+
+func (m *MapFS) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, e, err := m.lookup(name, true)
+	if err == nil && e.mode.IsDir() {
+		err = errIsDir
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+func (m *MapFS) EvalSymlinks(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rel, _, err := m.lookup(name, true)
+	if err != nil {
+		return "", &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return filepath.Join(m.root, filepath.FromSlash(rel)), nil
+}
+
+func (m *MapFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rel, err := m.rel(name)
+	if err == nil {
+		err = m.add(rel, &mapEntry{mode: perm & os.ModePerm, data: append([]byte(nil), data...), modTime: time.Now()})
+	}
+	if err != nil {
+		return &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Symlink creates a symbolic link at newname pointing to oldname, like
+// os.Symlink. oldname may be absolute or relative to the link's directory.
+func (m *MapFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rel, err := m.rel(newname)
+	if err == nil {
+		err = m.add(rel, &mapEntry{mode: os.ModeSymlink | 0777, target: oldname, modTime: time.Now()})
+	}
+	if err != nil {
+		return &os.PathError{Op: "symlink", Path: newname, Err: err}
+	}
+	return nil
+}
+
+// Mkdir creates a directory and any parents that don't exist yet.
+func (m *MapFS) Mkdir(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rel, err := m.rel(name)
+	if err == nil {
+		_, err = m.mkdirAll(rel)
+	}
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// rel converts an absolute path to a slash-separated path relative to
+// m.root. Paths outside m.root don't exist.
+func (m *MapFS) rel(name string) (string, error) {
+	rel, err := filepath.Rel(m.root, filepath.Clean(name))
+	if err != nil || !filepath.IsAbs(name) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", os.ErrNotExist
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// add stores e at rel, creating parent directories as needed. Existing
+// files and links are replaced; existing directories are not.
+func (m *MapFS) add(rel string, e *mapEntry) error {
+	if rel == "" {
+		return errIsDir
+	}
+	parent, err := m.mkdirAll(path.Dir(strings.TrimSuffix(rel, "/")))
+	if err != nil {
+		return err
+	}
+	if old, ok := m.entries[rel]; ok && old.mode.IsDir() {
+		return errIsDir
+	}
+	m.entries[rel] = e
+	parent.children[path.Base(rel)] = true
+	return nil
+}
+
+// mkdirAll creates the directory rel and its parents, if they don't exist.
+func (m *MapFS) mkdirAll(rel string) (*mapEntry, error) {
+	if rel == "." {
+		rel = ""
+	}
+	if e, ok := m.entries[rel]; ok {
+		if !e.mode.IsDir() {
+			return nil, errNotDir
+		}
+		return e, nil
+	}
+	parent, err := m.mkdirAll(path.Dir(rel))
+	if err != nil {
+		return nil, err
+	}
+	e := &mapEntry{mode: os.ModeDir | 0777, children: make(map[string]bool), modTime: time.Now()}
+	m.entries[rel] = e
+	parent.children[path.Base(rel)] = true
+	return e, nil
+}
+
+// lookup finds the entry for name, following symbolic links in directory
+// components. If follow is true, a symbolic link in the last component is
+// followed, too. The relative path of the entry found is returned.
+func (m *MapFS) lookup(name string, follow bool) (string, *mapEntry, error) {
+	rel, err := m.rel(name)
+	if err != nil {
+		return "", nil, err
+	}
+	comps := splitRel(rel)
+	resolved := ""
+	links := 0
+	for i := 0; i < len(comps); i++ {
+		switch comps[i] {
+		case ".":
+			continue
+		case "..":
+			if resolved == "" {
+				return "", nil, os.ErrNotExist
+			}
+			resolved = dirRel(resolved)
+			continue
+		}
+		next := path.Join(resolved, comps[i])
+		e, ok := m.entries[next]
+		if !ok {
+			return "", nil, os.ErrNotExist
+		}
+		last := i == len(comps)-1
+		if e.mode&os.ModeSymlink != 0 && (!last || follow) {
+			links++
+			if links > 255 {
+				return "", nil, errTooManySym
+			}
+			var target string
+			if filepath.IsAbs(e.target) {
+				if target, err = m.rel(e.target); err != nil {
+					return "", nil, err
+				}
+			} else {
+				target = path.Join(resolved, filepath.ToSlash(e.target))
+				if target == ".." || strings.HasPrefix(target, "../") {
+					return "", nil, os.ErrNotExist
+				}
+			}
+			comps = append(splitRel(target), comps[i+1:]...)
+			resolved = ""
+			i = -1
+			continue
+		}
+		if !last && !e.mode.IsDir() {
+			return "", nil, errNotDir
+		}
+		resolved = next
+	}
+	return resolved, m.entries[resolved], nil
+}
+
+func (m *MapFS) info(rel string, e *mapEntry) os.FileInfo {
+	name := path.Base(rel)
+	if rel == "" {
+		name = filepath.Base(m.root)
+	}
+	return mapFileInfo{name: name, e: e}
+}
+
+func splitRel(rel string) []string {
+	if rel == "" || rel == "." {
+		return nil
+	}
+	return strings.Split(rel, "/")
+}
+
+func dirRel(rel string) string {
+	if dir := path.Dir(rel); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+type mapFileInfo struct {
+	name string
+	e    *mapEntry
+}
+
+func (fi mapFileInfo) Name() string       { return fi.name }
+func (fi mapFileInfo) Size() int64        { return int64(len(fi.e.data)) }
+func (fi mapFileInfo) Mode() os.FileMode  { return fi.e.mode }
+func (fi mapFileInfo) ModTime() time.Time { return fi.e.modTime }
+func (fi mapFileInfo) IsDir() bool        { return fi.e.mode.IsDir() }
+func (fi mapFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,216 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ReadOverlayFile reads a JSON file in the format accepted by
+// 'go build -overlay'. The file contains an object with a "Replace" field,
+// which maps paths of files to paths of files to read instead. An empty
+// replacement path means the file is treated as deleted. Relative paths
+// are resolved against dir.
+//
+// The returned map has absolute, clean paths for keys and values, and it
+// may be passed to NewOverlayFS.
+func ReadOverlayFile(path, dir string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overlay struct {
+		Replace map[string]string
+	}
+	if err := json.Unmarshal(data, &overlay); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	abs := func(p string) string {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		return filepath.Clean(p)
+	}
+	replace := make(map[string]string, len(overlay.Replace))
+	for from, to := range overlay.Replace {
+		if from == "" {
+			return nil, fmt.Errorf("%s: empty path in Replace", path)
+		}
+		if to != "" {
+			to = abs(to)
+		}
+		replace[abs(from)] = to
+	}
+	return replace, nil
+}
+
+type overlayFS struct {
+	base FS
+
+	// replace maps absolute paths of files to paths of files on the
+	// operating system's file system to read instead, or to "" for files
+	// that are deleted.
+	replace map[string]string
+
+	// dirs maps directories containing replaced files to the base names of
+	// files and directories in them that the overlay adds. Directories that
+	// don't exist in base are created implicitly.
+	dirs map[string]map[string]bool
+}
+
+// NewOverlayFS returns a file system that reads files from base, except
+// that files in replace are read from the operating system's file system at
+// the paths they map to. Files that map to "" don't exist. Keys of replace
+// must be absolute, clean paths. Directories containing replaced files
+// exist, even if they don't exist in base. Files are written to base.
+func NewOverlayFS(base FS, replace map[string]string) FS {
+	o := &overlayFS{
+		base:    base,
+		replace: replace,
+		dirs:    make(map[string]map[string]bool),
+	}
+	for p, to := range replace {
+		if to == "" {
+			continue
+		}
+		for {
+			dir := filepath.Dir(p)
+			if dir == p {
+				break
+			}
+			if o.dirs[dir] == nil {
+				o.dirs[dir] = make(map[string]bool)
+			}
+			o.dirs[dir][filepath.Base(p)] = true
+			p = dir
+		}
+	}
+	return o
+}
+
+func (o *overlayFS) Stat(name string) (os.FileInfo, error) {
+	return o.stat(name, o.base.Stat)
+}
+
+func (o *overlayFS) Lstat(name string) (os.FileInfo, error) {
+	return o.stat(name, o.base.Lstat)
+}
+
+func (o *overlayFS) stat(name string, baseStat func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	if to, ok := o.replace[name]; ok {
+		if to == "" {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+		}
+		fi, err := os.Stat(to)
+		if err != nil {
+			return nil, err
+		}
+		return renamedFileInfo{fi, filepath.Base(name)}, nil
+	}
+	fi, err := baseStat(name)
+	if os.IsNotExist(err) && o.dirs[name] != nil {
+		return overlayDirInfo(filepath.Base(name)), nil
+	}
+	return fi, err
+}
+
+func (o *overlayFS) ReadDir(name string) ([]os.FileInfo, error) {
+	name = filepath.Clean(name)
+	infos, err := o.base.ReadDir(name)
+	added := o.dirs[name]
+	if err != nil && !(os.IsNotExist(err) && added != nil) {
+		return nil, err
+	}
+
+	var result []os.FileInfo
+	for _, fi := range infos {
+		if _, ok := o.replace[filepath.Join(name, fi.Name())]; ok || added[fi.Name()] {
+			continue
+		}
+		result = append(result, fi)
+	}
+	for base := range added {
+		fi, err := o.Lstat(filepath.Join(name, base))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fi)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
+
+func (o *overlayFS) ReadFile(name string) ([]byte, error) {
+	name = filepath.Clean(name)
+	if to, ok := o.replace[name]; ok {
+		if to == "" {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return ioutil.ReadFile(to)
+	}
+	return o.base.ReadFile(name)
+}
+
+func (o *overlayFS) EvalSymlinks(name string) (string, error) {
+	name = filepath.Clean(name)
+	if _, ok := o.replace[name]; ok {
+		return filepath.Join(o.evalDir(filepath.Dir(name)), filepath.Base(name)), nil
+	}
+	if p, err := o.base.EvalSymlinks(name); !os.IsNotExist(err) || o.dirs[name] == nil {
+		return p, err
+	}
+	return filepath.Join(o.evalDir(filepath.Dir(name)), filepath.Base(name)), nil
+}
+
+// evalDir evaluates symbolic links in the directory containing a file
+// added by the overlay. The directory itself may have been added, too.
+func (o *overlayFS) evalDir(dir string) string {
+	if p, err := o.EvalSymlinks(dir); err == nil {
+		return p
+	}
+	return dir
+}
+
+func (o *overlayFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return o.base.WriteFile(name, data, perm)
+}
+
+// renamedFileInfo describes a replacement file under the name of the file
+// it replaces.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
+
+// overlayDirInfo describes a directory that only exists because the overlay
+// adds files to it.
+type overlayDirInfo string
+
+func (fi overlayDirInfo) Name() string       { return string(fi) }
+func (fi overlayDirInfo) Size() int64        { return 0 }
+func (fi overlayDirInfo) Mode() os.FileMode  { return os.ModeDir | 0777 }
+func (fi overlayDirInfo) ModTime() time.Time { return time.Time{} }
+func (fi overlayDirInfo) IsDir() bool        { return true }
+func (fi overlayDirInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,89 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// NewTarFS reads a tar archive into a file system rooted at root. The
+// archive may be compressed with gzip. Paths in the archive are relative
+// to root.
+func NewTarFS(root string, r io.Reader) (*MapFS, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	m := NewMapFS(root)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		rel := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("%s: path is outside the archive root", hdr.Name)
+		}
+		name := filepath.Join(m.root, filepath.FromSlash(rel))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if rel != "." {
+				err = m.Mkdir(name)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			var data []byte
+			if data, err = ioutil.ReadAll(tr); err == nil {
+				err = m.WriteFile(name, data, os.FileMode(hdr.Mode))
+			}
+		case tar.TypeSymlink:
+			err = m.Symlink(hdr.Linkname, name)
+		case tar.TypeLink:
+			var data []byte
+			target := filepath.Join(m.root, filepath.FromSlash(path.Clean(hdr.Linkname)))
+			if data, err = m.ReadFile(target); err == nil {
+				err = m.WriteFile(name, data, os.FileMode(hdr.Mode))
+			}
+		default:
+			// Global headers, devices, and FIFOs have no content Gazelle
+			// could use.
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
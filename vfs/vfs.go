@@ -0,0 +1,72 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vfs provides file systems that Gazelle can read source files and
+// build files from. Besides the operating system's file system, files may
+// be held in memory, read from a tar archive, or read from a revision of a
+// git repository, so Gazelle can generate build files for a tree that isn't
+// checked out.
+//
+// File systems are accessed with absolute, OS-specific paths, like the rest
+// of Gazelle. File systems other than the OS's are rooted at a directory,
+// usually the repository root, and contain no files outside it.
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FS is a file system. Implementations must be safe for concurrent use.
+type FS interface {
+	// Stat returns information about a file, following symbolic links.
+	Stat(name string) (os.FileInfo, error)
+
+	// Lstat returns information about a file without following symbolic
+	// links.
+	Lstat(name string) (os.FileInfo, error)
+
+	// ReadDir returns a list of entries in a directory, sorted by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+
+	// ReadFile returns the contents of a file.
+	ReadFile(name string) ([]byte, error)
+
+	// EvalSymlinks returns name after evaluating any symbolic links,
+	// like filepath.EvalSymlinks.
+	EvalSymlinks(name string) (string, error)
+
+	// WriteFile writes a file, creating parent directories if needed.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+}
+
+// OS is the operating system's file system.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Stat(name string) (os.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Lstat(name string) (os.FileInfo, error)     { return os.Lstat(name) }
+func (osFS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return ioutil.ReadFile(name) }
+func (osFS) EvalSymlinks(name string) (string, error)   { return filepath.EvalSymlinks(name) }
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, data, perm)
+}
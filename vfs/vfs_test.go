@@ -0,0 +1,311 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMapFS(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	m := NewMapFS(root)
+	join := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+	for rel, content := range map[string]string{
+		"BUILD.bazel": "# root",
+		"a/a.go":      "package a",
+		"a/b/b.go":    "package b",
+	} {
+		if err := m.WriteFile(join(rel), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Symlink("a", join("link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Symlink(join("a/b"), join("c/abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Symlink("../..", join("a/b/up")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ReadDir", func(t *testing.T) {
+		infos, err := m.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, fi := range infos {
+			got = append(got, fi.Name())
+		}
+		want := []string{"BUILD.bazel", "a", "c", "link"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q; want %q", got, want)
+		}
+		if !infos[1].IsDir() || infos[3].Mode()&os.ModeSymlink == 0 {
+			t.Errorf("got modes %v, %v; want directory and symlink", infos[1].Mode(), infos[3].Mode())
+		}
+	})
+
+	t.Run("ReadFile", func(t *testing.T) {
+		for _, rel := range []string{"a/b/b.go", "link/b/b.go", "c/abs/b.go", "a/b/up/a/a.go"} {
+			data, err := m.ReadFile(join(rel))
+			if err != nil {
+				t.Errorf("%s: %v", rel, err)
+			} else if rel != "a/b/up/a/a.go" && string(data) != "package b" {
+				t.Errorf("%s: got %q", rel, data)
+			}
+		}
+		if _, err := m.ReadFile(join("a")); err == nil {
+			t.Error("reading a directory: got nil error")
+		}
+		if _, err := m.ReadFile(join("missing")); !os.IsNotExist(err) {
+			t.Errorf("reading a missing file: got %v; want a not-exist error", err)
+		}
+		if _, err := m.ReadFile(filepath.FromSlash("/other/a.go")); !os.IsNotExist(err) {
+			t.Errorf("reading a file outside root: got %v; want a not-exist error", err)
+		}
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		fi, err := m.Stat(join("link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.IsDir() {
+			t.Errorf("Stat: got mode %v; want a directory", fi.Mode())
+		}
+		fi, err = m.Lstat(join("link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Lstat: got mode %v; want a symlink", fi.Mode())
+		}
+	})
+
+	t.Run("EvalSymlinks", func(t *testing.T) {
+		got, err := m.EvalSymlinks(join("c/abs/up"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != root {
+			t.Errorf("got %s; want %s", got, root)
+		}
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		if err := m.Symlink("loop", join("loop")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Stat(join("loop")); err == nil {
+			t.Error("got nil error")
+		}
+	})
+}
+
+func TestTarFS(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	for _, hdr := range []*tar.Header{
+		{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "x"}},
+		{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "a/a.go", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len("package a"))},
+		{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a"},
+		{Name: "empty/", Typeflag: tar.TypeDir, Mode: 0755},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte("package a"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.FromSlash("/repo")
+	m, err := NewTarFS(root, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.ReadFile(filepath.Join(root, "b", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "package a" {
+		t.Errorf("got %q; want %q", data, "package a")
+	}
+	if fi, err := m.Stat(filepath.Join(root, "empty")); err != nil || !fi.IsDir() {
+		t.Errorf("empty directory: got %v, %v", fi, err)
+	}
+	if _, err := m.Stat(filepath.Join(root, "pax_global_header")); !os.IsNotExist(err) {
+		t.Errorf("global header: got %v; want a not-exist error", err)
+	}
+}
+
+func TestTarFSOutsideRoot(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "../x", Typeflag: tar.TypeReg})
+	tw.Close()
+	if _, err := NewTarFS(filepath.FromSlash("/repo"), buf); err == nil {
+		t.Error("got nil error")
+	}
+}
+
+func TestGitFS(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "vfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(rel, content string) {
+		t.Helper()
+		if err := OS.WriteFile(filepath.Join(dir, filepath.FromSlash(rel)), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("sub/a.go", "package old")
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+	write("sub/a.go", "package new")
+	write("sub/b.go", "package new")
+
+	m, err := NewGitFS(filepath.Join(dir, "sub"), "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.ReadFile(filepath.Join(dir, "sub", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "package old" {
+		t.Errorf("got %q; want %q", data, "package old")
+	}
+	if _, err := m.Stat(filepath.Join(dir, "sub", "b.go")); !os.IsNotExist(err) {
+		t.Errorf("uncommitted file: got %v; want a not-exist error", err)
+	}
+
+	if _, err := NewGitFS(dir, "no-such-rev"); err == nil {
+		t.Error("bad revision: got nil error")
+	}
+}
+
+func TestOverlayFS(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "vfs_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	for rel, content := range map[string]string{
+		"repo/a/a.go":   "package saved",
+		"repo/a/del.go": "package a",
+		"edits/a.go":    "package unsaved",
+		"edits/n.go":    "package n",
+	} {
+		if err := OS.WriteFile(filepath.Join(dir, filepath.FromSlash(rel)), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overlayPath := filepath.Join(dir, "overlay.json")
+	overlayJSON := `{"Replace": {
+	"repo/a/a.go": "edits/a.go",
+	"repo/a/del.go": "",
+	"` + filepath.ToSlash(filepath.Join(dir, "repo/new/n.go")) + `": "edits/n.go"
+}}`
+	if err := ioutil.WriteFile(overlayPath, []byte(overlayJSON), 0666); err != nil {
+		t.Fatal(err)
+	}
+	replace, err := ReadOverlayFile(overlayPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := NewOverlayFS(OS, replace)
+	repo := filepath.Join(dir, "repo")
+
+	readDir := func(name string) []string {
+		t.Helper()
+		infos, err := o.ReadDir(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range infos {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+	if got, want := readDir(repo), []string{"a", "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir(repo): got %q; want %q", got, want)
+	}
+	if got, want := readDir(filepath.Join(repo, "a")), []string{"a.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir(repo/a): got %q; want %q", got, want)
+	}
+	if got, want := readDir(filepath.Join(repo, "new")), []string{"n.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir(repo/new): got %q; want %q", got, want)
+	}
+
+	if data, err := o.ReadFile(filepath.Join(repo, "a", "a.go")); err != nil {
+		t.Error(err)
+	} else if string(data) != "package unsaved" {
+		t.Errorf("ReadFile(repo/a/a.go): got %q; want %q", data, "package unsaved")
+	}
+	if _, err := o.ReadFile(filepath.Join(repo, "a", "del.go")); !os.IsNotExist(err) {
+		t.Errorf("ReadFile(repo/a/del.go): got %v; want a not-exist error", err)
+	}
+	if fi, err := o.Stat(filepath.Join(repo, "new")); err != nil || !fi.IsDir() {
+		t.Errorf("Stat(repo/new): got %v, %v; want a directory", fi, err)
+	}
+	if fi, err := o.Stat(filepath.Join(repo, "new", "n.go")); err != nil || fi.Name() != "n.go" {
+		t.Errorf("Stat(repo/new/n.go): got %v, %v", fi, err)
+	}
+	want := filepath.Join(repo, "new", "n.go")
+	if got, err := o.EvalSymlinks(want); err != nil || got != want {
+		t.Errorf("EvalSymlinks: got %q, %v; want %q", got, err, want)
+	}
+}
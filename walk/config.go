@@ -19,8 +19,12 @@ import (
 	"flag"
 	"log"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/bmatcuk/doublestar"
 
@@ -32,9 +36,28 @@ import (
 // declared generated files, so we can't just stat.
 
 type walkConfig struct {
+	// excludes is a list of doublestar patterns for paths relative to the
+	// repository root. Patterns starting with '!' re-include paths. See
+	// matchExcludes.
 	excludes []string
 	ignore   bool
 	follow   []string
+
+	// langExcludes maps language names to lists of patterns like excludes.
+	// Files they match are hidden from that language only.
+	langExcludes map[string][]string
+
+	// bazelignore is whether paths listed in .bazelignore are skipped.
+	// bazelignored is the set of those paths, loaded at the repository root.
+	bazelignore  bool
+	bazelignored map[string]bool
+
+	// gitignore is whether paths matched by patterns in .gitignore files are
+	// skipped. gitignorePatterns holds patterns from .gitignore files in
+	// the current directory and its parents, loaded whether or not gitignore
+	// is set.
+	gitignore         bool
+	gitignorePatterns []gitignorePattern
 }
 
 const walkName = "_walk"
@@ -47,27 +70,122 @@ func (wc *walkConfig) isExcluded(rel, base string) bool {
 	if base == ".git" {
 		return true
 	}
-	f := path.Join(rel, base)
+	// Walk doesn't visit excluded directories unless a pattern might
+	// re-include something in them, so parent directories only need to be
+	// checked in that case.
+	return matchExcludes(wc.excludes, path.Join(rel, base), hasNegation(wc.excludes))
+}
+
+// mayReinclude returns whether a pattern starting with '!' could re-include
+// the directory rel or something below it. Walk visits excluded directories
+// when this is true.
+func (wc *walkConfig) mayReinclude(rel string) bool {
 	for _, x := range wc.excludes {
-		matched, err := doublestar.Match(x, f)
-		if err != nil {
-			// doublestar.Match returns only one possible error, and only if the
-			// pattern is not valid. During the configuration of the walker (see
-			// Configure below), we discard any invalid pattern and thus an error
-			// here should not be possible.
-			log.Panicf("error during doublestar.Match. This should not happen, please file an issue https://github.com/bazelbuild/bazel-gazelle/issues/new: %s", err)
+		if !strings.HasPrefix(x, "!") {
+			continue
+		}
+		x = x[1:]
+		lit := literalPrefix(x)
+		if pathtools.HasPrefix(lit, rel) || lit != x && pathtools.HasPrefix(rel, lit) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExcludes returns whether the file or directory f, a slash-separated
+// path relative to the repository root, is excluded by patterns. Patterns
+// starting with '!' re-include paths matched by earlier patterns; the last
+// pattern that matches f decides. If parents is true and no pattern matches
+// f, the closest parent directory that a pattern matches decides, so
+// excluding a directory excludes everything in it.
+func matchExcludes(patterns []string, f string, parents bool) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for {
+		for i := len(patterns) - 1; i >= 0; i-- {
+			x := patterns[i]
+			negate := strings.HasPrefix(x, "!")
+			if negate {
+				x = x[1:]
+			}
+			matched, err := doublestar.Match(x, f)
+			if err != nil {
+				// doublestar.Match returns only one possible error, and only if the
+				// pattern is not valid. During the configuration of the walker (see
+				// Configure below), we discard any invalid pattern and thus an error
+				// here should not be possible.
+				log.Panicf("error during doublestar.Match. This should not happen, please file an issue https://github.com/bazelbuild/bazel-gazelle/issues/new: %s", err)
+			}
+			if matched {
+				return !negate
+			}
+		}
+		if !parents || f == "." || f == "" {
+			return false
 		}
-		if matched {
+		f = path.Dir(f)
+	}
+}
+
+// hasNegation returns whether any pattern starts with '!'.
+func hasNegation(patterns []string) bool {
+	for _, x := range patterns {
+		if strings.HasPrefix(x, "!") {
 			return true
 		}
 	}
 	return false
 }
 
+// literalPrefix returns the leading path components of a doublestar pattern
+// that contain no special characters.
+func literalPrefix(pattern string) string {
+	var lit []string
+	for _, elem := range strings.Split(pattern, "/") {
+		if strings.ContainsAny(elem, `*?[{\`) {
+			break
+		}
+		lit = append(lit, elem)
+	}
+	return strings.Join(lit, "/")
+}
+
+// FilterLangFiles returns the files in the directory rel that aren't hidden
+// from the language lang with the exclude_lang directive. files is returned
+// unchanged if nothing is hidden from lang.
+func FilterLangFiles(c *config.Config, lang, rel string, files []string) []string {
+	wc, ok := c.Exts[walkName].(*walkConfig)
+	if !ok || len(wc.langExcludes[lang]) == 0 {
+		return files
+	}
+	var kept []string
+	for _, f := range files {
+		// Files are hidden from one language in directories Walk still
+		// visits, so parent directories are always checked.
+		if !matchExcludes(wc.langExcludes[lang], path.Join(rel, f), true) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// isIgnoredByFile returns whether the file or directory base in the
+// directory rel is listed in .bazelignore or matched by a pattern in a
+// .gitignore file, when those are honored.
+func (wc *walkConfig) isIgnoredByFile(rel, base string, isDir bool) bool {
+	f := path.Join(rel, base)
+	if wc.bazelignore && wc.bazelignored[f] {
+		return true
+	}
+	return wc.gitignore && matchGitignore(wc.gitignorePatterns, f, isDir)
+}
+
 type Configurer struct{}
 
 func (_ *Configurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
-	wc := &walkConfig{}
+	wc := &walkConfig{bazelignore: true}
 	c.Exts[walkName] = wc
 	fs.Var(&gzflag.MultiFlag{Values: &wc.excludes}, "exclude", "pattern that should be ignored (may be repeated)")
 }
@@ -75,7 +193,80 @@ func (_ *Configurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Confi
 func (_ *Configurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error { return nil }
 
 func (_ *Configurer) KnownDirectives() []string {
-	return []string{"exclude", "follow", "ignore"}
+	return []string{"bazelignore", "exclude", "exclude_lang", "follow", "gitignore", "ignore"}
+}
+
+func (_ *Configurer) DescribeDirectives() []config.DirectiveInfo {
+	return []config.DirectiveInfo{
+		{
+			Key:  "bazelignore",
+			Doc:  "Whether Gazelle skips directories listed in the .bazelignore file at the repository root, like Bazel does. Enabled by default.",
+			Args: []config.DirectiveArg{{Name: "enabled", Type: config.ArgBool}},
+		},
+		{
+			Key: "exclude",
+			Doc: "Prevents Gazelle from processing files and directories that match a doublestar pattern, relative to the directory where the directive is written. A pattern starting with '!' re-includes paths matched by earlier patterns.",
+			Args: []config.DirectiveArg{
+				{Name: "pattern", Type: config.ArgPath},
+			},
+			Scope: config.ScopeAccumulated,
+		},
+		{
+			Key: "exclude_lang",
+			Doc: "Hides files that match a doublestar pattern from one language. Other languages still generate rules for them. Patterns work like those of exclude.",
+			Args: []config.DirectiveArg{
+				{Name: "lang", Type: config.ArgString},
+				{Name: "pattern", Type: config.ArgPath},
+			},
+			Scope: config.ScopeAccumulated,
+		},
+		{
+			Key: "follow",
+			Doc: "Instructs Gazelle to follow a symbolic link to a directory within the repository.",
+			Args: []config.DirectiveArg{
+				{Name: "path", Type: config.ArgPath},
+			},
+			Scope: config.ScopeAccumulated,
+		},
+		{
+			Key:  "gitignore",
+			Doc:  "Whether Gazelle skips files and directories matched by patterns in .gitignore files. Disabled by default.",
+			Args: []config.DirectiveArg{{Name: "enabled", Type: config.ArgBool}},
+		},
+		{
+			Key:   "ignore",
+			Doc:   "Prevents Gazelle from modifying the build file. Gazelle still reads rules in the file and may modify build files in subdirectories.",
+			Scope: config.ScopeLocal,
+		},
+	}
+}
+
+func (_ *Configurer) DirectiveValues(c *config.Config, key string) []string {
+	wc := getWalkConfig(c)
+	switch key {
+	case "bazelignore":
+		return []string{strconv.FormatBool(wc.bazelignore)}
+	case "exclude":
+		return wc.excludes
+	case "exclude_lang":
+		var values []string
+		for lang, patterns := range wc.langExcludes {
+			for _, p := range patterns {
+				values = append(values, lang+" "+p)
+			}
+		}
+		sort.Strings(values)
+		return values
+	case "follow":
+		return wc.follow
+	case "gitignore":
+		return []string{strconv.FormatBool(wc.gitignore)}
+	case "ignore":
+		if wc.ignore {
+			return []string{""}
+		}
+	}
+	return nil
 }
 
 func (cr *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
@@ -85,16 +276,43 @@ func (cr *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	wcCopy.ignore = false
 
 	if f != nil {
-		for _, d := range f.Directives {
+		for i, d := range f.Directives {
 			switch d.Key {
 			case "exclude":
-				if err := checkPathMatchPattern(path.Join(rel, d.Value)); err != nil {
-					log.Printf("the exclusion pattern is not valid %q: %s", path.Join(rel, d.Value), err)
+				pattern := joinExcludePattern(rel, d.Value)
+				if err := checkPathMatchPattern(strings.TrimPrefix(pattern, "!")); err != nil {
+					c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: f.DirectiveLine(i)}, config.DiagInvalidDirective, "the exclusion pattern is not valid %q: %s", pattern, err)
+					continue
+				}
+				wcCopy.excludes = append(wcCopy.excludes, pattern)
+			case "exclude_lang":
+				fields := strings.Fields(d.Value)
+				if len(fields) != 2 {
+					c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: f.DirectiveLine(i)}, config.DiagInvalidDirective, "expected two arguments (gazelle:exclude_lang lang pattern), got %v", fields)
+					continue
+				}
+				lang, pattern := fields[0], joinExcludePattern(rel, fields[1])
+				if err := checkPathMatchPattern(strings.TrimPrefix(pattern, "!")); err != nil {
+					c.Diagnostics.Warnf(config.Position{Path: f.Path, Line: f.DirectiveLine(i)}, config.DiagInvalidDirective, "the exclusion pattern is not valid %q: %s", pattern, err)
 					continue
 				}
-				wcCopy.excludes = append(wcCopy.excludes, path.Join(rel, d.Value))
+				langExcludes := make(map[string][]string, len(wcCopy.langExcludes)+1)
+				for k, v := range wcCopy.langExcludes {
+					langExcludes[k] = v
+				}
+				old := langExcludes[lang]
+				langExcludes[lang] = append(old[:len(old):len(old)], pattern)
+				wcCopy.langExcludes = langExcludes
 			case "follow":
 				wcCopy.follow = append(wcCopy.follow, path.Join(rel, d.Value))
+			case "bazelignore":
+				if b, err := strconv.ParseBool(d.Value); err == nil {
+					wcCopy.bazelignore = b
+				}
+			case "gitignore":
+				if b, err := strconv.ParseBool(d.Value); err == nil {
+					wcCopy.gitignore = b
+				}
 			case "ignore":
 				wcCopy.ignore = true
 			}
@@ -104,6 +322,15 @@ func (cr *Configurer) Configure(c *config.Config, rel string, f *rule.File) {
 	c.Exts[walkName] = wcCopy
 }
 
+// joinExcludePattern makes pattern, written in a directive in the directory
+// rel, relative to the repository root. A leading '!' is preserved.
+func joinExcludePattern(rel, pattern string) string {
+	if strings.HasPrefix(pattern, "!") {
+		return "!" + path.Join(rel, pattern[1:])
+	}
+	return path.Join(rel, pattern)
+}
+
 func checkPathMatchPattern(pattern string) error {
 	_, err := doublestar.Match(pattern, "x")
 	return err
@@ -0,0 +1,207 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
+	"github.com/bazelbuild/bazel-gazelle/vfs"
+	"github.com/bmatcuk/doublestar"
+)
+
+// parseBazelignore reads the contents of a .bazelignore file. Each line
+// is a slash-separated path to a directory, relative to the repository root,
+// that Bazel won't load packages from. Blank lines and lines starting with
+// '#' are skipped.
+func parseBazelignore(data []byte) map[string]bool {
+	paths := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = path.Clean(strings.Trim(line, "/"))
+		if line != "." {
+			paths[line] = true
+		}
+	}
+	return paths
+}
+
+// loadBazelignore reads the .bazelignore file in the repository root
+// directory dir. nil is returned if the file doesn't exist.
+func loadBazelignore(fsys vfs.FS, dir string) map[string]bool {
+	data, err := fsys.ReadFile(filepath.Join(dir, ".bazelignore"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return nil
+	}
+	return parseBazelignore(data)
+}
+
+// loadGitignore reads the .gitignore file in dir and adds its patterns
+// to wc. wc must not be shared with the parent directory's configuration.
+func (wc *walkConfig) loadGitignore(fsys vfs.FS, dir, rel string) {
+	data, err := fsys.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	patterns := wc.gitignorePatterns[:len(wc.gitignorePatterns):len(wc.gitignorePatterns)]
+	wc.gitignorePatterns = append(patterns, parseGitignore(rel, data)...)
+}
+
+// gitignorePattern is a pattern read from a .gitignore file.
+type gitignorePattern struct {
+	// dir is the slash-separated path of the directory containing the
+	// .gitignore file, relative to the repository root.
+	dir string
+
+	// glob is a doublestar pattern. If anchored is true, glob is matched
+	// against paths relative to dir. Otherwise, it's matched against base
+	// names of files and directories in dir and its subdirectories.
+	glob     string
+	anchored bool
+
+	// negate is true for patterns starting with '!'. Paths they match are
+	// not ignored, even if an earlier pattern matched them.
+	negate bool
+
+	// dirOnly is true for patterns ending with '/'. They only match
+	// directories.
+	dirOnly bool
+}
+
+// parseGitignore reads patterns from the contents of a .gitignore file in
+// the directory dir. See https://git-scm.com/docs/gitignore for the format.
+// Invalid patterns are skipped.
+func parseGitignore(dir string, data []byte) []gitignorePattern {
+	var patterns []gitignorePattern
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := trimGitignoreSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := gitignorePattern{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		// A pattern with a slash at the beginning or middle is matched
+		// relative to the directory of the .gitignore file. Other patterns
+		// match at any level below it.
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		p.glob = gitignoreGlob(line)
+		if _, err := doublestar.Match(p.glob, "x"); err != nil {
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// trimGitignoreSpace removes trailing spaces from line, unless they're
+// escaped with a backslash.
+func trimGitignoreSpace(line string) string {
+	line = strings.TrimRight(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// gitignoreGlob converts a gitignore pattern to a doublestar pattern.
+// Braces have no special meaning in gitignore patterns, and character
+// classes are negated with '!' instead of '^'.
+func gitignoreGlob(pattern string) string {
+	var sb strings.Builder
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			sb.WriteByte(c)
+			sb.WriteByte(pattern[i+1])
+			i++
+			continue
+		case !inClass && (c == '{' || c == '}'):
+			sb.WriteByte('\\')
+		case !inClass && c == '[':
+			inClass = true
+			sb.WriteByte(c)
+			if i+1 < len(pattern) && pattern[i+1] == '!' {
+				sb.WriteByte('^')
+				i++
+			}
+			continue
+		case inClass && c == ']':
+			inClass = false
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// match returns whether the pattern matches the file or directory at rel,
+// a slash-separated path relative to the repository root.
+func (p gitignorePattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !pathtools.HasPrefix(rel, p.dir) || rel == p.dir {
+		return false
+	}
+	name := path.Base(rel)
+	if p.anchored {
+		name = pathtools.TrimPrefix(rel, p.dir)
+	}
+	matched, _ := doublestar.Match(p.glob, name)
+	return matched
+}
+
+// matchGitignore returns whether the file or directory at rel is ignored by
+// patterns. Patterns are in order of increasing precedence: patterns from
+// .gitignore files in parent directories come first. The last pattern that
+// matches decides.
+func matchGitignore(patterns []gitignorePattern, rel string, isDir bool) bool {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].match(rel, isDir) {
+			return !patterns[i].negate
+		}
+	}
+	return false
+}
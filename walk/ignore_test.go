@@ -0,0 +1,137 @@
+/* Copyright 2020 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBazelignore(t *testing.T) {
+	got := parseBazelignore([]byte(`# comment
+node_modules
+/third_party/out/
+
+`))
+	want := map[string]bool{"node_modules": true, "third_party/out": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestMatchGitignore(t *testing.T) {
+	for _, tc := range []struct {
+		desc, dir, gitignore string
+		rel                  string
+		isDir, want          bool
+	}{
+		{
+			desc:      "basename",
+			gitignore: "*.log",
+			rel:       "a/b/x.log",
+			want:      true,
+		}, {
+			desc:      "basename_no_match",
+			gitignore: "*.log",
+			rel:       "a/b/x.go",
+		}, {
+			desc:      "comment",
+			gitignore: "# *.go",
+			rel:       "x.go",
+		}, {
+			desc:      "escaped_hash",
+			gitignore: `\#x`,
+			rel:       "#x",
+			want:      true,
+		}, {
+			desc:      "anchored_leading_slash",
+			gitignore: "/out",
+			rel:       "a/out",
+		}, {
+			desc:      "anchored_root",
+			gitignore: "/out",
+			rel:       "out",
+			isDir:     true,
+			want:      true,
+		}, {
+			desc:      "anchored_middle_slash",
+			gitignore: "a/out",
+			rel:       "a/out",
+			want:      true,
+		}, {
+			desc:      "anchored_middle_slash_nested",
+			gitignore: "a/out",
+			rel:       "b/a/out",
+		}, {
+			desc:      "dir_only_file",
+			gitignore: "build/",
+			rel:       "a/build",
+		}, {
+			desc:      "dir_only_dir",
+			gitignore: "build/",
+			rel:       "a/build",
+			isDir:     true,
+			want:      true,
+		}, {
+			desc:      "double_star",
+			gitignore: "**/gen/*.go",
+			rel:       "a/b/gen/x.go",
+			want:      true,
+		}, {
+			desc:      "negation",
+			gitignore: "*.go\n!keep.go",
+			rel:       "a/keep.go",
+		}, {
+			desc:      "negation_order",
+			gitignore: "!keep.go\n*.go",
+			rel:       "a/keep.go",
+			want:      true,
+		}, {
+			desc:      "nested_dir",
+			dir:       "sub",
+			gitignore: "/x",
+			rel:       "sub/x",
+			want:      true,
+		}, {
+			desc:      "nested_dir_outside",
+			dir:       "sub",
+			gitignore: "x",
+			rel:       "other/x",
+		}, {
+			desc:      "braces_literal",
+			gitignore: "{a,b}",
+			rel:       "{a,b}",
+			want:      true,
+		}, {
+			desc:      "negated_class",
+			gitignore: "x[!0-9]",
+			rel:       "xa",
+			want:      true,
+		}, {
+			desc:      "trailing_space",
+			gitignore: "x  ",
+			rel:       "x",
+			want:      true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			patterns := parseGitignore(tc.dir, []byte(tc.gitignore))
+			if got := matchGitignore(patterns, tc.rel, tc.isDir); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -18,7 +18,6 @@ limitations under the License.
 package walk
 
 import (
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -97,8 +96,10 @@ type WalkFunc func(dir, rel string, c *config.Config, update bool, f *rule.File,
 // cexts is a list of configuration extensions. When visiting a directory,
 // before visiting subdirectories, Walk makes a copy of the parent configuration
 // and Configure for each extension on the copy. If Walk sees a directive
-// that is not listed in KnownDirectives of any extension, an error will
-// be logged.
+// that is not listed in KnownDirectives of any extension, a warning is
+// reported to c.Diagnostics. Directives with values that don't match their
+// description (see config.DirectiveDescriber) are reported and are not
+// passed to Configure.
 //
 // dirs is a list of absolute, canonical file system paths of directories
 // to visit.
@@ -109,12 +110,7 @@ type WalkFunc func(dir, rel string, c *config.Config, update bool, f *rule.File,
 //
 // wf is a function that may be called in each directory.
 func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode, wf WalkFunc) {
-	knownDirectives := make(map[string]bool)
-	for _, cext := range cexts {
-		for _, d := range cext.KnownDirectives() {
-			knownDirectives[d] = true
-		}
-	}
+	registry := config.NewDirectiveRegistry(cexts)
 
 	symlinks := symlinkResolver{visited: []string{c.RepoRoot}}
 
@@ -127,7 +123,7 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 		// TODO: OPT: ReadDir stats all the files, which is slow. We just care about
 		// names and modes, so we should use something like
 		// golang.org/x/tools/internal/fastwalk to speed this up.
-		files, err := ioutil.ReadDir(dir)
+		files, err := c.FileSystem().ReadDir(dir)
 		if err != nil {
 			log.Print(err)
 			return
@@ -139,18 +135,31 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 			haveError = true
 		}
 
-		c = configure(cexts, knownDirectives, c, rel, f)
+		c = configure(cexts, registry, c, rel, f)
 		wc := getWalkConfig(c)
 
-		if wc.isExcluded(rel, ".") {
+		excluded := wc.isExcluded(rel, ".")
+		if excluded && !wc.mayReinclude(rel) {
 			return
 		}
+		if rel == "" {
+			wc.bazelignored = loadBazelignore(c.FileSystem(), dir)
+		}
+		for _, fi := range files {
+			if fi.Name() == ".gitignore" && !fi.IsDir() {
+				wc.loadGitignore(c.FileSystem(), dir, rel)
+				break
+			}
+		}
 
 		var subdirs, regularFiles []string
 		for _, fi := range files {
 			base := fi.Name()
 			switch {
-			case base == "" || wc.isExcluded(rel, base):
+			case base == "" || wc.isIgnoredByFile(rel, base, fi.IsDir()):
+				continue
+
+			case wc.isExcluded(rel, base) && !(fi.IsDir() && wc.mayReinclude(path.Join(rel, base))):
 				continue
 
 			case fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 && symlinks.follow(c, dir, rel, base):
@@ -168,7 +177,9 @@ func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode,
 			}
 		}
 
-		update := !haveError && !wc.ignore && shouldUpdate
+		// An excluded directory is only visited to reach re-included files
+		// below it. Its build file is left alone unless some are in it.
+		update := !haveError && !wc.ignore && shouldUpdate && (!excluded || len(regularFiles) > 0)
 		if shouldCall(rel, mode, updateParent, updateRels) {
 			genFiles := findGenFiles(wc, f)
 			wf(dir, rel, c, update, f, subdirs, regularFiles, genFiles)
@@ -255,7 +266,7 @@ func loadBuildFile(c *config.Config, pkg, dir string, files []os.FileInfo) (*rul
 	readFiles := files
 	if c.ReadBuildFilesDir != "" {
 		readDir = filepath.Join(c.ReadBuildFilesDir, filepath.FromSlash(pkg))
-		readFiles, err = ioutil.ReadDir(readDir)
+		readFiles, err = c.FileSystem().ReadDir(readDir)
 		if err != nil {
 			return nil, err
 		}
@@ -264,22 +275,37 @@ func loadBuildFile(c *config.Config, pkg, dir string, files []os.FileInfo) (*rul
 	if path == "" {
 		return nil, nil
 	}
-	return rule.LoadFile(path, pkg)
+	data, err := c.FileSystem().ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return rule.LoadData(path, pkg, data)
 }
 
-func configure(cexts []config.Configurer, knownDirectives map[string]bool, c *config.Config, rel string, f *rule.File) *config.Config {
+func configure(cexts []config.Configurer, registry *config.DirectiveRegistry, c *config.Config, rel string, f *rule.File) *config.Config {
 	if rel != "" {
 		c = c.Clone()
 	}
+	cf := f
 	if f != nil {
-		for _, d := range f.Directives {
-			if !knownDirectives[d.Key] {
-				log.Printf("%s: unknown directive: gazelle:%s", f.Path, d.Key)
+		invalid := make(map[int]bool)
+		for i, d := range f.Directives {
+			pos := config.Position{Path: f.Path, Line: f.DirectiveLine(i)}
+			if _, ok := registry.Lookup(d.Key); !ok {
+				c.Diagnostics.Warnf(pos, config.DiagUnknownDirective, "%v", registry.Check(d))
+			} else if err := registry.Check(d); err != nil {
+				c.Diagnostics.Warnf(pos, config.DiagInvalidDirective, "gazelle:%s: %v", d.Key, err)
+				invalid[i] = true
 			}
 		}
+		if len(invalid) > 0 {
+			// Configurers see a shallow copy of the file without invalid
+			// directives. The file passed to the WalkFunc is unchanged.
+			cf = f.FilterDirectives(func(i int) bool { return !invalid[i] })
+		}
 	}
 	for _, cext := range cexts {
-		cext.Configure(c, rel, f)
+		cext.Configure(c, rel, cf)
 	}
 	return c
 }
@@ -331,7 +357,7 @@ func (r *symlinkResolver) follow(c *config.Config, dir, rel, base string) bool {
 
 	// See if the symlink points to a tree that has been already visited.
 	fullpath := filepath.Join(dir, base)
-	dest, err := filepath.EvalSymlinks(fullpath)
+	dest, err := c.FileSystem().EvalSymlinks(fullpath)
 	if err != nil {
 		return false
 	}
@@ -347,7 +373,7 @@ func (r *symlinkResolver) follow(c *config.Config, dir, rel, base string) bool {
 		}
 	}
 	r.visited = append(r.visited, dest)
-	stat, err := os.Stat(fullpath)
+	stat, err := c.FileSystem().Stat(fullpath)
 	if err != nil {
 		return false
 	}
@@ -17,10 +17,12 @@ package walk
 
 import (
 	"flag"
+	"fmt"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"testing"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -174,6 +176,69 @@ func TestCustomBuildName(t *testing.T) {
 	}
 }
 
+func TestIgnoreFiles(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: ".bazelignore", Content: "node_modules\n"},
+		{Path: ".gitignore", Content: "*.log\nout/\n"},
+		{Path: "node_modules/pkg/x.go"},
+		{Path: "a/x.go"},
+		{Path: "a/x.log"},
+		{Path: "a/out/y.go"},
+		{Path: "a/.gitignore", Content: "!keep.log\n"},
+		{Path: "a/keep.log"},
+	}
+	for _, tc := range []struct {
+		desc, directives string
+		want             []string
+	}{
+		{
+			desc: "default",
+			want: []string{
+				"a/keep.log",
+				"a/out/y.go",
+				"a/x.go",
+				"a/x.log",
+			},
+		}, {
+			desc:       "gitignore",
+			directives: "# gazelle:gitignore true",
+			want: []string{
+				"a/keep.log",
+				"a/x.go",
+			},
+		}, {
+			desc:       "no_bazelignore",
+			directives: "# gazelle:bazelignore false",
+			want: []string{
+				"a/keep.log",
+				"a/out/y.go",
+				"a/x.go",
+				"a/x.log",
+				"node_modules/pkg/x.go",
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, cleanup := testtools.CreateFiles(t, append(files, testtools.FileSpec{Path: "BUILD.bazel", Content: tc.directives}))
+			defer cleanup()
+
+			c, cexts := testConfig(t, dir)
+			var got []string
+			Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+				for _, f := range regularFiles {
+					if path.Ext(f) == ".go" || path.Ext(f) == ".log" {
+						got = append(got, path.Join(rel, f))
+					}
+				}
+			})
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestExcludeFiles(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{
@@ -227,6 +292,102 @@ gen(
 	}
 }
 
+func TestExcludeNegated(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:exclude *.gen.go
+# gazelle:exclude !keep.gen.go
+# gazelle:exclude vendor
+# gazelle:exclude !vendor/keep/**
+`,
+		},
+		{Path: "a.gen.go"},             // ignored by '*.gen.go'
+		{Path: "keep.gen.go"},          // re-included by '!keep.gen.go'
+		{Path: "vendor/BUILD.bazel"},   // ignored by 'vendor'
+		{Path: "vendor/a/a.go"},        // ignored by 'vendor'
+		{Path: "vendor/keep/k.go"},     // re-included by '!vendor/keep/**'
+		{Path: "vendor/keep/sub/s.go"}, // re-included by '!vendor/keep/**'
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var files []string
+	updates := make(map[string]bool)
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, update bool, _ *rule.File, _, regularFiles, _ []string) {
+		updates[rel] = update
+		for _, f := range regularFiles {
+			files = append(files, path.Join(rel, f))
+		}
+	})
+	sort.Strings(files)
+	want := []string{"BUILD.bazel", "keep.gen.go", "vendor/keep/k.go", "vendor/keep/sub/s.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %#v; want %#v", files, want)
+	}
+	wantUpdates := map[string]bool{"": true, "vendor": false, "vendor/keep": true, "vendor/keep/sub": true}
+	if !reflect.DeepEqual(updates, wantUpdates) {
+		t.Errorf("got updates %v; want %v", updates, wantUpdates)
+	}
+}
+
+func TestFilterLangFiles(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: "BUILD.bazel",
+			Content: `
+# gazelle:exclude_lang proto vendor
+# gazelle:exclude_lang proto !vendor/keep.proto
+# gazelle:exclude_lang go **/*_mock.go
+`,
+		},
+		{Path: "vendor/a.proto"},
+		{Path: "vendor/keep.proto"},
+		{Path: "vendor/a.go"},
+		{Path: "vendor/a_mock.go"},
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	got := make(map[string][]string)
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, c *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+		if rel != "vendor" {
+			return
+		}
+		for _, lang := range []string{"go", "proto", "other"} {
+			got[lang] = FilterLangFiles(c, lang, rel, regularFiles)
+		}
+	})
+	want := map[string][]string{
+		"go":    {"a.go", "a.proto", "keep.proto"},
+		"proto": {"keep.proto"},
+		"other": {"a.go", "a.proto", "a_mock.go", "keep.proto"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestMatchExcludes(t *testing.T) {
+	for _, tc := range []struct {
+		patterns []string
+		f        string
+		parents  bool
+		want     bool
+	}{
+		{patterns: []string{"vendor"}, f: "vendor", want: true},
+		{patterns: []string{"vendor"}, f: "vendor/a.go", want: false},
+		{patterns: []string{"vendor"}, f: "vendor/a.go", parents: true, want: true},
+		{patterns: []string{"vendor", "!vendor/keep/**"}, f: "vendor/keep/a.go", parents: true, want: false},
+		{patterns: []string{"vendor", "!vendor/keep/**"}, f: "vendor/drop/a.go", parents: true, want: true},
+	} {
+		if got := matchExcludes(tc.patterns, tc.f, tc.parents); got != tc.want {
+			t.Errorf("matchExcludes(%q, %q, %v): got %v; want %v", tc.patterns, tc.f, tc.parents, got, tc.want)
+		}
+	}
+}
+
 func TestExcludeSelf(t *testing.T) {
 	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
 		{
@@ -458,6 +619,49 @@ func TestSymlinksFollow(t *testing.T) {
 	}
 }
 
+func TestInvalidDirectives(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{
+		Path: "BUILD.bazel",
+		Content: `# gazelle:exclud a
+# gazelle:ignore extra
+# gazelle:exclude b
+`,
+	}})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var configured []string
+	cexts = append(cexts, &testConfigurer{func(_ *config.Config, _ string, f *rule.File) {
+		for i, d := range f.Directives {
+			configured = append(configured, fmt.Sprintf("%s:%d", d.Key, f.DirectiveLine(i)))
+		}
+	}})
+	var walked []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, _ string, _ *config.Config, _ bool, f *rule.File, _, _, _ []string) {
+		for _, d := range f.Directives {
+			walked = append(walked, d.Key)
+		}
+	})
+
+	if want := []string{"exclud:1", "exclude:3"}; !reflect.DeepEqual(configured, want) {
+		t.Errorf("directives passed to Configure: got %q; want %q", configured, want)
+	}
+	if want := []string{"exclud", "ignore", "exclude"}; !reflect.DeepEqual(walked, want) {
+		t.Errorf("directives passed to WalkFunc: got %q; want %q", walked, want)
+	}
+	var diags []string
+	for _, d := range c.Diagnostics.List() {
+		diags = append(diags, fmt.Sprintf("%d: %s [%s]", d.Pos.Line, d.Message, d.Code))
+	}
+	want := []string{
+		"1: unknown directive: gazelle:exclud; did you mean gazelle:exclude? [unknown-directive]",
+		`2: gazelle:ignore: expected no arguments, got "extra" [invalid-directive]`,
+	}
+	if !reflect.DeepEqual(diags, want) {
+		t.Errorf("got diagnostics %q; want %q", diags, want)
+	}
+}
+
 func testConfig(t *testing.T, dir string) (*config.Config, []config.Configurer) {
 	args := []string{"-repo_root", dir}
 	cexts := []config.Configurer{&config.CommonConfigurer{}, &Configurer{}}